@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OperationCreate  = "create"
+	OperationUpdate  = "update"
+	OperationDelete  = "delete"
+	OperationRestore = "restore"
+	OperationMerge   = "merge"
+	OperationPurge   = "purge"
+	// The password operations have no changes, the hashes are never logged
+	OperationPasswordChange = "password_change"
+	OperationPasswordReset  = "password_reset"
+)
+
+var (
+	ErrAppendingEntry = errors.New("error appending audit entry")
+	ErrReadingEntries = errors.New("error reading audit entries")
+)
+
+// Entry is an immutable record of one change made to an entity
+type Entry struct {
+	Id         uuid.UUID `json:"id" xml:"id" yaml:"id"`
+	EntityType string    `json:"entity_type" xml:"entity_type" yaml:"entity_type"`
+	EntityId   uuid.UUID `json:"entity_id" xml:"entity_id" yaml:"entity_id"`
+	Actor      string    `json:"actor" xml:"actor" yaml:"actor"`
+	Timestamp  time.Time `json:"timestamp" xml:"timestamp" yaml:"timestamp"`
+	Operation  string    `json:"operation" xml:"operation" yaml:"operation"`
+	Changes    []Change  `json:"changes" xml:"changes>change" yaml:"changes"`
+	// Empty for the default tenant
+	Tenant string `json:"tenant,omitempty" xml:"tenant,omitempty" yaml:"tenant,omitempty"`
+}
+
+func (e Entry) GetId() uuid.UUID {
+	return e.Id
+}
+
+// Change is the value of one field before and after the operation, nested
+// fields are named with dots: "address.city"
+type Change struct {
+	Field  string `json:"field" xml:"field" yaml:"field"`
+	Before any    `json:"before" xml:"before" yaml:"before"`
+	After  any    `json:"after" xml:"after" yaml:"after"`
+}
+
+// Sink stores the audit entries, entries are only ever appended
+type Sink interface {
+	Append(entry Entry) error
+	Query(query Query) ([]Entry, error)
+}
+
+// Query filters the audit entries, zero values don't filter. From is inclusive and To exclusive.
+// Tenant always filters, the entries of the default tenant have an empty one.
+type Query struct {
+	Tenant     string
+	EntityType string
+	EntityId   uuid.UUID
+	Actor      string
+	Operation  string
+	From       time.Time
+	To         time.Time
+}
+
+func (q Query) Matches(entry Entry) bool {
+	if entry.Tenant != q.Tenant {
+		return false
+	}
+	if q.EntityType != "" && entry.EntityType != q.EntityType {
+		return false
+	}
+	if q.EntityId != uuid.Nil && entry.EntityId != q.EntityId {
+		return false
+	}
+	if q.Actor != "" && entry.Actor != q.Actor {
+		return false
+	}
+	if q.Operation != "" && entry.Operation != q.Operation {
+		return false
+	}
+	if !q.From.IsZero() && entry.Timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !entry.Timestamp.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// NewEntry builds the entry of an operation, before is nil on creations and after on deletions
+func NewEntry(entityType string, entityId uuid.UUID, actor string, timestamp time.Time, operation string, before any, after any) Entry {
+	return Entry{
+		Id:         uuid.New(),
+		EntityType: entityType,
+		EntityId:   entityId,
+		Actor:      actor,
+		Timestamp:  timestamp,
+		Operation:  operation,
+		Changes:    Diff(before, after),
+	}
+}
+
+// Diff lists the fields that differ between two values of the same struct type
+func Diff(before any, after any) []Change {
+	beforeFields := make(map[string]any)
+	afterFields := make(map[string]any)
+	names := make([]string, 0)
+	if before != nil {
+		names = flatten(reflect.ValueOf(before), "", beforeFields, names)
+	}
+	if after != nil {
+		names = flatten(reflect.ValueOf(after), "", afterFields, names)
+	}
+
+	changes := make([]Change, 0)
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		beforeValue, afterValue := beforeFields[name], afterFields[name]
+		if reflect.DeepEqual(beforeValue, afterValue) {
+			continue
+		}
+		changes = append(changes, Change{Field: name, Before: beforeValue, After: afterValue})
+	}
+	return changes
+}
+
+// flatten stores the fields of a struct by their JSON name, keeping their order in names
+func flatten(value reflect.Value, prefix string, fields map[string]any, names []string) []string {
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return names
+		}
+		value = value.Elem()
+	}
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		name = prefix + name
+
+		fieldValue := value.Field(i)
+		if fieldValue.Kind() == reflect.Pointer {
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+		}
+		// Nested structs are flattened, time and uuid values are kept whole
+		if fieldValue.Kind() == reflect.Struct && !isLeaf(fieldValue.Type()) {
+			names = flatten(fieldValue, name+".", fields, names)
+			continue
+		}
+		fields[name] = fieldValue.Interface()
+		names = append(names, name)
+	}
+	return names
+}
+
+func isLeaf(t reflect.Type) bool {
+	return t == reflect.TypeOf(time.Time{}) || t == reflect.TypeOf(uuid.UUID{})
+}
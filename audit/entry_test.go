@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type place struct {
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+type person struct {
+	Id      uuid.UUID  `json:"id"`
+	Name    string     `json:"name"`
+	Secret  string     `json:"-"`
+	Home    place      `json:"home"`
+	Work    *place     `json:"work,omitempty"`
+	Born    time.Time  `json:"born"`
+	Deleted *time.Time `json:"deleted,omitempty"`
+}
+
+func TestDiffListsTheChangedFieldsByTheirJSONName(t *testing.T) {
+	id := uuid.New()
+	born := time.Date(1990, time.May, 1, 0, 0, 0, 0, time.UTC)
+	before := person{Id: id, Name: "Ann", Secret: "a", Home: place{City: "Rome", Country: "IT"}, Born: born}
+	after := person{Id: id, Name: "Anna", Secret: "b", Home: place{City: "Milan", Country: "IT"}, Work: &place{City: "Turin", Country: "IT"}, Born: born}
+
+	got := Diff(before, after)
+	want := []Change{
+		{Field: "name", Before: "Ann", After: "Anna"},
+		{Field: "home.city", Before: "Rome", After: "Milan"},
+		{Field: "work.city", Before: nil, After: "Turin"},
+		{Field: "work.country", Before: nil, After: "IT"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDiffOfACreationAndADeletion(t *testing.T) {
+	born := time.Date(1990, time.May, 1, 0, 0, 0, 0, time.UTC)
+	p := person{Id: uuid.New(), Name: "Ann", Home: place{City: "Rome", Country: "IT"}, Born: born}
+
+	created := Diff(nil, p)
+	fields := make([]string, 0, len(created))
+	for _, change := range created {
+		if change.Before != nil {
+			t.Errorf("%s: got the value %v before the creation", change.Field, change.Before)
+		}
+		fields = append(fields, change.Field)
+	}
+	// Time and uuid values are single fields, nil pointers are left out
+	if want := []string{"id", "name", "home.city", "home.country", "born"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("got the fields %v, want %v", fields, want)
+	}
+
+	deleted := Diff(&p, nil)
+	if len(deleted) != len(created) {
+		t.Fatalf("got %d changes on the deletion, want %d", len(deleted), len(created))
+	}
+	for _, change := range deleted {
+		if change.After != nil {
+			t.Errorf("%s: got the value %v after the deletion", change.Field, change.After)
+		}
+	}
+
+	if changes := Diff(p, p); len(changes) != 0 {
+		t.Errorf("got %+v between equal values", changes)
+	}
+}
+
+func TestQueryMatchesFromInclusiveToExclusive(t *testing.T) {
+	at := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+	id := uuid.New()
+	entry := Entry{Id: uuid.New(), EntityType: "user", EntityId: id, Actor: "alice", Timestamp: at, Operation: OperationUpdate}
+
+	for _, test := range []struct {
+		name  string
+		query Query
+		want  bool
+	}{
+		{name: "empty", query: Query{}, want: true},
+		{name: "entity", query: Query{EntityType: "user", EntityId: id}, want: true},
+		{name: "other entity", query: Query{EntityId: uuid.New()}, want: false},
+		{name: "other type", query: Query{EntityType: "organization"}, want: false},
+		{name: "actor", query: Query{Actor: "alice"}, want: true},
+		{name: "other actor", query: Query{Actor: "bob"}, want: false},
+		{name: "other operation", query: Query{Operation: OperationDelete}, want: false},
+		{name: "from the timestamp", query: Query{From: at}, want: true},
+		{name: "from after", query: Query{From: at.Add(time.Millisecond)}, want: false},
+		{name: "to the timestamp", query: Query{To: at}, want: false},
+		{name: "to after", query: Query{To: at.Add(time.Millisecond)}, want: true},
+		{name: "range", query: Query{From: at.Add(-time.Hour), To: at.Add(time.Hour)}, want: true},
+	} {
+		if got := test.query.Matches(entry); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// fileSink appends the entries to a file, one JSON document per line
+type fileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileSink(path string) (*fileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{path: path, file: file}, nil
+}
+
+func (f *fileSink) Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return ErrAppendingEntry
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		slog.Error(err.Error())
+		return ErrAppendingEntry
+	}
+	// Entries must survive a crash right after the change
+	return f.file.Sync()
+}
+
+func (f *fileSink) Query(query Query) ([]Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.Open(f.path)
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrReadingEntries
+	}
+	defer file.Close()
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Error(err.Error())
+			return nil, ErrReadingEntries
+		}
+		if query.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Error(err.Error())
+		return nil, ErrReadingEntries
+	}
+	return entries, nil
+}
@@ -0,0 +1,31 @@
+package audit
+
+import "sync"
+
+type memorySink struct {
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+func NewMemorySink() *memorySink {
+	return &memorySink{entries: make([]Entry, 0)}
+}
+
+func (m *memorySink) Append(entry Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *memorySink) Query(query Query) ([]Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := make([]Entry, 0)
+	for _, entry := range m.entries {
+		if query.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
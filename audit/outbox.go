@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example/bootcamp_ex1/db"
+)
+
+// Topic of the outbox messages holding audit entries
+const OutboxTopic = "audit-entries"
+
+var (
+	ErrNotAnEntry = errors.New("outbox message is not an audit entry")
+)
+
+// ToOutbox wraps the entry in a message to store with the change it records, so an entry
+// is never lost once the change is stored
+func ToOutbox(entry Entry) (db.OutboxMessage, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return db.OutboxMessage{}, err
+	}
+	return db.OutboxMessage{
+		Id:            entry.Id,
+		Topic:         OutboxTopic,
+		Payload:       payload,
+		CreatedAt:     entry.Timestamp,
+		NextAttemptAt: entry.Timestamp,
+	}, nil
+}
+
+func FromOutbox(message db.OutboxMessage) (Entry, error) {
+	if message.Topic != OutboxTopic {
+		return Entry{}, ErrNotAnEntry
+	}
+	entry := Entry{}
+	if err := json.Unmarshal(message.Payload, &entry); err != nil {
+		return Entry{}, ErrNotAnEntry
+	}
+	return entry, nil
+}
+
+// Deliver appends the entries relayed from an outbox to the sink, a failing append
+// is retried by the relay
+func Deliver(sink Sink) func(ctx context.Context, message db.OutboxMessage) error {
+	return func(ctx context.Context, message db.OutboxMessage) error {
+		entry, err := FromOutbox(message)
+		if err != nil {
+			return err
+		}
+		return sink.Append(entry)
+	}
+}
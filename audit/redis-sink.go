@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const entryField = "entry"
+
+// Stream ids are set by redis when the entry is appended, which can be a bit
+// after its timestamp, so time ranges are read with some slack and filtered
+const streamSlack = time.Minute
+
+// redisSink appends the entries to a Redis Stream, time ranges are read with XRANGE
+type redisSink struct {
+	client *redis.Client
+	stream string
+}
+
+func NewRedisSink(client *redis.Client, stream string) *redisSink {
+	return &redisSink{client: client, stream: stream}
+}
+
+func (r *redisSink) Append(entry Entry) error {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return ErrAppendingEntry
+	}
+	err = r.client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: r.stream,
+		Values: map[string]any{entryField: string(payload)},
+	}).Err()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrAppendingEntry
+	}
+	return nil
+}
+
+func (r *redisSink) Query(query Query) ([]Entry, error) {
+	start, end := "-", "+"
+	if !query.From.IsZero() {
+		start = strconv.FormatInt(query.From.Add(-streamSlack).UnixMilli(), 10)
+	}
+	if !query.To.IsZero() {
+		end = strconv.FormatInt(query.To.Add(streamSlack).UnixMilli(), 10)
+	}
+	messages, err := r.client.XRange(context.Background(), r.stream, start, end).Result()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrReadingEntries
+	}
+
+	entries := make([]Entry, 0)
+	for _, message := range messages {
+		payload, ok := message.Values[entryField].(string)
+		if !ok {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal([]byte(payload), &entry); err != nil {
+			slog.Error(err.Error())
+			return nil, ErrReadingEntries
+		}
+		if query.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// forEachSink runs the test on a new sink of every backend
+func forEachSink(t *testing.T, test func(t *testing.T, sink Sink)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemorySink())
+	})
+	t.Run("file", func(t *testing.T) {
+		sink, err := NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, sink)
+	})
+	t.Run("redis", func(t *testing.T) {
+		test(t, newTestRedisSink(t))
+	})
+}
+
+// newTestRedisSink returns a sink of the redis at REDIS_HOST with its own stream,
+// removed after the test. The test is skipped without REDIS_HOST.
+func newTestRedisSink(t *testing.T) *redisSink {
+	t.Helper()
+	if os.Getenv("REDIS_HOST") == "" {
+		t.Skip("REDIS_HOST is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_HOST")})
+	stream := "test:" + t.Name()
+	client.Del(context.Background(), stream)
+	t.Cleanup(func() {
+		client.Del(context.Background(), stream)
+		client.Close()
+	})
+	return NewRedisSink(client, stream)
+}
+
+func appendEntries(t *testing.T, sink Sink, entries ...Entry) {
+	t.Helper()
+	for _, entry := range entries {
+		if err := sink.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func entryIds(entries []Entry) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.Id)
+	}
+	return ids
+}
+
+func TestSinksReturnTheMatchingEntriesInOrder(t *testing.T) {
+	forEachSink(t, func(t *testing.T, sink Sink) {
+		// The timestamps are close to now, as redis reads time ranges by the append time
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		ann, bea := uuid.New(), uuid.New()
+		created := NewEntry("user", ann, "alice", now.Add(-2*time.Second), OperationCreate, nil, place{City: "Rome"})
+		updated := NewEntry("user", ann, "bob", now.Add(-time.Second), OperationUpdate, place{City: "Rome"}, place{City: "Milan"})
+		other := NewEntry("user", bea, "alice", now, OperationCreate, nil, place{City: "Turin"})
+		appendEntries(t, sink, created, updated, other)
+
+		all, err := sink.Query(Query{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := entryIds(all), []uuid.UUID{created.Id, updated.Id, other.Id}; !equalIds(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if all[1].Actor != "bob" || all[1].Operation != OperationUpdate || len(all[1].Changes) != 1 || all[1].Changes[0].After != "Milan" {
+			t.Errorf("the entry was not kept as appended: %+v", all[1])
+		}
+		if !all[1].Timestamp.Equal(updated.Timestamp) {
+			t.Errorf("got the timestamp %s, want %s", all[1].Timestamp, updated.Timestamp)
+		}
+
+		for _, test := range []struct {
+			name  string
+			query Query
+			want  []uuid.UUID
+		}{
+			{name: "entity", query: Query{EntityId: ann}, want: []uuid.UUID{created.Id, updated.Id}},
+			{name: "actor", query: Query{Actor: "alice"}, want: []uuid.UUID{created.Id, other.Id}},
+			{name: "from", query: Query{From: updated.Timestamp}, want: []uuid.UUID{updated.Id, other.Id}},
+			{name: "to", query: Query{To: updated.Timestamp}, want: []uuid.UUID{created.Id}},
+			{name: "none", query: Query{Operation: OperationDelete}, want: []uuid.UUID{}},
+		} {
+			entries, err := sink.Query(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entryIds(entries); !equalIds(got, test.want) {
+				t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			}
+		}
+	})
+}
+
+func TestFileSinkKeepsTheEntriesOfEarlierRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	first, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := NewEntry("user", uuid.New(), "alice", time.Now(), OperationCreate, nil, place{City: "Rome"})
+	appendEntries(t, first, entry)
+
+	second, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEntries(t, second, NewEntry("user", uuid.New(), "bob", time.Now(), OperationCreate, nil, place{City: "Milan"}))
+	entries, err := second.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Id != entry.Id {
+		t.Errorf("got %+v, want the entry of the first sink followed by the new one", entries)
+	}
+}
+
+func equalIds(got []uuid.UUID, want []uuid.UUID) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"slices"
+)
+
+var (
+	ErrUnknownIndex = errors.New("the storage has no index with this name")
+	ErrNotCounted   = errors.New("the index doesn't count its records")
+)
+
+// Index lists the keys a record is found by with Storage.FindBy, like its tags.
+// The storages keep the indexes of the live records up to date on every write.
+type Index[T entities.StorageObject] struct {
+	Name string
+	Keys func(T) []string
+	// Counted indexes also keep the number of live records having each key, read with Storage.Count
+	Counted bool
+}
+
+// keysOf returns the keys of the record once each, so a counted key is counted once per record
+func (i Index[T]) keysOf(thing T) []string {
+	keys := slices.Clone(i.Keys(thing))
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// indexesByName checks the names of the indexes given to a storage
+func indexesByName[T entities.StorageObject](indexes []Index[T]) map[string]Index[T] {
+	byName := make(map[string]Index[T], len(indexes))
+	for _, index := range indexes {
+		byName[index.Name] = index
+	}
+	return byName
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"example/bootcamp_ex1/entities"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	testTagIndex = Index[entities.User]{Name: "tag", Keys: func(user entities.User) []string {
+		return user.Tags
+	}}
+	// testNameIndex counts the records by name, the repeated keys of a record count once
+	testNameIndex = Index[entities.User]{Name: "name", Counted: true, Keys: func(user entities.User) []string {
+		return []string{user.Name, user.Name}
+	}}
+)
+
+// findIds returns the ids of the records found by the key in the index
+func findIds(t *testing.T, storage Storage[entities.User], index string, key string) map[uuid.UUID]bool {
+	t.Helper()
+	found, err := Collect(storage.FindBy(index, key, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[uuid.UUID]bool, len(found))
+	for _, user := range found {
+		ids[user.Id] = true
+	}
+	return ids
+}
+
+// forEachIndexedStorage runs the test on a new storage of every backend keeping the indexes
+func forEachIndexedStorage(t *testing.T, test func(t *testing.T, storage Storage[entities.User]), indexes ...Index[entities.User]) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStorage(indexes...))
+	})
+	t.Run("redis", func(t *testing.T) {
+		test(t, newTestRedisStorage(t, indexes...))
+	})
+}
+
+func TestIndexesFollowTheWrites(t *testing.T) {
+	forEachIndexedStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		ann := testUser("ann")
+		ann.Tags = []string{"admin", "staff"}
+		bea := testUser("bea")
+		bea.Tags = []string{"staff"}
+		for _, user := range []entities.User{ann, bea, testUser("cid")} {
+			if _, err := storage.Create(user); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if found := findIds(t, storage, "tag", "staff"); len(found) != 2 || !found[ann.Id] || !found[bea.Id] {
+			t.Errorf("staff: found %v", found)
+		}
+
+		// An update moves the record to its new keys
+		ann.Tags = []string{"staff"}
+		if _, err := storage.Update(ann.Id, ann); err != nil {
+			t.Fatal(err)
+		}
+		if found := findIds(t, storage, "tag", "admin"); len(found) != 0 {
+			t.Errorf("admin after the update: found %v", found)
+		}
+
+		// Only the live records are found
+		if _, err := storage.SoftDelete(bea.Id, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if found := findIds(t, storage, "tag", "staff"); len(found) != 1 || !found[ann.Id] {
+			t.Errorf("staff after the soft delete: found %v", found)
+		}
+		if _, err := storage.Restore(bea.Id, time.Now(), nil); err != nil {
+			t.Fatal(err)
+		}
+		if found := findIds(t, storage, "tag", "staff"); !found[bea.Id] {
+			t.Errorf("staff after the restore: found %v", found)
+		}
+		if _, err := storage.Delete(ann.Id); err != nil {
+			t.Fatal(err)
+		}
+		if found := findIds(t, storage, "tag", "staff"); len(found) != 1 || !found[bea.Id] {
+			t.Errorf("staff after the delete: found %v", found)
+		}
+
+		if _, err := Collect(storage.FindBy("team", "core", 2)); err != ErrUnknownIndex {
+			t.Errorf("unknown index: got %v, want %v", err, ErrUnknownIndex)
+		}
+	}, testTagIndex)
+}
+
+func TestCountedIndexesFollowTheWrites(t *testing.T) {
+	forEachIndexedStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		count := func(want map[string]int) {
+			t.Helper()
+			counts, err := storage.Count("name")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(counts) != len(want) {
+				t.Errorf("got the counts %v, want %v", counts, want)
+				return
+			}
+			for key, users := range want {
+				if counts[key] != users {
+					t.Errorf("got the counts %v, want %v", counts, want)
+					return
+				}
+			}
+		}
+		ann := testUser("ann")
+		for _, user := range []entities.User{ann, testUser("ann"), testUser("bea")} {
+			if _, err := storage.Create(user); err != nil {
+				t.Fatal(err)
+			}
+		}
+		count(map[string]int{"ann": 2, "bea": 1})
+
+		ann.Name = "cid"
+		if _, err := storage.Update(ann.Id, ann); err != nil {
+			t.Fatal(err)
+		}
+		count(map[string]int{"ann": 1, "bea": 1, "cid": 1})
+
+		if _, err := storage.SoftDelete(ann.Id, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		count(map[string]int{"ann": 1, "bea": 1})
+		if _, err := storage.Restore(ann.Id, time.Now(), nil); err != nil {
+			t.Fatal(err)
+		}
+		count(map[string]int{"ann": 1, "bea": 1, "cid": 1})
+		if _, err := storage.Delete(ann.Id); err != nil {
+			t.Fatal(err)
+		}
+		count(map[string]int{"ann": 1, "bea": 1})
+
+		if _, err := storage.Count("tag"); err != ErrNotCounted {
+			t.Errorf("an index not counted: got %v, want %v", err, ErrNotCounted)
+		}
+		if _, err := storage.Count("team"); err != ErrUnknownIndex {
+			t.Errorf("an unknown index: got %v, want %v", err, ErrUnknownIndex)
+		}
+	}, testTagIndex, testNameIndex)
+}
+
+func TestConcurrentUpdatesKeepTheIndexesOfTheStoredRecord(t *testing.T) {
+	forEachIndexedStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		ann := testUser("ann")
+		if _, err := storage.Create(ann); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				renamed := ann
+				renamed.Name = name
+				// A write losing every attempt to the others may fail, never leave stale keys
+				storage.Update(ann.Id, renamed)
+			}(fmt.Sprintf("user%d", i))
+		}
+		wg.Wait()
+
+		stored, err := storage.Get(ann.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts, err := storage.Count("name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != 1 || counts[stored.Name] != 1 {
+			t.Errorf("got the counts %v, want only %s", counts, stored.Name)
+		}
+	}, testNameIndex)
+}
@@ -3,31 +3,66 @@ package db
 import (
 	"errors"
 	"example/bootcamp_ex1/entities"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 var (
-	ErrUserNotFound = errors.New("cannot find a user with this id")
+	ErrUserNotFound    = errors.New("cannot find a user with this id")
+	ErrVersionNotFound = errors.New("cannot find this version")
 )
 
 type memoryStorage[T entities.StorageObject] struct {
+	mu       sync.RWMutex
 	entities map[uuid.UUID]T
+	deleted  map[uuid.UUID]Deleted[T]
+	versions map[uuid.UUID][]Version[T]
+	outbox   map[uuid.UUID]OutboxMessage
+	// pending has the ids of the messages to dispatch, dispatched the ids of the dispatched
+	// ones in the order they were, to drop them after the retention
+	pending    map[uuid.UUID]bool
+	dispatched []uuid.UUID
+	// indexed holds the ids of the live records by index name and key
+	indexes map[string]Index[T]
+	indexed map[string]map[string]map[uuid.UUID]bool
 }
 
-func NewMemoryStorage[T entities.StorageObject]() *memoryStorage[T] {
-	return &memoryStorage[T]{
+func NewMemoryStorage[T entities.StorageObject](indexes ...Index[T]) *memoryStorage[T] {
+	storage := &memoryStorage[T]{
 		entities: make(map[uuid.UUID]T),
+		deleted:  make(map[uuid.UUID]Deleted[T]),
+		versions: make(map[uuid.UUID][]Version[T]),
+		outbox:   make(map[uuid.UUID]OutboxMessage),
+		pending:  make(map[uuid.UUID]bool),
+		indexes:  indexesByName(indexes),
+		indexed:  make(map[string]map[string]map[uuid.UUID]bool),
 	}
+	for _, index := range indexes {
+		storage.indexed[index.Name] = make(map[string]map[uuid.UUID]bool)
+	}
+	return storage
 }
 
-func (m *memoryStorage[T]) Create(thing T) (uuid.UUID, error) {
+func (m *memoryStorage[T]) Create(thing T, outbox ...OutboxMessage) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	id := thing.GetId()
+	if previous, ok := m.entities[id]; ok {
+		m.unindex(previous)
+	}
 	m.entities[id] = thing
+	m.index(thing)
+	m.addVersion(thing, nil, false)
+	m.addOutbox(outbox)
 	return id, nil
 }
 
 func (m *memoryStorage[T]) Get(key uuid.UUID) (T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	value, ok := m.entities[key]
 	//If user doesn't exist we return a nil value and a error
 	if !ok {
@@ -38,31 +73,385 @@ func (m *memoryStorage[T]) Get(key uuid.UUID) (T, error) {
 }
 
 func (u *memoryStorage[T]) GetAll() ([]T, error) {
-	userList := make([]T, 0, len(u.entities))
-	for _, user := range u.entities {
-		userList = append(userList, user)
+	return Collect[T](u.Iterate(DefaultBatchSize))
+}
+
+func (u *memoryStorage[T]) Iterate(batchSize int) Iterator[T] {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	// Snapshot only the keys, values are read batch by batch
+	u.mu.RLock()
+	keys := make([]uuid.UUID, 0, len(u.entities))
+	for key := range u.entities {
+		keys = append(keys, key)
+	}
+	u.mu.RUnlock()
+
+	return &memoryIterator[T]{
+		storage:   u,
+		keys:      keys,
+		batchSize: batchSize,
+	}
+}
+
+func (u *memoryStorage[T]) FindBy(index string, key string, batchSize int) Iterator[T] {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	u.mu.RLock()
+	indexed, ok := u.indexed[index]
+	if !ok {
+		u.mu.RUnlock()
+		return NewErrorIterator[T](ErrUnknownIndex)
+	}
+	keys := make([]uuid.UUID, 0, len(indexed[key]))
+	for id := range indexed[key] {
+		keys = append(keys, id)
+	}
+	u.mu.RUnlock()
+
+	return &memoryIterator[T]{
+		storage:   u,
+		keys:      keys,
+		batchSize: batchSize,
 	}
-	return userList, nil
 }
 
-func (u *memoryStorage[T]) Update(key uuid.UUID, newUser T) (T, error) {
+// Count reads the counters from the sizes of the index sets, kept on every write
+func (u *memoryStorage[T]) Count(index string) (map[string]int, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if definition, ok := u.indexes[index]; !ok {
+		return nil, ErrUnknownIndex
+	} else if !definition.Counted {
+		return nil, ErrNotCounted
+	}
+	counts := make(map[string]int, len(u.indexed[index]))
+	for key, ids := range u.indexed[index] {
+		counts[key] = len(ids)
+	}
+	return counts, nil
+}
+
+func (u *memoryStorage[T]) Update(key uuid.UUID, newUser T, outbox ...OutboxMessage) (T, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
 	// If not exists return error
-	_, err := u.Get(key)
-	if err != nil {
+	previous, ok := u.entities[key]
+	if !ok {
 		var zeroValue T
 		return zeroValue, ErrUserNotFound
 	}
+	u.unindex(previous)
 	u.entities[key] = newUser
+	u.index(newUser)
+	u.addVersion(newUser, nil, false)
+	u.addOutbox(outbox)
 
 	return u.entities[key], nil
 }
+func (u *memoryStorage[T]) Swap(key uuid.UUID, current T, newUser T, outbox ...OutboxMessage) (T, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	previous, ok := u.entities[key]
+	if !ok {
+		var zeroValue T
+		return zeroValue, ErrUserNotFound
+	}
+	if !sameRecord(previous, current) {
+		var zeroValue T
+		return zeroValue, ErrStale
+	}
+	u.unindex(previous)
+	u.entities[key] = newUser
+	u.index(newUser)
+	u.addVersion(newUser, nil, false)
+	u.addOutbox(outbox)
+	return newUser, nil
+}
+
 func (u *memoryStorage[T]) Delete(key uuid.UUID) (uuid.UUID, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
 	// If not exists return error
-	_, err := u.Get(key)
-	if err != nil {
-		return uuid.Nil, err
+	value, ok := u.entities[key]
+	if !ok {
+		return uuid.Nil, ErrUserNotFound
 	}
 	// delete
+	u.unindex(value)
+	delete(u.entities, key)
+	delete(u.versions, key)
+	return key, nil
+}
+
+func (u *memoryStorage[T]) SoftDelete(key uuid.UUID, deletedAt time.Time, outbox ...OutboxMessage) (uuid.UUID, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	// If not exists return error
+	value, ok := u.entities[key]
+	if !ok {
+		return uuid.Nil, ErrUserNotFound
+	}
+	// Move the record to the deleted ones
+	u.deleted[key] = Deleted[T]{Record: value, DeletedAt: deletedAt}
+	u.unindex(value)
 	delete(u.entities, key)
+	u.addVersion(value, &deletedAt, true)
+	u.addOutbox(outbox)
 	return key, nil
 }
+
+func (u *memoryStorage[T]) Restore(key uuid.UUID, restoredAt time.Time, upgrade func(*T) bool, outbox ...OutboxMessage) (T, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	// If it was not deleted return error
+	deleted, ok := u.deleted[key]
+	if !ok {
+		var zeroValue T
+		return zeroValue, ErrUserNotFound
+	}
+	restored := deleted.Record
+	if upgrade != nil {
+		upgrade(&restored)
+	}
+	u.entities[key] = restored
+	u.index(restored)
+	delete(u.deleted, key)
+	u.addVersion(restored, &restoredAt, false)
+	u.addOutbox(outbox)
+	return restored, nil
+}
+
+func (u *memoryStorage[T]) GetDeleted() ([]Deleted[T], error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	deletedList := make([]Deleted[T], 0, len(u.deleted))
+	for _, deleted := range u.deleted {
+		deletedList = append(deletedList, deleted)
+	}
+	return deletedList, nil
+}
+
+func (u *memoryStorage[T]) GetDeletedRecord(key uuid.UUID) (Deleted[T], error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	deleted, ok := u.deleted[key]
+	if !ok {
+		return Deleted[T]{}, ErrUserNotFound
+	}
+	return deleted, nil
+}
+
+func (u *memoryStorage[T]) Purge(deletedBefore time.Time, outbox func(deleted Deleted[T]) ([]OutboxMessage, error)) ([]uuid.UUID, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	purged := make([]uuid.UUID, 0)
+	messages := make([]OutboxMessage, 0)
+	for key, deleted := range u.deleted {
+		if !deleted.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+		if outbox != nil {
+			recordMessages, err := outbox(deleted)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, recordMessages...)
+		}
+		purged = append(purged, key)
+	}
+	// Nothing is purged when a message fails
+	for _, key := range purged {
+		delete(u.deleted, key)
+		delete(u.versions, key)
+	}
+	u.addOutbox(messages)
+	return purged, nil
+}
+
+func (u *memoryStorage[T]) BackfillVersion(key uuid.UUID) (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	value, ok := u.entities[key]
+	if !ok {
+		return false, ErrUserNotFound
+	}
+	if len(u.versions[key]) > 0 {
+		return false, nil
+	}
+	return u.addVersion(value, nil, false), nil
+}
+
+func (u *memoryStorage[T]) GetVersions(key uuid.UUID) ([]Version[T], error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	versions, ok := u.versions[key]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return append([]Version[T](nil), versions...), nil
+}
+
+func (u *memoryStorage[T]) GetVersion(key uuid.UUID, number int) (Version[T], error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	versions := u.versions[key]
+	if number < 1 || number > len(versions) {
+		return Version[T]{}, ErrVersionNotFound
+	}
+	return versions[number-1], nil
+}
+
+func (u *memoryStorage[T]) GetAsOf(key uuid.UUID, at time.Time) (Version[T], error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return versionAsOf(u.versions[key], at)
+}
+
+func (u *memoryStorage[T]) PendingMessages(now time.Time, limit int) ([]OutboxMessage, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	// Old dispatched messages are dropped on the way
+	for len(u.dispatched) > 0 {
+		message := u.outbox[u.dispatched[0]]
+		if message.DispatchedAt != nil && now.Sub(*message.DispatchedAt) <= DispatchedRetention {
+			break
+		}
+		delete(u.outbox, u.dispatched[0])
+		u.dispatched = u.dispatched[1:]
+	}
+	pending := make([]OutboxMessage, 0)
+	for id := range u.pending {
+		if message := u.outbox[id]; !message.NextAttemptAt.After(now) {
+			pending = append(pending, message)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+	return pending, nil
+}
+
+func (u *memoryStorage[T]) MarkDispatched(id uuid.UUID, dispatchedAt time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	message, ok := u.outbox[id]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	message.DispatchedAt = &dispatchedAt
+	u.outbox[id] = message
+	if u.pending[id] {
+		delete(u.pending, id)
+		u.dispatched = append(u.dispatched, id)
+	}
+	return nil
+}
+
+func (u *memoryStorage[T]) MarkFailed(id uuid.UUID, nextAttemptAt time.Time, reason string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	message, ok := u.outbox[id]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	message.Attempts++
+	message.NextAttemptAt = nextAttemptAt
+	message.LastError = reason
+	u.outbox[id] = message
+	return nil
+}
+
+func (u *memoryStorage[T]) MarkDead(id uuid.UUID, deadAt time.Time, reason string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	message, ok := u.outbox[id]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	message.Attempts++
+	message.DeadAt = &deadAt
+	message.LastError = reason
+	u.outbox[id] = message
+	delete(u.pending, id)
+	return nil
+}
+
+// addVersion keeps the written state of a versioned record, the caller holds the lock of the record change
+func (u *memoryStorage[T]) addVersion(thing T, at *time.Time, deleted bool) bool {
+	version, ok := newVersion(thing, at, deleted)
+	if !ok {
+		return false
+	}
+	key := thing.GetId()
+	version.Number = len(u.versions[key]) + 1
+	u.versions[key] = append(u.versions[key], version)
+	return true
+}
+
+// addOutbox stores the messages, the caller holds the lock of the record change
+func (u *memoryStorage[T]) addOutbox(outbox []OutboxMessage) {
+	for _, message := range outbox {
+		u.outbox[message.Id] = message
+		u.pending[message.Id] = true
+	}
+}
+
+// index adds the live record to the indexes, the caller holds the write lock
+func (u *memoryStorage[T]) index(thing T) {
+	for name, index := range u.indexes {
+		for _, key := range index.keysOf(thing) {
+			if u.indexed[name][key] == nil {
+				u.indexed[name][key] = make(map[uuid.UUID]bool)
+			}
+			u.indexed[name][key][thing.GetId()] = true
+		}
+	}
+}
+
+// unindex removes the record from the indexes, the caller holds the write lock
+func (u *memoryStorage[T]) unindex(thing T) {
+	for name, index := range u.indexes {
+		for _, key := range index.keysOf(thing) {
+			delete(u.indexed[name][key], thing.GetId())
+			if len(u.indexed[name][key]) == 0 {
+				delete(u.indexed[name], key)
+			}
+		}
+	}
+}
+
+type memoryIterator[T entities.StorageObject] struct {
+	storage   *memoryStorage[T]
+	keys      []uuid.UUID
+	batchSize int
+	batch     []T
+}
+
+func (it *memoryIterator[T]) Next() bool {
+	it.batch = make([]T, 0, it.batchSize)
+	it.storage.mu.RLock()
+	defer it.storage.mu.RUnlock()
+	for len(it.keys) > 0 && len(it.batch) < it.batchSize {
+		key := it.keys[0]
+		it.keys = it.keys[1:]
+		// Records deleted after the snapshot are skipped
+		if value, ok := it.storage.entities[key]; ok {
+			it.batch = append(it.batch, value)
+		}
+	}
+	return len(it.batch) > 0
+}
+
+func (it *memoryIterator[T]) Batch() []T {
+	return it.batch
+}
+
+func (it *memoryIterator[T]) Err() error {
+	return nil
+}
@@ -0,0 +1,19 @@
+package db
+
+import (
+	"example/bootcamp_ex1/entities"
+	"testing"
+)
+
+func TestMemoryIterateKeepsTheBatchSize(t *testing.T) {
+	storage := NewMemoryStorage[entities.User]()
+	createUsers(t, storage, 7)
+	sizes := make([]int, 0)
+	iter := storage.Iterate(3)
+	for iter.Next() {
+		sizes = append(sizes, len(iter.Batch()))
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Errorf("got batches of %v records, want [3 3 1]", sizes)
+	}
+}
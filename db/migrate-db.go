@@ -0,0 +1,100 @@
+package db
+
+import (
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"time"
+)
+
+// Times a record written during its migration is read and upgraded again
+const migrateAttempts = 3
+
+// RunOnce runs the migration with this name unless the applied storage records it ran,
+// and records it when it succeeds. Two processes starting together may both run it, so
+// migrate must be safe to run again.
+func RunOnce(applied Storage[entities.Migration], name string, now time.Time, migrate func() (int, error)) (int, error) {
+	id := entities.MigrationId(name)
+	if _, err := applied.Get(id); err == nil {
+		return 0, nil
+	} else if !errors.Is(err, ErrUserNotFound) {
+		return 0, err
+	}
+	count, err := migrate()
+	if err != nil {
+		return count, err
+	}
+	_, err = applied.Create(entities.Migration{Id: id, Name: name, Count: count, AppliedAt: now})
+	return count, err
+}
+
+// Migrate rewrites the records of the storage changed by upgrade and returns how
+// many changed, upgrade must report false for the records already upgraded. Every
+// record is swapped from the state it was upgraded from, so a write of another
+// process meanwhile is never lost: the record is read and upgraded again.
+func Migrate[T entities.StorageObject](storage Storage[T], upgrade func(*T) bool) (int, error) {
+	migrated := 0
+	iter := storage.Iterate(DefaultBatchSize)
+	for iter.Next() {
+		for _, thing := range iter.Batch() {
+			changed, err := migrateRecord(storage, thing, upgrade)
+			if err != nil {
+				return migrated, err
+			}
+			if changed {
+				migrated++
+			}
+		}
+	}
+	return migrated, iter.Err()
+}
+
+// migrateRecord upgrades the record read as current and swaps it in
+func migrateRecord[T entities.StorageObject](storage Storage[T], current T, upgrade func(*T) bool) (bool, error) {
+	id := current.GetId()
+	for attempt := 1; ; attempt++ {
+		// upgrade changes a copy, current is the state the swap checks
+		upgraded, err := copyRecord(current)
+		if err != nil {
+			return false, err
+		}
+		if !upgrade(&upgraded) {
+			return false, nil
+		}
+		_, err = storage.Swap(id, current, upgraded)
+		// Deleted since it was read
+		if errors.Is(err, ErrUserNotFound) {
+			return false, nil
+		}
+		if !errors.Is(err, ErrStale) || attempt == migrateAttempts {
+			return err == nil, err
+		}
+		if current, err = storage.Get(id); errors.Is(err, ErrUserNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+// BackfillVersions stores the current state of the versioned records written before
+// they were versioned as their version 1, returning how many had none
+func BackfillVersions[T entities.StorageObject](storage Storage[T]) (int, error) {
+	backfilled := 0
+	iter := storage.Iterate(DefaultBatchSize)
+	for iter.Next() {
+		for _, thing := range iter.Batch() {
+			done, err := storage.BackfillVersion(thing.GetId())
+			// Deleted since the batch was read
+			if errors.Is(err, ErrUserNotFound) {
+				continue
+			}
+			if err != nil {
+				return backfilled, err
+			}
+			if done {
+				backfilled++
+			}
+		}
+	}
+	return backfilled, iter.Err()
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"example/bootcamp_ex1/entities"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMigrateUpgradesEveryRecordOnce(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		created := createUsers(t, storage, DefaultBatchSize+3)
+
+		migrated, err := Migrate(storage, (*entities.User).UpgradeAddresses)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migrated != len(created) {
+			t.Errorf("migrated %d users, want %d", migrated, len(created))
+		}
+		for id := range created {
+			user, err := storage.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := uuid.NewSHA1(id, []byte(entities.AddressHome))
+			if len(user.Addresses) != 1 || user.Addresses[0].Id != want || !user.Addresses[0].Primary ||
+				user.Addresses[0].Address != user.Address {
+				t.Fatalf("got the addresses %+v of %+v", user.Addresses, user.Address)
+			}
+		}
+
+		// Every instance runs the migration, the second run changes nothing
+		if migrated, err := Migrate(storage, (*entities.User).UpgradeAddresses); err != nil || migrated != 0 {
+			t.Errorf("migrating again: got %d, %v", migrated, err)
+		}
+	})
+}
+
+// dropVersions forgets the versions of the record, as if it was written before them
+func dropVersions(t *testing.T, storage Storage[entities.User], id uuid.UUID) {
+	t.Helper()
+	switch storage := storage.(type) {
+	case *memoryStorage[entities.User]:
+		delete(storage.versions, id)
+	case *redisStorage[entities.User]:
+		if err := storage.client.Del(context.Background(), storage.versionsPrefix+id.String()).Err(); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unknown storage %T", storage)
+	}
+}
+
+func TestBackfillVersionsStoresTheRecordsWithoutVersions(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		created := createUsers(t, storage, 3)
+		old := testUser("old")
+		old.UpdatedAt = time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+		if _, err := storage.Create(old); err != nil {
+			t.Fatal(err)
+		}
+		dropVersions(t, storage, old.Id)
+
+		if backfilled, err := BackfillVersions(storage); err != nil || backfilled != 1 {
+			t.Errorf("got %d, %v, want only the old record backfilled", backfilled, err)
+		}
+		versions, err := storage.GetVersions(old.Id)
+		if err != nil || len(versions) != 1 || versions[0].Number != 1 || !versions[0].Timestamp.Equal(old.UpdatedAt) {
+			t.Errorf("got the versions %+v, %v of the old record", versions, err)
+		}
+		for id := range created {
+			if versions, err := storage.GetVersions(id); err != nil || len(versions) != 1 {
+				t.Errorf("got the versions %+v, %v", versions, err)
+			}
+		}
+		if backfilled, err := BackfillVersions(storage); err != nil || backfilled != 0 {
+			t.Errorf("backfilling again: got %d, %v", backfilled, err)
+		}
+	})
+}
+
+// racingStorage renames the record before its first swap, like another process writing it
+// while it is migrated
+type racingStorage struct {
+	Storage[entities.User]
+	raced bool
+}
+
+func (r *racingStorage) Swap(id uuid.UUID, current entities.User, thing entities.User, outbox ...OutboxMessage) (entities.User, error) {
+	if !r.raced {
+		r.raced = true
+		renamed := current
+		renamed.Name = "renamed"
+		if _, err := r.Storage.Update(id, renamed); err != nil {
+			return entities.User{}, err
+		}
+	}
+	return r.Storage.Swap(id, current, thing, outbox...)
+}
+
+func TestMigrateKeepsTheWritesMadeMeanwhile(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		created := createUsers(t, storage, 1)
+
+		migrated, err := Migrate[entities.User](&racingStorage{Storage: storage}, (*entities.User).UpgradeAddresses)
+		if err != nil || migrated != 1 {
+			t.Fatalf("got %d, %v", migrated, err)
+		}
+		for id := range created {
+			user, _ := storage.Get(id)
+			if user.Name != "renamed" || len(user.Addresses) != 1 {
+				t.Errorf("got %+v, want the write made meanwhile upgraded", user)
+			}
+		}
+	})
+}
+
+func TestRunOnceRunsTheMigrationUntilItSucceeds(t *testing.T) {
+	applied := NewMemoryStorage[entities.Migration]()
+	now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+	runs := 0
+	failing := func() (int, error) {
+		runs++
+		return 0, ErrStale
+	}
+	succeeding := func() (int, error) {
+		runs++
+		return 2, nil
+	}
+
+	if _, err := RunOnce(applied, "addresses", now, failing); err != ErrStale {
+		t.Errorf("got %v, want the error of the migration", err)
+	}
+	if count, err := RunOnce(applied, "addresses", now, succeeding); err != nil || count != 2 {
+		t.Errorf("got %d, %v after the failed run", count, err)
+	}
+	if count, err := RunOnce(applied, "addresses", now, succeeding); err != nil || count != 0 {
+		t.Errorf("got %d, %v for an applied migration", count, err)
+	}
+	if runs != 2 {
+		t.Errorf("the migration ran %d times, want 2", runs)
+	}
+	migration, err := applied.Get(entities.MigrationId("addresses"))
+	if err != nil || migration.Count != 2 || !migration.AppliedAt.Equal(now) {
+		t.Errorf("got the applied migration %+v, %v", migration, err)
+	}
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Dispatched messages are kept this long before they are dropped
+const DispatchedRetention = 24 * time.Hour
+
+var (
+	ErrMessageNotFound = errors.New("cannot find an outbox message with this id")
+)
+
+// OutboxMessage is a message written in the same transaction as the record
+// change that produced it, delivered later by a relay
+type OutboxMessage struct {
+	Id            uuid.UUID       `json:"id"`
+	Topic         string          `json:"topic"`
+	Payload       json.RawMessage `json:"payload"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     string          `json:"last_error,omitempty"`
+	DispatchedAt  *time.Time      `json:"dispatched_at,omitempty"`
+	// Set when the message failed too many times, dead messages are never retried
+	DeadAt *time.Time `json:"dead_at,omitempty"`
+}
+
+type Outbox interface {
+	// PendingMessages returns up to limit undispatched messages due at the given time, oldest first
+	PendingMessages(now time.Time, limit int) ([]OutboxMessage, error)
+	MarkDispatched(id uuid.UUID, dispatchedAt time.Time) error
+	// MarkFailed counts a failed attempt and schedules the next one
+	MarkFailed(id uuid.UUID, nextAttemptAt time.Time, reason string) error
+	// MarkDead counts the last failed attempt and stops retrying the message, it is kept
+	// to be looked into
+	MarkDead(id uuid.UUID, deadAt time.Time, reason string) error
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"encoding/json"
+	"example/bootcamp_ex1/entities"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func testMessage(createdAt time.Time) OutboxMessage {
+	return OutboxMessage{
+		Id:            uuid.New(),
+		Topic:         "test",
+		Payload:       json.RawMessage(`{}`),
+		CreatedAt:     createdAt,
+		NextAttemptAt: createdAt,
+	}
+}
+
+func TestOutboxMessagesAreStoredWithTheChange(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+		user := testUser("ann")
+		created, updated, deleted, restored := testMessage(now), testMessage(now.Add(time.Second)), testMessage(now.Add(2*time.Second)), testMessage(now.Add(3*time.Second))
+		storage.Create(user, created)
+		storage.Update(user.Id, user, updated)
+		storage.SoftDelete(user.Id, now, deleted)
+		storage.Restore(user.Id, now.Add(3*time.Second), nil, restored)
+
+		pending, err := storage.PendingMessages(now.Add(time.Hour), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []uuid.UUID{created.Id, updated.Id, deleted.Id, restored.Id}
+		if len(pending) != len(want) {
+			t.Fatalf("got %d pending messages, want %d", len(pending), len(want))
+		}
+		for i, message := range pending {
+			if message.Id != want[i] {
+				t.Errorf("message %d: got %s, want %s", i, message.Id, want[i])
+			}
+		}
+		if due, _ := storage.PendingMessages(now.Add(time.Second), 10); len(due) != 2 {
+			t.Errorf("got %d messages due, want 2", len(due))
+		}
+		if limited, _ := storage.PendingMessages(now.Add(time.Hour), 3); len(limited) != 3 {
+			t.Errorf("got %d messages over the limit of 3", len(limited))
+		}
+
+		// A failed write stores no message
+		storage.Update(uuid.New(), user, testMessage(now))
+		if pending, _ := storage.PendingMessages(now.Add(time.Hour), 10); len(pending) != len(want) {
+			t.Errorf("got %d pending messages after a failed update", len(pending))
+		}
+	})
+}
+
+func TestOutboxMessagesAreRetriedUntilDispatched(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+		message := testMessage(now)
+		storage.Create(testUser("ann"), message)
+
+		if err := storage.MarkFailed(message.Id, now.Add(time.Minute), "down"); err != nil {
+			t.Fatal(err)
+		}
+		if due, _ := storage.PendingMessages(now, 10); len(due) != 0 {
+			t.Errorf("the failed message is due before its next attempt")
+		}
+		pending, _ := storage.PendingMessages(now.Add(time.Minute), 10)
+		if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError != "down" {
+			t.Fatalf("got the pending messages %+v", pending)
+		}
+
+		if err := storage.MarkDispatched(message.Id, now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if pending, _ := storage.PendingMessages(now.Add(time.Hour), 10); len(pending) != 0 {
+			t.Errorf("the dispatched message is pending: %+v", pending)
+		}
+		if err := storage.MarkDispatched(uuid.New(), now); err != ErrMessageNotFound {
+			t.Errorf("got %v, want %v", err, ErrMessageNotFound)
+		}
+	})
+}
+
+func TestDeadMessagesAreNoLongerPending(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+		dead, live := testMessage(now), testMessage(now)
+		storage.Create(testUser("ann"), dead)
+		storage.Create(testUser("bea"), live)
+
+		if err := storage.MarkDead(dead.Id, now, "poison"); err != nil {
+			t.Fatal(err)
+		}
+		pending, _ := storage.PendingMessages(now.Add(time.Hour), 10)
+		if len(pending) != 1 || pending[0].Id != live.Id {
+			t.Errorf("got the pending messages %+v, want only %s", pending, live.Id)
+		}
+		if err := storage.MarkDead(uuid.New(), now, "poison"); err != ErrMessageNotFound {
+			t.Errorf("got %v, want %v", err, ErrMessageNotFound)
+		}
+	})
+}
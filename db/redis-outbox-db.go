@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func (r *redisStorage[T]) PendingMessages(now time.Time, limit int) ([]OutboxMessage, error) {
+	ctx := context.Background()
+	ids, err := r.client.ZRangeByScore(ctx, r.outboxPending, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrConsultingRecords
+	}
+	messages := make([]OutboxMessage, 0, len(ids))
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, r.outboxPrefix+id)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrConsultingRecords
+	}
+	for _, val := range values {
+		if val == nil {
+			continue
+		}
+		message := new(OutboxMessage)
+		if err := json.Unmarshal([]byte(fmt.Sprint(val)), message); err != nil {
+			return nil, ErrUnmarshalingRecord
+		}
+		messages = append(messages, *message)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+	return messages, nil
+}
+
+func (r *redisStorage[T]) MarkDispatched(id uuid.UUID, dispatchedAt time.Time) error {
+	return r.updateMessage(id, func(message *OutboxMessage, pipe redis.Pipeliner, key string) {
+		ctx := context.Background()
+		message.DispatchedAt = &dispatchedAt
+		pipe.ZRem(ctx, r.outboxPending, id.String())
+		// Dispatched messages expire on their own
+		pipe.Expire(ctx, key, DispatchedRetention)
+	})
+}
+
+func (r *redisStorage[T]) MarkFailed(id uuid.UUID, nextAttemptAt time.Time, reason string) error {
+	return r.updateMessage(id, func(message *OutboxMessage, pipe redis.Pipeliner, key string) {
+		message.Attempts++
+		message.NextAttemptAt = nextAttemptAt
+		message.LastError = reason
+		pipe.ZAdd(context.Background(), r.outboxPending, redis.Z{Score: float64(nextAttemptAt.UnixMilli()), Member: id.String()})
+	})
+}
+
+func (r *redisStorage[T]) MarkDead(id uuid.UUID, deadAt time.Time, reason string) error {
+	return r.updateMessage(id, func(message *OutboxMessage, pipe redis.Pipeliner, key string) {
+		message.Attempts++
+		message.DeadAt = &deadAt
+		message.LastError = reason
+		pipe.ZRem(context.Background(), r.outboxPending, id.String())
+	})
+}
+
+// updateMessage changes a stored message, update queues the extra commands of the change
+func (r *redisStorage[T]) updateMessage(id uuid.UUID, update func(message *OutboxMessage, pipe redis.Pipeliner, key string)) error {
+	ctx := context.Background()
+	key := r.outboxPrefix + id.String()
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		value, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			return ErrMessageNotFound
+		}
+		message := new(OutboxMessage)
+		if err := json.Unmarshal([]byte(value), message); err != nil {
+			return ErrUnmarshalingRecord
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			update(message, pipe, key)
+			serialized, err := json.Marshal(message)
+			if err != nil {
+				return ErrMarshalingRecord
+			}
+			// KeepTTL so a dispatched message keeps expiring
+			pipe.SetArgs(ctx, key, string(serialized), redis.SetArgs{KeepTTL: true})
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// queueOutbox adds the messages to a MULTI/EXEC pipeline
+func (r *redisStorage[T]) queueOutbox(ctx context.Context, pipe redis.Pipeliner, outbox []OutboxMessage) error {
+	for _, message := range outbox {
+		serialized, err := json.Marshal(message)
+		if err != nil {
+			return ErrMarshalingRecord
+		}
+		pipe.Set(ctx, r.outboxPrefix+message.Id.String(), string(serialized), 0)
+		pipe.ZAdd(ctx, r.outboxPending, redis.Z{Score: float64(message.NextAttemptAt.UnixMilli()), Member: message.Id.String()})
+	}
+	return nil
+}
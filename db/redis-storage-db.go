@@ -9,6 +9,11 @@ import (
 	"log/slog"
 	"os"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
@@ -21,28 +26,79 @@ var (
 	ErrMarshalingRecord   = errors.New("error unmarshaling record")
 )
 
+// watchAttempts is how many times a write is tried while other clients change its watched keys
+const watchAttempts = 3
+
+var (
+	redisClient     *redis.Client
+	redisClientOnce sync.Once
+)
+
 type redisStorage[T entities.StorageObject] struct {
 	client *redis.Client
 	prefix string
+	// Soft deleted records live under their own prefix, indexed by deletion time in a sorted set
+	deletedPrefix string
+	deletedIndex  string
+	// Versions are kept in a list per record, the version number is its position
+	versionsPrefix string
+	// Outbox messages are stored by id, the pending ones indexed by next attempt in a sorted set
+	outboxPrefix  string
+	outboxPending string
+	// Every index key is a set with the ids of the live records having it, the set
+	// at builtIndexes has the indexes built for the records written before them
+	indexes      map[string]Index[T]
+	indexPrefix  string
+	builtIndexes string
+	// Counted indexes also keep a hash of the number of records per key
+	countPrefix string
 }
 
-func NewRedisStorage[T entities.StorageObject]() *redisStorage[T] {
-	redisStorage := new(redisStorage[T])
-	// Creating and assigning client
-	redisStorage.client = redis.NewClient(&redis.Options{
-		Addr: os.Getenv("REDIS_HOST"),
-	})
-	// Assigning prefix to search in redis. it has the form of "entityType:id" "user:b6cfb84-4831-429e-a61b-4d28b154fb8c"
-	redisStorage.prefix = reflect.TypeOf(new(T)).String() + ":"
+// RedisClient returns the client shared by every redis backed component, it
+// connects on the first call
+func RedisClient() *redis.Client {
+	redisClientOnce.Do(func() {
+		// Creating and assigning client
+		redisClient = redis.NewClient(&redis.Options{
+			Addr: os.Getenv("REDIS_HOST"),
+		})
 
-	// Verifying Connection
-	_, err := redisStorage.client.Ping(context.Background()).Result()
+		// Verifying Connection
+		_, err := redisClient.Ping(context.Background()).Result()
 
-	if err != nil {
-		slog.Error(ErrConnectionFailed.Error(), err)
-		panic(err)
+		if err != nil {
+			slog.Error(ErrConnectionFailed.Error(), "error", err)
+			panic(err)
+		}
+		slog.Info("Connection succesful with redis")
+	})
+	return redisClient
+}
+
+// NewRedisStorage keeps the records of the tenant, the keys of the other tenants
+// start with "tenant:name:" so the default tenant keeps the keys it always had
+func NewRedisStorage[T entities.StorageObject](tenant string, indexes ...Index[T]) *redisStorage[T] {
+	redisStorage := new(redisStorage[T])
+	redisStorage.client = RedisClient()
+	tenantPrefix := ""
+	if tenant != DefaultTenant {
+		tenantPrefix = "tenant:" + tenant + ":"
+	}
+	entityType := reflect.TypeOf(new(T)).String()
+	// Assigning prefix to search in redis. it has the form of "entityType:id" "user:b6cfb84-4831-429e-a61b-4d28b154fb8c"
+	redisStorage.prefix = tenantPrefix + entityType + ":"
+	redisStorage.deletedPrefix = tenantPrefix + "deleted:" + entityType + ":"
+	redisStorage.deletedIndex = tenantPrefix + "deleted:" + entityType
+	redisStorage.versionsPrefix = tenantPrefix + "versions:" + entityType + ":"
+	redisStorage.outboxPrefix = tenantPrefix + "outbox:" + entityType + ":"
+	redisStorage.outboxPending = tenantPrefix + "outbox:pending:" + entityType
+	redisStorage.indexes = indexesByName(indexes)
+	redisStorage.indexPrefix = tenantPrefix + "index:" + entityType + ":"
+	redisStorage.builtIndexes = tenantPrefix + "indexes:" + entityType
+	redisStorage.countPrefix = tenantPrefix + "counts:" + entityType + ":"
+	if err := redisStorage.buildIndexes(); err != nil {
+		slog.Error(err.Error(), "tenant", tenant, "type", entityType)
 	}
-	slog.Info("Connection succesful with redis")
 
 	// Returning instance
 	return redisStorage
@@ -53,12 +109,60 @@ func (r *redisStorage[T]) Get(id uuid.UUID) (T, error) {
 }
 
 func (r *redisStorage[T]) GetAll() ([]T, error) {
-	return r.getAllValuesCache()
+	return Collect[T](r.Iterate(DefaultBatchSize))
+}
+
+func (r *redisStorage[T]) Iterate(batchSize int) Iterator[T] {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &redisIterator[T]{
+		storage:   r,
+		match:     escapeGlob(r.prefix) + "*",
+		batchSize: int64(batchSize),
+	}
+}
+
+func (r *redisStorage[T]) FindBy(index string, key string, batchSize int) Iterator[T] {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	if _, ok := r.indexes[index]; !ok {
+		return NewErrorIterator[T](ErrUnknownIndex)
+	}
+	return &redisIterator[T]{
+		storage:   r,
+		set:       r.indexKey(index, key),
+		batchSize: int64(batchSize),
+	}
+}
+
+func (r *redisStorage[T]) Count(index string) (map[string]int, error) {
+	if definition, ok := r.indexes[index]; !ok {
+		return nil, ErrUnknownIndex
+	} else if !definition.Counted {
+		return nil, ErrNotCounted
+	}
+	values, err := r.client.HGetAll(context.Background(), r.countPrefix+index).Result()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrConsultingRecords
+	}
+	counts := make(map[string]int, len(values))
+	for key, value := range values {
+		// The keys no record has anymore stay in the hash with 0
+		if count, err := strconv.Atoi(value); err == nil && count > 0 {
+			counts[key] = count
+		}
+	}
+	return counts, nil
 }
 
-func (r *redisStorage[T]) Create(thing T) (uuid.UUID, error) {
+func (r *redisStorage[T]) Create(thing T, outbox ...OutboxMessage) (uuid.UUID, error) {
 	id := thing.GetId()
-	err := r.setValueCache(id.String(), thing)
+	err := retryWatched(func() error {
+		return r.setValueCache(id.String(), thing, outbox)
+	})
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -66,48 +170,367 @@ func (r *redisStorage[T]) Create(thing T) (uuid.UUID, error) {
 	return id, nil
 }
 
-func (r *redisStorage[T]) Update(id uuid.UUID, thing T) (T, error) {
+func (r *redisStorage[T]) Update(id uuid.UUID, thing T, outbox ...OutboxMessage) (T, error) {
 	//If thing not exists return error
-	_, err := r.Get(id)
-	var zeroValue T
+	err := retryWatched(func() error {
+		return r.replace(id, nil, thing, outbox)
+	})
 	if err != nil {
+		slog.Error(err.Error())
+		var zeroValue T
 		return zeroValue, err
 	}
-	//Updating new record
-	err = r.setValueCache(id.String(), thing)
+
+	return thing, nil
+
+}
+
+func (r *redisStorage[T]) Swap(id uuid.UUID, current T, thing T, outbox ...OutboxMessage) (T, error) {
+	var zeroValue T
+	err := r.replace(id, &current, thing, outbox)
+	if errors.Is(err, redis.TxFailedErr) {
+		return zeroValue, ErrStale
+	}
 	if err != nil {
 		return zeroValue, err
 	}
-
 	return thing, nil
-
 }
 
 func (r *redisStorage[T]) Delete(id uuid.UUID) (uuid.UUID, error) {
-	//If thing not exists return error
-	_, err := r.Get(id)
+	ctx := context.Background()
+	key := r.prefix + id.String()
+	// The key is watched so the index keys removed are those of the record deleted
+	err := retryWatched(func() error {
+		return r.client.Watch(ctx, func(tx *redis.Tx) error {
+			//If thing not exists return error
+			value, err := r.watchedRecord(ctx, tx, key)
+			if err != nil {
+				return err
+			}
+			// Delete thing and its versions
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, key, r.versionsPrefix+id.String())
+				r.queueIndex(ctx, pipe, *value, false)
+				return nil
+			})
+			return err
+		}, key)
+	})
 	if err != nil {
 		return uuid.Nil, err
 	}
-	// Delete thing
+
+	return id, nil
+
+}
+
+func (r *redisStorage[T]) SoftDelete(id uuid.UUID, deletedAt time.Time, outbox ...OutboxMessage) (uuid.UUID, error) {
+	ctx := context.Background()
 	key := r.prefix + id.String()
-	err = r.client.Del(context.Background(), key).Err()
+	// The live key is watched so the record can't change while it is moved
+	err := retryWatched(func() error {
+		return r.client.Watch(ctx, func(tx *redis.Tx) error {
+			record, err := r.watchedRecord(ctx, tx, key)
+			if err != nil {
+				return err
+			}
+			serialized, err := json.Marshal(Deleted[T]{Record: *record, DeletedAt: deletedAt})
+			if err != nil {
+				return ErrMarshalingRecord
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, r.deletedPrefix+id.String(), string(serialized), 0)
+				pipe.ZAdd(ctx, r.deletedIndex, redis.Z{Score: float64(deletedAt.UnixMilli()), Member: id.String()})
+				pipe.Del(ctx, key)
+				r.queueIndex(ctx, pipe, *record, false)
+				if _, err := r.queueVersion(ctx, pipe, *record, &deletedAt, true); err != nil {
+					return err
+				}
+				return r.queueOutbox(ctx, pipe, outbox)
+			})
+			return err
+		}, key)
+	})
 	if err != nil {
+		slog.Error(err.Error())
 		return uuid.Nil, err
 	}
 
 	return id, nil
+}
+
+func (r *redisStorage[T]) Restore(id uuid.UUID, restoredAt time.Time, upgrade func(*T) bool, outbox ...OutboxMessage) (T, error) {
+	ctx := context.Background()
+	deletedKey := r.deletedPrefix + id.String()
+	var restored T
+	err := retryWatched(func() error {
+		return r.client.Watch(ctx, func(tx *redis.Tx) error {
+			value, err := tx.Get(ctx, deletedKey).Result()
+			if err != nil {
+				return ErrUserNotFound
+			}
+			deleted := new(Deleted[T])
+			if err := json.Unmarshal([]byte(value), deleted); err != nil {
+				return ErrUnmarshalingRecord
+			}
+			if upgrade != nil {
+				upgrade(&deleted.Record)
+			}
+			serialized, err := json.Marshal(deleted.Record)
+			if err != nil {
+				return ErrMarshalingRecord
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, r.prefix+id.String(), string(serialized), 0)
+				pipe.ZRem(ctx, r.deletedIndex, id.String())
+				pipe.Del(ctx, deletedKey)
+				r.queueIndex(ctx, pipe, deleted.Record, true)
+				if _, err := r.queueVersion(ctx, pipe, deleted.Record, &restoredAt, false); err != nil {
+					return err
+				}
+				return r.queueOutbox(ctx, pipe, outbox)
+			})
+			restored = deleted.Record
+			return err
+		}, deletedKey)
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		var zeroValue T
+		return zeroValue, err
+	}
+
+	return restored, nil
+}
+
+func (r *redisStorage[T]) GetDeleted() ([]Deleted[T], error) {
+	ctx := context.Background()
+	ids, err := r.client.ZRange(ctx, r.deletedIndex, 0, -1).Result()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrConsultingRecords
+	}
+	deletedList := make([]Deleted[T], 0, len(ids))
+	if len(ids) == 0 {
+		return deletedList, nil
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, r.deletedPrefix+id)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrConsultingRecords
+	}
+	for _, val := range values {
+		if val == nil {
+			continue
+		}
+		deleted := new(Deleted[T])
+		if err := json.Unmarshal([]byte(fmt.Sprint(val)), deleted); err != nil {
+			return nil, ErrUnmarshalingRecord
+		}
+		deletedList = append(deletedList, *deleted)
+	}
+
+	return deletedList, nil
+}
+
+func (r *redisStorage[T]) GetDeletedRecord(id uuid.UUID) (Deleted[T], error) {
+	value, err := r.client.Get(context.Background(), r.deletedPrefix+id.String()).Result()
+	if errors.Is(err, redis.Nil) {
+		return Deleted[T]{}, ErrUserNotFound
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		return Deleted[T]{}, ErrConsultingRecords
+	}
+	deleted := new(Deleted[T])
+	if err := json.Unmarshal([]byte(value), deleted); err != nil {
+		return Deleted[T]{}, ErrUnmarshalingRecord
+	}
+	return *deleted, nil
+}
+
+func (r *redisStorage[T]) Purge(deletedBefore time.Time, outbox func(deleted Deleted[T]) ([]OutboxMessage, error)) ([]uuid.UUID, error) {
+	ctx := context.Background()
+	// Only the records deleted strictly before the given time
+	ids, err := r.client.ZRangeByScore(ctx, r.deletedIndex, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(deletedBefore.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrConsultingRecords
+	}
+
+	purged := make([]uuid.UUID, 0, len(ids))
+	if len(ids) == 0 {
+		return purged, nil
+	}
+	deletedKeys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		deletedKeys = append(deletedKeys, r.deletedPrefix+id)
+	}
+	// The deleted records are watched so a record restored meanwhile isn't purged
+	err = r.client.Watch(ctx, func(tx *redis.Tx) error {
+		purged = purged[:0]
+		values, err := tx.MGet(ctx, deletedKeys...).Result()
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(ids))
+		members := make([]any, 0, len(ids))
+		messages := make([]OutboxMessage, 0)
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+			deleted := new(Deleted[T])
+			if err := json.Unmarshal([]byte(fmt.Sprint(value)), deleted); err != nil {
+				return ErrUnmarshalingRecord
+			}
+			if outbox != nil {
+				recordMessages, err := outbox(*deleted)
+				if err != nil {
+					return err
+				}
+				messages = append(messages, recordMessages...)
+			}
+			purged = append(purged, deleted.Record.GetId())
+			keys = append(keys, deletedKeys[i], r.versionsPrefix+ids[i])
+			members = append(members, ids[i])
+		}
+		if len(purged) == 0 {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, keys...)
+			pipe.ZRem(ctx, r.deletedIndex, members...)
+			return r.queueOutbox(ctx, pipe, messages)
+		})
+		return err
+	}, deletedKeys...)
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, err
+	}
 
+	return purged, nil
 }
 
-func (r *redisStorage[T]) setValueCache(key string, thing T) error {
+func (r *redisStorage[T]) BackfillVersion(id uuid.UUID) (bool, error) {
+	ctx := context.Background()
+	key := r.prefix + id.String()
+	versionsKey := r.versionsPrefix + id.String()
+	backfilled := false
+	// Watched so a write storing the first version meanwhile isn't preceded by this one
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		length, err := tx.LLen(ctx, versionsKey).Result()
+		if err != nil || length > 0 {
+			return err
+		}
+		value, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			return ErrUserNotFound
+		}
+		record := new(T)
+		if err := json.Unmarshal([]byte(value), record); err != nil {
+			return ErrUnmarshalingRecord
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			backfilled, err = r.queueVersion(ctx, pipe, *record, nil, false)
+			return err
+		})
+		return err
+	}, key, versionsKey)
+	if err != nil {
+		return false, err
+	}
+	return backfilled, nil
+}
+
+func (r *redisStorage[T]) GetVersions(id uuid.UUID) ([]Version[T], error) {
+	values, err := r.client.LRange(context.Background(), r.versionsPrefix+id.String(), 0, -1).Result()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrConsultingRecords
+	}
+	if len(values) == 0 {
+		return nil, ErrUserNotFound
+	}
+	versions := make([]Version[T], 0, len(values))
+	for i, value := range values {
+		version, err := r.decodeVersion(value, i+1)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (r *redisStorage[T]) GetVersion(id uuid.UUID, number int) (Version[T], error) {
+	if number < 1 {
+		return Version[T]{}, ErrVersionNotFound
+	}
+	value, err := r.client.LIndex(context.Background(), r.versionsPrefix+id.String(), int64(number-1)).Result()
+	if err != nil {
+		return Version[T]{}, ErrVersionNotFound
+	}
+	return r.decodeVersion(value, number)
+}
+
+func (r *redisStorage[T]) GetAsOf(id uuid.UUID, at time.Time) (Version[T], error) {
+	versions, err := r.GetVersions(id)
+	if err != nil {
+		return Version[T]{}, ErrVersionNotFound
+	}
+	return versionAsOf(versions, at)
+}
+
+func (r *redisStorage[T]) decodeVersion(value string, number int) (Version[T], error) {
+	version := new(Version[T])
+	if err := json.Unmarshal([]byte(value), version); err != nil {
+		return Version[T]{}, ErrUnmarshalingRecord
+	}
+	version.Number = number
+	return *version, nil
+}
+
+// setValueCache writes the record, with its key watched so a record stored there meanwhile
+// has its index keys moved
+func (r *redisStorage[T]) setValueCache(key string, thing T, outbox []OutboxMessage) error {
 	ctx := context.Background()
 	serialized, err := json.Marshal(thing)
 	if err != nil {
 		return ErrMarshalingRecord
 	}
 	key = r.prefix + key
-	err = r.client.Set(ctx, key, string(serialized), 0).Err()
+	err = r.client.Watch(ctx, func(tx *redis.Tx) error {
+		previous, err := r.watchedRecord(ctx, tx, key)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		// The record, its version and its outbox messages are written in one MULTI/EXEC
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, string(serialized), 0)
+			if previous != nil {
+				r.queueIndex(ctx, pipe, *previous, false)
+			}
+			r.queueIndex(ctx, pipe, thing, true)
+			if _, err := r.queueVersion(ctx, pipe, thing, nil, false); err != nil {
+				return err
+			}
+			return r.queueOutbox(ctx, pipe, outbox)
+		})
+		return err
+	}, key)
 	if err != nil {
 		slog.Error(err.Error())
 		return err
@@ -116,6 +539,65 @@ func (r *redisStorage[T]) setValueCache(key string, thing T) error {
 	return nil
 }
 
+// replace writes the live record, with its key watched so the index keys moved are those of
+// the record replaced. With current, the record is only replaced while it is stored as current.
+func (r *redisStorage[T]) replace(id uuid.UUID, current *T, thing T, outbox []OutboxMessage) error {
+	ctx := context.Background()
+	key := r.prefix + id.String()
+	serialized, err := json.Marshal(thing)
+	if err != nil {
+		return ErrMarshalingRecord
+	}
+	return r.client.Watch(ctx, func(tx *redis.Tx) error {
+		previous, err := r.watchedRecord(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if current != nil && !sameRecord(*previous, *current) {
+			return ErrStale
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, string(serialized), 0)
+			r.queueIndex(ctx, pipe, *previous, false)
+			r.queueIndex(ctx, pipe, thing, true)
+			if _, err := r.queueVersion(ctx, pipe, thing, nil, false); err != nil {
+				return err
+			}
+			return r.queueOutbox(ctx, pipe, outbox)
+		})
+		return err
+	}, key)
+}
+
+// watchedRecord reads the record at the watched key, ErrUserNotFound when there is none
+func (r *redisStorage[T]) watchedRecord(ctx context.Context, tx *redis.Tx, key string) (*T, error) {
+	value, err := tx.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := new(T)
+	if err := json.Unmarshal([]byte(value), record); err != nil {
+		return nil, ErrUnmarshalingRecord
+	}
+	return record, nil
+}
+
+// retryWatched runs the watched write again while another client changes its keys first
+func retryWatched(write func() error) error {
+	var err error
+	for attempt := 0; attempt < watchAttempts; attempt++ {
+		err = write()
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
 func (r *redisStorage[T]) getValueCache(key string) (T, error) {
 	// Try to get the value
 	ctx := context.Background()
@@ -140,23 +622,9 @@ func (r *redisStorage[T]) getValueCache(key string) (T, error) {
 
 }
 
-func (r *redisStorage[T]) getAllValuesCache() ([]T, error) {
-	// Getting all the keys of the existing thing records
+func (r *redisStorage[T]) getValuesCache(keys []string) ([]T, error) {
 	ctx := context.Background()
-	prefix := r.prefix + "*"
-	iter := r.client.Scan(ctx, 0, prefix, 0).Iterator()
-	things := make([]T, 0)
-	keys := make([]string, 0)
-
-	for iter.Next(ctx) {
-		value := iter.Val()
-		keys = append(keys, value)
-	}
-	if err := iter.Err(); err != nil {
-		slog.Error(err.Error())
-		return nil, err
-	}
-
+	things := make([]T, 0, len(keys))
 	if len(keys) == 0 {
 		return things, nil
 	}
@@ -168,11 +636,15 @@ func (r *redisStorage[T]) getAllValuesCache() ([]T, error) {
 	}
 
 	for _, val := range values {
+		// Keys deleted between SCAN and MGET come back as nil
+		if val == nil {
+			continue
+		}
 		jsonValue := fmt.Sprint(val)
 		currentThing := new(T)
 		err := json.Unmarshal([]byte(jsonValue), currentThing)
 		if err != nil {
-			return nil, err
+			return nil, ErrUnmarshalingRecord
 		}
 
 		things = append(things, *currentThing)
@@ -180,3 +652,156 @@ func (r *redisStorage[T]) getAllValuesCache() ([]T, error) {
 
 	return things, nil
 }
+
+// queueVersion appends the written state of a versioned record to its list, the number of a
+// version is its position
+func (r *redisStorage[T]) queueVersion(ctx context.Context, pipe redis.Pipeliner, thing T, at *time.Time, deleted bool) (bool, error) {
+	version, ok := newVersion(thing, at, deleted)
+	if !ok {
+		return false, nil
+	}
+	serialized, err := json.Marshal(version)
+	if err != nil {
+		return false, ErrMarshalingRecord
+	}
+	pipe.RPush(ctx, r.versionsPrefix+thing.GetId().String(), string(serialized))
+	return true, nil
+}
+
+// queueIndex adds the record to its index keys, or removes it from them, and counts it
+func (r *redisStorage[T]) queueIndex(ctx context.Context, pipe redis.Pipeliner, thing T, add bool) {
+	id := thing.GetId().String()
+	for name, index := range r.indexes {
+		for _, key := range index.keysOf(thing) {
+			if add {
+				pipe.SAdd(ctx, r.indexKey(name, key), id)
+			} else {
+				pipe.SRem(ctx, r.indexKey(name, key), id)
+			}
+			if !index.Counted {
+				continue
+			}
+			if add {
+				pipe.HIncrBy(ctx, r.countPrefix+name, key, 1)
+			} else {
+				pipe.HIncrBy(ctx, r.countPrefix+name, key, -1)
+			}
+		}
+	}
+}
+
+// buildIndexes indexes, and counts, the stored records in the indexes that were never
+// built, like the ones added after the records were written. Records written by another
+// process while it runs may be counted wrong.
+func (r *redisStorage[T]) buildIndexes() error {
+	ctx := context.Background()
+	built, err := r.client.SMembers(ctx, r.builtIndexes).Result()
+	if err != nil {
+		return err
+	}
+	missing := make([]Index[T], 0)
+	for name, index := range r.indexes {
+		if !slices.Contains(built, name) {
+			missing = append(missing, index)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	records, err := r.GetAll()
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, index := range missing {
+			counts := make(map[string]any)
+			for _, record := range records {
+				for _, key := range index.keysOf(record) {
+					pipe.SAdd(ctx, r.indexKey(index.Name, key), record.GetId().String())
+					count, _ := counts[key].(int)
+					counts[key] = count + 1
+				}
+			}
+			if index.Counted {
+				pipe.Del(ctx, r.countPrefix+index.Name)
+				if len(counts) > 0 {
+					pipe.HSet(ctx, r.countPrefix+index.Name, counts)
+				}
+			}
+			pipe.SAdd(ctx, r.builtIndexes, index.Name)
+		}
+		return nil
+	})
+	if err == nil {
+		slog.Info("Built indexes", "records", len(records), "indexes", len(missing))
+	}
+	return err
+}
+
+func (r *redisStorage[T]) indexKey(index string, key string) string {
+	return r.indexPrefix + index + ":" + key
+}
+
+// redisIterator follows the SCAN cursor, loading one MGET per cursor batch. With
+// a set it follows the SSCAN cursor of the ids in the set instead.
+type redisIterator[T entities.StorageObject] struct {
+	storage   *redisStorage[T]
+	match     string
+	set       string
+	batchSize int64
+	cursor    uint64
+	done      bool
+	batch     []T
+	err       error
+}
+
+func (it *redisIterator[T]) Next() bool {
+	ctx := context.Background()
+	// SCAN may return empty pages, keep going until a page has records or the cursor ends
+	for !it.done && it.err == nil {
+		keys, cursor, err := it.scan(ctx)
+		if err != nil {
+			slog.Error(err.Error())
+			it.err = ErrConsultingRecords
+			return false
+		}
+		it.cursor = cursor
+		it.done = cursor == 0
+
+		it.batch, it.err = it.storage.getValuesCache(keys)
+		if it.err != nil {
+			return false
+		}
+		if len(it.batch) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *redisIterator[T]) scan(ctx context.Context) ([]string, uint64, error) {
+	if it.set == "" {
+		return it.storage.client.Scan(ctx, it.cursor, it.match, it.batchSize).Result()
+	}
+	ids, cursor, err := it.storage.client.SScan(ctx, it.set, it.cursor, "", it.batchSize).Result()
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, it.storage.prefix+id)
+	}
+	return keys, cursor, err
+}
+
+func (it *redisIterator[T]) Batch() []T {
+	return it.batch
+}
+
+func (it *redisIterator[T]) Err() error {
+	return it.err
+}
+
+// escapeGlob escapes the redis glob characters, the type based prefix starts with "*"
+func escapeGlob(pattern string) string {
+	replacer := strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`, `\`, `\\`)
+	return replacer.Replace(pattern)
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"example/bootcamp_ex1/entities"
+	"os"
+	"testing"
+)
+
+// newTestRedisStorage returns a storage of the redis at REDIS_HOST with its own key prefix,
+// removed after the test. The test is skipped without REDIS_HOST.
+func newTestRedisStorage[T entities.StorageObject](t *testing.T, indexes ...Index[T]) *redisStorage[T] {
+	t.Helper()
+	if os.Getenv("REDIS_HOST") == "" {
+		t.Skip("REDIS_HOST is not set")
+	}
+	// The indexes are set once the keys are the test's, so they are built on them
+	storage := NewRedisStorage[T](DefaultTenant)
+	storage.prefix = "test:" + t.Name() + ":"
+	storage.deletedPrefix = "deleted:" + storage.prefix
+	storage.deletedIndex = "deleted:test:" + t.Name()
+	storage.versionsPrefix = "versions:" + storage.prefix
+	storage.outboxPrefix = "outbox:" + storage.prefix
+	storage.outboxPending = "outbox:pending:test:" + t.Name()
+	storage.indexPrefix = "index:" + storage.prefix
+	storage.countPrefix = "counts:" + storage.prefix
+	storage.builtIndexes = "indexes:test:" + t.Name()
+	storage.indexes = indexesByName(indexes)
+	// Every key of the test holds its name
+	clean := func() {
+		ctx := context.Background()
+		iter := storage.client.Scan(ctx, 0, "*"+escapeGlob("test:"+t.Name())+"*", 0).Iterator()
+		for iter.Next(ctx) {
+			storage.client.Del(ctx, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clean()
+	t.Cleanup(clean)
+	if err := storage.buildIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func TestRedisCountsTheRecordsWrittenBeforeTheIndex(t *testing.T) {
+	storage := newTestRedisStorage[entities.User](t)
+	createUsers(t, storage, 3)
+	if _, err := storage.Create(testUser("ann")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The counted index is built once, when a storage having it is opened
+	storage.indexes = indexesByName([]Index[entities.User]{testNameIndex})
+	for i := 0; i < 2; i++ {
+		if err := storage.buildIndexes(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts, err := storage.Count("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["ann"] != 1 || counts["user0"] != 1 || len(counts) != 4 {
+		t.Errorf("got the counts %v", counts)
+	}
+	if found := findIds(t, storage, "name", "ann"); len(found) != 1 {
+		t.Errorf("ann: found %v", found)
+	}
+}
@@ -1,15 +1,240 @@
 package db
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"example/bootcamp_ex1/entities"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const DefaultBatchSize = 100
+
+var (
+	ErrStale = errors.New("the record was changed since it was read")
+)
+
+// Storage keeps the records of one entity. The outbox messages given to the
+// writes are stored atomically with the change of the record, and so are the
+// versions of the entities.Versioned records.
 type Storage[T entities.StorageObject] interface {
+	Outbox
 	Get(id uuid.UUID) (T, error)
 	GetAll() ([]T, error)
-	Create(thing T) (uuid.UUID, error)
-	Update(id uuid.UUID, thing T) (T, error)
+	Iterate(batchSize int) Iterator[T]
+	// FindBy iterates over the live records having the key in the index
+	FindBy(index string, key string, batchSize int) Iterator[T]
+	// Count returns the number of live records having each key of a counted index
+	Count(index string) (map[string]int, error)
+	Create(thing T, outbox ...OutboxMessage) (uuid.UUID, error)
+	Update(id uuid.UUID, thing T, outbox ...OutboxMessage) (T, error)
+	// Swap is the Update of a record read as current, it fails with ErrStale when the
+	// record was written since, so a change computed from current never overwrites another
+	Swap(id uuid.UUID, current T, thing T, outbox ...OutboxMessage) (T, error)
 	Delete(id uuid.UUID) (uuid.UUID, error)
+	// Soft deleted records are hidden from Get, GetAll and Iterate until they are restored or purged
+	SoftDelete(id uuid.UUID, deletedAt time.Time, outbox ...OutboxMessage) (uuid.UUID, error)
+	// Restore stores the deleted record back, changed by upgrade first when it isn't nil, so the
+	// records deleted before a migration are restored migrated
+	Restore(id uuid.UUID, restoredAt time.Time, upgrade func(*T) bool, outbox ...OutboxMessage) (T, error)
+	GetDeleted() ([]Deleted[T], error)
+	GetDeletedRecord(id uuid.UUID) (Deleted[T], error)
+	// Purge hard deletes the records soft deleted before the given time, with their versions.
+	// The messages outbox returns for a record, when it isn't nil, are stored with its purge.
+	Purge(deletedBefore time.Time, outbox func(deleted Deleted[T]) ([]OutboxMessage, error)) ([]uuid.UUID, error)
+	// Every stored state of a versioned record is kept as a numbered version, starting at 1.
+	// BackfillVersion stores the live record as version 1 when it has none, for the records
+	// written before they were versioned, and reports if it did.
+	BackfillVersion(id uuid.UUID) (bool, error)
+	GetVersions(id uuid.UUID) ([]Version[T], error)
+	GetVersion(id uuid.UUID, number int) (Version[T], error)
+	// GetAsOf returns the version that was current at the given time
+	GetAsOf(id uuid.UUID, at time.Time) (Version[T], error)
+}
+
+// Deleted is a soft deleted record and the time it was deleted
+type Deleted[T entities.StorageObject] struct {
+	Record    T         `json:"record"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Version is one state of a record, deleted versions mark when the record was deleted
+type Version[T entities.StorageObject] struct {
+	Number    int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Deleted   bool      `json:"deleted"`
+	Record    T         `json:"record"`
+}
+
+func (v Version[T]) GetId() uuid.UUID {
+	return v.Record.GetId()
+}
+
+// newVersion returns the version of a write of the record, false when the record isn't versioned.
+// The deleted versions are at the deletion time, the restored ones at the restore time.
+func newVersion[T entities.StorageObject](thing T, at *time.Time, deleted bool) (Version[T], bool) {
+	versioned, ok := any(thing).(entities.Versioned)
+	if !ok {
+		return Version[T]{}, false
+	}
+	timestamp := versioned.VersionedAt()
+	if at != nil {
+		timestamp = *at
+	}
+	return Version[T]{Timestamp: timestamp, Deleted: deleted, Record: thing}, true
+}
+
+// sameRecord reports if the records are in the same state, compared as they are stored
+func sameRecord[T entities.StorageObject](a T, b T) bool {
+	first, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	second, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(first, second)
+}
+
+// copyRecord returns a deep copy of the record, made as it is stored
+func copyRecord[T entities.StorageObject](thing T) (T, error) {
+	var copied T
+	serialized, err := json.Marshal(thing)
+	if err != nil {
+		return copied, ErrMarshalingRecord
+	}
+	if err := json.Unmarshal(serialized, &copied); err != nil {
+		return copied, ErrUnmarshalingRecord
+	}
+	return copied, nil
+}
+
+// versionAsOf finds the version current at the given time in versions sorted by number
+func versionAsOf[T entities.StorageObject](versions []Version[T], at time.Time) (Version[T], error) {
+	var found *Version[T]
+	for i := range versions {
+		if versions[i].Timestamp.After(at) {
+			break
+		}
+		found = &versions[i]
+	}
+	if found == nil || found.Deleted {
+		return Version[T]{}, ErrVersionNotFound
+	}
+	return *found, nil
+}
+
+// Iterator walks over the records of a storage in batches, so callers never
+// need to hold every record in memory at once.
+//
+//	iter := storage.Iterate(db.DefaultBatchSize)
+//	for iter.Next() {
+//		for _, thing := range iter.Batch() { ... }
+//	}
+//	if err := iter.Err(); err != nil { ... }
+type Iterator[T entities.StorageObject] interface {
+	Next() bool
+	Batch() []T
+	Err() error
+}
+
+// Collect drains an iterator into a single slice
+func Collect[T entities.StorageObject](iter Iterator[T]) ([]T, error) {
+	things := make([]T, 0)
+	for iter.Next() {
+		things = append(things, iter.Batch()...)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return things, nil
+}
+
+// NewSliceIterator iterates in batches over records already in memory
+func NewSliceIterator[T entities.StorageObject](things []T, batchSize int) Iterator[T] {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &sliceIterator[T]{things: things, batchSize: batchSize}
+}
+
+type sliceIterator[T entities.StorageObject] struct {
+	things    []T
+	batchSize int
+	batch     []T
+}
+
+func (it *sliceIterator[T]) Next() bool {
+	size := min(it.batchSize, len(it.things))
+	it.batch = it.things[:size]
+	it.things = it.things[size:]
+	return len(it.batch) > 0
+}
+
+func (it *sliceIterator[T]) Batch() []T {
+	return it.batch
+}
+
+func (it *sliceIterator[T]) Err() error {
+	return nil
+}
+
+// NewFilterIterator yields only the records of iter accepted by keep
+func NewFilterIterator[T entities.StorageObject](iter Iterator[T], keep func(T) bool) Iterator[T] {
+	return &filterIterator[T]{iter: iter, keep: keep}
+}
+
+type filterIterator[T entities.StorageObject] struct {
+	iter  Iterator[T]
+	keep  func(T) bool
+	batch []T
+}
+
+func (it *filterIterator[T]) Next() bool {
+	// Batches left empty by the filter are skipped
+	for it.iter.Next() {
+		it.batch = make([]T, 0, len(it.iter.Batch()))
+		for _, thing := range it.iter.Batch() {
+			if it.keep(thing) {
+				it.batch = append(it.batch, thing)
+			}
+		}
+		if len(it.batch) > 0 {
+			return true
+		}
+	}
+	it.batch = nil
+	return false
+}
+
+func (it *filterIterator[T]) Batch() []T {
+	return it.batch
+}
+
+func (it *filterIterator[T]) Err() error {
+	return it.iter.Err()
+}
+
+// NewErrorIterator yields no records and fails with err
+func NewErrorIterator[T entities.StorageObject](err error) Iterator[T] {
+	return errorIterator[T]{err: err}
+}
+
+type errorIterator[T entities.StorageObject] struct {
+	err error
+}
+
+func (it errorIterator[T]) Next() bool {
+	return false
+}
+
+func (it errorIterator[T]) Batch() []T {
+	return nil
+}
+
+func (it errorIterator[T]) Err() error {
+	return it.err
 }
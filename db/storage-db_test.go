@@ -0,0 +1,360 @@
+package db
+
+import (
+	"example/bootcamp_ex1/entities"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// forEachStorage runs the test on a new storage of every backend
+func forEachStorage(t *testing.T, test func(t *testing.T, storage Storage[entities.User])) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStorage[entities.User]())
+	})
+	t.Run("redis", func(t *testing.T) {
+		test(t, newTestRedisStorage[entities.User](t))
+	})
+}
+
+func testUser(name string) entities.User {
+	return entities.User{
+		Id:       uuid.New(),
+		Name:     name,
+		LastName: "Lee",
+		Email:    name + "@example.com",
+		Address:  entities.Address{City: "Rome", Country: "IT", AddressString: "Via 1"},
+	}
+}
+
+// createUsers stores count users and returns their ids
+func createUsers(t *testing.T, storage Storage[entities.User], count int) map[uuid.UUID]bool {
+	t.Helper()
+	ids := make(map[uuid.UUID]bool, count)
+	for i := 0; i < count; i++ {
+		id, err := storage.Create(testUser(fmt.Sprintf("user%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+func TestIterateYieldsEveryRecordOnceInBatches(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		created := createUsers(t, storage, 7)
+
+		seen := make(map[uuid.UUID]bool)
+		iter := storage.Iterate(3)
+		for iter.Next() {
+			// The batch size is only a hint to the SCAN of redis
+			batch := iter.Batch()
+			if len(batch) == 0 {
+				t.Error("got an empty batch")
+			}
+			for _, user := range batch {
+				if seen[user.Id] {
+					t.Errorf("%s was yielded twice", user.Id)
+				}
+				seen[user.Id] = true
+			}
+		}
+		if err := iter.Err(); err != nil {
+			t.Fatal(err)
+		}
+		for id := range created {
+			if !seen[id] {
+				t.Errorf("%s was never yielded", id)
+			}
+		}
+		if len(seen) != len(created) {
+			t.Errorf("yielded %d records, want %d", len(seen), len(created))
+		}
+	})
+}
+
+func TestIterateOverAnEmptyStorage(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		iter := storage.Iterate(DefaultBatchSize)
+		if iter.Next() {
+			t.Errorf("got a batch of %d records from an empty storage", len(iter.Batch()))
+		}
+		if err := iter.Err(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestIterateSkipsTheRecordsDeletedMeanwhile(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		created := createUsers(t, storage, 4)
+		iter := storage.Iterate(1)
+		if !iter.Next() {
+			t.Fatal("no first batch")
+		}
+		first := iter.Batch()[0].Id
+		for id := range created {
+			if id != first {
+				if _, err := storage.Delete(id); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		for iter.Next() {
+			for _, user := range iter.Batch() {
+				t.Errorf("%s was yielded after its deletion", user.Id)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestGetAllCollectsTheIteration(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		created := createUsers(t, storage, DefaultBatchSize+5)
+		all, err := storage.GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(created) {
+			t.Errorf("got %d records, want %d", len(all), len(created))
+		}
+	})
+}
+
+func TestSoftDeleteHidesTheRecordUntilItIsRestored(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		user := testUser("ann")
+		if _, err := storage.Create(user); err != nil {
+			t.Fatal(err)
+		}
+		deletedAt := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+		if _, err := storage.SoftDelete(user.Id, deletedAt); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := storage.Get(user.Id); err == nil {
+			t.Error("the deleted record is still found")
+		}
+		if users, _ := storage.GetAll(); len(users) != 0 {
+			t.Errorf("the deleted record is still listed: %v", users)
+		}
+		deleted, err := storage.GetDeleted()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deleted) != 1 || deleted[0].Record.Id != user.Id || !deleted[0].DeletedAt.Equal(deletedAt) {
+			t.Fatalf("got the deleted records %+v", deleted)
+		}
+		if found, err := storage.GetDeletedRecord(user.Id); err != nil || found.Record.Id != user.Id || !found.DeletedAt.Equal(deletedAt) {
+			t.Errorf("got the deleted record %+v, %v", found, err)
+		}
+		if _, err := storage.GetDeletedRecord(uuid.New()); err != ErrUserNotFound {
+			t.Errorf("unknown deleted record: got %v, want %v", err, ErrUserNotFound)
+		}
+		if _, err := storage.SoftDelete(user.Id, deletedAt); err != ErrUserNotFound {
+			t.Errorf("deleting twice: got %v, want %v", err, ErrUserNotFound)
+		}
+
+		restored, err := storage.Restore(user.Id, deletedAt, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(restored, user) {
+			t.Errorf("restored %+v, want %+v", restored, user)
+		}
+		if got, err := storage.Get(user.Id); err != nil || !reflect.DeepEqual(got, user) {
+			t.Errorf("got %+v, %v after the restore", got, err)
+		}
+		if deleted, _ := storage.GetDeleted(); len(deleted) != 0 {
+			t.Errorf("the restored record is still deleted: %+v", deleted)
+		}
+		if _, err := storage.GetDeletedRecord(user.Id); err != ErrUserNotFound {
+			t.Errorf("the restored record: got %v, want %v", err, ErrUserNotFound)
+		}
+		if _, err := storage.Restore(user.Id, deletedAt, nil); err != ErrUserNotFound {
+			t.Errorf("restoring twice: got %v, want %v", err, ErrUserNotFound)
+		}
+	})
+}
+
+func TestPurgeRemovesTheRecordsDeletedBeforeTheTime(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+		old, recent, live := testUser("old"), testUser("recent"), testUser("live")
+		for _, user := range []entities.User{old, recent, live} {
+			if _, err := storage.Create(user); err != nil {
+				t.Fatal(err)
+			}
+		}
+		storage.SoftDelete(old.Id, now.Add(-2*time.Hour))
+		storage.SoftDelete(recent.Id, now)
+
+		purged, err := storage.Purge(now, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(purged) != 1 || purged[0] != old.Id {
+			t.Errorf("purged %v, want only %s", purged, old.Id)
+		}
+		if _, err := storage.Restore(old.Id, now, nil); err != ErrUserNotFound {
+			t.Errorf("restoring a purged record: got %v, want %v", err, ErrUserNotFound)
+		}
+		deleted, _ := storage.GetDeleted()
+		if len(deleted) != 1 || deleted[0].Record.Id != recent.Id {
+			t.Errorf("got the deleted records %+v, want only %s", deleted, recent.Id)
+		}
+		if _, err := storage.Get(live.Id); err != nil {
+			t.Errorf("the live record was purged: %v", err)
+		}
+
+		if purged, _ := storage.Purge(now, nil); len(purged) != 0 {
+			t.Errorf("purged %v twice", purged)
+		}
+	})
+}
+
+func TestVersionsAreNumberedAndReadAsOf(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		created := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+		updated, deleted, restored := created.Add(time.Hour), created.Add(2*time.Hour), created.Add(3*time.Hour)
+		user := testUser("ann")
+		user.UpdatedAt = created
+		renamed := user
+		renamed.Name = "anna"
+		renamed.UpdatedAt = updated
+		if _, err := storage.Create(user); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.Update(user.Id, renamed); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.SoftDelete(user.Id, deleted); err != nil {
+			t.Fatal(err)
+		}
+
+		versions, err := storage.GetVersions(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 3 {
+			t.Fatalf("got %d versions, want 3", len(versions))
+		}
+		for i, version := range versions {
+			if version.Number != i+1 {
+				t.Errorf("version %d is numbered %d", i+1, version.Number)
+			}
+		}
+		if versions[1].Deleted || !versions[1].Timestamp.Equal(updated) || !versions[2].Deleted || !versions[2].Timestamp.Equal(deleted) {
+			t.Errorf("got the versions %+v", versions)
+		}
+		if version, err := storage.GetVersion(user.Id, 2); err != nil || version.Record.Name != "anna" {
+			t.Errorf("got the version %+v, %v", version, err)
+		}
+		for _, number := range []int{0, 4} {
+			if _, err := storage.GetVersion(user.Id, number); err != ErrVersionNotFound {
+				t.Errorf("version %d: got %v, want %v", number, err, ErrVersionNotFound)
+			}
+		}
+		if _, err := storage.GetVersions(uuid.New()); err != ErrUserNotFound {
+			t.Errorf("versions of an unknown record: got %v, want %v", err, ErrUserNotFound)
+		}
+
+		for _, test := range []struct {
+			name string
+			at   time.Time
+			want string
+			err  error
+		}{
+			{name: "before the creation", at: created.Add(-time.Second), err: ErrVersionNotFound},
+			{name: "at the creation", at: created, want: "ann"},
+			{name: "before the update", at: updated.Add(-time.Second), want: "ann"},
+			{name: "at the update", at: updated, want: "anna"},
+			{name: "after the deletion", at: deleted.Add(time.Minute), err: ErrVersionNotFound},
+		} {
+			version, err := storage.GetAsOf(user.Id, test.at)
+			if err != test.err || (err == nil && version.Record.Name != test.want) {
+				t.Errorf("%s: got %q, %v, want %q, %v", test.name, version.Record.Name, err, test.want, test.err)
+			}
+		}
+
+		// The restore is versioned at its own time
+		if _, err := storage.Restore(user.Id, restored, nil); err != nil {
+			t.Fatal(err)
+		}
+		if version, err := storage.GetAsOf(user.Id, restored); err != nil || version.Number != 4 || version.Deleted {
+			t.Errorf("after the restore: got %+v, %v", version, err)
+		}
+	})
+}
+
+func TestPurgeRemovesTheVersions(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+		user := testUser("ann")
+		user.UpdatedAt = now.Add(-time.Hour)
+		storage.Create(user)
+		storage.SoftDelete(user.Id, now.Add(-time.Minute))
+
+		if _, err := storage.Purge(now, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.GetVersions(user.Id); err != ErrUserNotFound {
+			t.Errorf("got %v, want the versions purged", err)
+		}
+	})
+}
+
+func TestSwapFailsWhenTheRecordChangedSinceItWasRead(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		user := testUser("ann")
+		storage.Create(user)
+		renamed := user
+		renamed.Name = "anna"
+
+		if _, err := storage.Swap(user.Id, user, renamed); err != nil {
+			t.Fatal(err)
+		}
+		// user is no longer the stored state
+		other := user
+		other.Name = "annie"
+		if _, err := storage.Swap(user.Id, user, other); err != ErrStale {
+			t.Errorf("swapping a stale record: got %v, want %v", err, ErrStale)
+		}
+		if got, _ := storage.Get(user.Id); got.Name != "anna" {
+			t.Errorf("got %q after the stale swap, want anna", got.Name)
+		}
+		if _, err := storage.Swap(uuid.New(), user, other); err != ErrUserNotFound {
+			t.Errorf("swapping an unknown record: got %v, want %v", err, ErrUserNotFound)
+		}
+	})
+}
+
+func TestRestoreStoresTheUpgradedRecord(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, storage Storage[entities.User]) {
+		now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+		user := testUser("ann")
+		storage.Create(user)
+		storage.SoftDelete(user.Id, now)
+
+		restored, err := storage.Restore(user.Id, now.Add(time.Minute), (*entities.User).UpgradeAddresses)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(restored.Addresses) != 1 {
+			t.Errorf("restored the addresses %+v", restored.Addresses)
+		}
+		if stored, _ := storage.Get(user.Id); !reflect.DeepEqual(stored, restored) {
+			t.Errorf("stored %+v, want %+v", stored, restored)
+		}
+		if version, err := storage.GetAsOf(user.Id, now.Add(time.Minute)); err != nil || len(version.Record.Addresses) != 1 {
+			t.Errorf("got the restored version %+v, %v", version, err)
+		}
+	})
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The records written without a tenant belong to the default tenant
+const DefaultTenant = ""
+
+// Tenants keeps the records of every tenant in its own storage, so a tenant
+// can never read or change the records of another one. The storages are
+// created on the first use of each tenant of the set, the other names get a
+// storage failing with ErrUnknownTenant.
+type Tenants[T entities.StorageObject] struct {
+	mu         sync.Mutex
+	known      TenantSet
+	newStorage func(tenant string) Storage[T]
+	storages   map[string]*tenantStorage[T]
+	// owners remembers the tenant of the pending outbox messages handed to the relay
+	owners map[uuid.UUID]string
+}
+
+func NewTenants[T entities.StorageObject](known TenantSet, newStorage func(tenant string) Storage[T]) *Tenants[T] {
+	return &Tenants[T]{
+		known:      known,
+		newStorage: newStorage,
+		storages:   make(map[string]*tenantStorage[T]),
+		owners:     make(map[uuid.UUID]string),
+	}
+}
+
+// tenantStorage is created once, the first users of a tenant wait for it without
+// holding up the other tenants
+type tenantStorage[T entities.StorageObject] struct {
+	once    sync.Once
+	storage Storage[T]
+}
+
+// For returns the storage of the tenant, one failing every call when the tenant isn't in the set
+func (t *Tenants[T]) For(tenant string) Storage[T] {
+	t.mu.Lock()
+	entry, ok := t.storages[tenant]
+	t.mu.Unlock()
+	if !ok {
+		// Tenants are never removed from the set, so only the first use is checked
+		known, err := t.known.Has(tenant)
+		if err != nil {
+			return closedStorage[T]{err: err}
+		}
+		if !known {
+			return closedStorage[T]{err: ErrUnknownTenant}
+		}
+		t.mu.Lock()
+		if entry, ok = t.storages[tenant]; !ok {
+			entry = new(tenantStorage[T])
+			t.storages[tenant] = entry
+		}
+		t.mu.Unlock()
+	}
+	// The storage may migrate its records, it is created outside the lock
+	entry.once.Do(func() {
+		entry.storage = t.newStorage(tenant)
+	})
+	return entry.storage
+}
+
+// Each calls fn with the storage of every tenant of the set, sorted by tenant
+func (t *Tenants[T]) Each(fn func(tenant string, storage Storage[T]) error) error {
+	tenants, err := t.known.List()
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, tenant := range tenants {
+		if err := fn(tenant, t.For(tenant)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PendingMessages merges the pending outbox messages of every tenant, oldest first
+func (t *Tenants[T]) PendingMessages(now time.Time, limit int) ([]OutboxMessage, error) {
+	pending := make([]OutboxMessage, 0)
+	owners := make(map[uuid.UUID]string)
+	err := t.Each(func(tenant string, storage Storage[T]) error {
+		messages, err := storage.PendingMessages(now, limit)
+		for _, message := range messages {
+			owners[message.Id] = tenant
+		}
+		pending = append(pending, messages...)
+		return err
+	})
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+
+	t.mu.Lock()
+	t.owners = owners
+	t.mu.Unlock()
+	return pending, err
+}
+
+func (t *Tenants[T]) MarkDispatched(id uuid.UUID, dispatchedAt time.Time) error {
+	storage, err := t.owner(id)
+	if err != nil {
+		return err
+	}
+	return storage.MarkDispatched(id, dispatchedAt)
+}
+
+func (t *Tenants[T]) MarkFailed(id uuid.UUID, nextAttemptAt time.Time, reason string) error {
+	storage, err := t.owner(id)
+	if err != nil {
+		return err
+	}
+	return storage.MarkFailed(id, nextAttemptAt, reason)
+}
+
+func (t *Tenants[T]) MarkDead(id uuid.UUID, deadAt time.Time, reason string) error {
+	storage, err := t.owner(id)
+	if err != nil {
+		return err
+	}
+	return storage.MarkDead(id, deadAt, reason)
+}
+
+// owner returns the storage of the tenant that wrote the outbox message
+func (t *Tenants[T]) owner(id uuid.UUID) (Storage[T], error) {
+	t.mu.Lock()
+	tenant, ok := t.owners[id]
+	t.mu.Unlock()
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return t.For(tenant), nil
+}
+
+// closedStorage is the storage of the tenants that can't be served, every call fails with err
+type closedStorage[T entities.StorageObject] struct {
+	err error
+}
+
+func (c closedStorage[T]) PendingMessages(now time.Time, limit int) ([]OutboxMessage, error) {
+	return nil, c.err
+}
+
+func (c closedStorage[T]) MarkDispatched(id uuid.UUID, dispatchedAt time.Time) error {
+	return c.err
+}
+
+func (c closedStorage[T]) MarkFailed(id uuid.UUID, nextAttemptAt time.Time, reason string) error {
+	return c.err
+}
+
+func (c closedStorage[T]) MarkDead(id uuid.UUID, deadAt time.Time, reason string) error {
+	return c.err
+}
+
+func (c closedStorage[T]) Get(id uuid.UUID) (T, error) {
+	var zeroValue T
+	return zeroValue, c.err
+}
+
+func (c closedStorage[T]) GetAll() ([]T, error) {
+	return nil, c.err
+}
+
+func (c closedStorage[T]) Iterate(batchSize int) Iterator[T] {
+	return NewErrorIterator[T](c.err)
+}
+
+func (c closedStorage[T]) FindBy(index string, key string, batchSize int) Iterator[T] {
+	return NewErrorIterator[T](c.err)
+}
+
+func (c closedStorage[T]) Count(index string) (map[string]int, error) {
+	return nil, c.err
+}
+
+func (c closedStorage[T]) Create(thing T, outbox ...OutboxMessage) (uuid.UUID, error) {
+	return uuid.Nil, c.err
+}
+
+func (c closedStorage[T]) Update(id uuid.UUID, thing T, outbox ...OutboxMessage) (T, error) {
+	var zeroValue T
+	return zeroValue, c.err
+}
+
+func (c closedStorage[T]) Swap(id uuid.UUID, current T, thing T, outbox ...OutboxMessage) (T, error) {
+	var zeroValue T
+	return zeroValue, c.err
+}
+
+func (c closedStorage[T]) Delete(id uuid.UUID) (uuid.UUID, error) {
+	return uuid.Nil, c.err
+}
+
+func (c closedStorage[T]) SoftDelete(id uuid.UUID, deletedAt time.Time, outbox ...OutboxMessage) (uuid.UUID, error) {
+	return uuid.Nil, c.err
+}
+
+func (c closedStorage[T]) Restore(id uuid.UUID, restoredAt time.Time, upgrade func(*T) bool, outbox ...OutboxMessage) (T, error) {
+	var zeroValue T
+	return zeroValue, c.err
+}
+
+func (c closedStorage[T]) GetDeleted() ([]Deleted[T], error) {
+	return nil, c.err
+}
+
+func (c closedStorage[T]) GetDeletedRecord(id uuid.UUID) (Deleted[T], error) {
+	return Deleted[T]{}, c.err
+}
+
+func (c closedStorage[T]) Purge(deletedBefore time.Time, outbox func(deleted Deleted[T]) ([]OutboxMessage, error)) ([]uuid.UUID, error) {
+	return nil, c.err
+}
+
+func (c closedStorage[T]) BackfillVersion(id uuid.UUID) (bool, error) {
+	return false, c.err
+}
+
+func (c closedStorage[T]) GetVersions(id uuid.UUID) ([]Version[T], error) {
+	return nil, c.err
+}
+
+func (c closedStorage[T]) GetVersion(id uuid.UUID, number int) (Version[T], error) {
+	return Version[T]{}, c.err
+}
+
+func (c closedStorage[T]) GetAsOf(id uuid.UUID, at time.Time) (Version[T], error) {
+	return Version[T]{}, c.err
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Key of the redis set with the names of the tenants
+const redisTenantsKey = "tenants"
+
+var (
+	ErrUnknownTenant = errors.New("there is no tenant with this name")
+)
+
+// TenantSet is the set of the tenants served. It is kept in the backing store, so every
+// process serves and enumerates the same tenants. The default tenant is always part of it.
+type TenantSet interface {
+	Has(tenant string) (bool, error)
+	Add(tenants ...string) error
+	// List returns every tenant, sorted, the default one first
+	List() ([]string, error)
+}
+
+type memoryTenantSet struct {
+	mu      sync.RWMutex
+	tenants map[string]bool
+}
+
+func NewMemoryTenantSet() TenantSet {
+	return &memoryTenantSet{tenants: map[string]bool{DefaultTenant: true}}
+}
+
+func (s *memoryTenantSet) Has(tenant string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tenants[tenant], nil
+}
+
+func (s *memoryTenantSet) Add(tenants ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tenant := range tenants {
+		s.tenants[tenant] = true
+	}
+	return nil
+}
+
+func (s *memoryTenantSet) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tenants := make([]string, 0, len(s.tenants))
+	for tenant := range s.tenants {
+		tenants = append(tenants, tenant)
+	}
+	slices.Sort(tenants)
+	return tenants, nil
+}
+
+type redisTenantSet struct {
+	client *redis.Client
+}
+
+// NewRedisTenantSet keeps the tenants in the "tenants" set, next to their records
+func NewRedisTenantSet() TenantSet {
+	return &redisTenantSet{client: RedisClient()}
+}
+
+func (s *redisTenantSet) Has(tenant string) (bool, error) {
+	if tenant == DefaultTenant {
+		return true, nil
+	}
+	return s.client.SIsMember(context.Background(), redisTenantsKey, tenant).Result()
+}
+
+func (s *redisTenantSet) Add(tenants ...string) error {
+	members := make([]any, 0, len(tenants))
+	for _, tenant := range tenants {
+		if tenant != DefaultTenant {
+			members = append(members, tenant)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return s.client.SAdd(context.Background(), redisTenantsKey, members...).Err()
+}
+
+func (s *redisTenantSet) List() ([]string, error) {
+	tenants, err := s.client.SMembers(context.Background(), redisTenantsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	tenants = append(tenants, DefaultTenant)
+	slices.Sort(tenants)
+	return tenants, nil
+}
@@ -0,0 +1,55 @@
+package entities
+
+import "github.com/google/uuid"
+
+// Usual labels of the addresses, any other label is accepted
+const (
+	AddressHome     = "home"
+	AddressBilling  = "billing"
+	AddressShipping = "shipping"
+)
+
+// UserAddress is one of the labeled addresses of a user
+type UserAddress struct {
+	Id      uuid.UUID `json:"id" xml:"id" yaml:"id"`
+	Label   string    `json:"label" xml:"label" yaml:"label"`
+	Primary bool      `json:"primary" xml:"primary" yaml:"primary"`
+	Address `yaml:",inline"`
+}
+
+func (a UserAddress) GetId() uuid.UUID {
+	return a.Id
+}
+
+type UserAddressRequest struct {
+	Label   string `json:"label" xml:"label" yaml:"label" validate:"required,max=32"`
+	Primary bool   `json:"primary" xml:"primary" yaml:"primary"`
+	Address `yaml:",inline"`
+}
+
+// SetAddresses replaces the addresses of the user, Address becomes the primary one
+func (u *User) SetAddresses(addresses []UserAddress) {
+	u.Addresses = addresses
+	u.Address = Address{}
+	for _, address := range addresses {
+		if address.Primary {
+			u.Address = address.Address
+		}
+	}
+}
+
+// UpgradeAddresses moves the single address of the users stored before the
+// labeled addresses to a primary home address, it reports if the user changed
+func (u *User) UpgradeAddresses() bool {
+	if len(u.Addresses) > 0 || u.Address == (Address{}) {
+		return false
+	}
+	// The id is derived from the user so every instance migrating it agrees on it
+	u.Addresses = []UserAddress{{
+		Id:      uuid.NewSHA1(u.Id, []byte(AddressHome)),
+		Label:   AddressHome,
+		Primary: true,
+		Address: u.Address,
+	}}
+	return true
+}
@@ -0,0 +1,129 @@
+package entities
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of the custom attributes
+const (
+	AttributeString  = "string"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+)
+
+// attributeNamespace derives the attribute definition ids, there is one definition per name
+var attributeNamespace = uuid.MustParse("b3a1d8e2-6c4f-4e7a-9d25-0f8c7e1a4b63")
+
+// AttributeDefinition is a custom attribute of the users defined by an admin
+type AttributeDefinition struct {
+	Id          uuid.UUID `json:"id" xml:"id" yaml:"id"`
+	Name        string    `json:"name" xml:"name" yaml:"name"`
+	Type        string    `json:"type" xml:"type" yaml:"type"`
+	Required    bool      `json:"required" xml:"required" yaml:"required"`
+	Enum        []string  `json:"enum,omitempty" xml:"enum>value,omitempty" yaml:"enum,omitempty"`
+	Description string    `json:"description,omitempty" xml:"description,omitempty" yaml:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at" xml:"created_at" yaml:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" xml:"updated_at" yaml:"updated_at"`
+}
+
+func (a AttributeDefinition) GetId() uuid.UUID {
+	return a.Id
+}
+
+// AttributeDefinitionId is the id of the definition of the attribute with this name
+func AttributeDefinitionId(name string) uuid.UUID {
+	return uuid.NewSHA1(attributeNamespace, []byte(name))
+}
+
+type AttributeDefinitionRequest struct {
+	Name     string `json:"name" xml:"name" yaml:"name" validate:"required,max=64,excludesall=:"`
+	Type     string `json:"type" xml:"type" yaml:"type" validate:"required,oneof=string number boolean"`
+	Required bool   `json:"required" xml:"required" yaml:"required"`
+	// Only string attributes can be limited to a list of values
+	Enum        []string `json:"enum,omitempty" xml:"enum>value,omitempty" yaml:"enum,omitempty" validate:"excluded_unless=Type string,dive,required"`
+	Description string   `json:"description,omitempty" xml:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// Attributes are the custom attributes of a user by name. Values are strings,
+// float64 numbers or booleans, as defined by the attribute definitions.
+type Attributes map[string]any
+
+// MarshalXML writes the attributes sorted by name: <attribute name="team">core</attribute>
+func (a Attributes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, name := range a.names() {
+		element := xml.StartElement{
+			Name: xml.Name{Local: "attribute"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}},
+		}
+		if err := e.EncodeElement(AttributeText(a[name]), element); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// UnmarshalXML reads the attributes written by MarshalXML, the values are read as text
+func (a *Attributes) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var elements struct {
+		Attributes []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"attribute"`
+	}
+	if err := d.DecodeElement(&elements, &start); err != nil {
+		return err
+	}
+	*a = make(Attributes, len(elements.Attributes))
+	for _, attribute := range elements.Attributes {
+		(*a)[attribute.Name] = attribute.Value
+	}
+	return nil
+}
+
+func (a Attributes) names() []string {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AttributeText is the text form of an attribute value, used in the filters and the indexes
+func AttributeText(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return fmt.Sprint(value)
+}
+
+// NormalizeTags lowercases, sorts and removes the repeated tags
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
@@ -0,0 +1,54 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Credential is the password of a user, with the same id as the user. It is kept apart
+// from the user so its hash is never part of the user payloads, versions, audit log or events.
+type Credential struct {
+	Id uuid.UUID `json:"id"`
+	// PasswordHash is an argon2id hash in the PHC string format, empty until a password is set
+	PasswordHash      string     `json:"password_hash,omitempty"`
+	PasswordChangedAt *time.Time `json:"password_changed_at,omitempty"`
+	// TokenVersion is signed in the refresh tokens, changing the password increments it so
+	// the refresh tokens issued before stop working
+	TokenVersion int `json:"token_version"`
+	// Consecutive failed logins, the account is locked until LockedUntil after too many
+	FailedLogins int        `json:"failed_logins"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+	// SHA-256 of the pending password reset token
+	ResetHash      string     `json:"reset_hash,omitempty"`
+	ResetExpiresAt *time.Time `json:"reset_expires_at,omitempty"`
+}
+
+func (c Credential) GetId() uuid.UUID {
+	return c.Id
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" xml:"email" yaml:"email" validate:"required"`
+	Password string `json:"password" xml:"password" yaml:"password" validate:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" xml:"refresh_token" yaml:"refresh_token" validate:"required"`
+}
+
+// PasswordChangeRequest changes the password of a user, admins can leave out the current one
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password,omitempty" xml:"current_password,omitempty" yaml:"current_password,omitempty"`
+	NewPassword     string `json:"new_password" xml:"new_password" yaml:"new_password" validate:"min=8,max=128"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" xml:"email" yaml:"email" validate:"required"`
+}
+
+// PasswordResetConfirmRequest sets the password with the token mailed by a reset request
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token" xml:"token" yaml:"token" validate:"required"`
+	NewPassword string `json:"new_password" xml:"new_password" yaml:"new_password" validate:"min=8,max=128"`
+}
@@ -0,0 +1,24 @@
+package entities
+
+import "github.com/google/uuid"
+
+// Fields of a merge that can be taken from the merged user instead of the survivor
+const (
+	MergeName       = "name"
+	MergeLastName   = "lastname"
+	MergeEmail      = "email"
+	MergeActive     = "active"
+	MergeAddress    = "address"
+	MergeAttributes = "attributes"
+)
+
+// MergeRequest merges a user into the survivor, which keeps its id. Every field keeps the
+// value of the survivor unless it is empty or listed in TakeFromMerged. Addresses and tags
+// of both users are kept, the merged user is deleted.
+type MergeRequest struct {
+	SurvivorId uuid.UUID `json:"survivor_id" xml:"survivor_id" yaml:"survivor_id" validate:"required"`
+	MergedId   uuid.UUID `json:"merged_id" xml:"merged_id" yaml:"merged_id" validate:"required"`
+	// address makes the primary address of the merged user the primary one, attributes
+	// takes the merged value of the attributes both users have
+	TakeFromMerged []string `json:"take_from_merged,omitempty" xml:"take_from_merged>field,omitempty" yaml:"take_from_merged,omitempty" validate:"dive,oneof=name lastname email active address attributes"`
+}
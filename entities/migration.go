@@ -0,0 +1,27 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// migrationNamespace derives the migration ids, a migration is applied once per tenant
+var migrationNamespace = uuid.MustParse("5f0c2a9e-3b7d-4c1e-8a46-d2e9b71f6c05")
+
+// Migration records a data migration applied to the records of a tenant
+type Migration struct {
+	Id        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Count     int       `json:"count"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func (m Migration) GetId() uuid.UUID {
+	return m.Id
+}
+
+// MigrationId is the id of the migration with this name
+func MigrationId(name string) uuid.UUID {
+	return uuid.NewSHA1(migrationNamespace, []byte(name))
+}
@@ -0,0 +1,56 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Roles of a user in an organization
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// membershipNamespace derives the membership ids, a user has one membership per organization
+var membershipNamespace = uuid.MustParse("4f7e3c2a-9a51-4d0e-8c1b-6f2d8e5a7b90")
+
+type Organization struct {
+	Id          uuid.UUID `json:"id" xml:"id" yaml:"id"`
+	Name        string    `json:"name" xml:"name" yaml:"name"`
+	Description string    `json:"description" xml:"description" yaml:"description"`
+	CreatedAt   time.Time `json:"created_at" xml:"created_at" yaml:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" xml:"updated_at" yaml:"updated_at"`
+}
+
+func (o Organization) GetId() uuid.UUID {
+	return o.Id
+}
+
+type OrganizationRequest struct {
+	Name        string `json:"name" xml:"name" yaml:"name" validate:"required"`
+	Description string `json:"description" xml:"description" yaml:"description"`
+}
+
+// Membership links a user to an organization with a role
+type Membership struct {
+	Id             uuid.UUID `json:"id" xml:"id" yaml:"id"`
+	OrganizationId uuid.UUID `json:"organization_id" xml:"organization_id" yaml:"organization_id"`
+	UserId         uuid.UUID `json:"user_id" xml:"user_id" yaml:"user_id"`
+	Role           string    `json:"role" xml:"role" yaml:"role"`
+	CreatedAt      time.Time `json:"created_at" xml:"created_at" yaml:"created_at"`
+}
+
+func (m Membership) GetId() uuid.UUID {
+	return m.Id
+}
+
+// MembershipId is the id of the membership of the user in the organization
+func MembershipId(organizationId uuid.UUID, userId uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(membershipNamespace, []byte(organizationId.String()+userId.String()))
+}
+
+type MembershipRequest struct {
+	Role string `json:"role" xml:"role" yaml:"role" validate:"required,oneof=owner admin member"`
+}
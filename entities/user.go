@@ -1,6 +1,9 @@
 package entities
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -8,29 +11,88 @@ type StorageObject interface {
 	GetId() uuid.UUID
 }
 
+// Versioned records keep every stored state as a numbered version, VersionedAt is
+// the time of the state
+type Versioned interface {
+	VersionedAt() time.Time
+}
+
+// CSVRecord is implemented by the entities that can be listed as text/csv
+type CSVRecord interface {
+	CSVHeader() []string
+	CSVRecord() []string
+}
+
 type User struct {
-	Id       uuid.UUID `json:"id"`
-	Name     string    `json:"name"`
-	LastName string    `json:"lastname"`
-	Email    string    `json:"email"`
-	Active   bool      `json:"active"`
-	Address  Address   `json:"address"`
+	Id       uuid.UUID `json:"id" xml:"id" yaml:"id"`
+	Name     string    `json:"name" xml:"name" yaml:"name"`
+	LastName string    `json:"lastname" xml:"lastname" yaml:"lastname"`
+	Email    string    `json:"email" xml:"email" yaml:"email"`
+	Active   bool      `json:"active" xml:"active" yaml:"active"`
+	// Address is the primary one of Addresses, kept for the clients of a single address
+	Address   Address       `json:"address" xml:"address" yaml:"address"`
+	Addresses []UserAddress `json:"addresses" xml:"addresses>address" yaml:"addresses"`
+	// Custom attributes, validated by the attribute definitions, and free tags
+	Attributes Attributes `json:"attributes,omitempty" xml:"attributes,omitempty" yaml:"attributes,omitempty"`
+	Tags       []string   `json:"tags,omitempty" xml:"tags>tag,omitempty" yaml:"tags,omitempty"`
+	// Set when the user verifies its email, cleared when the email changes
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" xml:"email_verified_at,omitempty" yaml:"email_verified_at,omitempty"`
+	// Set when an admin deactivates the user, only an admin can activate it again
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" xml:"deactivated_at,omitempty" yaml:"deactivated_at,omitempty"`
+	// Audit metadata managed by the service
+	CreatedAt time.Time `json:"created_at" xml:"created_at" yaml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at" yaml:"updated_at"`
+	CreatedBy string    `json:"created_by" xml:"created_by" yaml:"created_by"`
+	UpdatedBy string    `json:"updated_by" xml:"updated_by" yaml:"updated_by"`
+	// Only set on the users listed as deleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty" yaml:"deleted_at,omitempty"`
 }
 
 func (u User) GetId() uuid.UUID {
 	return u.Id
 }
 
+func (u User) VersionedAt() time.Time {
+	return u.UpdatedAt
+}
+
+func (u User) CSVHeader() []string {
+	return []string{"id", "name", "lastname", "email", "active", "city", "country", "address_string", "created_at", "updated_at", "created_by", "updated_by"}
+}
+
+func (u User) CSVRecord() []string {
+	return []string{
+		u.Id.String(),
+		u.Name,
+		u.LastName,
+		u.Email,
+		strconv.FormatBool(u.Active),
+		u.Address.City,
+		u.Address.Country,
+		u.Address.AddressString,
+		u.CreatedAt.Format(time.RFC3339),
+		u.UpdatedAt.Format(time.RFC3339),
+		u.CreatedBy,
+		u.UpdatedBy,
+	}
+}
+
 type UserRequest struct {
-	Name     string  `json:"name" validate:"required"`
-	LastName string  `json:"lastname" validate:"required"`
-	Email    string  `json:"email" validate:"required"`
-	Active   bool    `json:"active"`
-	Address  Address `json:"address" validate:"required"`
+	Name     string `json:"name" xml:"name" yaml:"name" validate:"required"`
+	LastName string `json:"lastname" xml:"lastname" yaml:"lastname" validate:"required"`
+	Email    string `json:"email" xml:"email" yaml:"email" validate:"required"`
+	// Only admins can set it, the rest of the users are activated by verifying their email.
+	// Updates without it keep the activation of the user.
+	Active *bool `json:"active,omitempty" xml:"active,omitempty" yaml:"active,omitempty"`
+	// Clients of a single address send Address, on updates it replaces the primary address
+	Address    *Address             `json:"address,omitempty" xml:"address,omitempty" yaml:"address,omitempty" validate:"required_without=Addresses,omitempty"`
+	Addresses  []UserAddressRequest `json:"addresses,omitempty" xml:"addresses>address,omitempty" yaml:"addresses,omitempty" validate:"omitempty,dive"`
+	Attributes Attributes           `json:"attributes,omitempty" xml:"attributes,omitempty" yaml:"attributes,omitempty"`
+	Tags       []string             `json:"tags,omitempty" xml:"tags>tag,omitempty" yaml:"tags,omitempty" validate:"max=50,dive,required,max=64"`
 }
 
 type Address struct {
-	City          string `json:"city" validate:"required"`
-	Country       string `json:"country" validate:"required"`
-	AddressString string `json:"address_string" validate:"required"`
+	City          string `json:"city" xml:"city" yaml:"city" validate:"required"`
+	Country       string `json:"country" xml:"country" yaml:"country" validate:"required"`
+	AddressString string `json:"address_string" xml:"address_string" yaml:"address_string" validate:"required"`
 }
@@ -0,0 +1,73 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeliveryPending    = "pending"
+	DeliveryDelivered  = "delivered"
+	DeliveryDeadLetter = "dead_letter"
+)
+
+// WebhookSubscription is an endpoint of a partner receiving the user events.
+// An empty EventTypes receives every event.
+type WebhookSubscription struct {
+	Id         uuid.UUID `json:"id" xml:"id" yaml:"id"`
+	Url        string    `json:"url" xml:"url" yaml:"url"`
+	Secret     string    `json:"secret" xml:"secret" yaml:"secret"`
+	EventTypes []string  `json:"event_types" xml:"event_types>event_type" yaml:"event_types"`
+	Active     bool      `json:"active" xml:"active" yaml:"active"`
+	CreatedAt  time.Time `json:"created_at" xml:"created_at" yaml:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" xml:"updated_at" yaml:"updated_at"`
+}
+
+func (w WebhookSubscription) GetId() uuid.UUID {
+	return w.Id
+}
+
+// Receives reports if the subscription wants the events of this type
+func (w WebhookSubscription) Receives(eventType string) bool {
+	if !w.Active {
+		return false
+	}
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, subscribed := range w.EventTypes {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookSubscriptionRequest creates or updates a subscription, a secret is generated when empty
+type WebhookSubscriptionRequest struct {
+	Url        string   `json:"url" xml:"url" yaml:"url" validate:"required,http_url"`
+	Secret     string   `json:"secret" xml:"secret" yaml:"secret" validate:"omitempty,min=16"`
+	EventTypes []string `json:"event_types" xml:"event_types>event_type" yaml:"event_types" validate:"dive,oneof=UserCreated UserUpdated UserActivated UserDeactivated UserDeleted UserRestored UserMerged UserPurged"`
+	Active     bool     `json:"active" xml:"active" yaml:"active"`
+}
+
+// WebhookDelivery is one event sent to one subscription, with the result of its last attempt
+type WebhookDelivery struct {
+	Id             uuid.UUID  `json:"id" xml:"id" yaml:"id"`
+	SubscriptionId uuid.UUID  `json:"subscription_id" xml:"subscription_id" yaml:"subscription_id"`
+	EventId        uuid.UUID  `json:"event_id" xml:"event_id" yaml:"event_id"`
+	EventType      string     `json:"event_type" xml:"event_type" yaml:"event_type"`
+	Payload        string     `json:"payload" xml:"payload" yaml:"payload"`
+	Status         string     `json:"status" xml:"status" yaml:"status"`
+	Attempts       int        `json:"attempts" xml:"attempts" yaml:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" xml:"next_attempt_at" yaml:"next_attempt_at"`
+	LastStatusCode int        `json:"last_status_code,omitempty" xml:"last_status_code,omitempty" yaml:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty" xml:"last_error,omitempty" yaml:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at" xml:"created_at" yaml:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" xml:"delivered_at,omitempty" yaml:"delivered_at,omitempty"`
+}
+
+func (w WebhookDelivery) GetId() uuid.UUID {
+	return w.Id
+}
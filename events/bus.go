@@ -0,0 +1,54 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Handler handles a published event, an error makes the publisher retry the event when it can
+type Handler func(ctx context.Context, event Event) error
+
+// Bus delivers the events to the handlers subscribed in the same process.
+// Handlers run synchronously, the slow ones must hand the event off to their own goroutine.
+// Every handler sees the event even when another fails, so they must tolerate seeing it again.
+type Bus struct {
+	mu       sync.RWMutex
+	nextId   int
+	handlers map[int]Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[int]Handler)}
+}
+
+// Subscribe registers a handler for every published event, the returned function unsubscribes it
+func (b *Bus) Subscribe(handler Handler) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextId
+	b.nextId++
+	b.handlers[id] = handler
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,29 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPublishReturnsTheErrorsOfTheHandlers(t *testing.T) {
+	bus := NewBus()
+	failure := errors.New("handler failed")
+	called := 0
+	bus.Subscribe(func(ctx context.Context, event Event) error {
+		called++
+		return failure
+	})
+	bus.Subscribe(func(ctx context.Context, event Event) error {
+		called++
+		return nil
+	})
+
+	err := bus.Publish(context.Background(), Event{Type: UserCreated})
+	if !errors.Is(err, failure) {
+		t.Errorf("got %v, want %v", err, failure)
+	}
+	if called != 2 {
+		t.Errorf("got %d handlers called, want 2", called)
+	}
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EventType string
+
+const (
+	UserCreated     EventType = "UserCreated"
+	UserUpdated     EventType = "UserUpdated"
+	UserActivated   EventType = "UserActivated"
+	UserDeactivated EventType = "UserDeactivated"
+	UserDeleted     EventType = "UserDeleted"
+	UserRestored    EventType = "UserRestored"
+	UserMerged      EventType = "UserMerged"
+	// UserPurged is the removal for good of a deleted user, once its retention is over
+	UserPurged EventType = "UserPurged"
+)
+
+var (
+	ErrPublishingEvent = errors.New("error publishing event")
+)
+
+// Event is something that happened to a user. User is the state after the
+// change, or the last state for UserDeleted and UserPurged.
+type Event struct {
+	Id         uuid.UUID     `json:"id" xml:"id" yaml:"id"`
+	Type       EventType     `json:"type" xml:"type" yaml:"type"`
+	UserId     uuid.UUID     `json:"user_id" xml:"user_id" yaml:"user_id"`
+	Actor      string        `json:"actor" xml:"actor" yaml:"actor"`
+	OccurredAt time.Time     `json:"occurred_at" xml:"occurred_at" yaml:"occurred_at"`
+	User       entities.User `json:"user" xml:"user" yaml:"user"`
+	// Empty for the users of the default tenant
+	Tenant string `json:"tenant,omitempty" xml:"tenant,omitempty" yaml:"tenant,omitempty"`
+	// Only on UserMerged, the user merged into User, deleted right after
+	MergedId *uuid.UUID `json:"merged_id,omitempty" xml:"merged_id,omitempty" yaml:"merged_id,omitempty"`
+}
+
+func NewEvent(eventType EventType, user entities.User, actor string, occurredAt time.Time) Event {
+	return Event{
+		Id:         uuid.New(),
+		Type:       eventType,
+		UserId:     user.Id,
+		Actor:      actor,
+		OccurredAt: occurredAt,
+		User:       user,
+	}
+}
+
+// Publisher delivers the events to whoever is interested in them
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
@@ -0,0 +1,24 @@
+package events
+
+import (
+	"context"
+	"errors"
+)
+
+// fanout publishes every event to all of its publishers
+type fanout []Publisher
+
+func NewFanout(publishers ...Publisher) Publisher {
+	return fanout(publishers)
+}
+
+// Publish fails when any publisher fails, the ones that succeeded may see the event again on retry
+func (f fanout) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, publisher := range f {
+		if err := publisher.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,40 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"example/bootcamp_ex1/db"
+)
+
+// Topic of the outbox messages holding user events
+const OutboxTopic = "user-events"
+
+var (
+	ErrNotAnEvent = errors.New("outbox message is not a user event")
+)
+
+// ToOutbox wraps the event in a message to store with the change of the user
+func ToOutbox(event Event) (db.OutboxMessage, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return db.OutboxMessage{}, err
+	}
+	return db.OutboxMessage{
+		Id:            event.Id,
+		Topic:         OutboxTopic,
+		Payload:       payload,
+		CreatedAt:     event.OccurredAt,
+		NextAttemptAt: event.OccurredAt,
+	}, nil
+}
+
+func FromOutbox(message db.OutboxMessage) (Event, error) {
+	if message.Topic != OutboxTopic {
+		return Event{}, ErrNotAnEvent
+	}
+	event := Event{}
+	if err := json.Unmarshal(message.Payload, &event); err != nil {
+		return Event{}, ErrNotAnEvent
+	}
+	return event, nil
+}
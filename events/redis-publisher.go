@@ -0,0 +1,43 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	typeField  = "type"
+	eventField = "event"
+)
+
+// redisPublisher appends the events to a Redis Stream other services consume
+type redisPublisher struct {
+	client *redis.Client
+	stream string
+}
+
+func NewRedisPublisher(client *redis.Client, stream string) *redisPublisher {
+	return &redisPublisher{client: client, stream: stream}
+}
+
+func (r *redisPublisher) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return ErrPublishingEvent
+	}
+	err = r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.stream,
+		Values: map[string]any{
+			typeField:  string(event.Type),
+			eventField: string(payload),
+		},
+	}).Err()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrPublishingEvent
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"log/slog"
+	"time"
+)
+
+const (
+	relayBatchSize = 100
+	// Failed messages are retried after baseBackoff, doubling on every attempt up to maxBackoff
+	baseBackoff = time.Second
+	maxBackoff  = 5 * time.Minute
+	// A message failing maxAttempts times, about an hour, is dead and no longer retried
+	maxAttempts = 20
+)
+
+var (
+	ErrUnknownTopic = errors.New("no delivery for the topic of the outbox message")
+)
+
+// Deliver delivers the outbox messages of one topic
+type Deliver func(ctx context.Context, message db.OutboxMessage) error
+
+// Relay delivers the events stored in an outbox to a publisher. A message is
+// only marked dispatched after it was published, so events are delivered at
+// least once and consumers must tolerate duplicates.
+type Relay struct {
+	outbox   db.Outbox
+	interval time.Duration
+	// routes delivers the messages by topic, the events go to the publisher
+	routes map[string]Deliver
+}
+
+func NewRelay(outbox db.Outbox, publisher Publisher, interval time.Duration) *Relay {
+	relay := &Relay{outbox: outbox, interval: interval, routes: make(map[string]Deliver)}
+	relay.Route(OutboxTopic, func(ctx context.Context, message db.OutboxMessage) error {
+		event, err := FromOutbox(message)
+		if err != nil {
+			return err
+		}
+		return publisher.Publish(ctx, event)
+	})
+	return relay
+}
+
+// Route delivers the messages of another topic of the outbox, with the same retries as the events
+func (r *Relay) Route(topic string, deliver Deliver) *Relay {
+	r.routes[topic] = deliver
+	return r
+}
+
+// Start dispatches the pending events every interval until the context is done
+func (r *Relay) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := r.DispatchPending(ctx); err != nil {
+					slog.Error(err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// DispatchPending delivers the messages due now, returning how many were dispatched
+func (r *Relay) DispatchPending(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	messages, err := r.outbox.PendingMessages(now, relayBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	dispatched := 0
+	for _, message := range messages {
+		err := ErrUnknownTopic
+		if deliver, ok := r.routes[message.Topic]; ok {
+			err = deliver(ctx, message)
+		}
+		if err != nil {
+			slog.Error(err.Error(), "message", message.Id, "attempts", message.Attempts+1)
+			if err := r.fail(message, now, err); err != nil {
+				slog.Error(err.Error(), "message", message.Id)
+			}
+			continue
+		}
+		// A failure here only means the event is published again
+		if err := r.outbox.MarkDispatched(message.Id, now); err != nil {
+			slog.Error(err.Error(), "message", message.Id)
+			continue
+		}
+		dispatched++
+	}
+	return dispatched, nil
+}
+
+// fail schedules the next attempt of a message, or marks it dead after maxAttempts
+func (r *Relay) fail(message db.OutboxMessage, now time.Time, err error) error {
+	if message.Attempts+1 >= maxAttempts {
+		slog.Error("Outbox message is dead after too many attempts", "message", message.Id, "topic", message.Topic, "error", err)
+		return r.outbox.MarkDead(message.Id, now, err.Error())
+	}
+	return r.outbox.MarkFailed(message.Id, now.Add(backoff(message.Attempts)), err.Error())
+}
+
+// backoff is the wait before the next attempt of a message that failed attempts times before
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff
+	for i := 0; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
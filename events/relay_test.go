@@ -0,0 +1,138 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var errPublisherDown = errors.New("publisher is down")
+
+// flakyPublisher fails the first failures publications
+type flakyPublisher struct {
+	failures  int
+	published []Event
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, event Event) error {
+	if p.failures > 0 {
+		p.failures--
+		return errPublisherDown
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+// laterOutbox reads the pending messages ahead of time, so the ones waiting
+// for their backoff are due at once
+type laterOutbox struct {
+	db.Outbox
+	ahead time.Duration
+}
+
+func (o laterOutbox) PendingMessages(now time.Time, limit int) ([]db.OutboxMessage, error) {
+	return o.Outbox.PendingMessages(now.Add(o.ahead), limit)
+}
+
+// storeEvent creates a user with the event of its creation in the outbox
+func storeEvent(t *testing.T, storage db.Storage[entities.User]) Event {
+	t.Helper()
+	user := entities.User{Id: uuid.New(), Name: "Ann"}
+	event := NewEvent(UserCreated, user, "alice", time.Now().UTC().Add(-time.Second))
+	message, err := ToOutbox(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Create(user, message); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestRelayRetriesTheEventUntilItIsPublished(t *testing.T) {
+	storage := db.NewMemoryStorage[entities.User]()
+	event := storeEvent(t, storage)
+	publisher := &flakyPublisher{failures: 2}
+	relay := NewRelay(laterOutbox{Outbox: storage, ahead: 2 * maxBackoff}, publisher, time.Hour)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if dispatched, err := relay.DispatchPending(context.Background()); err != nil || dispatched != 0 {
+			t.Fatalf("attempt %d: got %d dispatched, %v", attempt, dispatched, err)
+		}
+		pending, _ := storage.PendingMessages(time.Now().Add(time.Hour), 10)
+		if len(pending) != 1 || pending[0].Attempts != attempt || pending[0].LastError != errPublisherDown.Error() {
+			t.Fatalf("attempt %d: got the pending messages %+v", attempt, pending)
+		}
+		// The failed message waits for its backoff
+		if due, _ := storage.PendingMessages(time.Now(), 10); len(due) != 0 {
+			t.Errorf("attempt %d: the failed message is due at once", attempt)
+		}
+	}
+
+	if dispatched, err := relay.DispatchPending(context.Background()); err != nil || dispatched != 1 {
+		t.Fatalf("got %d dispatched, %v", dispatched, err)
+	}
+	if len(publisher.published) != 1 || publisher.published[0].Id != event.Id || publisher.published[0].Type != UserCreated {
+		t.Errorf("got the published events %+v", publisher.published)
+	}
+	if pending, _ := storage.PendingMessages(time.Now().Add(time.Hour), 10); len(pending) != 0 {
+		t.Errorf("the dispatched message is still pending: %+v", pending)
+	}
+	if dispatched, _ := relay.DispatchPending(context.Background()); dispatched != 0 {
+		t.Errorf("the event was dispatched twice")
+	}
+}
+
+func TestRelayStopsRetryingTheDeadMessages(t *testing.T) {
+	storage := db.NewMemoryStorage[entities.User]()
+	storeEvent(t, storage)
+	publisher := &flakyPublisher{failures: maxAttempts + 1}
+	relay := NewRelay(laterOutbox{Outbox: storage, ahead: 2 * maxBackoff}, publisher, time.Hour)
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if dispatched, err := relay.DispatchPending(context.Background()); err != nil || dispatched != 0 {
+			t.Fatalf("attempt %d: got %d dispatched, %v", attempt, dispatched, err)
+		}
+	}
+	if pending, _ := storage.PendingMessages(time.Now().Add(time.Hour), 10); len(pending) != 0 {
+		t.Errorf("the dead message is still pending: %+v", pending)
+	}
+	relay.DispatchPending(context.Background())
+	if publisher.failures != 1 || len(publisher.published) != 0 {
+		t.Errorf("the dead message was retried, %d failures left", publisher.failures)
+	}
+}
+
+func TestRelayDispatchesTheEventsInOrder(t *testing.T) {
+	storage := db.NewMemoryStorage[entities.User]()
+	first := storeEvent(t, storage)
+	second := storeEvent(t, storage)
+	publisher := &flakyPublisher{}
+
+	if dispatched, err := NewRelay(storage, publisher, time.Hour).DispatchPending(context.Background()); err != nil || dispatched != 2 {
+		t.Fatalf("got %d dispatched, %v", dispatched, err)
+	}
+	if len(publisher.published) != 2 || publisher.published[0].Id != first.Id || publisher.published[1].Id != second.Id {
+		t.Errorf("got the published events %+v", publisher.published)
+	}
+}
+
+func TestBackoffDoublesUpToTheMax(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		0:  time.Second,
+		1:  2 * time.Second,
+		3:  8 * time.Second,
+		8:  256 * time.Second,
+		9:  maxBackoff,
+		50: maxBackoff,
+	} {
+		if got := backoff(attempts); got != want {
+			t.Errorf("after %d attempts: got %s, want %s", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// StreamEntry is an event numbered in the order it was added to a stream, starting at 1
+type StreamEntry struct {
+	Sequence uint64
+	Event    Event
+}
+
+// Stream keeps the last events in a ring buffer so subscribers can resume after a
+// disconnection, and fans the new ones out without ever blocking the publisher.
+type Stream struct {
+	mu          sync.Mutex
+	history     []StreamEntry
+	next        uint64
+	subscribers map[chan StreamEntry]struct{}
+}
+
+// NewStream keeps up to history events for resuming subscribers
+func NewStream(history int) *Stream {
+	return &Stream{
+		history:     make([]StreamEntry, history),
+		next:        1,
+		subscribers: make(map[chan StreamEntry]struct{}),
+	}
+}
+
+// Handle adds the event to the stream, it is meant to be subscribed to the changes
+func (s *Stream) Handle(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := StreamEntry{Sequence: s.next, Event: event}
+	s.next++
+	if len(s.history) > 0 {
+		s.history[(entry.Sequence-1)%uint64(len(s.history))] = entry
+	}
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- entry:
+		default:
+			// A subscriber that fell behind is dropped, it can resume from the history
+			delete(s.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+	return nil
+}
+
+// Subscribe returns the kept entries after the given sequence and a channel receiving the
+// following ones. The channel is closed when the subscriber falls more than buffer entries
+// behind or when the returned function is called.
+func (s *Stream) Subscribe(after uint64, buffer int) ([]StreamEntry, <-chan StreamEntry, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A sequence from before a restart replays everything kept
+	if after >= s.next {
+		after = 0
+	}
+	first := after + 1
+	if kept := uint64(len(s.history)); s.next-first > kept {
+		first = s.next - kept
+	}
+	backlog := make([]StreamEntry, 0, s.next-first)
+	for sequence := first; sequence < s.next; sequence++ {
+		backlog = append(backlog, s.history[(sequence-1)%uint64(len(s.history))])
+	}
+
+	subscriber := make(chan StreamEntry, buffer)
+	s.subscribers[subscriber] = struct{}{}
+	return backlog, subscriber, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[subscriber]; ok {
+			delete(s.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package events
+
+import (
+	"context"
+	"example/bootcamp_ex1/entities"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func handleEvents(s *Stream, count int) {
+	for i := 0; i < count; i++ {
+		s.Handle(context.Background(), NewEvent(UserUpdated, entities.User{Id: uuid.New()}, "alice", time.Now()))
+	}
+}
+
+func sequences(entries []StreamEntry) []uint64 {
+	numbers := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		numbers = append(numbers, entry.Sequence)
+	}
+	return numbers
+}
+
+func equalSequences(got []uint64, want []uint64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubscribeResumesAfterTheLastEvent(t *testing.T) {
+	s := NewStream(3)
+	handleEvents(s, 5)
+
+	for _, test := range []struct {
+		name  string
+		after uint64
+		want  []uint64
+	}{
+		{name: "up to date", after: 5, want: []uint64{}},
+		{name: "behind", after: 3, want: []uint64{4, 5}},
+		// Only the last 3 events are kept
+		{name: "beyond the history", after: 1, want: []uint64{3, 4, 5}},
+		{name: "new client", after: 0, want: []uint64{3, 4, 5}},
+		// An id from before a restart replays everything kept
+		{name: "unknown id", after: 9, want: []uint64{3, 4, 5}},
+	} {
+		backlog, _, cancel := s.Subscribe(test.after, 1)
+		cancel()
+		if got := sequences(backlog); !equalSequences(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSubscribersReceiveTheNewEvents(t *testing.T) {
+	s := NewStream(3)
+	handleEvents(s, 1)
+	backlog, entries, cancel := s.Subscribe(1, 2)
+	defer cancel()
+	if len(backlog) != 0 {
+		t.Fatalf("got the backlog %v", sequences(backlog))
+	}
+
+	handleEvents(s, 1)
+	if entry := <-entries; entry.Sequence != 2 {
+		t.Errorf("got the entry %d, want 2", entry.Sequence)
+	}
+
+	cancel()
+	if _, ok := <-entries; ok {
+		t.Error("the channel is open after cancel")
+	}
+	// Cancelling twice is harmless
+	cancel()
+}
+
+func TestSlowSubscribersAreDropped(t *testing.T) {
+	s := NewStream(10)
+	_, entries, cancel := s.Subscribe(0, 2)
+	defer cancel()
+
+	// The third event doesn't fit the buffer, the publisher doesn't wait for it
+	handleEvents(s, 3)
+	received := make([]uint64, 0)
+	for entry := range entries {
+		received = append(received, entry.Sequence)
+	}
+	if !equalSequences(received, []uint64{1, 2}) {
+		t.Errorf("got %v before the channel was closed, want [1 2]", received)
+	}
+
+	// The dropped subscriber resumes from the history
+	backlog, _, cancelResume := s.Subscribe(2, 2)
+	cancelResume()
+	if !equalSequences(sequences(backlog), []uint64{3}) {
+		t.Errorf("got the backlog %v, want [3]", sequences(backlog))
+	}
+}
@@ -0,0 +1,247 @@
+package graphqlapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/services"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+//go:embed schema.graphql
+var schema string
+
+// Pages are never bigger than this, whatever first asks for
+const maxPageSize = 100
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidFirst  = errors.New("first must be between 0 and 100")
+)
+
+// NewHandler serves the GraphQL schema of the users, resolved through the UserService
+func NewHandler(userService *services.UserService) http.Handler {
+	return &relay.Handler{Schema: graphql.MustParseSchema(schema, &resolver{
+		userService: userService,
+		validate:    validator.New(),
+	})}
+}
+
+type resolver struct {
+	userService *services.UserService
+	validate    *validator.Validate
+}
+
+func (r *resolver) User(ctx context.Context, args struct{ Id graphql.ID }) (*userResolver, error) {
+	id, err := parseId(args.Id)
+	if err != nil {
+		return nil, err
+	}
+	user, err := r.userService.Get(ctx, id)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &userResolver{user}, nil
+}
+
+type usersArgs struct {
+	Filter *userFilter
+	First  int32
+	After  *string
+}
+
+type userFilter struct {
+	Sort          *string
+	Descending    *bool
+	CreatedAfter  *graphql.Time
+	CreatedBefore *graphql.Time
+	UpdatedAfter  *graphql.Time
+	UpdatedBefore *graphql.Time
+	CreatedBy     *string
+	UpdatedBy     *string
+}
+
+func (r *resolver) Users(ctx context.Context, args usersArgs) (*connectionResolver, error) {
+	if args.First < 0 || args.First > maxPageSize {
+		return nil, userError{ErrInvalidFirst, codeBadUserInput}
+	}
+	query := args.Filter.toQuery()
+	if err := query.Validate(); err != nil {
+		return nil, userError{err, codeBadUserInput}
+	}
+	// Cursors point into a stable order, so the users are always sorted
+	if query.Sort == "" {
+		query.Sort = services.SortByCreatedAt
+	}
+	var after *services.SortKey
+	if args.After != nil {
+		key, err := decodeCursor(*args.After, query)
+		if err != nil {
+			return nil, err
+		}
+		after = &key
+	}
+	users, hasNextPage, err := r.userService.Page(ctx, query, after, int(args.First))
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &connectionResolver{users: users, query: query, hasNextPage: hasNextPage}, nil
+}
+
+type userInput struct {
+	Name     string
+	Lastname string
+	Email    string
+	Active   *bool
+	Address  struct {
+		City          string
+		Country       string
+		AddressString string
+	}
+}
+
+func (r *resolver) CreateUser(ctx context.Context, args struct{ Input userInput }) (*userResolver, error) {
+	userReq, err := r.toUserRequest(args.Input)
+	if err != nil {
+		return nil, err
+	}
+	id, err := r.userService.Create(ctx, userReq)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	user, err := r.userService.Get(ctx, id)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &userResolver{user}, nil
+}
+
+func (r *resolver) UpdateUser(ctx context.Context, args struct {
+	Id    graphql.ID
+	Input userInput
+}) (*userResolver, error) {
+	id, err := parseId(args.Id)
+	if err != nil {
+		return nil, err
+	}
+	userReq, err := r.toUserRequest(args.Input)
+	if err != nil {
+		return nil, err
+	}
+	user, err := r.userService.Update(ctx, id, userReq)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &userResolver{user}, nil
+}
+
+func (r *resolver) DeleteUser(ctx context.Context, args struct{ Id graphql.ID }) (graphql.ID, error) {
+	id, err := parseId(args.Id)
+	if err != nil {
+		return "", err
+	}
+	id, err = r.userService.Delete(ctx, id)
+	if err != nil {
+		return "", serviceError(err)
+	}
+	return graphql.ID(id.String()), nil
+}
+
+// toUserRequest converts and validates the input like the bodies of the http api
+func (r *resolver) toUserRequest(input userInput) (entities.UserRequest, error) {
+	userReq := entities.UserRequest{
+		Name:     input.Name,
+		LastName: input.Lastname,
+		Email:    input.Email,
+		Active:   input.Active,
+		Address: &entities.Address{
+			City:          input.Address.City,
+			Country:       input.Address.Country,
+			AddressString: input.Address.AddressString,
+		},
+	}
+	if err := r.validate.Struct(userReq); err != nil {
+		return userReq, userError{err, codeBadUserInput}
+	}
+	return userReq, nil
+}
+
+func (f *userFilter) toQuery() services.ListQuery {
+	query := services.ListQuery{}
+	if f == nil {
+		return query
+	}
+	if f.Sort != nil {
+		query.Sort = *f.Sort
+	}
+	if f.Descending != nil {
+		query.Descending = *f.Descending
+	}
+	if f.CreatedBy != nil {
+		query.CreatedBy = *f.CreatedBy
+	}
+	if f.UpdatedBy != nil {
+		query.UpdatedBy = *f.UpdatedBy
+	}
+	if f.CreatedAfter != nil {
+		query.CreatedAfter = f.CreatedAfter.Time
+	}
+	if f.CreatedBefore != nil {
+		query.CreatedBefore = f.CreatedBefore.Time
+	}
+	if f.UpdatedAfter != nil {
+		query.UpdatedAfter = f.UpdatedAfter.Time
+	}
+	if f.UpdatedBefore != nil {
+		query.UpdatedBefore = f.UpdatedBefore.Time
+	}
+	return query
+}
+
+func parseId(id graphql.ID) (uuid.UUID, error) {
+	parsed, err := uuid.Parse(string(id))
+	if err != nil {
+		return uuid.Nil, userError{err, codeBadUserInput}
+	}
+	return parsed, nil
+}
+
+// cursor is the position of a user in a listing, with the order it belongs to
+type cursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	services.SortKey
+}
+
+func encodeCursor(query services.ListQuery, user entities.User) string {
+	encoded, _ := json.Marshal(cursor{Sort: query.Sort, Descending: query.Descending, SortKey: query.SortKey(user)})
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeCursor returns the position of the cursor, which must come from a listing in the order of the query
+func decodeCursor(value string, query services.ListQuery) (services.SortKey, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return services.SortKey{}, userError{ErrInvalidCursor, codeBadUserInput}
+	}
+	position := cursor{}
+	if err := json.Unmarshal(decoded, &position); err != nil || position.Id == uuid.Nil {
+		return services.SortKey{}, userError{ErrInvalidCursor, codeBadUserInput}
+	}
+	if position.Sort != query.Sort || position.Descending != query.Descending {
+		return services.SortKey{}, userError{ErrInvalidCursor, codeBadUserInput}
+	}
+	return position.SortKey, nil
+}
@@ -0,0 +1,156 @@
+package graphqlapi
+
+import (
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/services"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// Codes set in the extensions of the errors
+const (
+	codeBadUserInput = "BAD_USER_INPUT"
+	codeNotFound     = "NOT_FOUND"
+	codeForbidden    = "FORBIDDEN"
+	codeConflict     = "CONFLICT"
+)
+
+// userError is an error caused by the request, with its code in the extensions
+type userError struct {
+	err  error
+	code string
+}
+
+func (e userError) Error() string {
+	return e.err.Error()
+}
+
+func (e userError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// serviceError gives the errors of the UserService their code
+func serviceError(err error) error {
+	if errors.Is(err, db.ErrUserNotFound) {
+		return userError{err, codeNotFound}
+	}
+	if errors.Is(err, services.ErrAdminOnly) || errors.Is(err, services.ErrOwnerOnly) {
+		return userError{err, codeForbidden}
+	}
+	if errors.Is(err, services.ErrEmailInUse) {
+		return userError{err, codeConflict}
+	}
+	return err
+}
+
+type userResolver struct {
+	user entities.User
+}
+
+func (r *userResolver) Id() graphql.ID {
+	return graphql.ID(r.user.Id.String())
+}
+
+func (r *userResolver) Name() string {
+	return r.user.Name
+}
+
+func (r *userResolver) Lastname() string {
+	return r.user.LastName
+}
+
+func (r *userResolver) Email() string {
+	return r.user.Email
+}
+
+func (r *userResolver) Active() bool {
+	return r.user.Active
+}
+
+func (r *userResolver) Address() *addressResolver {
+	return &addressResolver{r.user.Address}
+}
+
+func (r *userResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.user.CreatedAt}
+}
+
+func (r *userResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.user.UpdatedAt}
+}
+
+func (r *userResolver) CreatedBy() string {
+	return r.user.CreatedBy
+}
+
+func (r *userResolver) UpdatedBy() string {
+	return r.user.UpdatedBy
+}
+
+type addressResolver struct {
+	address entities.Address
+}
+
+func (r *addressResolver) City() string {
+	return r.address.City
+}
+
+func (r *addressResolver) Country() string {
+	return r.address.Country
+}
+
+func (r *addressResolver) AddressString() string {
+	return r.address.AddressString
+}
+
+// connectionResolver is one page of users
+type connectionResolver struct {
+	users       []entities.User
+	query       services.ListQuery
+	hasNextPage bool
+}
+
+func (r *connectionResolver) Edges() []*edgeResolver {
+	edges := make([]*edgeResolver, 0, len(r.users))
+	for _, user := range r.users {
+		edges = append(edges, &edgeResolver{user: user, query: r.query})
+	}
+	return edges
+}
+
+func (r *connectionResolver) PageInfo() *pageInfoResolver {
+	pageInfo := &pageInfoResolver{hasNextPage: r.hasNextPage}
+	if len(r.users) > 0 {
+		endCursor := encodeCursor(r.query, r.users[len(r.users)-1])
+		pageInfo.endCursor = &endCursor
+	}
+	return pageInfo
+}
+
+type edgeResolver struct {
+	user  entities.User
+	query services.ListQuery
+}
+
+func (r *edgeResolver) Cursor() string {
+	return encodeCursor(r.query, r.user)
+}
+
+func (r *edgeResolver) Node() *userResolver {
+	return &userResolver{r.user}
+}
+
+type pageInfoResolver struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+func (r *pageInfoResolver) HasNextPage() bool {
+	return r.hasNextPage
+}
+
+func (r *pageInfoResolver) EndCursor() *string {
+	return r.endCursor
+}
@@ -0,0 +1,274 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/services"
+	"example/bootcamp_ex1/userpb"
+	"log/slog"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Metadata keys naming who is doing the call, its tenant, its admin key and its tenant token,
+// like the X-Actor, X-Tenant-ID, X-Admin-Key and Authorization headers of the http api
+const (
+	ActorMetadata         = "x-actor"
+	TenantMetadata        = "x-tenant-id"
+	AdminKeyMetadata      = "x-admin-key"
+	AuthorizationMetadata = "authorization"
+)
+
+// usersServer serves the UserService over gRPC
+type usersServer struct {
+	userpb.UnimplementedUsersServer
+	userService *services.UserService
+	validate    *validator.Validate
+}
+
+// NewServer returns a gRPC server with the Users service registered, the calls with one of
+// the admin keys are admin calls and their tenant is checked by access
+func NewServer(userService *services.UserService, adminKeys services.AdminKeys, access services.TenantAccess) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(callerUnaryInterceptor(adminKeys, access)),
+		grpc.StreamInterceptor(callerStreamInterceptor(adminKeys, access)),
+	)
+	userpb.RegisterUsersServer(server, &usersServer{
+		userService: userService,
+		validate:    validator.New(),
+	})
+	return server
+}
+
+func (s *usersServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	id, err := parseId(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userService.Get(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProto(user), nil
+}
+
+func (s *usersServer) ListUsers(req *userpb.ListUsersRequest, stream userpb.Users_ListUsersServer) error {
+	query := services.ListQuery{
+		Sort:       req.GetSort(),
+		Descending: req.GetDescending(),
+		CreatedBy:  req.GetCreatedBy(),
+		UpdatedBy:  req.GetUpdatedBy(),
+	}
+	// Unset timestamps stay zero and don't filter
+	if req.CreatedAfter != nil {
+		query.CreatedAfter = req.CreatedAfter.AsTime()
+	}
+	if req.CreatedBefore != nil {
+		query.CreatedBefore = req.CreatedBefore.AsTime()
+	}
+	if req.UpdatedAfter != nil {
+		query.UpdatedAfter = req.UpdatedAfter.AsTime()
+	}
+	if req.UpdatedBefore != nil {
+		query.UpdatedBefore = req.UpdatedBefore.AsTime()
+	}
+	if err := query.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	iter := s.userService.List(stream.Context(), query, db.DefaultBatchSize)
+	for iter.Next() {
+		for _, user := range iter.Batch() {
+			if err := stream.Send(toProto(user)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+func (s *usersServer) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
+	userReq, err := s.toUserRequest(req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.userService.Create(ctx, userReq)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.CreateUserResponse{Id: id.String()}, nil
+}
+
+func (s *usersServer) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	id, err := parseId(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	userReq, err := s.toUserRequest(req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userService.Update(ctx, id, userReq)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProto(user), nil
+}
+
+func (s *usersServer) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	id, err := parseId(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	id, err = s.userService.Delete(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userpb.DeleteUserResponse{Id: id.String()}, nil
+}
+
+// toUserRequest converts and validates the fields like the bodies of the http api
+func (s *usersServer) toUserRequest(fields *userpb.UserFields) (entities.UserRequest, error) {
+	userReq := entities.UserRequest{
+		Name:     fields.GetName(),
+		LastName: fields.GetLastname(),
+		Email:    fields.GetEmail(),
+		Active:   fields.Active,
+		Address: &entities.Address{
+			City:          fields.GetAddress().GetCity(),
+			Country:       fields.GetAddress().GetCountry(),
+			AddressString: fields.GetAddress().GetAddressString(),
+		},
+	}
+	if err := s.validate.Struct(userReq); err != nil {
+		return userReq, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return userReq, nil
+}
+
+func toProto(user entities.User) *userpb.User {
+	return &userpb.User{
+		Id:       user.Id.String(),
+		Name:     user.Name,
+		Lastname: user.LastName,
+		Email:    user.Email,
+		Active:   user.Active,
+		Address: &userpb.Address{
+			City:          user.Address.City,
+			Country:       user.Address.Country,
+			AddressString: user.Address.AddressString,
+		},
+		CreatedAt: timestamppb.New(user.CreatedAt),
+		UpdatedAt: timestamppb.New(user.UpdatedAt),
+		CreatedBy: user.CreatedBy,
+		UpdatedBy: user.UpdatedBy,
+	}
+}
+
+func parseId(value string) (uuid.UUID, error) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, status.Error(codes.InvalidArgument, "invalid id: "+err.Error())
+	}
+	return id, nil
+}
+
+// toStatus maps the errors of the service to gRPC status codes. The message of an internal
+// error is only logged, it may tell how the service is built.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, db.ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidSortField), errors.Is(err, services.ErrInvalidAttribute),
+		errors.Is(err, services.ErrManyPrimaryAddresses), errors.Is(err, services.ErrInvalidTenant):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrEmailUnverified), errors.Is(err, services.ErrUserDeactivated),
+		errors.Is(err, db.ErrUnknownTenant):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, services.ErrAdminOnly), errors.Is(err, services.ErrOwnerOnly):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, services.ErrEmailInUse):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, db.ErrStale):
+		return status.Error(codes.Aborted, err.Error())
+	default:
+		slog.Error(err.Error())
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// callerUnaryInterceptor stores the x-actor, x-tenant and x-admin-key metadata in the context of the call
+func callerUnaryInterceptor(adminKeys services.AdminKeys, access services.TenantAccess) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := withCaller(ctx, adminKeys, access)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func callerStreamInterceptor(adminKeys services.AdminKeys, access services.TenantAccess) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := withCaller(stream.Context(), adminKeys, access)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &callerStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+type callerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *callerStream) Context() context.Context {
+	return s.ctx
+}
+
+// withCaller stores the admin flag, the actor and the tenant of the call in its context, the
+// tenant named by the metadata is only trusted as access allows
+func withCaller(ctx context.Context, adminKeys services.AdminKeys, access services.TenantAccess) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(AdminKeyMetadata); len(values) > 0 {
+		if !adminKeys.Allows(values[0]) {
+			return nil, status.Error(codes.Unauthenticated, "invalid admin key")
+		}
+		ctx = services.WithAdmin(ctx)
+		// Like the X-Actor header, the actor is only trusted from admins
+		if values := md.Get(ActorMetadata); len(values) > 0 {
+			ctx = services.WithActor(ctx, values[0])
+		}
+	}
+	bearer := ""
+	if values := md.Get(AuthorizationMetadata); len(values) > 0 {
+		bearer, _ = strings.CutPrefix(values[0], "Bearer ")
+	}
+	ctx, err := access.WithCaller(ctx, md.Get(TenantMetadata), bearer)
+	switch {
+	case errors.Is(err, services.ErrInvalidToken), errors.Is(err, services.ErrTokenExpired), errors.Is(err, services.ErrTenantUnverified):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, services.ErrTenantMismatch):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, db.ErrUnknownTenant):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidTenant):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		return nil, toStatus(err)
+	}
+	return ctx, nil
+}
@@ -0,0 +1,161 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/services"
+	"example/bootcamp_ex1/userpb"
+	"fmt"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	testAdminKey     = "test-admin-key"
+	testTenantSecret = "test-tenant-secret"
+)
+
+// tenantToken signs a tenant token of the tenant
+func tenantToken(tenant string) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"tenant":"`+tenant+`"}`))
+	mac := hmac.New(sha256.New, []byte(testTenantSecret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newTestClient serves the users of the tenants acme and globex over an in-memory connection
+func newTestClient(t *testing.T) (userpb.UsersClient, *services.UserService) {
+	t.Helper()
+	tenants := db.NewMemoryTenantSet()
+	tenants.Add("acme", "globex")
+	userService := services.NewUserService(db.NewTenants(tenants, func(tenant string) db.Storage[entities.User] {
+		return db.NewMemoryStorage(services.UserIndexes...)
+	}))
+	server := NewServer(userService, services.AdminKeys{testAdminKey}, services.TenantAccess{
+		TokenSecret: []byte(testTenantSecret),
+		Tenants:     tenants,
+	})
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return userpb.NewUsersClient(conn), userService
+}
+
+func TestCallsOnlyReachTheUsersOfTheirProvenTenant(t *testing.T) {
+	client, userService := newTestClient(t)
+	id, err := userService.Create(services.WithTenant(context.Background(), "acme"), entities.UserRequest{
+		Name:     "Ann",
+		LastName: "Lee",
+		Email:    "ann@example.com",
+		Address:  &entities.Address{City: "Rome", Country: "IT", AddressString: "Via 1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &userpb.GetUserRequest{Id: id.String()}
+
+	for _, test := range []struct {
+		name string
+		md   metadata.MD
+		want codes.Code
+	}{
+		{name: "bare tenant", md: metadata.Pairs(TenantMetadata, "acme"), want: codes.Unauthenticated},
+		{name: "tenant with its token", md: metadata.Pairs(TenantMetadata, "acme", AuthorizationMetadata, "Bearer "+tenantToken("acme")), want: codes.OK},
+		{name: "tenant of an admin", md: metadata.Pairs(TenantMetadata, "acme", AdminKeyMetadata, testAdminKey), want: codes.OK},
+		{name: "tenant with another token", md: metadata.Pairs(TenantMetadata, "acme", AuthorizationMetadata, "Bearer "+tenantToken("globex")), want: codes.PermissionDenied},
+		{name: "another tenant", md: metadata.Pairs(AuthorizationMetadata, "Bearer "+tenantToken("globex")), want: codes.NotFound},
+		{name: "default tenant", md: metadata.MD{}, want: codes.NotFound},
+		{name: "unknown tenant", md: metadata.Pairs(TenantMetadata, "initech", AdminKeyMetadata, testAdminKey), want: codes.NotFound},
+	} {
+		ctx := metadata.NewOutgoingContext(context.Background(), test.md)
+		_, err := client.GetUser(ctx, req)
+		if code := status.Code(err); code != test.want {
+			t.Errorf("%s: got %s, want %s: %v", test.name, code, test.want, err)
+		}
+	}
+}
+
+func TestUpdateWithoutActiveKeepsTheActivation(t *testing.T) {
+	client, _ := newTestClient(t)
+	acme := metadata.Pairs(TenantMetadata, "acme", AuthorizationMetadata, "Bearer "+tenantToken("acme"))
+	admin := metadata.Join(acme, metadata.Pairs(AdminKeyMetadata, testAdminKey))
+	fields := &userpb.UserFields{
+		Name:     "Ann",
+		Lastname: "Lee",
+		Email:    "ann@example.com",
+		Active:   proto.Bool(true),
+		Address:  &userpb.Address{City: "Rome", Country: "IT", AddressString: "Via 1"},
+	}
+	created, err := client.CreateUser(metadata.NewOutgoingContext(context.Background(), admin), &userpb.CreateUserRequest{User: fields})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields.Active = nil
+	fields.Name = "Anne"
+	updated, err := client.UpdateUser(metadata.NewOutgoingContext(context.Background(), acme), &userpb.UpdateUserRequest{Id: created.Id, User: fields})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Active || updated.Name != "Anne" {
+		t.Errorf("got %v after the update, want it renamed and still active", updated)
+	}
+
+	fields.Active = proto.Bool(false)
+	_, err = client.UpdateUser(metadata.NewOutgoingContext(context.Background(), acme), &userpb.UpdateUserRequest{Id: created.Id, User: fields})
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Errorf("deactivation without the admin key: got %s, want %s", code, codes.PermissionDenied)
+	}
+}
+
+func TestErrorsMapToTheirStatusCodes(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{db.ErrUserNotFound, codes.NotFound},
+		{fmt.Errorf("team: %w", services.ErrInvalidAttribute), codes.InvalidArgument},
+		{services.ErrManyPrimaryAddresses, codes.InvalidArgument},
+		{services.ErrInvalidTenant, codes.InvalidArgument},
+		{services.ErrEmailUnverified, codes.FailedPrecondition},
+		{db.ErrUnknownTenant, codes.FailedPrecondition},
+		{services.ErrOwnerOnly, codes.PermissionDenied},
+		{services.ErrEmailInUse, codes.AlreadyExists},
+		{db.ErrStale, codes.Aborted},
+	} {
+		if got := status.Code(toStatus(test.err)); got != test.code {
+			t.Errorf("%v: got %v, want %v", test.err, got, test.code)
+		}
+	}
+
+	// The internal errors don't tell their cause
+	internal := status.Convert(toStatus(errors.New("dial tcp 10.0.0.5:6379: connection refused")))
+	if internal.Code() != codes.Internal || internal.Message() != "internal error" {
+		t.Errorf("got %v: %q", internal.Code(), internal.Message())
+	}
+}
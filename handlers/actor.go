@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"example/bootcamp_ex1/services"
+	"net/http"
+)
+
+const ActorHeader = "X-Actor"
+
+// ActorMiddleware stores who is doing the request, from the X-Actor header, in the request context.
+// Anyone can send the header, so it only names the actor of admin requests, it runs after the
+// AdminMiddleware.
+func ActorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !services.IsAdmin(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := services.WithActor(r.Context(), r.Header.Get(ActorHeader))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var addressIdParameter = openapi.Parameter{
+	Name:   "addressId",
+	In:     "path",
+	Schema: &openapi.Schema{Type: "string", Format: "uuid"},
+}
+
+func GetUserAddresses(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		addresses, err := userService.Addresses(r.Context(), id)
+		if err != nil {
+			sendAddressError(w, r, err)
+			return
+		}
+		sendList(w, r, "addresses", "address", db.NewSliceIterator(addresses, db.DefaultBatchSize), addressPayload)
+	}
+}
+
+func GetUserAddress(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, addressId, ok := parseAddressIds(w, r)
+		if !ok {
+			return
+		}
+		address, err := userService.GetAddress(r.Context(), id, addressId)
+		if err != nil {
+			sendAddressError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "address", address)
+	}
+}
+
+func CreateUserAddress(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		req, ok := decodeRequest[entities.UserAddressRequest](w, r)
+		if !ok {
+			return
+		}
+		address, err := userService.AddAddress(r.Context(), id, req)
+		if err != nil {
+			sendAddressError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusCreated, "address", address)
+	}
+}
+
+func UpdateUserAddress(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, addressId, ok := parseAddressIds(w, r)
+		if !ok {
+			return
+		}
+		req, ok := decodeRequest[entities.UserAddressRequest](w, r)
+		if !ok {
+			return
+		}
+		address, err := userService.UpdateAddress(r.Context(), id, addressId, req)
+		if err != nil {
+			sendAddressError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "address", address)
+	}
+}
+
+func DeleteUserAddress(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, addressId, ok := parseAddressIds(w, r)
+		if !ok {
+			return
+		}
+		addressId, err := userService.DeleteAddress(r.Context(), id, addressId)
+		if err != nil {
+			sendAddressError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "result", IdResponse{Id: addressId})
+	}
+}
+
+// RegisterUserAddressRoutes mounts the addresses of the users on a user router
+func RegisterUserAddressRoutes(router *mux.Router, userService *services.UserService, spec *openapi.Spec) {
+	tags := []string{"addresses"}
+	spec.DocumentRoute(router.HandleFunc("/{id}/addresses", GetUserAddresses(userService)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the addresses of a user",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           []entities.UserAddress{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/addresses", CreateUserAddress(userService)).Methods(http.MethodPost), openapi.Operation{
+		Summary:            "Add an address to a user",
+		Description:        "The first address of a user, and the ones sent as primary, become its primary address.",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Request:            entities.UserAddressRequest{},
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           entities.UserAddress{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusUnsupportedMediaType},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/addresses/{addressId}", GetUserAddress(userService)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "Get an address of a user",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter, addressIdParameter},
+		Response:           entities.UserAddress{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/addresses/{addressId}", UpdateUserAddress(userService)).Methods(http.MethodPut), openapi.Operation{
+		Summary:            "Update an address of a user",
+		Description:        "Setting an address as primary unsets the previous one. The primary address can't be unset directly.",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter, addressIdParameter},
+		Request:            entities.UserAddressRequest{},
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           entities.UserAddress{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusConflict, http.StatusUnsupportedMediaType},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/addresses/{addressId}", DeleteUserAddress(userService)).Methods(http.MethodDelete), openapi.Operation{
+		Summary:            "Delete an address of a user",
+		Description:        "Deleting the primary address makes the first remaining one primary. The only address of a user can't be deleted.",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter, addressIdParameter},
+		Response:           IdResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusConflict},
+	})
+}
+
+func parseAddressIds(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	params := mux.Vars(r)
+	id, err := uuid.Parse(params["id"])
+	if err != nil {
+		sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+		return uuid.Nil, uuid.Nil, false
+	}
+	addressId, err := uuid.Parse(params["addressId"])
+	if err != nil {
+		sendError(w, r, "Invalid address id", http.StatusBadRequest, err.Error())
+		return uuid.Nil, uuid.Nil, false
+	}
+	return id, addressId, true
+}
+
+func addressPayload(address entities.UserAddress) any {
+	return address
+}
+
+func sendAddressError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, db.ErrUserNotFound):
+		sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrAddressNotFound):
+		sendError(w, r, "Address not found with this id", http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrPrimaryRequired), errors.Is(err, services.ErrLastAddress):
+		sendError(w, r, "Conflict", http.StatusConflict, err.Error())
+	default:
+		sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/services"
+	"net/http"
+)
+
+const AdminKeyHeader = "X-Admin-Key"
+
+var (
+	ErrInvalidAdminKey = errors.New("invalid admin key")
+	ErrAdminRequired   = errors.New("only admin requests, with an " + AdminKeyHeader + " header, can use this route")
+)
+
+// AdminMiddleware marks the requests with one of the admin keys in the X-Admin-Key header
+// as admin requests, a request with any other key is rejected
+func AdminMiddleware(keys services.AdminKeys) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(AdminKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !keys.Allows(key) {
+				sendError(w, r, "Unauthorized", http.StatusUnauthorized, ErrInvalidAdminKey.Error())
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(services.WithAdmin(r.Context())))
+		})
+	}
+}
+
+// RequireAdmin serves only the admin requests, the routes wrapped document the 403
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !services.IsAdmin(r.Context()) {
+			sendError(w, r, "Forbidden", http.StatusForbidden, ErrAdminRequired.Error())
+			return
+		}
+		next(w, r)
+	}
+}
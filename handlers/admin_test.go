@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+const testAdminKey = "test-admin-key"
+
+func TestAdminMiddlewareMarksTheAdminRequests(t *testing.T) {
+	handler := AdminMiddleware(services.AdminKeys{testAdminKey})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strconv.FormatBool(services.IsAdmin(r.Context()))))
+	}))
+
+	for _, test := range []struct {
+		name   string
+		key    string
+		status int
+		admin  string
+	}{
+		{name: "no key", status: http.StatusOK, admin: "false"},
+		{name: "admin key", key: testAdminKey, status: http.StatusOK, admin: "true"},
+		{name: "other key", key: "guess", status: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/user/", nil)
+		if test.key != "" {
+			req.Header.Set(AdminKeyHeader, test.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, rec.Code, test.status)
+			continue
+		}
+		if test.status == http.StatusOK && rec.Body.String() != test.admin {
+			t.Errorf("%s: got admin %s, want %s", test.name, rec.Body, test.admin)
+		}
+	}
+}
+
+func TestRequireAdminRejectsTheOtherRequests(t *testing.T) {
+	handler := AdminMiddleware(services.AdminKeys{testAdminKey})(ActorMiddleware(RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(services.ActorFrom(r.Context())))
+	})))
+
+	for _, test := range []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{name: "no key", status: http.StatusForbidden},
+		{name: "admin key", key: testAdminKey, status: http.StatusOK},
+		{name: "other key", key: "guess", status: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/audit", nil)
+		req.Header.Set(ActorHeader, "alice")
+		if test.key != "" {
+			req.Header.Set(AdminKeyHeader, test.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, rec.Code, test.status)
+			continue
+		}
+		if test.status == http.StatusOK && rec.Body.String() != "alice" {
+			t.Errorf("%s: got the actor %q, want alice", test.name, rec.Body)
+		}
+	}
+}
+
+func TestActorIsOnlyTakenFromAdminRequests(t *testing.T) {
+	handler := ActorMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(services.ActorFrom(r.Context())))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/user/", nil)
+	req.Header.Set(ActorHeader, "alice")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Body.String() != services.AnonymousActor {
+		t.Errorf("got the actor %q, want %q", rec.Body, services.AnonymousActor)
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/audit"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func GetUserAuditLog(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		id, err := uuid.Parse(params["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+
+		query, err := parseAuditQuery(r)
+		if err != nil {
+			sendError(w, r, "Invalid query", http.StatusBadRequest, err.Error())
+			return
+		}
+		query.EntityId = id
+
+		entries, err := userService.AuditLog(r.Context(), query)
+		if errors.Is(err, services.ErrAuditDisabled) {
+			sendError(w, r, "Audit log is not enabled", http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendList(w, r, "entries", "entry", db.NewSliceIterator(entries, db.DefaultBatchSize), auditPayload)
+	}
+}
+
+func GetAuditLog(sink audit.Sink) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseAuditQuery(r)
+		if err != nil {
+			sendError(w, r, "Invalid query", http.StatusBadRequest, err.Error())
+			return
+		}
+		query.Tenant = services.TenantFrom(r.Context())
+		query.EntityType = r.URL.Query().Get("entity_type")
+		if entityId := r.URL.Query().Get("entity_id"); entityId != "" {
+			query.EntityId, err = uuid.Parse(entityId)
+			if err != nil {
+				sendError(w, r, "Invalid entity id", http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		entries, err := sink.Query(query)
+		if err != nil {
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendList(w, r, "entries", "entry", db.NewSliceIterator(entries, db.DefaultBatchSize), auditPayload)
+	}
+}
+
+// RegisterAuditRoutes mounts the global audit log on the router
+func RegisterAuditRoutes(router *mux.Router, sink audit.Sink, spec *openapi.Spec) {
+	parameters := append(auditQueryParameters(),
+		openapi.Parameter{Name: "entity_type", In: "query", Schema: &openapi.Schema{Type: "string"}},
+		openapi.Parameter{Name: "entity_id", In: "query", Schema: &openapi.Schema{Type: "string", Format: "uuid"}},
+	)
+	spec.DocumentRoute(router.HandleFunc("", RequireAdmin(GetAuditLog(sink))).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the audit log of every entity",
+		Description:        "Only admin requests, with the " + AdminKeyHeader + " header, can read the audit log.",
+		Tags:               []string{"audit"},
+		Parameters:         parameters,
+		Response:           []audit.Entry{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotAcceptable, http.StatusInternalServerError},
+	})
+}
+
+// parseAuditQuery reads the time range and filters of an audit listing:
+// ?from=2023-01-01T00:00:00Z&to=2023-02-01T00:00:00Z&actor=admin&operation=update
+func parseAuditQuery(r *http.Request) (audit.Query, error) {
+	params := r.URL.Query()
+	query := audit.Query{
+		Actor:     params.Get("actor"),
+		Operation: params.Get("operation"),
+	}
+	timeParams := map[string]*time.Time{
+		"from": &query.From,
+		"to":   &query.To,
+	}
+	for name, target := range timeParams {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("%s: %w", name, err)
+		}
+		*target = parsed
+	}
+	return query, nil
+}
+
+func auditQueryParameters() []openapi.Parameter {
+	dateTime := &openapi.Schema{Type: "string", Format: "date-time"}
+	operations := []any{audit.OperationCreate, audit.OperationUpdate, audit.OperationDelete, audit.OperationRestore, audit.OperationMerge,
+		audit.OperationPurge, audit.OperationPasswordChange, audit.OperationPasswordReset}
+	return []openapi.Parameter{
+		{Name: "from", In: "query", Description: "Inclusive start of the time range", Schema: dateTime},
+		{Name: "to", In: "query", Description: "Exclusive end of the time range", Schema: dateTime},
+		{Name: "actor", In: "query", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "operation", In: "query", Schema: &openapi.Schema{Type: "string", Enum: operations}},
+	}
+}
+
+func auditPayload(entry audit.Entry) any {
+	return entry
+}
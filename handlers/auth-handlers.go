@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// TokenResponse has the tokens issued on login and refresh, ExpiresIn is the number of
+// seconds the access token is valid
+type TokenResponse struct {
+	AccessToken  string `json:"access_token" xml:"access_token" yaml:"access_token"`
+	RefreshToken string `json:"refresh_token" xml:"refresh_token" yaml:"refresh_token"`
+	TokenType    string `json:"token_type" xml:"token_type" yaml:"token_type"`
+	ExpiresIn    int64  `json:"expires_in" xml:"expires_in" yaml:"expires_in"`
+}
+
+func Login(authService *services.AuthService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRequest[entities.LoginRequest](w, r)
+		if !ok {
+			return
+		}
+		tokens, err := authService.Login(r.Context(), req)
+		if err != nil {
+			sendAuthError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "tokens", tokenResponse(tokens))
+	}
+}
+
+func RefreshTokens(authService *services.AuthService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRequest[entities.RefreshRequest](w, r)
+		if !ok {
+			return
+		}
+		tokens, err := authService.Refresh(r.Context(), req.RefreshToken)
+		if err != nil {
+			sendAuthError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "tokens", tokenResponse(tokens))
+	}
+}
+
+func GetAuthenticatedUser(authService *services.AuthService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			sendError(w, r, "Unauthorized", http.StatusUnauthorized, services.ErrInvalidAuthToken.Error())
+			return
+		}
+		user, err := authService.Authenticate(r.Context(), token)
+		if err != nil {
+			sendAuthError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "user", rep.FromUser(user))
+	}
+}
+
+func RequestPasswordReset(authService *services.AuthService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRequest[entities.PasswordResetRequest](w, r)
+		if !ok {
+			return
+		}
+		if err := authService.RequestPasswordReset(r.Context(), req); err != nil {
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func ConfirmPasswordReset(authService *services.AuthService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRequest[entities.PasswordResetConfirmRequest](w, r)
+		if !ok {
+			return
+		}
+		if err := authService.ConfirmPasswordReset(r.Context(), req); err != nil {
+			sendAuthError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ChangePassword(authService *services.AuthService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		req, ok := decodeRequest[entities.PasswordChangeRequest](w, r)
+		if !ok {
+			return
+		}
+		if err := authService.ChangePassword(r.Context(), id, req); err != nil {
+			sendAuthError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RegisterAuthRoutes mounts the login, the token refresh and the password reset on a router,
+// limited with RateLimit by the caller
+func RegisterAuthRoutes(router *mux.Router, authService *services.AuthService, spec *openapi.Spec, rep UserRepresentation) {
+	tags := []string{"auth"}
+	spec.DocumentRoute(router.HandleFunc("/login", Login(authService)).Methods(http.MethodPost), openapi.Operation{
+		Summary: "Log in a user with its email and password",
+		Description: "Only active users with a verified email and a password can log in. After too many consecutive failures the account " +
+			"is locked for a while, even for the right password.",
+		Tags:               tags,
+		Request:            entities.LoginRequest{},
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           TokenResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusLocked, http.StatusTooManyRequests, http.StatusInternalServerError},
+	})
+	spec.DocumentRoute(router.HandleFunc("/refresh", RefreshTokens(authService)).Methods(http.MethodPost), openapi.Operation{
+		Summary:            "Issue new tokens with a refresh token",
+		Description:        "The refresh tokens stop working when the password of the user changes.",
+		Tags:               tags,
+		Request:            entities.RefreshRequest{},
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           TokenResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusLocked, http.StatusTooManyRequests, http.StatusInternalServerError},
+	})
+	spec.DocumentRoute(router.HandleFunc("/me", GetAuthenticatedUser(authService, rep)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "Get the user of the access token in the Authorization header",
+		Tags:               tags,
+		Response:           responseSample(rep),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotAcceptable, http.StatusTooManyRequests, http.StatusInternalServerError},
+	})
+	spec.DocumentRoute(router.HandleFunc("/password-reset", RequestPasswordReset(authService)).Methods(http.MethodPost), openapi.Operation{
+		Summary: "Mail a password reset token to the user with the email",
+		Description: "Users without a password set their first one this way, once their email is verified. The answer " +
+			"is the same when no user has the email, so it can't tell which emails have an account.",
+		Tags:              tags,
+		Request:           entities.PasswordResetRequest{},
+		RequestMediaTypes: requestMediaTypes(),
+		Status:            http.StatusAccepted,
+		Errors:            []int{http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusTooManyRequests, http.StatusInternalServerError},
+	})
+	spec.DocumentRoute(router.HandleFunc("/password-reset/confirm", ConfirmPasswordReset(authService)).Methods(http.MethodPost), openapi.Operation{
+		Summary:           "Set a new password with a password reset token",
+		Description:       "The token is used once, it also unlocks the account. The refresh tokens issued before stop working.",
+		Tags:              tags,
+		Request:           entities.PasswordResetConfirmRequest{},
+		RequestMediaTypes: requestMediaTypes(),
+		Status:            http.StatusNoContent,
+		Errors:            []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnsupportedMediaType, http.StatusTooManyRequests, http.StatusInternalServerError},
+	})
+}
+
+// RegisterUserPasswordRoute mounts the password change on a user router
+func RegisterUserPasswordRoute(router *mux.Router, authService *services.AuthService, spec *openapi.Spec) {
+	spec.DocumentRoute(router.HandleFunc("/{id}/password", ChangePassword(authService)).Methods(http.MethodPut), openapi.Operation{
+		Summary: "Change the password of a user",
+		Description: "The current password is required, and counted as a login, unless the request is an admin one with the " +
+			AdminKeyHeader + " header. The refresh tokens issued before stop working.",
+		Tags:              []string{"auth"},
+		Parameters:        []openapi.Parameter{idParameter},
+		Request:           entities.PasswordChangeRequest{},
+		RequestMediaTypes: requestMediaTypes(),
+		Status:            http.StatusNoContent,
+		Errors:            []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict, http.StatusUnsupportedMediaType, http.StatusLocked, http.StatusInternalServerError},
+	})
+}
+
+// sendAuthError maps the errors of the AuthService to their status
+func sendAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials), errors.Is(err, services.ErrInvalidAuthToken), errors.Is(err, services.ErrAuthTokenExpired):
+		sendError(w, r, "Unauthorized", http.StatusUnauthorized, err.Error())
+	case errors.Is(err, services.ErrInactiveUser), errors.Is(err, services.ErrEmailUnverified):
+		sendError(w, r, "Forbidden", http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrAccountLocked):
+		sendError(w, r, "Locked", http.StatusLocked, err.Error())
+	case errors.Is(err, services.ErrPasswordRequired), errors.Is(err, services.ErrInvalidReset):
+		sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrEmailInUse):
+		sendError(w, r, "Conflict", http.StatusConflict, err.Error())
+	case errors.Is(err, db.ErrUserNotFound):
+		sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
+	default:
+		sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+	}
+}
+
+func tokenResponse(tokens services.Tokens) TokenResponse {
+	return TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+	}
+}
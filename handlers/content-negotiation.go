@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MediaTypeJSON    = "application/json"
+	MediaTypeXML     = "application/xml"
+	MediaTypeMsgPack = "application/msgpack"
+	MediaTypeYAML    = "application/yaml"
+	MediaTypeCSV     = "text/csv"
+)
+
+var (
+	ErrNotAcceptable        = errors.New("none of the accepted media types can be produced")
+	ErrUnsupportedMediaType = errors.New("request content type is not supported")
+	ErrNotCSVRecord         = errors.New("value cannot be encoded as csv")
+)
+
+// responseEncoder writes a value in one media type. name is the element name
+// used by the formats that need one (XML).
+type responseEncoder struct {
+	mediaType string
+	aliases   []string
+	encode    func(w io.Writer, name string, v any) error
+	// stream is nil for the formats that need the full list up front
+	stream func(w io.Writer, name string, itemName string) listEncoder
+	// listsOnly formats can't represent a single object
+	listsOnly bool
+}
+
+// listEncoder writes a list item by item
+type listEncoder interface {
+	Open() error
+	Item(v any) error
+	Close() error
+}
+
+type requestDecoder func(r io.Reader, v any) error
+
+// Ordered by preference, the first one is used when the client accepts anything
+var responseEncoders = []responseEncoder{
+	{
+		mediaType: MediaTypeJSON,
+		encode: func(w io.Writer, name string, v any) error {
+			return json.NewEncoder(w).Encode(v)
+		},
+		stream: func(w io.Writer, name string, itemName string) listEncoder {
+			return &jsonListEncoder{w: w, encoder: json.NewEncoder(w)}
+		},
+	},
+	{
+		mediaType: MediaTypeXML,
+		aliases:   []string{"text/xml"},
+		encode: func(w io.Writer, name string, v any) error {
+			return xml.NewEncoder(w).EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}})
+		},
+		stream: func(w io.Writer, name string, itemName string) listEncoder {
+			return &xmlListEncoder{encoder: xml.NewEncoder(w), name: name, itemName: itemName}
+		},
+	},
+	{
+		mediaType: MediaTypeMsgPack,
+		aliases:   []string{"application/x-msgpack", "application/vnd.msgpack"},
+		encode: func(w io.Writer, name string, v any) error {
+			encoder := msgpack.NewEncoder(w)
+			encoder.SetCustomStructTag("json")
+			return encoder.Encode(v)
+		},
+	},
+	{
+		mediaType: MediaTypeYAML,
+		aliases:   []string{"application/x-yaml", "text/yaml"},
+		encode: func(w io.Writer, name string, v any) error {
+			return yaml.NewEncoder(w).Encode(v)
+		},
+		stream: func(w io.Writer, name string, itemName string) listEncoder {
+			return &yamlListEncoder{w: w}
+		},
+	},
+	{
+		mediaType: MediaTypeCSV,
+		encode: func(w io.Writer, name string, v any) error {
+			return ErrNotCSVRecord
+		},
+		stream: func(w io.Writer, name string, itemName string) listEncoder {
+			return &csvListEncoder{writer: csv.NewWriter(w)}
+		},
+		listsOnly: true,
+	},
+}
+
+var requestDecoders = map[string]requestDecoder{
+	MediaTypeJSON: func(r io.Reader, v any) error {
+		return json.NewDecoder(r).Decode(v)
+	},
+	MediaTypeXML: func(r io.Reader, v any) error {
+		return xml.NewDecoder(r).Decode(v)
+	},
+	MediaTypeMsgPack: func(r io.Reader, v any) error {
+		decoder := msgpack.NewDecoder(r)
+		decoder.SetCustomStructTag("json")
+		return decoder.Decode(v)
+	},
+	MediaTypeYAML: func(r io.Reader, v any) error {
+		return yaml.NewDecoder(r).Decode(v)
+	},
+}
+
+// negotiate picks the response encoder for the Accept header of the request
+func negotiate(r *http.Request, list bool) (responseEncoder, error) {
+	ranges := parseAccept(r.Header.Get("Accept"))
+	if len(ranges) == 0 {
+		return responseEncoders[0], nil
+	}
+
+	for _, mediaRange := range ranges {
+		for _, encoder := range responseEncoders {
+			if encoder.listsOnly && !list {
+				continue
+			}
+			if encoder.matches(mediaRange) {
+				return encoder, nil
+			}
+		}
+	}
+	return responseEncoder{}, ErrNotAcceptable
+}
+
+func (e responseEncoder) matches(mediaRange string) bool {
+	if mediaRange == "*/*" {
+		return true
+	}
+	for _, mediaType := range append([]string{e.mediaType}, e.aliases...) {
+		if mediaRange == mediaType {
+			return true
+		}
+		if strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAccept returns the media ranges of an Accept header sorted by quality,
+// ranges with q=0 are dropped
+func parseAccept(header string) []string {
+	type weightedRange struct {
+		mediaRange string
+		quality    float64
+	}
+	weighted := make([]weightedRange, 0)
+	for _, part := range strings.Split(header, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		weighted = append(weighted, weightedRange{mediaRange: mediaRange, quality: quality})
+	}
+	sort.SliceStable(weighted, func(i, j int) bool {
+		return weighted[i].quality > weighted[j].quality
+	})
+
+	ranges := make([]string, 0, len(weighted))
+	for _, w := range weighted {
+		ranges = append(ranges, w.mediaRange)
+	}
+	return ranges
+}
+
+// decodeBody decodes the request body with the decoder of its Content-Type,
+// requests without Content-Type are read as JSON
+func decodeBody(r *http.Request, v any) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return requestDecoders[MediaTypeJSON](r.Body, v)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ErrUnsupportedMediaType
+	}
+	for _, encoder := range responseEncoders {
+		for _, alias := range encoder.aliases {
+			if mediaType == alias {
+				mediaType = encoder.mediaType
+			}
+		}
+	}
+	decoder, ok := requestDecoders[mediaType]
+	if !ok {
+		return ErrUnsupportedMediaType
+	}
+	return decoder(r.Body, v)
+}
+
+// sendDecodeError answers a request whose body couldn't be decoded
+func sendDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrUnsupportedMediaType) {
+		sendError(w, r, "Unsupported content type", http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+}
+
+// sendResponse encodes v with the media type accepted by the client
+func sendResponse(w http.ResponseWriter, r *http.Request, statusCode int, name string, v any) {
+	encoder, err := negotiate(r, false)
+	if err != nil {
+		sendError(w, r, "Not acceptable", http.StatusNotAcceptable, err.Error())
+		return
+	}
+
+	// Encoding to a buffer first lets encoding errors still become a 500
+	var payload bytes.Buffer
+	err = encoder.encode(&payload, name, v)
+	if err != nil {
+		sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", encoder.mediaType)
+	w.WriteHeader(statusCode)
+	w.Write(payload.Bytes())
+}
+
+// sendList encodes every record of the iterator converted by toPayload, streaming
+// them batch by batch when the negotiated format allows it
+func sendList[T entities.StorageObject](w http.ResponseWriter, r *http.Request, name string, itemName string, iter db.Iterator[T], toPayload func(T) any) {
+	// The list only formats need items that can be written as csv records
+	var zeroValue T
+	_, isRecord := toPayload(zeroValue).(entities.CSVRecord)
+	encoder, err := negotiate(r, isRecord)
+	if err != nil {
+		sendError(w, r, "Not acceptable", http.StatusNotAcceptable, err.Error())
+		return
+	}
+
+	// The first batch is loaded before writing so an early failure can still be reported
+	hasItems := iter.Next()
+	if err := iter.Err(); err != nil {
+		sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if encoder.stream == nil {
+		items := make([]any, 0)
+		for hasItems {
+			for _, item := range iter.Batch() {
+				items = append(items, toPayload(item))
+			}
+			hasItems = iter.Next()
+		}
+		if err := iter.Err(); err != nil {
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendResponse(w, r, http.StatusOK, name, items)
+		return
+	}
+
+	w.Header().Set("Content-Type", encoder.mediaType)
+	flusher, _ := w.(http.Flusher)
+	list := encoder.stream(w, name, itemName)
+
+	if err := list.Open(); err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	for hasItems {
+		for _, item := range iter.Batch() {
+			if err := list.Item(toPayload(item)); err != nil {
+				slog.Error(err.Error())
+				return
+			}
+		}
+		// Send every batch to the client as soon as it is encoded
+		if flusher != nil {
+			flusher.Flush()
+		}
+		hasItems = iter.Next()
+	}
+	if err := iter.Err(); err != nil {
+		// Headers are already sent, the client gets a truncated list
+		slog.Error(err.Error())
+		return
+	}
+	if err := list.Close(); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+type jsonListEncoder struct {
+	w       io.Writer
+	encoder *json.Encoder
+	count   int
+}
+
+func (e *jsonListEncoder) Open() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonListEncoder) Item(v any) error {
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+	return e.encoder.Encode(v)
+}
+
+func (e *jsonListEncoder) Close() error {
+	_, err := io.WriteString(e.w, "]")
+	return err
+}
+
+type xmlListEncoder struct {
+	encoder  *xml.Encoder
+	name     string
+	itemName string
+}
+
+func (e *xmlListEncoder) Open() error {
+	return e.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: e.name}})
+}
+
+func (e *xmlListEncoder) Item(v any) error {
+	return e.encoder.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: e.itemName}})
+}
+
+func (e *xmlListEncoder) Close() error {
+	err := e.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: e.name}})
+	if err != nil {
+		return err
+	}
+	return e.encoder.Flush()
+}
+
+type yamlListEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *yamlListEncoder) Open() error {
+	return nil
+}
+
+// Item writes every record as a one element sequence, which concatenates into a single sequence
+func (e *yamlListEncoder) Item(v any) error {
+	e.count++
+	payload, err := yaml.Marshal([]any{v})
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(payload)
+	return err
+}
+
+func (e *yamlListEncoder) Close() error {
+	if e.count == 0 {
+		_, err := io.WriteString(e.w, "[]\n")
+		return err
+	}
+	return nil
+}
+
+type csvListEncoder struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+func (e *csvListEncoder) Open() error {
+	return nil
+}
+
+func (e *csvListEncoder) Item(v any) error {
+	record, ok := v.(entities.CSVRecord)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotCSVRecord, v)
+	}
+	if !e.wroteHeader {
+		e.wroteHeader = true
+		if err := e.writer.Write(record.CSVHeader()); err != nil {
+			return err
+		}
+	}
+	if err := e.writer.Write(record.CSVRecord()); err != nil {
+		return err
+	}
+	// Flush so every batch reaches the response writer before it is flushed
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func (e *csvListEncoder) Close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// responseMediaTypes lists the media types that can be negotiated for single objects or lists
+func responseMediaTypes(list bool) []string {
+	mediaTypes := make([]string, 0, len(responseEncoders))
+	for _, encoder := range responseEncoders {
+		if encoder.listsOnly && !list {
+			continue
+		}
+		mediaTypes = append(mediaTypes, encoder.mediaType)
+	}
+	return mediaTypes
+}
+
+// requestMediaTypes lists the media types accepted for request bodies
+func requestMediaTypes() []string {
+	mediaTypes := make([]string, 0, len(requestDecoders))
+	for _, encoder := range responseEncoders {
+		if _, ok := requestDecoders[encoder.mediaType]; ok {
+			mediaTypes = append(mediaTypes, encoder.mediaType)
+		}
+	}
+	return mediaTypes
+}
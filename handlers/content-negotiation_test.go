@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// serve sends the request to the user handlers with the Accept and Content-Type headers
+func serve(t *testing.T, handler http.HandlerFunc, method string, path string, accept string, contentType string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := mux.NewRouter()
+	r.HandleFunc("/user/{id}", handler)
+	r.HandleFunc("/user/", handler)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func firstUser(t *testing.T, userService *services.UserService) entities.User {
+	t.Helper()
+	users, err := userService.GetAll(context.Background())
+	if err != nil || len(users) == 0 {
+		t.Fatalf("no user: %v", err)
+	}
+	return users[0]
+}
+
+func TestResponseMediaTypeFollowsTheAcceptHeader(t *testing.T) {
+	userService := newTestUserService(t, 1)
+	user := firstUser(t, userService)
+	path := "/user/" + user.Id.String()
+
+	for _, test := range []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: MediaTypeJSON},
+		{accept: "*/*", want: MediaTypeJSON},
+		{accept: "application/xml", want: MediaTypeXML},
+		{accept: "text/xml", want: MediaTypeXML},
+		{accept: "application/msgpack", want: MediaTypeMsgPack},
+		{accept: "application/yaml", want: MediaTypeYAML},
+		{accept: "application/xml;q=0.5, application/yaml", want: MediaTypeYAML},
+		{accept: "text/html, application/xml;q=0.1", want: MediaTypeXML},
+	} {
+		rec := serve(t, GetUserById(userService, UserV1), http.MethodGet, path, test.accept, "", "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("Accept %q: got status %d", test.accept, rec.Code)
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); got != test.want {
+			t.Errorf("Accept %q: got %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestUnacceptableMediaTypesGet406(t *testing.T) {
+	userService := newTestUserService(t, 1)
+	user := firstUser(t, userService)
+
+	for _, test := range []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		accept  string
+	}{
+		{name: "unknown type", handler: GetUserById(userService, UserV1), path: "/user/" + user.Id.String(), accept: "application/pdf"},
+		// CSV can only represent lists
+		{name: "csv user", handler: GetUserById(userService, UserV1), path: "/user/" + user.Id.String(), accept: MediaTypeCSV},
+		{name: "unknown list type", handler: GetAllUsers(userService, UserV1), path: "/user/", accept: "image/png"},
+	} {
+		rec := serve(t, test.handler, http.MethodGet, test.path, test.accept, "", "")
+		if rec.Code != http.StatusNotAcceptable {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, http.StatusNotAcceptable)
+		}
+	}
+}
+
+func TestUsersAreEncodedAsXML(t *testing.T) {
+	userService := newTestUserService(t, 2)
+	user := firstUser(t, userService)
+
+	rec := serve(t, GetUserById(userService, UserV1), http.MethodGet, "/user/"+user.Id.String(), MediaTypeXML, "", "")
+	var got entities.User
+	if err := xml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("the user is not XML: %v\n%s", err, rec.Body)
+	}
+	// Empty and missing lists read back the same, so the users are compared encoded
+	encoded, _ := xml.Marshal(got)
+	want, _ := xml.Marshal(user)
+	if string(encoded) != string(want) {
+		t.Errorf("got %+v, want %+v", got, user)
+	}
+
+	rec = serve(t, GetAllUsers(userService, UserV1), http.MethodGet, "/user/", MediaTypeXML, "", "")
+	var list struct {
+		XMLName xml.Name        `xml:"users"`
+		Users   []entities.User `xml:"user"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("the list is not XML: %v\n%s", err, rec.Body)
+	}
+	if len(list.Users) != 2 {
+		t.Errorf("got %d users, want 2", len(list.Users))
+	}
+}
+
+func TestUserListsAreEncodedAsCSV(t *testing.T) {
+	userService := newTestUserService(t, 3)
+
+	rec := serve(t, GetAllUsers(userService, UserV1), http.MethodGet, "/user/", MediaTypeCSV, "", "")
+	if rec.Header().Get("Content-Type") != MediaTypeCSV {
+		t.Fatalf("got Content-Type %q", rec.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("got %d csv lines, want a header and 3 users", len(records))
+	}
+	if strings.Join(records[0], ",") != strings.Join(entities.User{}.CSVHeader(), ",") {
+		t.Errorf("got the header %v", records[0])
+	}
+	for _, record := range records[1:] {
+		user, err := userService.Get(context.Background(), mustParseId(t, record[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(record, ",") != strings.Join(user.CSVRecord(), ",") {
+			t.Errorf("got the record %v, want %v", record, user.CSVRecord())
+		}
+	}
+}
+
+func TestRequestBodiesAreDecodedByContentType(t *testing.T) {
+	userService := newTestUserService(t, 0)
+	user := map[string]any{
+		"name":     "Ann",
+		"lastname": "Lee",
+		"email":    "ann@example.com",
+		"address":  map[string]any{"city": "Rome", "country": "IT", "address_string": "Via 1"},
+	}
+	jsonBody, _ := json.Marshal(user)
+	yamlBody, _ := yaml.Marshal(user)
+	xmlBody := `<user><name>Ann</name><lastname>Lee</lastname><email>ann@example.com</email>` +
+		`<address><city>Rome</city><country>IT</country><address_string>Via 1</address_string></address></user>`
+
+	for _, test := range []struct {
+		contentType string
+		body        string
+		want        int
+	}{
+		{contentType: "", body: string(jsonBody), want: http.StatusOK},
+		{contentType: "application/json; charset=utf-8", body: string(jsonBody), want: http.StatusOK},
+		{contentType: MediaTypeXML, body: xmlBody, want: http.StatusOK},
+		{contentType: "text/xml", body: xmlBody, want: http.StatusOK},
+		{contentType: MediaTypeYAML, body: string(yamlBody), want: http.StatusOK},
+		{contentType: "text/plain", body: "Ann Lee", want: http.StatusUnsupportedMediaType},
+		{contentType: MediaTypeCSV, body: "name\nAnn\n", want: http.StatusUnsupportedMediaType},
+		{contentType: MediaTypeXML, body: string(jsonBody), want: http.StatusBadRequest},
+	} {
+		rec := serve(t, CreateUser(userService, UserV1), http.MethodPost, "/user/", "", test.contentType, test.body)
+		if rec.Code != test.want {
+			t.Errorf("Content-Type %q: got status %d, want %d", test.contentType, rec.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"example/bootcamp_ex1/openapi"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Deprecate adds the Deprecation (RFC 9745) and Sunset (RFC 8594) headers to every
+// response of the router, pointing the clients to the successor path
+func Deprecate(router *mux.Router, spec *openapi.Spec, deprecatedAt time.Time, sunset time.Time, successor string) {
+	deprecation := fmt.Sprintf("@%d", deprecatedAt.Unix())
+	sunsetDate := sunset.UTC().Format(http.TimeFormat)
+	link := fmt.Sprintf(`<%s>; rel="successor-version"`, successor)
+
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", deprecation)
+			w.Header().Set("Sunset", sunsetDate)
+			w.Header().Add("Link", link)
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	spec.Deprecate(router, map[string]openapi.Header{
+		"Deprecation": {Description: "Date the route was deprecated", Schema: &openapi.Schema{Type: "string"}},
+		"Sunset":      {Description: "Date the route stops being served", Schema: &openapi.Schema{Type: "string"}},
+		"Link":        {Description: "Successor version of the route", Schema: &openapi.Schema{Type: "string"}},
+	})
+}
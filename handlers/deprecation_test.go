@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"example/bootcamp_ex1/events"
+	"example/bootcamp_ex1/openapi"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDeprecatedRoutesSendTheDeprecationHeaders(t *testing.T) {
+	userService := newTestUserService(t, 1)
+	r := mux.NewRouter()
+	spec := openapi.New("Users API", "1.0.0")
+	RegisterUserRoutes(r.PathPrefix("/v1/users").Subrouter(), "", userService, events.NewStream(10), spec, UserV1)
+	legacyRouter := r.PathPrefix("/user").Subrouter()
+	RegisterUserRoutes(legacyRouter, "/", userService, events.NewStream(10), spec, UserV1)
+	deprecatedAt := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
+	Deprecate(legacyRouter, spec, deprecatedAt, sunset, "/v1/users")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/user/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	for header, want := range map[string]string{
+		"Deprecation": "@1792368000",
+		"Sunset":      "Mon, 19 Apr 2027 00:00:00 GMT",
+		"Link":        `</v1/users>; rel="successor-version"`,
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("got %s %q, want %q", header, got, want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/users", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	for _, header := range []string{"Deprecation", "Sunset", "Link"} {
+		if got := rec.Header().Get(header); got != "" {
+			t.Errorf("the v1 route sends %s %q", header, got)
+		}
+	}
+
+	doc := spec.Build(r)
+	if !doc.Paths["/user/"]["get"].Deprecated {
+		t.Error("the legacy route is not deprecated in the document")
+	}
+	if doc.Paths["/v1/users"]["get"].Deprecated {
+		t.Error("the v1 route is deprecated in the document")
+	}
+}
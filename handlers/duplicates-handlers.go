@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// DuplicateResponse is a pair of users that are probably the same person
+type DuplicateResponse struct {
+	Id        uuid.UUID `json:"id" xml:"id" yaml:"id"`
+	First     any       `json:"first" xml:"first" yaml:"first"`
+	Second    any       `json:"second" xml:"second" yaml:"second"`
+	Score     float64   `json:"score" xml:"score" yaml:"score"`
+	Reasons   []string  `json:"reasons" xml:"reasons>reason" yaml:"reasons"`
+	ScannedAt time.Time `json:"scanned_at" xml:"scanned_at" yaml:"scanned_at"`
+}
+
+func GetDuplicateUsers(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refresh := false
+		if value := r.URL.Query().Get("refresh"); value != "" {
+			var err error
+			if refresh, err = strconv.ParseBool(value); err != nil {
+				sendError(w, r, "Invalid query", http.StatusBadRequest, fmt.Sprintf("refresh: %q must be true or false", value))
+				return
+			}
+		}
+		report, err := userService.Duplicates(r.Context(), refresh)
+		if err != nil {
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendList(w, r, "duplicates", "duplicate", db.NewSliceIterator(report.Pairs, db.DefaultBatchSize), duplicatePayload(rep, report.ScannedAt))
+	}
+}
+
+func MergeUsers(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRequest[entities.MergeRequest](w, r)
+		if !ok {
+			return
+		}
+		user, err := userService.Merge(r.Context(), req)
+		switch {
+		case errors.Is(err, db.ErrUserNotFound):
+			sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrMergeSameUser):
+			sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrAdminOnly), errors.Is(err, services.ErrOwnerOnly):
+			sendError(w, r, "Forbidden", http.StatusForbidden, err.Error())
+		case errors.Is(err, services.ErrEmailInUse):
+			sendError(w, r, "Conflict", http.StatusConflict, err.Error())
+		case err != nil:
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+		default:
+			sendResponse(w, r, http.StatusOK, "user", rep.FromUser(user))
+		}
+	}
+}
+
+// RegisterUserDuplicateRoutes mounts the duplicate detection and the merge on a user router,
+// before RegisterUserRoutes so "duplicates" and "merge" aren't taken as user ids
+func RegisterUserDuplicateRoutes(router *mux.Router, userService *services.UserService, spec *openapi.Spec, rep UserRepresentation) {
+	tags := []string{"admin"}
+	spec.DocumentRoute(router.HandleFunc("/duplicates", RequireAdmin(GetDuplicateUsers(userService, rep))).Methods(http.MethodGet), openapi.Operation{
+		Summary: "List the pairs of users that are probably the same person",
+		Description: fmt.Sprintf("Pairs found by the last duplicate scan, scoring at least %g, best first. ", services.MinDuplicateScore) +
+			"The score adds the similarity of the emails, ignoring case and +suffixes, of the names and of the addresses. " +
+			"The users are shown as they are now, the pairs with a deleted user are left out. " +
+			"Only admin requests, with the " + AdminKeyHeader + " header, can list the duplicates.",
+		Tags: tags,
+		Parameters: []openapi.Parameter{
+			{Name: "refresh", In: "query", Description: "Scan the users again instead of using the last scan", Schema: &openapi.Schema{Type: "boolean"}},
+		},
+		Response:           []DuplicateResponse{},
+		ResponseMediaTypes: responseMediaTypes(true),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotAcceptable, http.StatusInternalServerError},
+	})
+	spec.DocumentRoute(router.HandleFunc("/merge", RequireAdmin(MergeUsers(userService, rep))).Methods(http.MethodPost), openapi.Operation{
+		Summary: "Merge a user into another one and delete it",
+		Description: "Every field keeps the value of the survivor unless it is empty or listed in take_from_merged. " +
+			"The addresses, tags and attributes of both users are kept and the memberships move to the survivor. " +
+			"The merge is recorded in the audit log of the survivor. " +
+			"Only admin requests, with the " + AdminKeyHeader + " header, can merge users.",
+		Tags:               tags,
+		Request:            entities.MergeRequest{},
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           responseSample(rep),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+	})
+}
+
+func duplicatePayload(rep UserRepresentation, scannedAt time.Time) func(services.DuplicatePair) any {
+	return func(pair services.DuplicatePair) any {
+		return DuplicateResponse{
+			Id:        pair.GetId(),
+			First:     rep.FromUser(pair.First),
+			Second:    rep.FromUser(pair.Second),
+			Score:     pair.Score,
+			Reasons:   pair.Reasons,
+			ScannedAt: scannedAt,
+		}
+	}
+}
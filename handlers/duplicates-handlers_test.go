@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newDuplicatesRouter(userService *services.UserService) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(AdminMiddleware(services.AdminKeys{testAdminKey}))
+	RegisterUserDuplicateRoutes(router.PathPrefix("/v1/users").Subrouter(), userService, openapi.New("test", "1.0.0"), UserV1)
+	return router
+}
+
+func TestDuplicatesAndMergeAreAdminOnly(t *testing.T) {
+	userService := newTestUserService(t, 2)
+	users, _ := userService.GetAll(context.Background())
+	router := newDuplicatesRouter(userService)
+	merge := `{"survivor_id":"` + users[0].Id.String() + `","merged_id":"` + users[1].Id.String() + `"}`
+
+	for _, test := range []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		adminKey string
+		want     int
+	}{
+		{name: "duplicates without the admin key", method: http.MethodGet, target: "/v1/users/duplicates", want: http.StatusForbidden},
+		{name: "duplicates with another key", method: http.MethodGet, target: "/v1/users/duplicates", adminKey: "guess", want: http.StatusUnauthorized},
+		{name: "merge without the admin key", method: http.MethodPost, target: "/v1/users/merge", body: merge, want: http.StatusForbidden},
+		{name: "duplicates of an admin", method: http.MethodGet, target: "/v1/users/duplicates", adminKey: testAdminKey, want: http.StatusOK},
+		{name: "merge of an admin", method: http.MethodPost, target: "/v1/users/merge", body: merge, adminKey: testAdminKey, want: http.StatusOK},
+	} {
+		rec := serveTenant(router, test.method, test.target, test.body, "", "", test.adminKey)
+		if rec.Code != test.want {
+			t.Errorf("%s: got %d, want %d: %s", test.name, rec.Code, test.want, rec.Body)
+		}
+	}
+
+	// Only the merge of the admin went through
+	if remaining, _ := userService.GetAll(context.Background()); len(remaining) != 1 {
+		t.Errorf("got %d users after the merges, want 1", len(remaining))
+	}
+}
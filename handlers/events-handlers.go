@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"example/bootcamp_ex1/events"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventStream = "text/event-stream"
+	// Comments sent on idle streams so proxies don't close them
+	keepAliveInterval = 15 * time.Second
+	// Events a slow client can be behind before it is disconnected to resume later
+	subscriberBuffer = 64
+)
+
+var (
+	ErrStreamingUnsupported = errors.New("streaming is not supported")
+)
+
+// EventResponse is a user event with the user in the representation of the api version
+type EventResponse struct {
+	Id         uuid.UUID        `json:"id" xml:"id" yaml:"id"`
+	Type       events.EventType `json:"type" xml:"type" yaml:"type"`
+	UserId     uuid.UUID        `json:"user_id" xml:"user_id" yaml:"user_id"`
+	Actor      string           `json:"actor" xml:"actor" yaml:"actor"`
+	OccurredAt time.Time        `json:"occurred_at" xml:"occurred_at" yaml:"occurred_at"`
+	User       any              `json:"user" xml:"user" yaml:"user"`
+}
+
+// StreamUserEvents sends the user changes as Server-Sent Events, the id of every event is
+// its position in the stream so clients resume with the Last-Event-ID header
+func StreamUserEvents(userStream *events.Stream, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			sendError(w, r, "There was an error", http.StatusInternalServerError, ErrStreamingUnsupported.Error())
+			return
+		}
+		keep, err := parseEventFilter(r)
+		if err != nil {
+			sendError(w, r, "Invalid query", http.StatusBadRequest, err.Error())
+			return
+		}
+		var lastEventId uint64
+		if header := r.Header.Get("Last-Event-ID"); header != "" {
+			lastEventId, err = strconv.ParseUint(header, 10, 64)
+			if err != nil {
+				sendError(w, r, "Invalid Last-Event-ID", http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		backlog, entries, cancel := userStream.Subscribe(lastEventId, subscriberBuffer)
+		defer cancel()
+
+		w.Header().Set("Content-Type", EventStream)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		send := func(entry events.StreamEntry) error {
+			if !keep(entry.Event) {
+				return nil
+			}
+			data, err := json.Marshal(eventPayload(entry.Event, rep))
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", entry.Sequence, entry.Event.Type, data)
+			return err
+		}
+		for _, entry := range backlog {
+			if send(entry) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case entry, ok := <-entries:
+				// Closed when the client fell behind, it reconnects with its Last-Event-ID
+				if !ok || send(entry) != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// parseEventFilter reads the optional filters of the stream: ?user_id=<uuid>&country=AR
+func parseEventFilter(r *http.Request) (func(events.Event) bool, error) {
+	params := r.URL.Query()
+	userIds := make([]uuid.UUID, 0)
+	if value := params.Get("user_id"); value != "" {
+		userId, err := uuid.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("user_id: %w", err)
+		}
+		userIds = append(userIds, userId)
+	}
+	return eventFilter(services.TenantFrom(r.Context()), userIds, params.Get("country")), nil
+}
+
+// eventFilter keeps the events of the tenant of any of the users, when given, living in the country, when given
+func eventFilter(tenant string, userIds []uuid.UUID, country string) func(events.Event) bool {
+	return func(event events.Event) bool {
+		if event.Tenant != tenant {
+			return false
+		}
+		if len(userIds) > 0 && !slices.Contains(userIds, event.UserId) {
+			return false
+		}
+		if country != "" && !strings.EqualFold(event.User.Address.Country, country) {
+			return false
+		}
+		return true
+	}
+}
+
+func eventQueryParameters() []openapi.Parameter {
+	return []openapi.Parameter{
+		{Name: "user_id", In: "query", Description: "Only the events of this user", Schema: &openapi.Schema{Type: "string", Format: "uuid"}},
+		{Name: "country", In: "query", Description: "Only the events of users living in this country", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "Last-Event-ID", In: "header", Description: "Resume after this event", Schema: &openapi.Schema{Type: "integer", Format: "int64"}},
+	}
+}
+
+func eventPayload(event events.Event, rep UserRepresentation) EventResponse {
+	return EventResponse{
+		Id:         event.Id,
+		Type:       event.Type,
+		UserId:     event.UserId,
+		Actor:      event.Actor,
+		OccurredAt: event.OccurredAt,
+		User:       rep.FromUser(event.User),
+	}
+}
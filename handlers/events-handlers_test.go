@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// sentEvent is an event as read from the stream
+type sentEvent struct {
+	id      string
+	name    string
+	payload EventResponse
+}
+
+// readEvents sends the request to the stream and returns the events sent before the
+// request ended, the request context is done from the start
+func readEvents(t *testing.T, userStream *events.Stream, target string, lastEventId string) (*httptest.ResponseRecorder, []sentEvent) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	rec := httptest.NewRecorder()
+	StreamUserEvents(userStream, UserV1)(rec, req)
+
+	sent := make([]sentEvent, 0)
+	current := sentEvent{}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		field, value, _ := strings.Cut(scanner.Text(), ": ")
+		switch field {
+		case "id":
+			current.id = value
+		case "event":
+			current.name = value
+		case "data":
+			if err := json.Unmarshal([]byte(value), &current.payload); err != nil {
+				t.Fatal(err)
+			}
+		case "":
+			sent = append(sent, current)
+			current = sentEvent{}
+		}
+	}
+	return rec, sent
+}
+
+func newStreamedUserService(t *testing.T) (*services.UserService, *events.Stream) {
+	t.Helper()
+	userStream := events.NewStream(10)
+	userService := services.NewUserService(memoryTenants[entities.User](newTestTenants()))
+	userService.OnChange(userStream.Handle)
+	return userService, userStream
+}
+
+func createUser(t *testing.T, userService *services.UserService, name string, country string) uuid.UUID {
+	t.Helper()
+	id, err := userService.Create(context.Background(), entities.UserRequest{
+		Name:     name,
+		LastName: "Lee",
+		Email:    name + "@example.com",
+		Address:  &entities.Address{City: "Rome", Country: country, AddressString: "Via 1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestUserEventsResumeAfterTheLastEventId(t *testing.T) {
+	userService, userStream := newStreamedUserService(t)
+	ann := createUser(t, userService, "ann", "IT")
+	bea := createUser(t, userService, "bea", "AR")
+	carl := createUser(t, userService, "carl", "IT")
+
+	rec, sent := readEvents(t, userStream, "/v1/users/events", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != EventStream {
+		t.Fatalf("got status %d and Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if len(sent) != 3 || sent[0].id != "1" || sent[0].name != string(events.UserCreated) || sent[0].payload.UserId != ann {
+		t.Fatalf("got the events %+v", sent)
+	}
+
+	_, sent = readEvents(t, userStream, "/v1/users/events", "1")
+	if len(sent) != 2 || sent[0].id != "2" || sent[0].payload.UserId != bea || sent[1].id != "3" || sent[1].payload.UserId != carl {
+		t.Errorf("got the events %+v after the first one", sent)
+	}
+
+	if _, sent = readEvents(t, userStream, "/v1/users/events", "3"); len(sent) != 0 {
+		t.Errorf("got the events %+v after the last one", sent)
+	}
+}
+
+func TestUserEventsAreFiltered(t *testing.T) {
+	userService, userStream := newStreamedUserService(t)
+	ann := createUser(t, userService, "ann", "IT")
+	createUser(t, userService, "bea", "AR")
+	carl := createUser(t, userService, "carl", "IT")
+
+	_, sent := readEvents(t, userStream, "/v1/users/events?country=it", "")
+	if len(sent) != 2 || sent[0].payload.UserId != ann || sent[1].payload.UserId != carl {
+		t.Errorf("got the events %+v of Italy", sent)
+	}
+	_, sent = readEvents(t, userStream, "/v1/users/events?user_id="+carl.String(), "")
+	if len(sent) != 1 || sent[0].id != "3" {
+		t.Errorf("got the events %+v of carl", sent)
+	}
+}
+
+func TestUserEventsRefuseInvalidQueries(t *testing.T) {
+	_, userStream := newStreamedUserService(t)
+	for _, test := range []struct {
+		target      string
+		lastEventId string
+	}{
+		{target: "/v1/users/events?user_id=ann"},
+		{target: "/v1/users/events", lastEventId: "last"},
+	} {
+		if rec, _ := readEvents(t, userStream, test.target, test.lastEventId); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s with Last-Event-ID %q: got status %d", test.target, test.lastEventId, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// parseListQuery reads the sort and filter parameters of a user listing:
+// ?sort=-created_at&created_after=2023-01-01T00:00:00Z&created_by=admin&tag=vip&attribute=team:core
+func parseListQuery(r *http.Request) (services.ListQuery, error) {
+	params := r.URL.Query()
+	query := services.ListQuery{
+		Sort:       strings.TrimPrefix(params.Get("sort"), "-"),
+		CreatedBy:  params.Get("created_by"),
+		UpdatedBy:  params.Get("updated_by"),
+		Tags:       entities.NormalizeTags(params["tag"]),
+		Attributes: make(map[string]string),
+	}
+	for _, attribute := range params["attribute"] {
+		name, value, found := strings.Cut(attribute, ":")
+		if !found || name == "" {
+			return query, fmt.Errorf("attribute: %q must be name:value", attribute)
+		}
+		query.Attributes[name] = value
+	}
+	// A leading "-" sorts in descending order
+	query.Descending = strings.HasPrefix(params.Get("sort"), "-")
+	if err := query.Validate(); err != nil {
+		return query, err
+	}
+
+	timeParams := map[string]*time.Time{
+		"created_after":  &query.CreatedAfter,
+		"created_before": &query.CreatedBefore,
+		"updated_after":  &query.UpdatedAfter,
+		"updated_before": &query.UpdatedBefore,
+	}
+	for name, target := range timeParams {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("%s: %w", name, err)
+		}
+		*target = parsed
+	}
+	return query, nil
+}
+
+// listQueryParameters documents the parameters read by parseListQuery
+func listQueryParameters() []openapi.Parameter {
+	sortValues := make([]any, 0, len(services.SortFields)*2)
+	for _, field := range services.SortFields {
+		sortValues = append(sortValues, field, "-"+field)
+	}
+	dateTime := &openapi.Schema{Type: "string", Format: "date-time"}
+	return []openapi.Parameter{
+		{Name: "sort", In: "query", Description: "Field to sort by, prefixed with - for descending order", Schema: &openapi.Schema{Type: "string", Enum: sortValues}},
+		{Name: "created_after", In: "query", Schema: dateTime},
+		{Name: "created_before", In: "query", Schema: dateTime},
+		{Name: "updated_after", In: "query", Schema: dateTime},
+		{Name: "updated_before", In: "query", Schema: dateTime},
+		{Name: "created_by", In: "query", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "updated_by", In: "query", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "tag", In: "query", Description: "Only the users with this tag, can be repeated", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "attribute", In: "query", Description: "Only the users with this custom attribute value, as name:value, can be repeated", Schema: &openapi.Schema{Type: "string"}},
+	}
+}
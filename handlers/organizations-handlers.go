@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var userIdParameter = openapi.Parameter{
+	Name:   "userId",
+	In:     "path",
+	Schema: &openapi.Schema{Type: "string", Format: "uuid"},
+}
+
+// MemberResponse is a user of an organization in the representation of the api version
+type MemberResponse struct {
+	User  any       `json:"user" xml:"user" yaml:"user"`
+	Role  string    `json:"role" xml:"role" yaml:"role"`
+	Since time.Time `json:"since" xml:"since" yaml:"since"`
+}
+
+// MemberOfResponse is an organization of a user
+type MemberOfResponse struct {
+	Organization entities.Organization `json:"organization" xml:"organization" yaml:"organization"`
+	Role         string                `json:"role" xml:"role" yaml:"role"`
+	Since        time.Time             `json:"since" xml:"since" yaml:"since"`
+}
+
+func GetOrganizationMembers(membershipService *services.MembershipService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		members, err := membershipService.Members(r.Context(), id)
+		if err != nil {
+			sendResourceError(w, r, err)
+			return
+		}
+		sendList(w, r, "members", "member", db.NewSliceIterator(members, db.DefaultBatchSize), memberPayload(rep))
+	}
+}
+
+func AddOrganizationMember(membershipService *services.MembershipService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		organizationId, userId, ok := parseMembershipIds(w, r)
+		if !ok {
+			return
+		}
+		req, ok := decodeRequest[entities.MembershipRequest](w, r)
+		if !ok {
+			return
+		}
+		membership, err := membershipService.AddMember(r.Context(), organizationId, userId, req.Role)
+		if errors.Is(err, db.ErrUserNotFound) {
+			sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			sendResourceError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "membership", membership)
+	}
+}
+
+func RemoveOrganizationMember(membershipService *services.MembershipService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		organizationId, userId, ok := parseMembershipIds(w, r)
+		if !ok {
+			return
+		}
+		if err := membershipService.RemoveMember(r.Context(), organizationId, userId); err != nil {
+			sendError(w, r, "Membership not found", http.StatusNotFound, err.Error())
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "result", IdResponse{Id: entities.MembershipId(organizationId, userId)})
+	}
+}
+
+func GetUserOrganizations(membershipService *services.MembershipService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		organizations, err := membershipService.Organizations(r.Context(), id)
+		if err != nil {
+			sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
+			return
+		}
+		sendList(w, r, "organizations", "organization", db.NewSliceIterator(organizations, db.DefaultBatchSize), memberOfPayload)
+	}
+}
+
+// RegisterOrganizationRoutes mounts the organizations and their members on the router
+func RegisterOrganizationRoutes(router *mux.Router, organizations *services.ResourceService[entities.Organization, entities.OrganizationRequest], membershipService *services.MembershipService, spec *openapi.Spec, rep UserRepresentation) {
+	RegisterResourceRoutes(router, Resource[entities.Organization, entities.OrganizationRequest]{Service: organizations}, spec)
+
+	tags := []string{"organizations"}
+	spec.DocumentRoute(router.HandleFunc("/{id}/users", GetOrganizationMembers(membershipService, rep)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the users of an organization with their roles",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           []MemberResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/users/{userId}", AddOrganizationMember(membershipService)).Methods(http.MethodPut), openapi.Operation{
+		Summary:            "Add a user to an organization, or change its role",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter, userIdParameter},
+		Request:            entities.MembershipRequest{},
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           entities.Membership{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusUnsupportedMediaType},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/users/{userId}", RemoveOrganizationMember(membershipService)).Methods(http.MethodDelete), openapi.Operation{
+		Summary:            "Remove a user from an organization",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter, userIdParameter},
+		Response:           IdResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+}
+
+// RegisterUserOrganizationsRoute mounts the organizations of a user on a user router
+func RegisterUserOrganizationsRoute(router *mux.Router, membershipService *services.MembershipService, spec *openapi.Spec) {
+	spec.DocumentRoute(router.HandleFunc("/{id}/organizations", GetUserOrganizations(membershipService)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the organizations of a user with its roles",
+		Tags:               []string{"organizations"},
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           []MemberOfResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+}
+
+func parseMembershipIds(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	params := mux.Vars(r)
+	organizationId, err := uuid.Parse(params["id"])
+	if err != nil {
+		sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+		return uuid.Nil, uuid.Nil, false
+	}
+	userId, err := uuid.Parse(params["userId"])
+	if err != nil {
+		sendError(w, r, "Invalid user id", http.StatusBadRequest, err.Error())
+		return uuid.Nil, uuid.Nil, false
+	}
+	return organizationId, userId, true
+}
+
+func memberPayload(rep UserRepresentation) func(services.Member) any {
+	return func(member services.Member) any {
+		return MemberResponse{
+			User:  rep.FromUser(member.User),
+			Role:  member.Membership.Role,
+			Since: member.Membership.CreatedAt,
+		}
+	}
+}
+
+func memberOfPayload(memberOf services.MemberOf) any {
+	return MemberOfResponse{
+		Organization: memberOf.Organization,
+		Role:         memberOf.Membership.Role,
+		Since:        memberOf.Membership.CreatedAt,
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrRateLimited = errors.New("too many requests, try again later")
+)
+
+// rateWindow counts the requests of a client since start
+type rateWindow struct {
+	start    time.Time
+	requests int
+}
+
+// rateLimiter allows limit requests per client in every window
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	clients map[string]rateWindow
+	// lastPrune is when the windows that ended were last dropped
+	lastPrune time.Time
+}
+
+// allow counts a request of the client, it returns how long to wait when it is over the limit
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) >= l.window {
+		for key, window := range l.clients {
+			if now.Sub(window.start) >= l.window {
+				delete(l.clients, key)
+			}
+		}
+		l.lastPrune = now
+	}
+	window, found := l.clients[client]
+	if !found || now.Sub(window.start) >= l.window {
+		window = rateWindow{start: now}
+	}
+	if window.requests >= l.limit {
+		return false, window.start.Add(l.window).Sub(now)
+	}
+	window.requests++
+	l.clients[client] = window
+	return true, 0
+}
+
+// RateLimit answers 429 to the clients, told apart by their ip, that send more than limit
+// requests to the router in a window, with a Retry-After header in seconds
+func RateLimit(router *mux.Router, limit int, window time.Duration) {
+	limiter := &rateLimiter{limit: limit, window: window, clients: map[string]rateWindow{}}
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				client = r.RemoteAddr
+			}
+			if ok, wait := limiter.allow(client, time.Now()); !ok {
+				w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+				sendError(w, r, "Too many requests", http.StatusTooManyRequests, ErrRateLimited.Error())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRateLimitCountsEveryClientApart(t *testing.T) {
+	r := mux.NewRouter()
+	limited := r.PathPrefix("/auth").Subrouter()
+	RateLimit(limited, 2, time.Minute)
+	limited.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	request := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+		req.RemoteAddr = client + ":40000"
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := request("192.0.2.1"); rec.Code != http.StatusNoContent {
+			t.Fatalf("request %d: got %d, want %d", i+1, rec.Code, http.StatusNoContent)
+		}
+	}
+	rec := request("192.0.2.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("over the limit: got %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("got Retry-After %q, want 60", rec.Header().Get("Retry-After"))
+	}
+	if rec := request("192.0.2.2"); rec.Code != http.StatusNoContent {
+		t.Errorf("another client: got %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
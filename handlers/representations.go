@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"reflect"
+)
+
+var (
+	ErrUnexpectedPayload = errors.New("unexpected request payload for this api version")
+)
+
+// UserRepresentation translates users between the service model and the
+// payloads of one API version, so a new version can change the shape of a
+// user without touching the clients of the older ones.
+type UserRepresentation interface {
+	Version() string
+	// NewRequest returns a pointer the request body is decoded into
+	NewRequest() any
+	// ToUserRequest converts a decoded request body into the service request
+	ToUserRequest(payload any) (entities.UserRequest, error)
+	// FromUser builds the response payload of a user
+	FromUser(user entities.User) any
+}
+
+var UserV1 UserRepresentation = userV1{}
+
+// userV1 exposes the service model as it is
+type userV1 struct{}
+
+func (userV1) Version() string {
+	return "v1"
+}
+
+func (userV1) NewRequest() any {
+	return new(entities.UserRequest)
+}
+
+func (userV1) ToUserRequest(payload any) (entities.UserRequest, error) {
+	userReq, ok := payload.(*entities.UserRequest)
+	if !ok {
+		return entities.UserRequest{}, ErrUnexpectedPayload
+	}
+	return *userReq, nil
+}
+
+func (userV1) FromUser(user entities.User) any {
+	return user
+}
+
+// requestSample and responseSample are the payload types documented in the OpenAPI spec
+func requestSample(rep UserRepresentation) any {
+	return reflect.ValueOf(rep.NewRequest()).Elem().Interface()
+}
+
+func responseSample(rep UserRepresentation) any {
+	return rep.FromUser(entities.User{})
+}
+
+// listSample documents a list of responses of the representation
+func listSample(rep UserRepresentation) any {
+	return reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(responseSample(rep))), 0, 0).Interface()
+}
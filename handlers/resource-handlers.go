@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Resource is an entity T served with the generic CRUD handlers, created and updated with
+// validated requests R. ToPayload converts the entities in the responses, they are sent as they are when nil.
+type Resource[T entities.StorageObject, R any] struct {
+	Service   *services.ResourceService[T, R]
+	ToPayload func(T) any
+	// ToCreatedPayload converts the entity in the response of its creation, ToPayload does when nil
+	ToCreatedPayload func(T) any
+	// Description is documented on every route of the resource
+	Description string
+	// AdminOnly resources serve only the admin requests
+	AdminOnly bool
+	// AdminWrites resources are read by every request, only the admin requests change them
+	AdminWrites bool
+}
+
+func (res Resource[T, R]) payload(thing T) any {
+	if res.ToPayload == nil {
+		return thing
+	}
+	return res.ToPayload(thing)
+}
+
+func (res Resource[T, R]) createdPayload(thing T) any {
+	if res.ToCreatedPayload == nil {
+		return res.payload(thing)
+	}
+	return res.ToCreatedPayload(thing)
+}
+
+// adminOnly reports if a route of the resource, writing or not, serves only the admin requests
+func (res Resource[T, R]) adminOnly(write bool) bool {
+	return res.AdminOnly || (write && res.AdminWrites)
+}
+
+// handle wraps the handler of a route of the resource in the admin check when it is admin only
+func (res Resource[T, R]) handle(handler http.HandlerFunc, write bool) http.HandlerFunc {
+	if res.adminOnly(write) {
+		return RequireAdmin(handler)
+	}
+	return handler
+}
+
+// errors adds the errors of the admin check to the documented errors of a route
+func (res Resource[T, R]) errors(write bool, codes ...int) []int {
+	if res.adminOnly(write) {
+		codes = append(codes, http.StatusUnauthorized, http.StatusForbidden)
+		slices.Sort(codes)
+	}
+	return codes
+}
+
+func GetResourceById[T entities.StorageObject, R any](res Resource[T, R]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		thing, err := res.Service.Get(r.Context(), id)
+		if err != nil {
+			sendResourceError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, res.Service.Name(), res.payload(thing))
+	}
+}
+
+func GetAllResources[T entities.StorageObject, R any](res Resource[T, R]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := res.Service.Name()
+		sendList(w, r, name+"s", name, res.Service.Iterate(r.Context(), db.DefaultBatchSize), res.payload)
+	}
+}
+
+func CreateResource[T entities.StorageObject, R any](res Resource[T, R]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRequest[R](w, r)
+		if !ok {
+			return
+		}
+		thing, err := res.Service.Create(r.Context(), req)
+		if err != nil {
+			sendResourceError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusCreated, res.Service.Name(), res.createdPayload(thing))
+	}
+}
+
+func UpdateResource[T entities.StorageObject, R any](res Resource[T, R]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		req, ok := decodeRequest[R](w, r)
+		if !ok {
+			return
+		}
+		thing, err := res.Service.Update(r.Context(), id, req)
+		if err != nil {
+			sendResourceError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, res.Service.Name(), res.payload(thing))
+	}
+}
+
+func DeleteResource[T entities.StorageObject, R any](res Resource[T, R]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		id, err = res.Service.Delete(r.Context(), id)
+		if err != nil {
+			sendResourceError(w, r, err)
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "result", IdResponse{Id: id})
+	}
+}
+
+// RegisterResourceRoutes mounts and documents the CRUD routes of the resource on the router:
+// GET and POST on the collection, GET, PUT and DELETE on /{id}
+func RegisterResourceRoutes[T entities.StorageObject, R any](router *mux.Router, res Resource[T, R], spec *openapi.Spec) {
+	name := res.Service.Name()
+	tags := []string{name + "s"}
+	var sample T
+	var request R
+
+	spec.DocumentRoute(router.HandleFunc("", res.handle(GetAllResources(res), false)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the " + name + "s",
+		Description:        res.Description,
+		Tags:               tags,
+		Response:           reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(res.payload(sample))), 0, 0).Interface(),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             res.errors(false, http.StatusNotAcceptable, http.StatusInternalServerError),
+	})
+	spec.DocumentRoute(router.HandleFunc("", res.handle(CreateResource(res), true)).Methods(http.MethodPost), openapi.Operation{
+		Summary:            "Create a " + name,
+		Description:        res.Description,
+		Tags:               tags,
+		Request:            request,
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           res.createdPayload(sample),
+		Status:             http.StatusCreated,
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             res.errors(true, http.StatusBadRequest, http.StatusNotAcceptable, http.StatusConflict, http.StatusUnsupportedMediaType, http.StatusInternalServerError),
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}", res.handle(GetResourceById(res), false)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "Get a " + name + " by id",
+		Description:        res.Description,
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           res.payload(sample),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             res.errors(false, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable),
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}", res.handle(UpdateResource(res), true)).Methods(http.MethodPut), openapi.Operation{
+		Summary:            "Update a " + name,
+		Description:        res.Description,
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Request:            request,
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           res.payload(sample),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             res.errors(true, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable, http.StatusConflict, http.StatusUnsupportedMediaType, http.StatusInternalServerError),
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}", res.handle(DeleteResource(res), true)).Methods(http.MethodDelete), openapi.Operation{
+		Summary:            "Delete a " + name,
+		Description:        res.Description,
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           IdResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             res.errors(true, http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable),
+	})
+}
+
+// decodeRequest decodes and validates the body, on failure the error response is already sent
+func decodeRequest[R any](w http.ResponseWriter, r *http.Request) (R, bool) {
+	var req R
+	if err := decodeBody(r, &req); err != nil {
+		sendDecodeError(w, r, err)
+		return req, false
+	}
+	if err := validator.New().Struct(req); err != nil {
+		sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+// sendResourceError answers 400 for the refused requests, 404 for the missing records, 409 for
+// the conflicts and 500 for the rest
+func sendResourceError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, services.ErrResourceInvalid) {
+		sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrResourceNotFound) {
+		sendError(w, r, "Not found with this id", http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrResourceConflict) {
+		sendError(w, r, "Conflict", http.StatusConflict, err.Error())
+		return
+	}
+	sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+}
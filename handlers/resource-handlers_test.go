@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// newOrganizationRouter serves the organizations with the generic resource routes
+func newOrganizationRouter(adminOnly bool) (*mux.Router, *openapi.Spec) {
+	organizations := services.NewOrganizationService(memoryTenants[entities.Organization](newTestTenants()), services.SystemClock)
+	router := mux.NewRouter()
+	router.Use(AdminMiddleware(services.AdminKeys{testAdminKey}))
+	spec := openapi.New("test", "1.0.0")
+	RegisterResourceRoutes(router.PathPrefix("/organizations").Subrouter(), Resource[entities.Organization, entities.OrganizationRequest]{
+		Service:   organizations,
+		AdminOnly: adminOnly,
+	}, spec)
+	return router, spec
+}
+
+func serveResource(router *mux.Router, method string, path string, body string, adminKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if adminKey != "" {
+		req.Header.Set(AdminKeyHeader, adminKey)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestResourceRoutesServeTheCRUDOfTheResource(t *testing.T) {
+	router, spec := newOrganizationRouter(false)
+
+	rec := serveResource(router, http.MethodPost, "/organizations", `{"name":"Acme"}`, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d: %s", rec.Code, rec.Body)
+	}
+	created := entities.Organization{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	path := "/organizations/" + created.Id.String()
+
+	if rec := serveResource(router, http.MethodGet, path, "", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Acme") {
+		t.Errorf("get: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serveResource(router, http.MethodGet, "/organizations", "", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), created.Id.String()) {
+		t.Errorf("list: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serveResource(router, http.MethodPut, path, `{"name":"Acme Inc"}`, ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Acme Inc") {
+		t.Errorf("update: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serveResource(router, http.MethodDelete, path, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("delete: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serveResource(router, http.MethodGet, path, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get deleted: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := serveResource(router, http.MethodPut, path, `{"name":"Acme"}`, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("update deleted: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if rec := serveResource(router, http.MethodPost, "/organizations", `{"description":"no name"}`, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("create without a required field: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := serveResource(router, http.MethodGet, "/organizations/not-an-id", "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("get an invalid id: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if undocumented := spec.Undocumented(router); len(undocumented) > 0 {
+		t.Errorf("undocumented routes %v", undocumented)
+	}
+}
+
+func TestAdminOnlyResourceRoutesNeedAnAdminKey(t *testing.T) {
+	router, _ := newOrganizationRouter(true)
+
+	if rec := serveResource(router, http.MethodGet, "/organizations", "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("list: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serveResource(router, http.MethodPost, "/organizations", `{"name":"Acme"}`, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("create: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serveResource(router, http.MethodPost, "/organizations", `{"name":"Acme"}`, testAdminKey); rec.Code != http.StatusCreated {
+		t.Errorf("create as admin: got %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
+
+func TestAdminWritesResourcesAreReadByEveryRequest(t *testing.T) {
+	attributes := services.NewAttributeService(memoryTenants[entities.AttributeDefinition](newTestTenants()), services.SystemClock)
+	router := mux.NewRouter()
+	router.Use(AdminMiddleware(services.AdminKeys{testAdminKey}))
+	RegisterResourceRoutes(router.PathPrefix("/attributes").Subrouter(), Resource[entities.AttributeDefinition, entities.AttributeDefinitionRequest]{
+		Service:     attributes,
+		AdminWrites: true,
+	}, openapi.New("test", "1.0.0"))
+	body := `{"name":"plan","type":"string"}`
+
+	if rec := serveResource(router, http.MethodPost, "/attributes", body, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("create: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec := serveResource(router, http.MethodPost, "/attributes", body, testAdminKey)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create as admin: got %d: %s", rec.Code, rec.Body)
+	}
+	created := entities.AttributeDefinition{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	path := "/attributes/" + created.Id.String()
+	if rec := serveResource(router, http.MethodPut, path, `{"name":"plan","type":"number"}`, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("update: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serveResource(router, http.MethodDelete, path, "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("delete: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serveResource(router, http.MethodGet, path, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("get: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := serveResource(router, http.MethodGet, "/attributes", "", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "plan") {
+		t.Errorf("list: got %d: %s", rec.Code, rec.Body)
+	}
+}
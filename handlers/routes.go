@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"example/bootcamp_ex1/audit"
+	"example/bootcamp_ex1/events"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+var idParameter = openapi.Parameter{
+	Name:   "id",
+	In:     "path",
+	Schema: &openapi.Schema{Type: "string", Format: "uuid"},
+}
+
+var versionParameter = openapi.Parameter{
+	Name:   "version",
+	In:     "path",
+	Schema: &openapi.Schema{Type: "integer", Format: "int32"},
+}
+
+// RegisterUserRoutes mounts the user handlers of one api version on the router and documents
+// them in the spec. collectionPath is the path of the list and create routes inside the router.
+func RegisterUserRoutes(router *mux.Router, collectionPath string, userService *services.UserService, userStream *events.Stream, spec *openapi.Spec, rep UserRepresentation) {
+	spec.SetErrorType(ErrorResponse{})
+	tags := []string{"users " + rep.Version()}
+
+	spec.DocumentRoute(router.HandleFunc(collectionPath, GetAllUsers(userService, rep)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List all the users",
+		Tags:               tags,
+		Parameters:         listQueryParameters(),
+		Response:           listSample(rep),
+		ResponseMediaTypes: responseMediaTypes(true),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotAcceptable, http.StatusInternalServerError},
+	})
+	// Registered before /{id} so "deleted" and "events" aren't taken as an id
+	spec.DocumentRoute(router.HandleFunc("/deleted", RequireAdmin(GetDeletedUsers(userService, rep))).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the deleted users that can still be restored",
+		Description:        "Only admin requests, with the " + AdminKeyHeader + " header, can list the deleted users.",
+		Tags:               []string{"admin"},
+		Response:           listSample(rep),
+		ResponseMediaTypes: responseMediaTypes(true),
+		Errors:             []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotAcceptable, http.StatusInternalServerError},
+	})
+	spec.DocumentRoute(router.HandleFunc("/events", StreamUserEvents(userStream, rep)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "Stream the user changes as Server-Sent Events",
+		Description:        "Every event has the user after the change. Clients resume after a disconnection by sending the id of the last event received in the Last-Event-ID header.",
+		Tags:               tags,
+		Parameters:         eventQueryParameters(),
+		Response:           EventResponse{},
+		ResponseMediaTypes: []string{EventStream},
+		Errors:             []int{http.StatusBadRequest, http.StatusInternalServerError},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}", GetUserById(userService, rep)).Methods(http.MethodGet), openapi.Operation{
+		Summary: "Get a user by id",
+		Tags:    tags,
+		Parameters: []openapi.Parameter{idParameter, {
+			Name:        "asOf",
+			In:          "query",
+			Description: "Read the user as it was at this time",
+			Schema:      &openapi.Schema{Type: "string", Format: "date-time"},
+		}},
+		Response:           responseSample(rep),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+	spec.DocumentRoute(router.HandleFunc(collectionPath, CreateUser(userService, rep)).Methods(http.MethodPost), openapi.Operation{
+		Summary:            "Create a user",
+		Description:        "The user starts inactive and gets an email to verify its address. Only an admin request, with the " + AdminKeyHeader + " header, can create it active.",
+		Tags:               tags,
+		Request:            requestSample(rep),
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           IdResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict, http.StatusNotAcceptable, http.StatusUnsupportedMediaType},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}", UpdateUser(userService, rep)).Methods(http.MethodPut), openapi.Operation{
+		Summary: "Update a user",
+		Description: "Without active the user keeps its activation, only an admin request can change it. Only the user, with its access token, " +
+			"or an admin request can change the email, and the new email has to be verified again.",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Request:            requestSample(rep),
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           responseSample(rep),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusNotAcceptable, http.StatusUnsupportedMediaType},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}", DeleteUser(userService, rep)).Methods(http.MethodDelete), openapi.Operation{
+		Summary:            "Delete a user, it can be restored until it is purged",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           IdResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/restore", RestoreUser(userService, rep)).Methods(http.MethodPost), openapi.Operation{
+		Summary:            "Restore a deleted user",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           responseSample(rep),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/audit", RequireAdmin(GetUserAuditLog(userService))).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the audit log of a user",
+		Description:        "Only admin requests, with the " + AdminKeyHeader + " header, can read the audit log.",
+		Tags:               []string{"audit"},
+		Parameters:         append([]openapi.Parameter{idParameter}, auditQueryParameters()...),
+		Response:           []audit.Entry{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusNotAcceptable, http.StatusInternalServerError},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/versions", GetUserVersions(userService, rep)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the stored versions of a user",
+		Tags:               []string{"versions"},
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           []VersionResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/versions/{version}", GetUserVersion(userService, rep)).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "Get one version of a user",
+		Tags:               []string{"versions"},
+		Parameters:         []openapi.Parameter{idParameter, versionParameter},
+		Response:           VersionResponse{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotAcceptable},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/revert/{version}", RevertUser(userService, rep)).Methods(http.MethodPost), openapi.Operation{
+		Summary:            "Update a user with the fields of one of its versions",
+		Tags:               []string{"versions"},
+		Parameters:         []openapi.Parameter{idParameter, versionParameter},
+		Response:           responseSample(rep),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusNotAcceptable},
+	})
+}
+
+// RegisterOpenAPIRoute serves the document of every documented route of the router
+func RegisterOpenAPIRoute(router *mux.Router, spec *openapi.Spec) {
+	spec.DocumentRoute(router.HandleFunc("/openapi.json", spec.Handler(router)).Methods(http.MethodGet), openapi.Operation{
+		Summary:  "OpenAPI document of this API",
+		Tags:     []string{"docs"},
+		Response: openapi.Document{},
+	})
+}
+
+// GraphQLRequest documents the body of the GraphQL route
+type GraphQLRequest struct {
+	Query         string         `json:"query" validate:"required"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// GraphQLResponse documents the answer of the GraphQL route, errors are reported in the body
+type GraphQLResponse struct {
+	Data   any   `json:"data"`
+	Errors []any `json:"errors,omitempty"`
+}
+
+// RegisterGraphQLRoute serves the GraphQL api of the users on the router
+func RegisterGraphQLRoute(router *mux.Router, graphql http.Handler, spec *openapi.Spec) {
+	spec.DocumentRoute(router.Handle("/graphql", graphql).Methods(http.MethodPost), openapi.Operation{
+		Summary:     "Query and change the users with GraphQL",
+		Description: "Queries user(id) and users(filter, first, after), mutations createUser, updateUser and deleteUser.",
+		Tags:        []string{"graphql"},
+		Request:     GraphQLRequest{},
+		Response:    GraphQLResponse{},
+		Errors:      []int{http.StatusBadRequest},
+	})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/search"
+	"example/bootcamp_ex1/services"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Results returned by a search without a limit, and the most it can ask for
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchResultResponse is a user found by a search in the representation of the api version
+type SearchResultResponse struct {
+	User  any     `json:"user" xml:"user" yaml:"user"`
+	Score float64 `json:"score" xml:"score" yaml:"score"`
+}
+
+func SearchUsers(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := parseSearchLimit(r)
+		if err != nil {
+			sendError(w, r, "Invalid query", http.StatusBadRequest, err.Error())
+			return
+		}
+		results, err := userService.Search(r.Context(), r.URL.Query().Get("q"), limit)
+		if errors.Is(err, search.ErrEmptyQuery) {
+			sendError(w, r, "Invalid query", http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendList(w, r, "results", "result", db.NewSliceIterator(results, db.DefaultBatchSize), searchResultPayload(rep))
+	}
+}
+
+// RegisterUserSearchRoute mounts the search on a user router, before RegisterUserRoutes
+// so "search" isn't taken as a user id
+func RegisterUserSearchRoute(router *mux.Router, userService *services.UserService, spec *openapi.Spec, rep UserRepresentation) {
+	spec.DocumentRoute(router.HandleFunc("/search", SearchUsers(userService, rep)).Methods(http.MethodGet), openapi.Operation{
+		Summary: "Search users by name, last name, email or city",
+		Description: "Users having every word of the query, as a whole word or the start of one, best matches first. " +
+			"Case and accents are ignored, so \"nunez\" finds \"Núñez\".",
+		Tags: []string{"users " + rep.Version()},
+		Parameters: []openapi.Parameter{
+			{Name: "q", In: "query", Required: true, Description: "Words to search", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "limit", In: "query", Description: fmt.Sprintf("Most results returned, %d by default", defaultSearchLimit), Schema: &openapi.Schema{Type: "integer", Format: "int32"}},
+		},
+		Response:           []SearchResultResponse{},
+		ResponseMediaTypes: responseMediaTypes(true),
+		Errors:             []int{http.StatusBadRequest, http.StatusNotAcceptable, http.StatusInternalServerError},
+	})
+}
+
+func parseSearchLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultSearchLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > maxSearchLimit {
+		return 0, fmt.Errorf("limit: %q must be a number from 1 to %d", value, maxSearchLimit)
+	}
+	return limit, nil
+}
+
+func searchResultPayload(rep UserRepresentation) func(services.SearchResult) any {
+	return func(result services.SearchResult) any {
+		return SearchResultResponse{
+			User:  rep.FromUser(result.User),
+			Score: result.Score,
+		}
+	}
+}
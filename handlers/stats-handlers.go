@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func GetUserStats(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := userService.Stats(r.Context())
+		if err != nil {
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "stats", stats)
+	}
+}
+
+// RegisterUserStatsRoute mounts the statistics on a user router, before RegisterUserRoutes
+// so "stats" isn't taken as a user id
+func RegisterUserStatsRoute(router *mux.Router, userService *services.UserService, spec *openapi.Spec) {
+	spec.DocumentRoute(router.HandleFunc("/stats", RequireAdmin(GetUserStats(userService))).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "Count the users by activation and by the country and city of their primary address",
+		Description:        "The counts are kept up to date by the storage on every change, the users aren't read. Only admin requests, with the " + AdminKeyHeader + " header, can read them.",
+		Tags:               []string{"admin"},
+		Response:           services.UserStats{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotAcceptable, http.StatusInternalServerError},
+	})
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestStatsAreAdminOnly(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(AdminMiddleware(services.AdminKeys{testAdminKey}))
+	RegisterUserStatsRoute(router.PathPrefix("/v1/users").Subrouter(), newTestUserService(t, 2), openapi.New("test", "1.0.0"))
+
+	for _, test := range []struct {
+		name     string
+		adminKey string
+		want     int
+	}{
+		{name: "without the admin key", want: http.StatusForbidden},
+		{name: "with another key", adminKey: "guess", want: http.StatusUnauthorized},
+		{name: "of an admin", adminKey: testAdminKey, want: http.StatusOK},
+	} {
+		rec := serveTenant(router, http.MethodGet, "/v1/users/stats", "", "", "", test.adminKey)
+		if rec.Code != test.want {
+			t.Errorf("%s: got %d, want %d: %s", test.name, rec.Code, test.want, rec.Body)
+		}
+	}
+}
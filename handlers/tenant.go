@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/services"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const TenantHeader = "X-Tenant-ID"
+
+// TenantResolver finds the tenant of a request in the X-Tenant-ID header, the
+// subdomain of Domain and the "tenant" claim of a bearer token. The sources
+// present must agree, so a header can't override the tenant of a token, and the
+// header and subdomain are only trusted as Access allows.
+type TenantResolver struct {
+	// Domain resolves "acme.Domain" to the tenant acme, subdomains are ignored when empty
+	Domain string
+	Access services.TenantAccess
+}
+
+// WithCaller returns the context of the request with its tenant, db.DefaultTenant when no
+// source names one, and with its user when it has an access token
+func (t TenantResolver) WithCaller(r *http.Request) (context.Context, error) {
+	bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return t.Access.WithCaller(r.Context(), []string{r.Header.Get(TenantHeader), t.subdomain(r.Host)}, bearer)
+}
+
+// subdomain returns the label before Domain in the host, "" for other hosts
+func (t TenantResolver) subdomain(host string) string {
+	if t.Domain == "" {
+		return ""
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	label, found := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(t.Domain))
+	if !found || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// TenantMiddleware stores the tenant resolved for the request, and the user of its access
+// token, in its context
+func TenantMiddleware(resolver TenantResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, err := resolver.WithCaller(r)
+			switch {
+			case errors.Is(err, services.ErrInvalidToken), errors.Is(err, services.ErrTokenExpired), errors.Is(err, services.ErrTenantUnverified):
+				sendError(w, r, "Unauthorized", http.StatusUnauthorized, err.Error())
+				return
+			case errors.Is(err, services.ErrTenantMismatch):
+				sendError(w, r, "Forbidden", http.StatusForbidden, err.Error())
+				return
+			case errors.Is(err, db.ErrUnknownTenant):
+				sendError(w, r, "Unknown tenant", http.StatusNotFound, err.Error())
+				return
+			case errors.Is(err, services.ErrInvalidTenant):
+				sendError(w, r, "Invalid tenant", http.StatusBadRequest, err.Error())
+				return
+			case err != nil:
+				sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"example/bootcamp_ex1/graphqlapi"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+const testTenantSecret = "test-tenant-secret"
+
+// newTestTenants is the set of the tenants of the tests, acme and globex with the default one
+func newTestTenants() db.TenantSet {
+	tenants := db.NewMemoryTenantSet()
+	tenants.Add("acme", "globex")
+	return tenants
+}
+
+// memoryTenants keeps the records of the tenants of the set in memory
+func memoryTenants[T entities.StorageObject](tenants db.TenantSet, indexes ...db.Index[T]) *db.Tenants[T] {
+	return db.NewTenants(tenants, func(tenant string) db.Storage[T] {
+		return db.NewMemoryStorage(indexes...)
+	})
+}
+
+// tenantToken signs a tenant token of the tenant
+func tenantToken(tenant string) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"tenant":"`+tenant+`"}`))
+	mac := hmac.New(sha256.New, []byte(testTenantSecret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newTenantRouter serves the users and the graphql api of the tenants of newTestTenants
+func newTenantRouter(userService *services.UserService) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(AdminMiddleware(services.AdminKeys{testAdminKey}))
+	router.Use(TenantMiddleware(TenantResolver{
+		Domain: "users.example.com",
+		Access: services.TenantAccess{TokenSecret: []byte(testTenantSecret), Tenants: newTestTenants()},
+	}))
+	spec := openapi.New("test", "1.0.0")
+	RegisterUserRoutes(router.PathPrefix("/v1/users").Subrouter(), "", userService, events.NewStream(10), spec, UserV1)
+	RegisterGraphQLRoute(router, graphqlapi.NewHandler(userService), spec)
+	return router
+}
+
+// serveTenant serves the request with the tenant header, the bearer token and the admin key given
+func serveTenant(router *mux.Router, method string, target string, body string, tenant string, token string, adminKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if tenant != "" {
+		req.Header.Set(TenantHeader, tenant)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if adminKey != "" {
+		req.Header.Set(AdminKeyHeader, adminKey)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTenantOfARequestMustBeProven(t *testing.T) {
+	router := newTenantRouter(newTestUserService(t, 0))
+
+	for _, test := range []struct {
+		name     string
+		tenant   string
+		token    string
+		adminKey string
+		want     int
+	}{
+		{name: "no tenant", want: http.StatusOK},
+		{name: "bare header", tenant: "acme", want: http.StatusUnauthorized},
+		{name: "header with its token", tenant: "acme", token: tenantToken("acme"), want: http.StatusOK},
+		{name: "header with another token", tenant: "acme", token: tenantToken("globex"), want: http.StatusForbidden},
+		{name: "header of an admin", tenant: "acme", adminKey: testAdminKey, want: http.StatusOK},
+		{name: "unknown tenant", tenant: "initech", adminKey: testAdminKey, want: http.StatusNotFound},
+	} {
+		rec := serveTenant(router, http.MethodGet, "/v1/users", "", test.tenant, test.token, test.adminKey)
+		if rec.Code != test.want {
+			t.Errorf("%s: got %d, want %d: %s", test.name, rec.Code, test.want, rec.Body)
+		}
+	}
+
+	// A subdomain is a bare tenant name too
+	req := httptest.NewRequest(http.MethodGet, "http://acme.users.example.com/v1/users", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("bare subdomain: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestUsersOfATenantAreHiddenFromTheOthers(t *testing.T) {
+	userService := newTestUserService(t, 0)
+	router := newTenantRouter(userService)
+	acme := services.WithTenant(context.Background(), "acme")
+	id, err := userService.Create(acme, entities.UserRequest{
+		Name:     "Ann",
+		LastName: "Lee",
+		Email:    "ann@example.com",
+		Address:  &entities.Address{City: "Rome", Country: "IT", AddressString: "Via 1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// REST
+	if rec := serveTenant(router, http.MethodGet, "/v1/users/"+id.String(), "", "acme", tenantToken("acme"), ""); rec.Code != http.StatusOK {
+		t.Errorf("get in acme: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serveTenant(router, http.MethodGet, "/v1/users/"+id.String(), "", "", tenantToken("globex"), ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get in globex: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := serveTenant(router, http.MethodGet, "/v1/users/"+id.String(), "", "", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get in the default tenant: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// GraphQL
+	query := `{"query":"{ user(id: \"` + id.String() + `\") { id } }"}`
+	if rec := serveTenant(router, http.MethodPost, "/graphql", query, "", tenantToken("acme"), ""); !strings.Contains(rec.Body.String(), id.String()) {
+		t.Errorf("graphql in acme: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serveTenant(router, http.MethodPost, "/graphql", query, "", tenantToken("globex"), ""); strings.Contains(rec.Body.String(), id.String()) {
+		t.Errorf("graphql in globex got the user of acme: %s", rec.Body)
+	}
+}
@@ -1,19 +1,22 @@
 package handlers
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
+	"example/bootcamp_ex1/db"
 	"example/bootcamp_ex1/entities"
 	"example/bootcamp_ex1/services"
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
-func GetUserById(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+func GetUserById(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		idParam := params["id"]
@@ -24,75 +27,66 @@ func GetUserById(userService *services.UserService) func(w http.ResponseWriter,
 			return
 		}
 
-		user, err := userService.Get(id)
-
-		if err != nil {
-			sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
-			return
+		var user entities.User
+		// ?asOf= reads the user as it was at that time
+		if asOf := r.URL.Query().Get("asOf"); asOf != "" {
+			at, parseErr := time.Parse(time.RFC3339, asOf)
+			if parseErr != nil {
+				sendError(w, r, "Invalid asOf time", http.StatusBadRequest, parseErr.Error())
+				return
+			}
+			user, err = userService.GetAsOf(r.Context(), id, at)
+		} else {
+			user, err = userService.Get(r.Context(), id)
 		}
 
-		userPayload, err := json.Marshal(user)
 		if err != nil {
-			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(userPayload)
+		sendResponse(w, r, http.StatusOK, "user", rep.FromUser(user))
 
 	}
 }
 
-func GetAllUsers(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+func GetAllUsers(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		users, err := userService.GetAll()
-		if err != nil {
-			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
-			return
-		}
-		usersPayload, err := json.Marshal(users)
+		query, err := parseListQuery(r)
 		if err != nil {
-			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			sendError(w, r, "Invalid query", http.StatusBadRequest, err.Error())
 			return
 		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(usersPayload)
-
+		sendList(w, r, "users", "user", userService.List(r.Context(), query, db.DefaultBatchSize), rep.FromUser)
 	}
 }
 
-func CreateUser(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+func CreateUser(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var newUser entities.UserRequest
-		err := json.NewDecoder(r.Body).Decode(&newUser)
-
-		if err != nil {
-			sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+		newUser, ok := decodeUserRequest(w, r, rep)
+		if !ok {
 			return
 		}
 
-		validate := validator.New()
-		err = validate.Struct(newUser)
-		if err != nil {
-			sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+		id, err := userService.Create(r.Context(), newUser)
+		if errors.Is(err, services.ErrAdminOnly) || errors.Is(err, services.ErrOwnerOnly) {
+			sendError(w, r, "Forbidden", http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrEmailInUse) {
+			sendError(w, r, "Conflict", http.StatusConflict, err.Error())
 			return
 		}
-
-		id, err := userService.Create(newUser)
 		if err != nil {
 			sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"id": id.String(),
-		})
+		sendResponse(w, r, http.StatusOK, "result", IdResponse{Id: id})
 
 	}
 }
 
-func UpdateUser(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+func UpdateUser(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		id, err := uuid.Parse(params["id"])
@@ -101,33 +95,56 @@ func UpdateUser(userService *services.UserService) func(w http.ResponseWriter, r
 			return
 		}
 
-		var newUser entities.UserRequest
-		err = json.NewDecoder(r.Body).Decode(&newUser)
-		if err != nil {
+		newUser, ok := decodeUserRequest(w, r, rep)
+		if !ok {
+			return
+		}
+
+		user, err := userService.Update(r.Context(), id, newUser)
+
+		if errors.Is(err, services.ErrAdminOnly) || errors.Is(err, services.ErrOwnerOnly) {
+			sendError(w, r, "Forbidden", http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrEmailInUse) {
+			sendError(w, r, "Conflict", http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrInvalidAttribute) || errors.Is(err, services.ErrManyPrimaryAddresses) {
 			sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
 			return
 		}
+		if err != nil {
+			sendError(w, r, "Error", http.StatusNotFound, err.Error())
+			return
+		}
+
+		sendResponse(w, r, http.StatusOK, "user", rep.FromUser(user))
+	}
+}
 
-		validate := validator.New()
-		err = validate.Struct(newUser)
+func DeleteUser(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		id, err := uuid.Parse(params["id"])
 		if err != nil {
-			sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
 			return
 		}
 
-		user, err := userService.Update(id, newUser)
+		id, err = userService.Delete(r.Context(), id)
 
 		if err != nil {
 			sendError(w, r, "Error", http.StatusNotFound, err.Error())
 			return
+
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(user)
+		sendResponse(w, r, http.StatusOK, "result", IdResponse{Id: id})
 	}
 }
 
-func DeleteUser(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+func RestoreUser(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		id, err := uuid.Parse(params["id"])
@@ -136,28 +153,65 @@ func DeleteUser(userService *services.UserService) func(w http.ResponseWriter, r
 			return
 		}
 
-		id, err = userService.Delete(id)
-
+		user, err := userService.Restore(r.Context(), id)
 		if err != nil {
-			sendError(w, r, "Error", http.StatusNotFound, err.Error())
+			sendError(w, r, "Deleted user not found with this id", http.StatusNotFound, err.Error())
 			return
+		}
+
+		sendResponse(w, r, http.StatusOK, "user", rep.FromUser(user))
+	}
+}
 
+func GetDeletedUsers(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		users, err := userService.GetDeleted(r.Context())
+		if err != nil {
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+			return
 		}
+		sendList(w, r, "users", "user", db.NewSliceIterator(users, db.DefaultBatchSize), rep.FromUser)
+	}
+}
+
+// decodeUserRequest decodes and validates the body with the payload of the representation,
+// on failure the error response is already sent
+func decodeUserRequest(w http.ResponseWriter, r *http.Request, rep UserRepresentation) (entities.UserRequest, bool) {
+	payload := rep.NewRequest()
+	err := decodeBody(r, payload)
+	if err != nil {
+		sendDecodeError(w, r, err)
+		return entities.UserRequest{}, false
+	}
+
+	validate := validator.New()
+	err = validate.Struct(payload)
+	if err != nil {
+		sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+		return entities.UserRequest{}, false
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"id": id.String(),
-		})
+	userReq, err := rep.ToUserRequest(payload)
+	if err != nil {
+		sendError(w, r, "Unvalid body", http.StatusBadRequest, err.Error())
+		return entities.UserRequest{}, false
 	}
+	return userReq, true
+}
+
+type IdResponse struct {
+	Id uuid.UUID `json:"id" xml:"id" yaml:"id"`
+}
+
+type ErrorResponse struct {
+	Code         int
+	Message      string
+	ErrorDetails string
 }
 
 func sendError(w http.ResponseWriter, r *http.Request, msg string, statusCode int, errorDetails string) {
 	slog.Error(errorDetails)
-	err := struct {
-		Code         int
-		Message      string
-		ErrorDetails string
-	}{
+	err := ErrorResponse{
 		Code:    statusCode,
 		Message: msg,
 	}
@@ -166,7 +220,14 @@ func sendError(w http.ResponseWriter, r *http.Request, msg string, statusCode in
 		err.ErrorDetails = errorDetails
 	}
 
-	errorPayload, _ := json.Marshal(err)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(errorPayload)
+	// Errors fall back to JSON when the accepted media types can't be produced
+	encoder, negotiateErr := negotiate(r, false)
+	if negotiateErr != nil {
+		encoder = responseEncoders[0]
+	}
+	var errorPayload bytes.Buffer
+	encoder.encode(&errorPayload, "error", err)
+	w.Header().Set("Content-Type", encoder.mediaType)
+	w.WriteHeader(statusCode)
+	w.Write(errorPayload.Bytes())
 }
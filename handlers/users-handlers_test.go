@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/services"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newTestUserService(t *testing.T, count int) *services.UserService {
+	t.Helper()
+	userService := services.NewUserService(memoryTenants(newTestTenants(), services.UserIndexes...))
+	for i := 0; i < count; i++ {
+		_, err := userService.Create(context.Background(), entities.UserRequest{
+			Name:     fmt.Sprintf("user%d", i),
+			LastName: "Lee",
+			Email:    fmt.Sprintf("user%d@example.com", i),
+			Address:  &entities.Address{City: "Rome", Country: "IT", AddressString: "Via 1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return userService
+}
+
+func TestGetAllUsersStreamsAJSONArray(t *testing.T) {
+	for _, count := range []int{0, 1, db.DefaultBatchSize + 1} {
+		rec := httptest.NewRecorder()
+		GetAllUsers(newTestUserService(t, count), UserV1)(rec, httptest.NewRequest(http.MethodGet, "/user/", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%d users: got status %d", count, rec.Code)
+		}
+		if rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%d users: got Content-Type %q", count, rec.Header().Get("Content-Type"))
+		}
+		var users []entities.User
+		if err := json.Unmarshal(rec.Body.Bytes(), &users); err != nil {
+			t.Fatalf("%d users: the body is not a JSON array: %v", count, err)
+		}
+		if len(users) != count {
+			t.Errorf("got %d users, want %d", len(users), count)
+		}
+		// Every batch is sent as soon as it is encoded
+		if count > 0 && !rec.Flushed {
+			t.Errorf("%d users: the response was never flushed", count)
+		}
+	}
+}
+
+func mustParseId(t *testing.T, value string) uuid.UUID {
+	t.Helper()
+	id, err := uuid.Parse(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestDeletedUsersAreListedOnlyToAdmins(t *testing.T) {
+	userService := newTestUserService(t, 1)
+	users, _ := userService.GetAll(context.Background())
+	if _, err := userService.Delete(context.Background(), users[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	router := newTenantRouter(userService)
+
+	if rec := serveTenant(router, http.MethodGet, "/v1/users/deleted", "", "", "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("without the admin key: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec := serveTenant(router, http.MethodGet, "/v1/users/deleted", "", "", "", testAdminKey)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), users[0].Id.String()) {
+		t.Errorf("with the admin key: got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// VerificationRequest has the token mailed to the user
+type VerificationRequest struct {
+	Token string `json:"token" xml:"token" yaml:"token" validate:"required"`
+}
+
+func VerifyUser(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRequest[VerificationRequest](w, r)
+		if !ok {
+			return
+		}
+		user, err := userService.Verify(r.Context(), req.Token)
+		switch {
+		case errors.Is(err, services.ErrInvalidVerification), errors.Is(err, services.ErrVerificationExpired):
+			sendError(w, r, "Invalid token", http.StatusBadRequest, err.Error())
+		case errors.Is(err, db.ErrUserNotFound):
+			sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrAlreadyVerified):
+			sendError(w, r, "Already verified", http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrUserDeactivated):
+			sendError(w, r, "Deactivated user", http.StatusForbidden, err.Error())
+		case errors.Is(err, services.ErrVerificationDisabled):
+			sendError(w, r, "Not available", http.StatusNotImplemented, err.Error())
+		case err != nil:
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+		default:
+			sendResponse(w, r, http.StatusOK, "user", rep.FromUser(user))
+		}
+	}
+}
+
+func ResendVerification(userService *services.UserService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		err = userService.SendVerification(r.Context(), id)
+		switch {
+		case errors.Is(err, db.ErrUserNotFound):
+			sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrAlreadyVerified):
+			sendError(w, r, "Already verified", http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrVerificationTooSoon):
+			sendError(w, r, "Too many requests", http.StatusTooManyRequests, err.Error())
+		case errors.Is(err, services.ErrVerificationDisabled):
+			sendError(w, r, "Not available", http.StatusNotImplemented, err.Error())
+		case err != nil:
+			sendError(w, r, "There was an error", http.StatusInternalServerError, err.Error())
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}
+}
+
+// RegisterUserVerificationRoutes mounts the email verification on a user router, before
+// RegisterUserRoutes so "verify" isn't taken as a user id
+func RegisterUserVerificationRoutes(router *mux.Router, userService *services.UserService, spec *openapi.Spec, rep UserRepresentation) {
+	tags := []string{"users " + rep.Version()}
+	spec.DocumentRoute(router.HandleFunc("/verify", VerifyUser(userService, rep)).Methods(http.MethodPost), openapi.Operation{
+		Summary: "Verify the email of a user and activate it",
+		Description: "The token is mailed to the user when it is created or changes its email, and expires. " +
+			"A token can't be used again once the email is verified, nor by a user an admin deactivated.",
+		Tags:               tags,
+		Request:            VerificationRequest{},
+		RequestMediaTypes:  requestMediaTypes(),
+		Response:           responseSample(rep),
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError, http.StatusNotImplemented},
+	})
+	spec.DocumentRoute(router.HandleFunc("/{id}/verification", ResendVerification(userService)).Methods(http.MethodPost), openapi.Operation{
+		Summary: "Mail a new verification token to a user",
+		Description: "Only for users that haven't verified their email, the tokens sent before keep working until they expire. " +
+			"A user gets at most one token per resend interval, unless an admin asks.",
+		Tags:       tags,
+		Parameters: []openapi.Parameter{idParameter},
+		Status:     http.StatusAccepted,
+		Errors:     []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusNotImplemented},
+	})
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// VersionResponse is one stored state of a user, in the representation of the api version
+type VersionResponse struct {
+	Version   int       `json:"version" xml:"version" yaml:"version"`
+	Timestamp time.Time `json:"timestamp" xml:"timestamp" yaml:"timestamp"`
+	Deleted   bool      `json:"deleted" xml:"deleted" yaml:"deleted"`
+	User      any       `json:"user" xml:"user" yaml:"user"`
+}
+
+func versionPayload(rep UserRepresentation) func(db.Version[entities.User]) any {
+	return func(version db.Version[entities.User]) any {
+		return VersionResponse{
+			Version:   version.Number,
+			Timestamp: version.Timestamp,
+			Deleted:   version.Deleted,
+			User:      rep.FromUser(version.Record),
+		}
+	}
+}
+
+func GetUserVersions(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		id, err := uuid.Parse(params["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+
+		versions, err := userService.GetVersions(r.Context(), id)
+		if err != nil {
+			sendError(w, r, "User not found with this id", http.StatusNotFound, err.Error())
+			return
+		}
+		sendList(w, r, "versions", "version", db.NewSliceIterator(versions, db.DefaultBatchSize), versionPayload(rep))
+	}
+}
+
+func GetUserVersion(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, number, err := parseVersionParams(r)
+		if err != nil {
+			sendError(w, r, "Invalid id or version", http.StatusBadRequest, err.Error())
+			return
+		}
+
+		version, err := userService.GetVersion(r.Context(), id, number)
+		if err != nil {
+			sendError(w, r, "Version not found", http.StatusNotFound, err.Error())
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "version", versionPayload(rep)(version))
+	}
+}
+
+func RevertUser(userService *services.UserService, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, number, err := parseVersionParams(r)
+		if err != nil {
+			sendError(w, r, "Invalid id or version", http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user, err := userService.Revert(r.Context(), id, number)
+		if errors.Is(err, services.ErrAdminOnly) || errors.Is(err, services.ErrOwnerOnly) {
+			sendError(w, r, "Forbidden", http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrEmailInUse) {
+			sendError(w, r, "Conflict", http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrRevertDeleted) {
+			sendError(w, r, "Cannot revert to a deleted version", http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			sendError(w, r, "Version not found", http.StatusNotFound, err.Error())
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "user", rep.FromUser(user))
+	}
+}
+
+func parseVersionParams(r *http.Request) (uuid.UUID, int, error) {
+	params := mux.Vars(r)
+	id, err := uuid.Parse(params["id"])
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	number, err := strconv.Atoi(params["version"])
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	return id, number, nil
+}
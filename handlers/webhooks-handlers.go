@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// WebhookResponse is a subscription without its secret, which is only sent back on creation
+type WebhookResponse struct {
+	Id         uuid.UUID `json:"id" xml:"id" yaml:"id"`
+	Url        string    `json:"url" xml:"url" yaml:"url"`
+	Secret     string    `json:"secret,omitempty" xml:"secret,omitempty" yaml:"secret,omitempty"`
+	EventTypes []string  `json:"event_types" xml:"event_types>event_type" yaml:"event_types"`
+	Active     bool      `json:"active" xml:"active" yaml:"active"`
+	CreatedAt  time.Time `json:"created_at" xml:"created_at" yaml:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" xml:"updated_at" yaml:"updated_at"`
+}
+
+func GetWebhookDeliveries(webhookService *services.WebhookService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := webhookService.Subscriptions().Get(r.Context(), id); err != nil {
+			sendResourceError(w, r, err)
+			return
+		}
+		sendList(w, r, "deliveries", "delivery", webhookService.Deliveries(r.Context(), id), deliveryPayload)
+	}
+}
+
+func GetDeadLetters(webhookService *services.WebhookService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendList(w, r, "deliveries", "delivery", webhookService.DeadLetters(r.Context()), deliveryPayload)
+	}
+}
+
+func RetryDeadLetter(webhookService *services.WebhookService) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			sendError(w, r, "Invalid id", http.StatusBadRequest, err.Error())
+			return
+		}
+
+		delivery, err := webhookService.Retry(r.Context(), id)
+		if errors.Is(err, services.ErrNotDeadLetter) {
+			sendError(w, r, "Delivery is not dead lettered", http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			sendError(w, r, "Delivery not found with this id", http.StatusNotFound, err.Error())
+			return
+		}
+		sendResponse(w, r, http.StatusOK, "delivery", delivery)
+	}
+}
+
+// RegisterWebhookRoutes mounts the webhook subscriptions and their deliveries on the router,
+// only admins can use them since the webhooks receive the users of every event
+func RegisterWebhookRoutes(router *mux.Router, webhookService *services.WebhookService, spec *openapi.Spec) {
+	tags := []string{"webhooks"}
+	// Registered before /{id} so "dead-letters" isn't taken as an id
+	spec.DocumentRoute(router.HandleFunc("/dead-letters", RequireAdmin(GetDeadLetters(webhookService))).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the deliveries that failed every attempt",
+		Tags:               tags,
+		Response:           []entities.WebhookDelivery{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotAcceptable, http.StatusInternalServerError},
+	})
+	spec.DocumentRoute(router.HandleFunc("/dead-letters/{id}/retry", RequireAdmin(RetryDeadLetter(webhookService))).Methods(http.MethodPost), openapi.Operation{
+		Summary:            "Schedule a dead lettered delivery again",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           entities.WebhookDelivery{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusNotAcceptable, http.StatusConflict},
+	})
+	RegisterResourceRoutes(router, Resource[entities.WebhookSubscription, entities.WebhookSubscriptionRequest]{
+		Service:   webhookService.Subscriptions(),
+		ToPayload: webhookPayload,
+		// The only response carrying the secret, receivers need it to verify the signatures
+		ToCreatedPayload: func(subscription entities.WebhookSubscription) any {
+			response := webhookPayload(subscription).(WebhookResponse)
+			response.Secret = subscription.Secret
+			return response
+		},
+		Description: "Deliveries are signed in the X-Webhook-Signature header with the hex HMAC-SHA256 of \"<X-Webhook-Timestamp>.<body>\" keyed with the secret. A secret is generated when none is given, it is only returned on creation and kept by the updates without one. Only public hosts can be called.",
+		AdminOnly:   true,
+	}, spec)
+	spec.DocumentRoute(router.HandleFunc("/{id}/deliveries", RequireAdmin(GetWebhookDeliveries(webhookService))).Methods(http.MethodGet), openapi.Operation{
+		Summary:            "List the delivery log of a webhook subscription",
+		Tags:               tags,
+		Parameters:         []openapi.Parameter{idParameter},
+		Response:           []entities.WebhookDelivery{},
+		ResponseMediaTypes: responseMediaTypes(false),
+		Errors:             []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusNotAcceptable, http.StatusInternalServerError},
+	})
+}
+
+func webhookPayload(subscription entities.WebhookSubscription) any {
+	return WebhookResponse{
+		Id:         subscription.Id,
+		Url:        subscription.Url,
+		EventTypes: subscription.EventTypes,
+		Active:     subscription.Active,
+		CreatedAt:  subscription.CreatedAt,
+		UpdatedAt:  subscription.UpdatedAt,
+	}
+}
+
+func deliveryPayload(delivery entities.WebhookDelivery) any {
+	return delivery
+}
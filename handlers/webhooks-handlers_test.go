@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newWebhookRouter() *mux.Router {
+	tenants := newTestTenants()
+	webhookService := services.NewWebhookService(
+		memoryTenants[entities.WebhookSubscription](tenants),
+		memoryTenants(tenants, services.WebhookDeliveryIndexes...),
+	)
+	router := mux.NewRouter()
+	router.Use(AdminMiddleware(services.AdminKeys{testAdminKey}))
+	RegisterWebhookRoutes(router.PathPrefix("/webhooks").Subrouter(), webhookService, openapi.New("test", "1.0.0"))
+	return router
+}
+
+func serveWebhooks(router *mux.Router, method string, body string, adminKey string) *httptest.ResponseRecorder {
+	return serveResource(router, method, "/webhooks", body, adminKey)
+}
+
+func TestWebhooksAreAdminOnly(t *testing.T) {
+	router := newWebhookRouter()
+	body := `{"url":"https://hooks.example.com/users","active":true}`
+
+	if rec := serveWebhooks(router, http.MethodPost, body, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("create without admin key: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serveWebhooks(router, http.MethodGet, "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("list without admin key: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serveWebhooks(router, http.MethodPost, body, "wrong-key"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("create with a wrong admin key: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := serveWebhooks(router, http.MethodPost, body, testAdminKey); rec.Code != http.StatusCreated {
+		t.Errorf("create as admin: got %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+}
+
+func TestCreateWebhookRefusesPrivateTargets(t *testing.T) {
+	router := newWebhookRouter()
+	body := `{"url":"http://169.254.169.254/latest/meta-data","active":true}`
+	if rec := serveWebhooks(router, http.MethodPost, body, testAdminKey); rec.Code != http.StatusBadRequest {
+		t.Errorf("got %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+}
+
+func TestWebhookSecretIsOnlySentOnCreation(t *testing.T) {
+	router := newWebhookRouter()
+	rec := serveWebhooks(router, http.MethodPost, `{"url":"https://hooks.example.com/users","active":true}`, testAdminKey)
+	created := WebhookResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Secret == "" {
+		t.Error("the creation didn't send the secret")
+	}
+	rec = serveResource(router, http.MethodGet, "/webhooks/"+created.Id.String(), "", testAdminKey)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), created.Secret) {
+		t.Errorf("get: got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/services"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// Commands a client sends over the socket, answered with a message of the same id
+const (
+	CommandSubscribe   = "subscribe"
+	CommandUnsubscribe = "unsubscribe"
+	CommandGet         = "get"
+	CommandUpdate      = "update"
+)
+
+// Messages the server sends
+const (
+	MessageSubscribed   = "subscribed"
+	MessageUnsubscribed = "unsubscribed"
+	MessageUser         = "user"
+	MessageEvent        = "event"
+	MessageError        = "error"
+)
+
+const (
+	// A connection without a pong for pongWait is closed, pings are sent before that
+	pongWait     = 60 * time.Second
+	pingInterval = pongWait * 9 / 10
+	writeWait    = 10 * time.Second
+	maxCommand   = 64 * 1024
+	// Messages queued for a client before it is considered too slow and disconnected
+	socketBuffer = 64
+)
+
+// Permissions of an api key on the socket
+type Permission string
+
+const (
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+)
+
+var (
+	ErrUnknownCommand   = errors.New("unknown command")
+	ErrForbidden        = errors.New("the api key is not allowed to do this")
+	ErrUnknownSubscribe = errors.New("cannot find a subscription with this id")
+)
+
+// APIKeys are the accepted api keys with what they allow. They can be replaced while the
+// sockets are open, every command is checked against the current keys.
+type APIKeys struct {
+	mu   sync.RWMutex
+	keys map[string]Permission
+}
+
+// ParseAPIKeys reads keys in the form "key1:read,key2:write", write implies read
+func ParseAPIKeys(value string) *APIKeys {
+	keys := new(APIKeys)
+	keys.Replace(value)
+	return keys
+}
+
+// Replace accepts only the keys of value from now on, in the form of ParseAPIKeys
+func (k *APIKeys) Replace(value string) {
+	keys := make(map[string]Permission)
+	for _, pair := range strings.Split(value, ",") {
+		key, permission, _ := strings.Cut(strings.TrimSpace(pair), ":")
+		if key == "" {
+			continue
+		}
+		keys[key] = Permission(permission)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+}
+
+// Len is the number of accepted keys
+func (k *APIKeys) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys)
+}
+
+func (k *APIKeys) allows(key string, permission Permission) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	granted, ok := k.keys[key]
+	if !ok {
+		return false
+	}
+	return granted == permission || granted == PermissionWrite
+}
+
+// SocketCommand is a message of the client
+type SocketCommand struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	// subscribe: the users and the country to receive the events of, none for every event
+	UserIds []uuid.UUID `json:"user_ids,omitempty"`
+	Country string      `json:"country,omitempty"`
+	// unsubscribe
+	Subscription string `json:"subscription,omitempty"`
+	// get and update
+	UserId uuid.UUID            `json:"user_id,omitempty"`
+	User   entities.UserRequest `json:"user"`
+}
+
+// SocketMessage is a message of the server, answers carry the id of their command
+type SocketMessage struct {
+	Id           string         `json:"id,omitempty"`
+	Type         string         `json:"type"`
+	Subscription string         `json:"subscription,omitempty"`
+	User         any            `json:"user,omitempty"`
+	Event        *EventResponse `json:"event,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// APIKeyProtocol is the subprotocol of the browsers, which can't set the Authorization header
+// of a WebSocket: they offer the protocols "api-key" and "<api key>", the server picks "api-key"
+const APIKeyProtocol = "api-key"
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{APIKeyProtocol},
+}
+
+// UserSocket serves the bidirectional user api over a WebSocket. The api key, from the
+// Authorization: Bearer header or the APIKeyProtocol subprotocol for browsers, is checked
+// for the connection and again for every command and event, so a replaced key stops working.
+// Keys are never read from the url, which ends up in the logs of the proxies.
+func UserSocket(userService *services.UserService, keys *APIKeys, rep UserRepresentation) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := socketKey(r)
+		if !keys.allows(key, PermissionRead) {
+			sendError(w, r, "Invalid api key", http.StatusUnauthorized, ErrForbidden.Error())
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader already answered the error
+			slog.Error(err.Error())
+			return
+		}
+		socket := &userSocket{
+			conn:          conn,
+			userService:   userService,
+			rep:           rep,
+			keys:          keys,
+			key:           key,
+			out:           make(chan SocketMessage, socketBuffer),
+			done:          make(chan struct{}),
+			subscriptions: make(map[string]func(events.Event) bool),
+		}
+		socket.serve(r.Context())
+	}
+}
+
+// userSocket is one connection. Only the writer goroutine writes to conn, the reader
+// queues the answers in out and blocks when it is full, slowing down the client.
+// Events never block the writers of the users: a client too slow to take them is disconnected.
+type userSocket struct {
+	conn        *websocket.Conn
+	userService *services.UserService
+	rep         UserRepresentation
+	keys        *APIKeys
+	key         string
+	out         chan SocketMessage
+	done        chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+
+	mu            sync.Mutex
+	subscriptions map[string]func(events.Event) bool
+}
+
+func (s *userSocket) serve(ctx context.Context) {
+	unsubscribe := s.userService.OnChange(s.onChange)
+	defer unsubscribe()
+	defer s.close(websocket.CloseNormalClosure)
+	go s.writeLoop()
+
+	s.conn.SetReadLimit(maxCommand)
+	s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		command := SocketCommand{}
+		if err := s.conn.ReadJSON(&command); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Error(err.Error())
+			}
+			return
+		}
+		// A key no longer accepted can't do anything more
+		if !s.allows(PermissionRead) {
+			s.revoke()
+			return
+		}
+		answer := s.handle(ctx, command)
+		answer.Id = command.Id
+		select {
+		case s.out <- answer:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// socketKey returns the api key of the Authorization header, or the protocol offered after APIKeyProtocol
+func socketKey(r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return key
+	}
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == APIKeyProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// allows checks the key of the socket against the current keys
+func (s *userSocket) allows(permission Permission) bool {
+	return s.keys.allows(s.key, permission)
+}
+
+func (s *userSocket) handle(ctx context.Context, command SocketCommand) SocketMessage {
+	switch command.Type {
+	case CommandSubscribe:
+		id := uuid.NewString()
+		s.mu.Lock()
+		s.subscriptions[id] = eventFilter(services.TenantFrom(ctx), command.UserIds, command.Country)
+		s.mu.Unlock()
+		return SocketMessage{Type: MessageSubscribed, Subscription: id}
+	case CommandUnsubscribe:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscriptions[command.Subscription]; !ok {
+			return SocketMessage{Type: MessageError, Error: ErrUnknownSubscribe.Error()}
+		}
+		delete(s.subscriptions, command.Subscription)
+		return SocketMessage{Type: MessageUnsubscribed, Subscription: command.Subscription}
+	case CommandGet:
+		user, err := s.userService.Get(ctx, command.UserId)
+		if err != nil {
+			return SocketMessage{Type: MessageError, Error: err.Error()}
+		}
+		return SocketMessage{Type: MessageUser, User: s.rep.FromUser(user)}
+	case CommandUpdate:
+		if !s.allows(PermissionWrite) {
+			return SocketMessage{Type: MessageError, Error: ErrForbidden.Error()}
+		}
+		if err := validator.New().Struct(command.User); err != nil {
+			return SocketMessage{Type: MessageError, Error: err.Error()}
+		}
+		user, err := s.userService.Update(ctx, command.UserId, command.User)
+		if err != nil {
+			return SocketMessage{Type: MessageError, Error: err.Error()}
+		}
+		return SocketMessage{Type: MessageUser, User: s.rep.FromUser(user)}
+	default:
+		return SocketMessage{Type: MessageError, Error: ErrUnknownCommand.Error()}
+	}
+}
+
+// onChange queues the event once for every subscription receiving it, without blocking.
+// It never fails, the events a slow client misses are not retried for it.
+func (s *userSocket) onChange(ctx context.Context, event events.Event) error {
+	if !s.allows(PermissionRead) {
+		s.revoke()
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, keep := range s.subscriptions {
+		if !keep(event) {
+			continue
+		}
+		payload := eventPayload(event, s.rep)
+		select {
+		case s.out <- SocketMessage{Type: MessageEvent, Subscription: id, Event: &payload}:
+		case <-s.done:
+			return nil
+		default:
+			slog.Error("websocket client is too slow, disconnecting it")
+			s.close(websocket.CloseTryAgainLater)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *userSocket) writeLoop() {
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-s.done:
+			s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(s.closeCode, s.closeReason), time.Now().Add(writeWait))
+			s.conn.Close()
+			return
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				s.close(websocket.CloseGoingAway)
+			}
+		case message := <-s.out:
+			s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := s.conn.WriteJSON(message); err != nil {
+				s.close(websocket.CloseGoingAway)
+			}
+		}
+	}
+}
+
+// close stops the connection with the close code, the reader fails on the closed conn and returns
+func (s *userSocket) close(code int) {
+	s.closeOnce.Do(func() {
+		s.closeCode = code
+		close(s.done)
+	})
+}
+
+// revoke closes the connection of a key no longer accepted
+func (s *userSocket) revoke() {
+	s.closeOnce.Do(func() {
+		s.closeCode = websocket.ClosePolicyViolation
+		s.closeReason = ErrForbidden.Error()
+		close(s.done)
+	})
+}
+
+// RegisterUserSocketRoute mounts the socket on the router, before RegisterUserRoutes
+// so "ws" isn't taken as a user id
+func RegisterUserSocketRoute(router *mux.Router, userService *services.UserService, keys *APIKeys, spec *openapi.Spec, rep UserRepresentation) {
+	spec.DocumentRoute(router.HandleFunc("/ws", UserSocket(userService, keys, rep)).Methods(http.MethodGet), openapi.Operation{
+		Summary: "Subscribe to the user changes and read or update users over a WebSocket",
+		Description: "Commands are JSON messages {\"id\", \"type\", ...} of type subscribe (user_ids, country), unsubscribe (subscription), " +
+			"get (user_id) and update (user_id, user), answered with a message of the same id. Subscriptions receive messages of type event. " +
+			"Updates need an api key with the write permission. Browsers send the api key as the protocol after \"" + APIKeyProtocol + "\" in Sec-WebSocket-Protocol. " +
+			"The key is checked again for every command and event, the connection is closed once it is no longer accepted.",
+		Tags: []string{"users " + rep.Version()},
+		Parameters: []openapi.Parameter{
+			{Name: "Authorization", In: "header", Description: "Bearer <api key>", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "Sec-WebSocket-Protocol", In: "header", Description: APIKeyProtocol + ", <api key>", Schema: &openapi.Schema{Type: "string"}},
+		},
+		Status: http.StatusSwitchingProtocols,
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized},
+	})
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func createTestUser(t *testing.T, userService *services.UserService) uuid.UUID {
+	t.Helper()
+	id, err := userService.Create(context.Background(), entities.UserRequest{
+		Name:     "Ann",
+		LastName: "Lee",
+		Email:    "ann@example.com",
+		Address:  &entities.Address{City: "Rome", Country: "IT", AddressString: "Via 1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// newSocketServer serves the socket of a user service with a read key and a write key
+func newSocketServer(t *testing.T, userService *services.UserService) (*httptest.Server, *APIKeys) {
+	keys := ParseAPIKeys("reader:read,writer:write")
+	router := mux.NewRouter()
+	router.HandleFunc("/ws", UserSocket(userService, keys, UserV1))
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, keys
+}
+
+func dialSocket(server *httptest.Server, header http.Header) (*websocket.Conn, *http.Response, error) {
+	return websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header)
+}
+
+func bearer(key string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + key}}
+}
+
+func command(t *testing.T, conn *websocket.Conn, cmd SocketCommand) SocketMessage {
+	t.Helper()
+	if err := conn.WriteJSON(cmd); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	answer := SocketMessage{}
+	if err := conn.ReadJSON(&answer); err != nil {
+		t.Fatal(err)
+	}
+	return answer
+}
+
+func TestSocketRefusesConnectionsWithoutAValidKey(t *testing.T) {
+	server, _ := newSocketServer(t, newTestUserService(t, 0))
+
+	for name, header := range map[string]http.Header{
+		"no key":    nil,
+		"wrong key": bearer("wrong"),
+	} {
+		_, res, err := dialSocket(server, header)
+		if err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: got %v, want %d", name, res, http.StatusUnauthorized)
+		}
+	}
+	// The key isn't read from the url, where the proxies would log it
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?api_key=reader"
+	if _, res, err := websocket.DefaultDialer.Dial(url, nil); err == nil || res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("query key: got %v, want %d", res, http.StatusUnauthorized)
+	}
+}
+
+func TestSocketAcceptsTheKeyAsASubprotocol(t *testing.T) {
+	server, _ := newSocketServer(t, newTestUserService(t, 0))
+
+	conn, res, err := dialSocket(server, http.Header{"Sec-WebSocket-Protocol": {APIKeyProtocol + ", reader"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if protocol := res.Header.Get("Sec-WebSocket-Protocol"); protocol != APIKeyProtocol {
+		t.Errorf("got protocol %q, want %q", protocol, APIKeyProtocol)
+	}
+}
+
+func TestSocketChecksTheWritePermissionOfUpdates(t *testing.T) {
+	userService := newTestUserService(t, 0)
+	id := createTestUser(t, userService)
+	server, _ := newSocketServer(t, userService)
+	update := SocketCommand{Id: "1", Type: CommandUpdate, UserId: id, User: entities.UserRequest{
+		Name:     "Bea",
+		LastName: "Lee",
+		Email:    "ann@example.com",
+		Address:  &entities.Address{City: "Rome", Country: "IT", AddressString: "Via 1"},
+	}}
+
+	reader, _, err := dialSocket(server, bearer("reader"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if answer := command(t, reader, SocketCommand{Id: "1", Type: CommandGet, UserId: id}); answer.Type != MessageUser {
+		t.Errorf("get with a read key: got %+v", answer)
+	}
+	if answer := command(t, reader, update); answer.Type != MessageError || answer.Error != ErrForbidden.Error() {
+		t.Errorf("update with a read key: got %+v", answer)
+	}
+
+	writer, _, err := dialSocket(server, bearer("writer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if answer := command(t, writer, update); answer.Type != MessageUser {
+		t.Errorf("update with a write key: got %+v", answer)
+	}
+}
+
+func TestSocketChecksTheKeyOfEveryCommand(t *testing.T) {
+	userService := newTestUserService(t, 0)
+	id := createTestUser(t, userService)
+	server, keys := newSocketServer(t, userService)
+
+	conn, _, err := dialSocket(server, bearer("writer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if answer := command(t, conn, SocketCommand{Id: "1", Type: CommandGet, UserId: id}); answer.Type != MessageUser {
+		t.Fatalf("got %+v", answer)
+	}
+
+	// The key is revoked while the socket is open
+	keys.Replace("reader:read")
+	if err := conn.WriteJSON(SocketCommand{Id: "2", Type: CommandGet, UserId: id}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) || !strings.Contains(err.Error(), ErrForbidden.Error()) {
+		t.Errorf("got %v, want the socket closed with %d", err, websocket.ClosePolicyViolation)
+	}
+}
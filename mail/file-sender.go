@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileSender appends the emails to a file instead of sending them, for local runs
+type fileSender struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileSender(path string) (*fileSender, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSender{file: file}, nil
+}
+
+func (f *fileSender) Send(ctx context.Context, message Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := fmt.Fprintf(f.file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), message.To, message.Subject, message.Body)
+	if err != nil {
+		slog.Error(err.Error(), "to", message.To)
+		return ErrSendingMail
+	}
+	return nil
+}
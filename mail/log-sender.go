@@ -0,0 +1,18 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+)
+
+// logSender writes the emails to the log instead of sending them, for local runs
+type logSender struct{}
+
+func NewLogSender() *logSender {
+	return &logSender{}
+}
+
+func (l *logSender) Send(ctx context.Context, message Message) error {
+	slog.Info("Mail", "to", message.To, "subject", message.Subject, "body", message.Body)
+	return nil
+}
@@ -0,0 +1,22 @@
+package mail
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrSendingMail = errors.New("error sending mail")
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers the emails sent to the users
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
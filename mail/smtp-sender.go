@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// smtpSender sends the emails through an SMTP server
+type smtpSender struct {
+	address string
+	from    string
+	auth    smtp.Auth
+}
+
+// NewSMTPSender sends from the address through the server at address, authenticating
+// with PLAIN when a username is given
+func NewSMTPSender(address string, from string, username string, password string) *smtpSender {
+	sender := &smtpSender{address: address, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(address)
+		sender.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return sender
+}
+
+func (s *smtpSender) Send(ctx context.Context, message Message) error {
+	// The headers can't have line breaks, they would add headers of their own
+	if strings.ContainsAny(message.To+message.Subject, "\r\n") {
+		return ErrSendingMail
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		s.from, message.To, message.Subject, strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	if err := smtp.SendMail(s.address, s.auth, s.from, []string{message.To}, []byte(body)); err != nil {
+		slog.Error(err.Error(), "to", message.To)
+		return ErrSendingMail
+	}
+	return nil
+}
@@ -1,57 +1,608 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"example/bootcamp_ex1/audit"
 	"example/bootcamp_ex1/db"
 	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"example/bootcamp_ex1/graphqlapi"
+	"example/bootcamp_ex1/grpcapi"
 	"example/bootcamp_ex1/handlers"
+	"example/bootcamp_ex1/mail"
+	"example/bootcamp_ex1/openapi"
+	"example/bootcamp_ex1/search"
 	"example/bootcamp_ex1/services"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
 
 const (
-	ENV_STAGE      = "STAGE"
-	ENV_STORAGE    = "STORAGE"
-	STORAGE_REDIS  = "REDIS"
-	STORAGE_MEMORY = "MEMORY"
+	ENV_STAGE         = "STAGE"
+	ENV_STORAGE       = "STORAGE"
+	ENV_LEGACY_SUNSET = "LEGACY_SUNSET"
+	ENV_RETENTION     = "SOFT_DELETE_RETENTION"
+	ENV_PURGE_EVERY   = "PURGE_INTERVAL"
+	ENV_AUDIT_SINK    = "AUDIT_SINK"
+	ENV_PUBLISHER     = "EVENTS_PUBLISHER"
+	ENV_RELAY_EVERY   = "OUTBOX_INTERVAL"
+	ENV_WEBHOOK_EVERY = "WEBHOOK_INTERVAL"
+	ENV_WEBHOOK_LOCAL = "WEBHOOK_PRIVATE_TARGETS"
+	ENV_API_KEYS      = "WEBSOCKET_API_KEYS"
+	ENV_GRPC_ADDRESS  = "GRPC_ADDRESS"
+	ENV_TENANT_DOMAIN = "TENANT_DOMAIN"
+	ENV_TENANT_SECRET = "TENANT_TOKEN_SECRET"
+	ENV_TENANTS       = "TENANTS"
+	ENV_SEARCH_INDEX  = "SEARCH_INDEX"
+	ENV_SEARCH_FORCE  = "SEARCH_REBUILD"
+	ENV_DUPLICATES    = "DUPLICATE_SCAN_INTERVAL"
+	ENV_ADMIN_KEYS    = "ADMIN_API_KEYS"
+	ENV_VERIFY_SECRET = "VERIFICATION_SECRET"
+	ENV_VERIFY_TTL    = "VERIFICATION_TTL"
+	ENV_VERIFY_URL    = "VERIFICATION_URL"
+	ENV_VERIFY_RESEND = "VERIFICATION_RESEND_INTERVAL"
+	ENV_MAIL_SENDER   = "MAIL_SENDER"
+	ENV_MAIL_FILE     = "MAIL_FILE"
+	ENV_SMTP_ADDRESS  = "SMTP_ADDRESS"
+	ENV_SMTP_FROM     = "SMTP_FROM"
+	ENV_SMTP_USERNAME = "SMTP_USERNAME"
+	ENV_SMTP_PASSWORD = "SMTP_PASSWORD"
+	ENV_AUTH_SECRET   = "AUTH_TOKEN_SECRET"
+	ENV_ACCESS_TTL    = "AUTH_ACCESS_TTL"
+	ENV_REFRESH_TTL   = "AUTH_REFRESH_TTL"
+	ENV_MAX_FAILURES  = "AUTH_MAX_FAILED_LOGINS"
+	ENV_LOCKOUT       = "AUTH_LOCKOUT"
+	ENV_RESET_TTL     = "PASSWORD_RESET_TTL"
+	ENV_RESET_URL     = "PASSWORD_RESET_URL"
+	ENV_AUTH_LIMIT    = "AUTH_RATE_LIMIT"
+	HTTP_ADDRESS      = ":8000"
+	GRPC_ADDRESS      = ":9000"
+	EVENTS_STREAM     = "events:users"
+	ENV_AUDIT_FILE    = "AUDIT_FILE"
+	AUDIT_FILE        = "FILE"
+	AUDIT_STREAM      = "audit"
+	DEFAULT_AUDIT_LOG = "audit.log"
+	STORAGE_REDIS     = "REDIS"
+	STORAGE_MEMORY    = "MEMORY"
+	MAIL_LOG          = "LOG"
+	MAIL_FILE         = "FILE"
+	MAIL_SMTP         = "SMTP"
+	DEFAULT_MAIL_FILE = "mail.log"
+	// Names the migrations of the users are recorded with
+	MIGRATION_ADDRESSES = "users-labeled-addresses"
+	MIGRATION_VERSIONS  = "users-versions-backfill"
 
-	ErrNotValidStorage = "storage is not valid"
+	ErrNotValidStorage   = "storage is not valid"
+	ErrUndocumentedRoute = "route is missing from the OpenAPI document"
+	ErrNotValidSunset    = "sunset date is not valid, it must be RFC 3339"
+	ErrNotValidDuration  = "duration is not valid"
+	ErrNotValidAuditSink = "audit sink is not valid"
+	ErrNotValidPublisher = "events publisher is not valid"
+	ErrNoAPIKeys         = "no api keys, the websocket rejects every connection"
+	ErrNotValidSearch    = "search index is not valid"
+	ErrNoAdminKeys       = "no admin keys, the users can only be activated by verifying their email"
+	ErrNoVerifySecret    = "no verification secret, the tokens sent stop working on restart"
+	ErrNotValidMail      = "mail sender is not valid"
+	ErrNoAuthSecret      = "no auth token secret, the tokens issued stop working on restart"
+	ErrSameAuthSecret    = "the auth token secret must not be the tenant token secret"
+	ErrNotValidNumber    = "number is not valid"
+	ErrNotValidTenant    = "tenant is not valid"
 )
 
+var (
+	// Date the unversioned /user routes were deprecated in favor of /v1/users
+	legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	// Default date the unversioned /user routes stop being served
+	defaultLegacySunset = legacyDeprecatedAt.AddDate(0, 6, 0)
+	// Deleted users can be restored for 30 days
+	defaultRetention    = 30 * 24 * time.Hour
+	defaultPurgeEvery   = time.Hour
+	defaultRelayEvery   = 500 * time.Millisecond
+	defaultWebhookEvery = 5 * time.Second
+	defaultScanEvery    = time.Hour
+	defaultVerifyTTL    = 24 * time.Hour
+	defaultVerifyResend = time.Minute
+	defaultAccessTTL    = 15 * time.Minute
+	defaultRefreshTTL   = 30 * 24 * time.Hour
+	defaultResetTTL     = time.Hour
+	defaultLockout      = 15 * time.Minute
+	defaultMaxFailures  = 5
+	// Requests a client can send to the /auth routes in a minute
+	defaultAuthLimit = 20
+	// User changes kept for the event stream clients resuming after a disconnection
+	streamHistory = 1000
+)
+
+// server has the services the api is served with
+type server struct {
+	tenants       db.TenantSet
+	bus           *events.Bus
+	users         *db.Tenants[entities.User]
+	credentials   *db.Tenants[entities.Credential]
+	userService   *services.UserService
+	userStream    *events.Stream
+	authService   *services.AuthService
+	auditSink     audit.Sink
+	attributes    *services.AttributeService
+	organizations *services.ResourceService[entities.Organization, entities.OrganizationRequest]
+	memberships   *services.MembershipService
+	webhooks      *services.WebhookService
+	adminKeys     services.AdminKeys
+	apiKeys       *handlers.APIKeys
+}
+
 func main() {
 	// Loading env variables
 	godotenv.Load()
-	slog.Info("ENVIRONMENT: ", os.Getenv(ENV_STAGE), os.Getenv(ENV_STORAGE))
+	slog.Info("ENVIRONMENT", ENV_STAGE, os.Getenv(ENV_STAGE), ENV_STORAGE, os.Getenv(ENV_STORAGE))
+
+	s, err := newServer()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	s.users.For(db.DefaultTenant)
+
+	// The events and the audit entries are written to the storage outbox and relayed from there
+	publisher := newPublisher(s.bus)
+	relayEvery := durationFromEnv(ENV_RELAY_EVERY, defaultRelayEvery)
+	events.NewRelay(s.users, publisher, relayEvery).Route(audit.OutboxTopic, audit.Deliver(s.auditSink)).Start(context.Background())
+	events.NewRelay(s.credentials, publisher, relayEvery).Route(audit.OutboxTopic, audit.Deliver(s.auditSink)).Start(context.Background())
+	s.userService.StartPurge(context.Background(), durationFromEnv(ENV_PURGE_EVERY, defaultPurgeEvery), durationFromEnv(ENV_RETENTION, defaultRetention))
+	s.userService.StartDuplicateScan(context.Background(), durationFromEnv(ENV_DUPLICATES, defaultScanEvery))
+	s.webhooks.StartDelivery(context.Background(), durationFromEnv(ENV_WEBHOOK_EVERY, defaultWebhookEvery))
+	reloadAPIKeysOnHangup(s.apiKeys)
+
+	r, spec := s.router()
+	// Every route must be part of the OpenAPI document
+	for _, route := range spec.Undocumented(r) {
+		slog.Error(ErrUndocumentedRoute, "route", route)
+	}
+
+	// The gRPC api serves the same UserService on its own port
+	go serveGRPC(s.userService, s.adminKeys, s.tenantAccess())
+
+	// Bind to a port and pass our router in
+	slog.Error(http.ListenAndServe(HTTP_ADDRESS, r).Error())
+}
+
+// newServer builds the services from the environment, without starting their background jobs
+func newServer() (*server, error) {
+	// Selecting storage from .env, every tenant keeps its records apart
+	tenants, err := newTenantSet()
+	if err != nil {
+		return nil, err
+	}
+	searchIndex := newSearchIndex()
+	users := db.NewTenants(tenants, func(tenant string) db.Storage[entities.User] {
+		return newUserStorage(tenant, searchIndex)
+	})
+
+	auditSink := newAuditSink()
+	mailSender := newMailSender()
+	bus := events.NewBus()
+	attributes := services.NewAttributeService(newTenants[entities.AttributeDefinition](tenants), services.SystemClock)
+	credentials := newTenants[entities.Credential](tenants)
+	userService := services.NewUserService(users, services.WithAuditLog(auditSink), services.WithAttributes(attributes), services.WithSearch(searchIndex), services.WithVerification(verification(mailSender)), services.WithCredentials(credentials))
+	organizations := services.NewOrganizationService(newTenants[entities.Organization](tenants), services.SystemClock)
+	memberships := services.NewMembershipService(newTenants(tenants, services.MembershipIndexes...), organizations, userService, services.SystemClock)
+	bus.Subscribe(memberships.HandleEvent)
+
+	userStream := events.NewStream(streamHistory)
+	userService.OnChange(userStream.Handle)
+
+	authService, err := services.NewAuthService(credentials, userService, services.SystemClock, authConfig(mailSender))
+	if err != nil {
+		return nil, err
+	}
+
+	webhookService := services.NewWebhookService(newTenants[entities.WebhookSubscription](tenants), newTenants(tenants, services.WebhookDeliveryIndexes...), webhookOptions()...)
+	bus.Subscribe(webhookService.HandleEvent)
+
+	return &server{
+		tenants:       tenants,
+		bus:           bus,
+		users:         users,
+		credentials:   credentials,
+		userService:   userService,
+		userStream:    userStream,
+		authService:   authService,
+		auditSink:     auditSink,
+		attributes:    attributes,
+		organizations: organizations,
+		memberships:   memberships,
+		webhooks:      webhookService,
+		adminKeys:     adminKeys(),
+		apiKeys:       apiKeys(),
+	}, nil
+}
+
+// router mounts the routes of the api and documents them
+func (s *server) router() (*mux.Router, *openapi.Spec) {
+	r := mux.NewRouter()
+	r.Use(handlers.AdminMiddleware(s.adminKeys))
+	r.Use(handlers.ActorMiddleware)
+	r.Use(handlers.TenantMiddleware(handlers.TenantResolver{
+		Domain: os.Getenv(ENV_TENANT_DOMAIN),
+		Access: s.tenantAccess(),
+	}))
+	spec := openapi.New("Users API", "1.0.0")
+	// Declaring versioned user subrouters
+	v1Router := r.PathPrefix("/v1/users").Subrouter()
+	handlers.RegisterUserSocketRoute(v1Router, s.userService, s.apiKeys, spec, handlers.UserV1)
+	handlers.RegisterUserSearchRoute(v1Router, s.userService, spec, handlers.UserV1)
+	handlers.RegisterUserDuplicateRoutes(v1Router, s.userService, spec, handlers.UserV1)
+	handlers.RegisterUserStatsRoute(v1Router, s.userService, spec)
+	handlers.RegisterUserVerificationRoutes(v1Router, s.userService, spec, handlers.UserV1)
+	handlers.RegisterUserPasswordRoute(v1Router, s.authService, spec)
+	handlers.RegisterUserRoutes(v1Router, "", s.userService, s.userStream, spec, handlers.UserV1)
+	handlers.RegisterUserOrganizationsRoute(v1Router, s.memberships, spec)
+	handlers.RegisterUserAddressRoutes(v1Router, s.userService, spec)
+
+	// The unversioned routes are kept as a deprecated alias of v1
+	legacyRouter := r.PathPrefix("/user").Subrouter()
+	handlers.RegisterUserSearchRoute(legacyRouter, s.userService, spec, handlers.UserV1)
+	handlers.RegisterUserDuplicateRoutes(legacyRouter, s.userService, spec, handlers.UserV1)
+	handlers.RegisterUserStatsRoute(legacyRouter, s.userService, spec)
+	handlers.RegisterUserVerificationRoutes(legacyRouter, s.userService, spec, handlers.UserV1)
+	handlers.RegisterUserPasswordRoute(legacyRouter, s.authService, spec)
+	handlers.RegisterUserRoutes(legacyRouter, "/", s.userService, s.userStream, spec, handlers.UserV1)
+	handlers.RegisterUserOrganizationsRoute(legacyRouter, s.memberships, spec)
+	handlers.RegisterUserAddressRoutes(legacyRouter, s.userService, spec)
+	handlers.Deprecate(legacyRouter, spec, legacyDeprecatedAt, legacySunset(), "/v1/users")
+	authRouter := r.PathPrefix("/auth").Subrouter()
+	handlers.RateLimit(authRouter, intFromEnv(ENV_AUTH_LIMIT, defaultAuthLimit), time.Minute)
+	handlers.RegisterAuthRoutes(authRouter, s.authService, spec, handlers.UserV1)
+	handlers.RegisterAuditRoutes(r.PathPrefix("/v1/audit").Subrouter(), s.auditSink, spec)
+	handlers.RegisterOrganizationRoutes(r.PathPrefix("/organizations").Subrouter(), s.organizations, s.memberships, spec, handlers.UserV1)
+	handlers.RegisterResourceRoutes(r.PathPrefix("/attributes").Subrouter(), handlers.Resource[entities.AttributeDefinition, entities.AttributeDefinitionRequest]{
+		Service:     s.attributes,
+		Description: "The custom attributes the users of the tenant can have, defined by its admins.",
+		AdminWrites: true,
+	}, spec)
+	handlers.RegisterWebhookRoutes(r.PathPrefix("/webhooks").Subrouter(), s.webhooks, spec)
+	handlers.RegisterGraphQLRoute(r, graphqlapi.NewHandler(s.userService), spec)
+	handlers.RegisterOpenAPIRoute(r, spec)
+	return r, spec
+}
 
-	// Selecting storage from .env
-	var storage db.Storage[entities.User]
+// tenantAccess trusts the tenants named by the requests with a tenant token signed with
+// TENANT_TOKEN_SECRET, an access token of one of their users or an admin key
+func (s *server) tenantAccess() services.TenantAccess {
+	return services.TenantAccess{
+		TokenSecret: []byte(os.Getenv(ENV_TENANT_SECRET)),
+		Tenants:     s.tenants,
+		Users:       s.authService,
+	}
+}
 
+// newTenantSet selects where the tenants are kept like the storage, and adds the tenants of
+// the comma separated TENANTS list to it. The tenants added by the other processes are kept.
+func newTenantSet() (db.TenantSet, error) {
+	var tenants db.TenantSet
 	switch os.Getenv(ENV_STORAGE) {
+	case STORAGE_REDIS:
+		tenants = db.NewRedisTenantSet()
+	default:
+		tenants = db.NewMemoryTenantSet()
+	}
+	names := make([]string, 0)
+	for _, name := range strings.Split(os.Getenv(ENV_TENANTS), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if err := services.ValidateTenant(name); err != nil {
+			slog.Error(ErrNotValidTenant, ENV_TENANTS, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return tenants, tenants.Add(names...)
+}
+
+// newTenants returns the storages of the records of the tenants of the set, keeping the indexes
+func newTenants[T entities.StorageObject](tenants db.TenantSet, indexes ...db.Index[T]) *db.Tenants[T] {
+	return db.NewTenants(tenants, func(tenant string) db.Storage[T] {
+		return newStorage(tenant, indexes...)
+	})
+}
 
+// newStorage returns the storage of the records of a tenant, keeping the indexes
+func newStorage[T entities.StorageObject](tenant string, indexes ...db.Index[T]) db.Storage[T] {
+	switch os.Getenv(ENV_STORAGE) {
 	case STORAGE_MEMORY:
-		storage = db.NewMemoryStorage[entities.User]()
+		return db.NewMemoryStorage[T](indexes...)
 	case STORAGE_REDIS:
-		storage = db.NewRedisStorage[entities.User]()
+		return db.NewRedisStorage[T](tenant, indexes...)
 	default:
-		slog.Error(ErrNotValidStorage, os.Getenv(ENV_STORAGE))
+		slog.Error(ErrNotValidStorage, ENV_STORAGE, os.Getenv(ENV_STORAGE))
+		return nil
+	}
+}
 
+// newUserStorage returns the storage of the users of a tenant, migrated to the current model
+// and with its users indexed for search. The migrations applied to a tenant are recorded, so
+// they run once and not on every start.
+func newUserStorage(tenant string, searchIndex search.Index) db.Storage[entities.User] {
+	storage := newStorage(tenant, services.UserIndexes...)
+	if storage == nil {
+		return nil
 	}
+	applied := newStorage[entities.Migration](tenant)
+	migrated, err := db.RunOnce(applied, MIGRATION_ADDRESSES, time.Now(), func() (int, error) {
+		return db.Migrate(storage, (*entities.User).UpgradeAddresses)
+	})
+	if err != nil {
+		slog.Error(err.Error(), "tenant", tenant)
+	}
+	if migrated > 0 {
+		slog.Info("Migrated users to labeled addresses", "tenant", tenant, "count", migrated)
+	}
+	backfilled, err := db.RunOnce(applied, MIGRATION_VERSIONS, time.Now(), func() (int, error) {
+		return db.BackfillVersions(storage)
+	})
+	if err != nil {
+		slog.Error(err.Error(), "tenant", tenant)
+	}
+	if backfilled > 0 {
+		slog.Info("Stored the first version of the users written before the versions", "tenant", tenant, "count", backfilled)
+	}
+	// Rebuilt only when the index was never built, or on demand, since a rebuild of a
+	// big tenant is slow and the index is shared by the other processes
+	force, _ := strconv.ParseBool(os.Getenv(ENV_SEARCH_FORCE))
+	rebuilt, err := services.RebuildSearch(searchIndex, tenant, storage, force)
+	if err != nil {
+		slog.Error(err.Error(), "tenant", tenant)
+	}
+	if rebuilt {
+		slog.Info("Rebuilt the search index", "tenant", tenant)
+	}
+	return storage
+}
 
-	userService := services.NewUserService(storage)
+// webhookOptions lets the webhooks call private network hosts when WEBHOOK_PRIVATE_TARGETS is
+// true, only meant for development since the admins could then reach the internal services
+func webhookOptions() []services.WebhookServiceOption {
+	private, _ := strconv.ParseBool(os.Getenv(ENV_WEBHOOK_LOCAL))
+	if !private {
+		return nil
+	}
+	slog.Warn("webhooks can call private network hosts", ENV_WEBHOOK_LOCAL, private)
+	return []services.WebhookServiceOption{services.WithPrivateTargets()}
+}
 
-	r := mux.NewRouter()
-	// Declaring user subrouter
-	userRouter := r.PathPrefix("/user").Subrouter()
-	userRouter.HandleFunc("/", handlers.GetAllUsers(userService)).Methods("GET")
-	userRouter.HandleFunc("/{id}", handlers.GetUserById(userService)).Methods("GET")
-	userRouter.HandleFunc("/", handlers.CreateUser(userService)).Methods("POST")
-	userRouter.HandleFunc("/{id}", handlers.UpdateUser(userService)).Methods("PUT")
-	userRouter.HandleFunc("/{id}", handlers.DeleteUser(userService)).Methods("DELETE")
+// serveGRPC serves the gRPC api on GRPC_ADDRESS, :9000 by default
+func serveGRPC(userService *services.UserService, adminKeys services.AdminKeys, access services.TenantAccess) {
+	address := os.Getenv(ENV_GRPC_ADDRESS)
+	if address == "" {
+		address = GRPC_ADDRESS
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		slog.Error(err.Error(), ENV_GRPC_ADDRESS, address)
+		return
+	}
+	slog.Info("Serving gRPC", "address", address)
+	if err := grpcapi.NewServer(userService, adminKeys, access).Serve(listener); err != nil {
+		slog.Error(err.Error())
+	}
+}
 
-	// Bind to a port and pass our router in
-	slog.Error(http.ListenAndServe(":8000", r).Error())
+// apiKeys reads the keys accepted by the websocket from the environment
+func apiKeys() *handlers.APIKeys {
+	keys := handlers.ParseAPIKeys(os.Getenv(ENV_API_KEYS))
+	if keys.Len() == 0 {
+		slog.Warn(ErrNoAPIKeys, ENV_API_KEYS, os.Getenv(ENV_API_KEYS))
+	}
+	return keys
+}
+
+// reloadAPIKeysOnHangup reads WEBSOCKET_API_KEYS again from .env on SIGHUP, the open sockets
+// of the keys removed are closed on their next command or event
+func reloadAPIKeysOnHangup(keys *handlers.APIKeys) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := godotenv.Overload(); err != nil {
+				slog.Error(err.Error())
+			}
+			keys.Replace(os.Getenv(ENV_API_KEYS))
+			slog.Info("Reloaded the websocket api keys", "keys", keys.Len())
+		}
+	}()
+}
+
+// adminKeys reads the keys of the admin requests from the environment
+func adminKeys() services.AdminKeys {
+	keys := services.ParseAdminKeys(os.Getenv(ENV_ADMIN_KEYS))
+	if len(keys) == 0 {
+		slog.Warn(ErrNoAdminKeys, ENV_ADMIN_KEYS, os.Getenv(ENV_ADMIN_KEYS))
+	}
+	return keys
+}
+
+// verification reads how the email verification tokens are signed and mailed from the
+// environment, without a secret a random one is used until the next restart
+func verification(sender mail.Sender) services.Verification {
+	secret := []byte(os.Getenv(ENV_VERIFY_SECRET))
+	if len(secret) == 0 {
+		slog.Warn(ErrNoVerifySecret, ENV_VERIFY_SECRET, "")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	return services.Verification{
+		Secret:         secret,
+		TTL:            durationFromEnv(ENV_VERIFY_TTL, defaultVerifyTTL),
+		Sender:         sender,
+		URL:            os.Getenv(ENV_VERIFY_URL),
+		ResendInterval: durationFromEnv(ENV_VERIFY_RESEND, defaultVerifyResend),
+	}
+}
+
+// authConfig reads how the login tokens are signed and the accounts locked from the environment.
+// The auth secret must differ from the tenant token secret, or the tokens of each would pass
+// for the other.
+func authConfig(sender mail.Sender) services.AuthConfig {
+	secret := []byte(os.Getenv(ENV_AUTH_SECRET))
+	if len(secret) > 0 && string(secret) == os.Getenv(ENV_TENANT_SECRET) {
+		slog.Error(ErrSameAuthSecret, ENV_AUTH_SECRET, "")
+		os.Exit(1)
+	}
+	if len(secret) == 0 {
+		slog.Warn(ErrNoAuthSecret, ENV_AUTH_SECRET, "")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	return services.AuthConfig{
+		TokenSecret:     secret,
+		AccessTTL:       durationFromEnv(ENV_ACCESS_TTL, defaultAccessTTL),
+		RefreshTTL:      durationFromEnv(ENV_REFRESH_TTL, defaultRefreshTTL),
+		ResetTTL:        durationFromEnv(ENV_RESET_TTL, defaultResetTTL),
+		MaxFailedLogins: intFromEnv(ENV_MAX_FAILURES, defaultMaxFailures),
+		Lockout:         durationFromEnv(ENV_LOCKOUT, defaultLockout),
+		Sender:          sender,
+		ResetURL:        os.Getenv(ENV_RESET_URL),
+	}
+}
+
+// legacySunset reads the sunset date of the unversioned routes from the environment
+func legacySunset() time.Time {
+	value := os.Getenv(ENV_LEGACY_SUNSET)
+	if value == "" {
+		return defaultLegacySunset
+	}
+	sunset, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		slog.Error(ErrNotValidSunset, ENV_LEGACY_SUNSET, value)
+		return defaultLegacySunset
+	}
+	return sunset
+}
+
+// durationFromEnv reads a positive time.Duration from the environment
+func durationFromEnv(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		slog.Error(ErrNotValidDuration, name, value)
+		return defaultValue
+	}
+	return duration
+}
+
+// intFromEnv reads a positive number from the environment
+func intFromEnv(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		slog.Error(ErrNotValidNumber, name, value)
+		return defaultValue
+	}
+	return number
+}
+
+// newAuditSink selects where the audit log is stored from the environment, memory by default
+func newAuditSink() audit.Sink {
+	switch os.Getenv(ENV_AUDIT_SINK) {
+	case "", STORAGE_MEMORY:
+		return audit.NewMemorySink()
+	case STORAGE_REDIS:
+		return audit.NewRedisSink(db.RedisClient(), AUDIT_STREAM)
+	case AUDIT_FILE:
+		path := os.Getenv(ENV_AUDIT_FILE)
+		if path == "" {
+			path = DEFAULT_AUDIT_LOG
+		}
+		sink, err := audit.NewFileSink(path)
+		if err != nil {
+			slog.Error(ErrNotValidAuditSink, "error", err)
+			os.Exit(1)
+		}
+		return sink
+	default:
+		slog.Error(ErrNotValidAuditSink, ENV_AUDIT_SINK, os.Getenv(ENV_AUDIT_SINK))
+		return audit.NewMemorySink()
+	}
+}
+
+// newSearchIndex selects where the search index is kept from the environment, next to the
+// users by default
+func newSearchIndex() search.Index {
+	switch os.Getenv(ENV_SEARCH_INDEX) {
+	case "":
+		if os.Getenv(ENV_STORAGE) == STORAGE_REDIS {
+			return search.NewRedisIndex(db.RedisClient())
+		}
+		return search.NewMemoryIndex()
+	case STORAGE_MEMORY:
+		return search.NewMemoryIndex()
+	case STORAGE_REDIS:
+		return search.NewRedisIndex(db.RedisClient())
+	default:
+		slog.Error(ErrNotValidSearch, ENV_SEARCH_INDEX, os.Getenv(ENV_SEARCH_INDEX))
+		return search.NewMemoryIndex()
+	}
+}
+
+// newMailSender selects how the emails are sent from the environment, written to the log by default
+func newMailSender() mail.Sender {
+	switch os.Getenv(ENV_MAIL_SENDER) {
+	case "", MAIL_LOG:
+		return mail.NewLogSender()
+	case MAIL_FILE:
+		path := os.Getenv(ENV_MAIL_FILE)
+		if path == "" {
+			path = DEFAULT_MAIL_FILE
+		}
+		sender, err := mail.NewFileSender(path)
+		if err != nil {
+			slog.Error(ErrNotValidMail, "error", err)
+			os.Exit(1)
+		}
+		return sender
+	case MAIL_SMTP:
+		return mail.NewSMTPSender(os.Getenv(ENV_SMTP_ADDRESS), os.Getenv(ENV_SMTP_FROM), os.Getenv(ENV_SMTP_USERNAME), os.Getenv(ENV_SMTP_PASSWORD))
+	default:
+		slog.Error(ErrNotValidMail, ENV_MAIL_SENDER, os.Getenv(ENV_MAIL_SENDER))
+		return mail.NewLogSender()
+	}
+}
+
+// newPublisher selects where the user events are published from the environment,
+// the in-process bus always gets them for the local subscribers
+func newPublisher(bus events.Publisher) events.Publisher {
+	switch os.Getenv(ENV_PUBLISHER) {
+	case "", STORAGE_MEMORY:
+		return bus
+	case STORAGE_REDIS:
+		return events.NewFanout(bus, events.NewRedisPublisher(db.RedisClient(), EVENTS_STREAM))
+	default:
+		slog.Error(ErrNotValidPublisher, ENV_PUBLISHER, os.Getenv(ENV_PUBLISHER))
+		return bus
+	}
 }
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	t.Setenv(ENV_STORAGE, STORAGE_MEMORY)
+	t.Setenv(ENV_VERIFY_SECRET, "verification-secret")
+	t.Setenv(ENV_AUTH_SECRET, "auth-secret")
+
+	s, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, spec := s.router()
+	for _, route := range spec.Undocumented(r) {
+		t.Errorf("%s: %s", ErrUndocumentedRoute, route)
+	}
+}
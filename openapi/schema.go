@@ -0,0 +1,227 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	uuidType      = reflect.TypeOf(uuid.UUID{})
+	rawJSONType   = reflect.TypeOf(json.RawMessage{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	interfaceType = reflect.TypeOf((*any)(nil)).Elem()
+)
+
+// schemaRegistry builds schemas from go types, named structs are stored once
+// in the components and referenced from everywhere else
+type schemaRegistry struct {
+	schemas map[string]*Schema
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{schemas: make(map[string]*Schema)}
+}
+
+func (s *schemaRegistry) schemaOf(v any) *Schema {
+	if v == nil {
+		return nil
+	}
+	return s.schemaFor(reflect.TypeOf(v))
+}
+
+func (s *schemaRegistry) schemaFor(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case uuidType:
+		return &Schema{Type: "string", Format: "uuid"}
+	case rawJSONType, interfaceType:
+		return &Schema{}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64", Description: "duration in nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.schemaFor(t.Elem())
+		if schema.Ref != "" {
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schemaFor(t.Elem())}
+	case reflect.Struct:
+		return s.structSchema(t)
+	}
+	return &Schema{}
+}
+
+func (s *schemaRegistry) structSchema(t reflect.Type) *Schema {
+	name := t.Name()
+	// Generic types are named like "Page[example/bootcamp_ex1/entities.User]"
+	if strings.Contains(name, "[") {
+		name = ""
+	}
+	if name != "" {
+		if _, ok := s.schemas[name]; ok {
+			return &Schema{Ref: "#/components/schemas/" + name}
+		}
+		// Registered before walking the fields so recursive types terminate
+		s.schemas[name] = &Schema{}
+	}
+
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	s.addFields(schema, t)
+
+	if name == "" {
+		return schema
+	}
+	*s.schemas[name] = *schema
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (s *schemaRegistry) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, omitted, inline := jsonName(field)
+		if omitted {
+			continue
+		}
+		if inline {
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			s.addFields(schema, fieldType)
+			continue
+		}
+
+		property := s.schemaFor(field.Type)
+		if applyValidation(property, field.Type, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		if description := field.Tag.Get("description"); description != "" {
+			property.Description = description
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// jsonName returns the name of the field in the JSON payloads
+func jsonName(field reflect.StructField) (name string, omitted bool, inline bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true, false
+	}
+	name = strings.Split(tag, ",")[0]
+	if name == "" {
+		if field.Anonymous {
+			return "", false, true
+		}
+		name = field.Name
+	}
+	return name, false, false
+}
+
+// applyValidation translates the validator tags into schema constraints and
+// reports whether the field is required
+func applyValidation(schema *Schema, t reflect.Type, tag string) bool {
+	required := false
+	if tag == "" {
+		return false
+	}
+	// Constraints are set on the referenced schema's wrapper
+	target := schema
+	if schema.Ref != "" {
+		target = &Schema{}
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			required = true
+		case "email":
+			target.Format = "email"
+		case "url", "uri", "http_url":
+			target.Format = "uri"
+		case "uuid", "uuid4":
+			target.Format = "uuid"
+		case "datetime":
+			target.Format = "date-time"
+		case "oneof":
+			for _, option := range strings.Fields(value) {
+				target.Enum = append(target.Enum, option)
+			}
+		case "min", "gte", "max", "lte", "len":
+			setBound(target, t, key, value)
+		case "dive":
+			// Rules after dive apply to the elements of the collection
+			return required
+		}
+	}
+	return required
+}
+
+func setBound(schema *Schema, t reflect.Type, key string, value string) {
+	bound, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	isMin := key == "min" || key == "gte" || key == "len"
+	isMax := key == "max" || key == "lte" || key == "len"
+	length := int(bound)
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		if isMin {
+			schema.MinLength = &length
+		}
+		if isMax {
+			schema.MaxLength = &length
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if isMin {
+			schema.MinItems = &length
+		}
+		if isMax {
+			schema.MaxItems = &length
+		}
+	default:
+		if isMin {
+			schema.Minimum = &bound
+		}
+		if isMax {
+			schema.Maximum = &bound
+		}
+	}
+}
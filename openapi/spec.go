@@ -0,0 +1,279 @@
+package openapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+const Version = "3.0.3"
+
+// pathVariable matches the gorilla/mux variables, with or without a pattern: {id} {id:[0-9]+}
+var pathVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Operation documents one route. Request and Response are sample values of the
+// body types, their schemas are generated by reflection.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+	Parameters  []Parameter
+	Request     any
+	Response    any
+	// Status of the successful response, 200 when empty
+	Status int
+	// Status codes answered with the error payload
+	Errors  []int
+	Headers map[string]Header
+	// Media types of the bodies, json when empty
+	RequestMediaTypes  []string
+	ResponseMediaTypes []string
+}
+
+// Spec collects the documented operations of a router
+type Spec struct {
+	mu         sync.RWMutex
+	title      string
+	version    string
+	errorType  any
+	operations map[string]Operation
+}
+
+func New(title string, version string) *Spec {
+	return &Spec{
+		title:      title,
+		version:    version,
+		operations: make(map[string]Operation),
+	}
+}
+
+// SetErrorType sets the payload documented for the error responses
+func (s *Spec) SetErrorType(errorType any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorType = errorType
+}
+
+// Document registers the operation for a method and a full mux path template
+func (s *Spec) Document(method string, pathTemplate string, op Operation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.operations[operationKey(method, pathTemplate)] = op
+}
+
+// DocumentRoute registers the operation for every method of a mux route
+func (s *Spec) DocumentRoute(route *mux.Route, op Operation) *mux.Route {
+	pathTemplate, err := route.GetPathTemplate()
+	if err != nil {
+		slog.Error(err.Error())
+		return route
+	}
+	methods, err := route.GetMethods()
+	if err != nil {
+		slog.Error(err.Error())
+		return route
+	}
+	for _, method := range methods {
+		s.Document(method, pathTemplate, op)
+	}
+	return route
+}
+
+// Build generates the document for the documented routes of the router
+func (s *Spec) Build(router *mux.Router) Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	registry := newSchemaRegistry()
+	doc := Document{
+		OpenAPI: Version,
+		Info:    Info{Title: s.title, Version: s.version},
+		Paths:   make(map[string]PathItem),
+	}
+
+	walkRoutes(router, func(method string, pathTemplate string) {
+		op, ok := s.operations[operationKey(method, pathTemplate)]
+		if !ok {
+			return
+		}
+		path := openAPIPath(pathTemplate)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(PathItem)
+		}
+		doc.Paths[path][strings.ToLower(method)] = s.operationObject(registry, pathTemplate, op)
+	})
+
+	doc.Components.Schemas = registry.schemas
+	return doc
+}
+
+// Undocumented lists the "METHOD path" of the routes without an operation
+func (s *Spec) Undocumented(router *mux.Router) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	missing := make([]string, 0)
+	walkRoutes(router, func(method string, pathTemplate string) {
+		key := operationKey(method, pathTemplate)
+		if _, ok := s.operations[key]; !ok {
+			missing = append(missing, key)
+		}
+	})
+	sort.Strings(missing)
+	return missing
+}
+
+// Deprecate marks the documented routes of the router as deprecated, adding the response headers
+func (s *Spec) Deprecate(router *mux.Router, headers map[string]Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	walkRoutes(router, func(method string, pathTemplate string) {
+		key := operationKey(method, pathTemplate)
+		op, ok := s.operations[key]
+		if !ok {
+			return
+		}
+		op.Deprecated = true
+		merged := make(map[string]Header, len(op.Headers)+len(headers))
+		for name, header := range op.Headers {
+			merged[name] = header
+		}
+		for name, header := range headers {
+			merged[name] = header
+		}
+		op.Headers = merged
+		s.operations[key] = op
+	})
+}
+
+// Handler serves the document of the router as JSON
+func (s *Spec) Handler(router *mux.Router) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload, err := json.Marshal(s.Build(router))
+		if err != nil {
+			slog.Error(err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(payload)
+	}
+}
+
+func (s *Spec) operationObject(registry *schemaRegistry, pathTemplate string, op Operation) *OperationObject {
+	object := &OperationObject{
+		Summary:     op.Summary,
+		Description: op.Description,
+		Tags:        op.Tags,
+		Deprecated:  op.Deprecated,
+		Parameters:  pathParameters(pathTemplate, op.Parameters),
+		Responses:   make(map[string]Response),
+	}
+	object.Parameters = append(object.Parameters, queryParameters(op.Parameters)...)
+
+	if op.Request != nil {
+		object.RequestBody = &RequestBody{
+			Required: true,
+			Content:  content(registry.schemaOf(op.Request), op.RequestMediaTypes),
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := Response{
+		Description: http.StatusText(status),
+		Headers:     op.Headers,
+	}
+	if op.Response != nil {
+		response.Content = content(registry.schemaOf(op.Response), op.ResponseMediaTypes)
+	}
+	object.Responses[strconv.Itoa(status)] = response
+
+	for _, code := range op.Errors {
+		errorResponse := Response{Description: http.StatusText(code), Headers: op.Headers}
+		if s.errorType != nil {
+			errorResponse.Content = content(registry.schemaOf(s.errorType), nil)
+		}
+		object.Responses[strconv.Itoa(code)] = errorResponse
+	}
+	return object
+}
+
+// pathParameters documents every mux variable, using the declared parameter when there is one
+func pathParameters(pathTemplate string, declared []Parameter) []Parameter {
+	parameters := make([]Parameter, 0)
+	for _, match := range pathVariable.FindAllStringSubmatch(pathTemplate, -1) {
+		parameter := Parameter{Name: match[1], In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		for _, d := range declared {
+			if d.In == "path" && d.Name == match[1] {
+				parameter = d
+				parameter.Required = true
+			}
+		}
+		parameters = append(parameters, parameter)
+	}
+	return parameters
+}
+
+func queryParameters(declared []Parameter) []Parameter {
+	parameters := make([]Parameter, 0)
+	for _, d := range declared {
+		if d.In != "path" {
+			parameters = append(parameters, d)
+		}
+	}
+	return parameters
+}
+
+func content(schema *Schema, mediaTypes []string) map[string]MediaType {
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{"application/json"}
+	}
+	content := make(map[string]MediaType, len(mediaTypes))
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = MediaType{Schema: schema}
+	}
+	return content
+}
+
+// walkRoutes calls fn for every method of the routes that handle requests
+func walkRoutes(router *mux.Router, fn func(method string, pathTemplate string)) {
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		pathTemplate, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Routes without methods answer all of them
+			methods = []string{http.MethodGet}
+		}
+		for _, method := range methods {
+			fn(method, pathTemplate)
+		}
+		return nil
+	})
+}
+
+// openAPIPath drops the mux patterns from the variables: /user/{id:[0-9]+} -> /user/{id}
+func openAPIPath(pathTemplate string) string {
+	return pathVariable.ReplaceAllString(pathTemplate, "{$1}")
+}
+
+func operationKey(method string, pathTemplate string) string {
+	return method + " " + pathTemplate
+}
@@ -0,0 +1,60 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type pet struct {
+	Name string `json:"name" validate:"required,min=2,max=20"`
+	Age  int    `json:"age,omitempty" validate:"gte=0"`
+}
+
+func TestUndocumentedListsTheRoutesWithoutAnOperation(t *testing.T) {
+	r := mux.NewRouter()
+	spec := New("Pets", "1.0.0")
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	spec.DocumentRoute(r.HandleFunc("/pets", noop).Methods(http.MethodGet), Operation{Summary: "List the pets"})
+	r.HandleFunc("/pets", noop).Methods(http.MethodPost)
+	r.HandleFunc("/pets/{id}", noop).Methods(http.MethodDelete, http.MethodGet)
+
+	got := spec.Undocumented(r)
+	want := []string{"DELETE /pets/{id}", "GET /pets/{id}", "POST /pets"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBuildReflectsTheDocumentedRoutes(t *testing.T) {
+	r := mux.NewRouter()
+	spec := New("Pets", "1.0.0")
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	spec.DocumentRoute(r.HandleFunc("/pets/{id:[0-9]+}", noop).Methods(http.MethodGet), Operation{Summary: "Get a pet", Response: pet{}})
+	r.HandleFunc("/hidden", noop).Methods(http.MethodGet)
+
+	doc := spec.Build(r)
+	if doc.OpenAPI != Version || doc.Info.Title != "Pets" {
+		t.Errorf("got the header %q %+v", doc.OpenAPI, doc.Info)
+	}
+	if _, ok := doc.Paths["/hidden"]; ok {
+		t.Error("an undocumented route is in the document")
+	}
+	item, ok := doc.Paths["/pets/{id}"]
+	if !ok || item["get"] == nil {
+		t.Fatalf("the pet route is missing: %v", doc.Paths)
+	}
+	schema, ok := doc.Components.Schemas["pet"]
+	if !ok {
+		t.Fatalf("the pet schema is missing: %v", doc.Components.Schemas)
+	}
+	name := schema.Properties["name"]
+	if name == nil || name.MinLength == nil || *name.MinLength != 2 || name.MaxLength == nil || *name.MaxLength != 20 {
+		t.Errorf("the validate bounds are not in the name schema: %+v", name)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"name"}) {
+		t.Errorf("got required %v, want [name]", schema.Required)
+	}
+}
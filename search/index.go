@@ -0,0 +1,135 @@
+package search
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrEmptyQuery = errors.New("the search query has no words")
+	ErrIndexing   = errors.New("error indexing document")
+	ErrSearching  = errors.New("error searching the index")
+)
+
+// A word found as the prefix of an indexed word scores this fraction of an exact match
+const prefixScore = 0.5
+
+// Index finds the documents of a tenant by the words of their fields. Every tenant
+// has its own documents, searching a tenant never returns the documents of another one.
+type Index interface {
+	// Add indexes the document, replacing it when it was already indexed
+	Add(tenant string, doc Document) error
+	Remove(tenant string, id uuid.UUID) error
+	// Built reports if the documents of the tenant were indexed by a Rebuild
+	Built(tenant string) (bool, error)
+	// Rebuild indexes the documents, replacing them when they were already indexed, and
+	// marks the index of the tenant built. The other documents are kept, so the ones
+	// indexed by a concurrent change aren't lost.
+	Rebuild(tenant string, docs []Document) error
+	// Search returns the documents having every word of the query, best first
+	Search(tenant string, query string, limit int) ([]Hit, error)
+}
+
+// Document is the searchable text of a record
+type Document struct {
+	Id     uuid.UUID
+	Fields []Field
+}
+
+// Field is a text of a document, the words of the fields with more weight rank higher
+type Field struct {
+	Text   string
+	Weight float64
+}
+
+type Hit struct {
+	Id    uuid.UUID
+	Score float64
+}
+
+// Tokenize splits the text into lowercase words without accents, so "Núñez" is found by "nunez"
+func Tokenize(text string) []string {
+	folded := make([]rune, 0, len(text))
+	for _, r := range norm.NFD.String(text) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		folded = append(folded, unicode.ToLower(r))
+	}
+	return strings.FieldsFunc(string(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// terms returns the words of the document with the weight of the best field having each one
+func terms(doc Document) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, field := range doc.Fields {
+		for _, term := range Tokenize(field.Text) {
+			weights[term] = max(weights[term], field.Weight)
+		}
+	}
+	return weights
+}
+
+// termScore is the score of a document having the term when it is searched by the word
+func termScore(term string, word string, weight float64) float64 {
+	if term == word {
+		return weight
+	}
+	return weight * prefixScore
+}
+
+// rank sums the scores of the documents matching every word of the query and returns the best ones.
+// matches returns the best score of every document having a term starting with the word.
+func rank(query string, limit int, matches func(word string) (map[uuid.UUID]float64, error)) ([]Hit, error) {
+	words := Tokenize(query)
+	if len(words) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	var scores map[uuid.UUID]float64
+	seen := make(map[string]bool, len(words))
+	for _, word := range words {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		found, err := matches(word)
+		if err != nil {
+			return nil, err
+		}
+		if scores == nil {
+			scores = found
+			continue
+		}
+		// Only the documents having every word are kept
+		for id, score := range scores {
+			if wordScore, ok := found[id]; ok {
+				scores[id] = score + wordScore
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{Id: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Id.String() < hits[j].Id.String()
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
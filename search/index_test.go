@@ -0,0 +1,209 @@
+package search
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Tenants of the tests, the redis keys of both are removed around every test
+const (
+	acme   = "test-acme"
+	globex = "test-globex"
+)
+
+func newTestRedisIndex(t *testing.T) *redisIndex {
+	t.Helper()
+	if os.Getenv("REDIS_HOST") == "" {
+		t.Skip("REDIS_HOST is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_HOST")})
+	clean := func() {
+		ctx := context.Background()
+		iter := client.Scan(ctx, 0, "tenant:test-*", 0).Iterator()
+		for iter.Next(ctx) {
+			client.Del(ctx, iter.Val())
+		}
+	}
+	clean()
+	t.Cleanup(func() {
+		clean()
+		client.Close()
+	})
+	return NewRedisIndex(client)
+}
+
+// forEachIndex runs the test on a new index of every backend
+func forEachIndex(t *testing.T, test func(t *testing.T, index Index)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryIndex())
+	})
+	t.Run("redis", func(t *testing.T) {
+		test(t, newTestRedisIndex(t))
+	})
+}
+
+func person(name string, lastName string, city string) Document {
+	return Document{Id: uuid.New(), Fields: []Field{
+		{Text: name, Weight: 4},
+		{Text: lastName, Weight: 4},
+		{Text: city, Weight: 1},
+	}}
+}
+
+func addDocuments(t *testing.T, index Index, tenant string, docs ...Document) {
+	t.Helper()
+	for _, doc := range docs {
+		if err := index.Add(tenant, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func hitIds(hits []Hit) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.Id)
+	}
+	return ids
+}
+
+func TestTokenizeFoldsCaseAndAccents(t *testing.T) {
+	tests := map[string][]string{
+		"Núñez":                  {"nunez"},
+		"José-María O'Brien":     {"jose", "maria", "o", "brien"},
+		"ann.lee+2@example.com":  {"ann", "lee", "2", "example", "com"},
+		"  São Paulo, ÅLESUND  ": {"sao", "paulo", "alesund"},
+		"":                       {},
+		"!?":                     {},
+	}
+	for text, want := range tests {
+		if got := Tokenize(text); len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("%q: got %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSearchFindsDocumentsHavingEveryWord(t *testing.T) {
+	forEachIndex(t, func(t *testing.T, index Index) {
+		ann := person("Ann", "Núñez", "Rome")
+		bea := person("Bea", "Nunes", "Rome")
+		cid := person("Cid", "Lee", "Milan")
+		addDocuments(t, index, acme, ann, bea, cid)
+
+		tests := []struct {
+			query string
+			want  []uuid.UUID
+		}{
+			{"nunez", []uuid.UUID{ann.Id}},
+			{"NÚÑEZ rome", []uuid.UUID{ann.Id}},
+			{"rome milan", []uuid.UUID{}},
+			{"lee", []uuid.UUID{cid.Id}},
+			{"paris", []uuid.UUID{}},
+		}
+		for _, tt := range tests {
+			hits, err := index.Search(acme, tt.query, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hitIds(hits); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+			}
+		}
+
+		if _, err := index.Search(acme, " ?! ", 10); err != ErrEmptyQuery {
+			t.Errorf("empty query: got %v, want %v", err, ErrEmptyQuery)
+		}
+	})
+}
+
+func TestSearchRanksExactAndWeightedMatchesFirst(t *testing.T) {
+	forEachIndex(t, func(t *testing.T, index Index) {
+		// "rom" is the prefix of the name of romy and the city of ann
+		romy := person("Romy", "Lee", "Milan")
+		ann := person("Ann", "Lee", "Rome")
+		// "rome" is the exact city of ann and a prefix of the name of romeo
+		romeo := person("Romeo", "Lee", "Turin")
+		addDocuments(t, index, acme, romy, ann, romeo)
+
+		hits, err := index.Search(acme, "rom", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A prefix of a name scores 4 * 0.5, above the 1 * 0.5 of a city
+		if len(hits) != 3 || hits[2].Id != ann.Id || hits[0].Score != 2 || hits[2].Score != 0.5 {
+			t.Errorf("rom: got %+v", hits)
+		}
+
+		hits, err = index.Search(acme, "rome", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The prefix match in the name of romeo, 2, ranks above the exact one in the city of ann, 1
+		if !reflect.DeepEqual(hitIds(hits), []uuid.UUID{romeo.Id, ann.Id}) || hits[0].Score != 2 || hits[1].Score != 1 {
+			t.Errorf("rome: got %+v", hits)
+		}
+
+		// Every word adds its score
+		hits, _ = index.Search(acme, "romeo lee", 10)
+		if len(hits) != 1 || hits[0].Id != romeo.Id || hits[0].Score != 8 {
+			t.Errorf("romeo lee: got %+v", hits)
+		}
+
+		if hits, _ := index.Search(acme, "lee", 2); len(hits) != 2 {
+			t.Errorf("the limit of 2 returned %+v", hits)
+		}
+	})
+}
+
+func TestIndexChangesReplaceTheDocuments(t *testing.T) {
+	forEachIndex(t, func(t *testing.T, index Index) {
+		ann := person("Ann", "Lee", "Rome")
+		addDocuments(t, index, acme, ann)
+		addDocuments(t, index, globex, person("Ann", "Lee", "Rome"))
+
+		// The tenants have their own documents
+		if hits, _ := index.Search(acme, "ann", 10); !reflect.DeepEqual(hitIds(hits), []uuid.UUID{ann.Id}) {
+			t.Errorf("acme: got %+v", hits)
+		}
+
+		// Adding a document again replaces its words
+		ann.Fields[2].Text = "Milan"
+		addDocuments(t, index, acme, ann)
+		if hits, _ := index.Search(acme, "rome", 10); len(hits) != 0 {
+			t.Errorf("the old city is still indexed: %+v", hits)
+		}
+		if hits, _ := index.Search(acme, "milan", 10); len(hits) != 1 {
+			t.Errorf("the new city is not indexed: %+v", hits)
+		}
+
+		if err := index.Remove(acme, ann.Id); err != nil {
+			t.Fatal(err)
+		}
+		if hits, _ := index.Search(acme, "ann", 10); len(hits) != 0 {
+			t.Errorf("the removed document is found: %+v", hits)
+		}
+
+		bea := person("Bea", "Lee", "Rome")
+		if built, err := index.Built(globex); err != nil || built {
+			t.Errorf("globex was never rebuilt: got %v, %v", built, err)
+		}
+		if err := index.Rebuild(globex, []Document{bea}); err != nil {
+			t.Fatal(err)
+		}
+		if built, err := index.Built(globex); err != nil || !built {
+			t.Errorf("globex was rebuilt: got %v, %v", built, err)
+		}
+		// The documents indexed meanwhile are kept
+		if hits, _ := index.Search(globex, "lee", 10); len(hits) != 2 {
+			t.Errorf("got %+v after the rebuild, want both documents", hits)
+		}
+		if built, _ := index.Built(acme); built {
+			t.Error("the rebuild of globex marked acme built")
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// memoryIndex keeps an inverted index per tenant in this process
+type memoryIndex struct {
+	mu      sync.RWMutex
+	tenants map[string]*postings
+}
+
+// postings maps every word to the documents having it with their weight
+type postings struct {
+	docs map[string]map[uuid.UUID]float64
+	// sorted words, the ones starting with a prefix are found by binary search
+	words []string
+	// words of every document, to remove it
+	terms map[uuid.UUID][]string
+	built bool
+}
+
+func NewMemoryIndex() *memoryIndex {
+	return &memoryIndex{tenants: make(map[string]*postings)}
+}
+
+func (m *memoryIndex) Add(tenant string, doc Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.postings(tenant)
+	p.remove(doc.Id)
+	p.add(doc)
+	return nil
+}
+
+func (m *memoryIndex) Remove(tenant string, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.postings(tenant).remove(id)
+	return nil
+}
+
+func (m *memoryIndex) Built(tenant string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.tenants[tenant]
+	return ok && p.built, nil
+}
+
+func (m *memoryIndex) Rebuild(tenant string, docs []Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.postings(tenant)
+	for _, doc := range docs {
+		p.remove(doc.Id)
+		p.add(doc)
+	}
+	p.built = true
+	return nil
+}
+
+func (m *memoryIndex) Search(tenant string, query string, limit int) ([]Hit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.tenants[tenant]
+	if !ok {
+		p = newPostings()
+	}
+	return rank(query, limit, func(word string) (map[uuid.UUID]float64, error) {
+		found := make(map[uuid.UUID]float64)
+		i, _ := slices.BinarySearch(p.words, word)
+		for ; i < len(p.words) && strings.HasPrefix(p.words[i], word); i++ {
+			for id, weight := range p.docs[p.words[i]] {
+				found[id] = max(found[id], termScore(p.words[i], word, weight))
+			}
+		}
+		return found, nil
+	})
+}
+
+// postings returns the index of the tenant, creating it on its first document
+func (m *memoryIndex) postings(tenant string) *postings {
+	p, ok := m.tenants[tenant]
+	if !ok {
+		p = newPostings()
+		m.tenants[tenant] = p
+	}
+	return p
+}
+
+func newPostings() *postings {
+	return &postings{
+		docs:  make(map[string]map[uuid.UUID]float64),
+		words: make([]string, 0),
+		terms: make(map[uuid.UUID][]string),
+	}
+}
+
+func (p *postings) add(doc Document) {
+	weights := terms(doc)
+	docTerms := make([]string, 0, len(weights))
+	for term, weight := range weights {
+		docs, ok := p.docs[term]
+		if !ok {
+			docs = make(map[uuid.UUID]float64)
+			p.docs[term] = docs
+			i, _ := slices.BinarySearch(p.words, term)
+			p.words = slices.Insert(p.words, i, term)
+		}
+		docs[doc.Id] = weight
+		docTerms = append(docTerms, term)
+	}
+	p.terms[doc.Id] = docTerms
+}
+
+func (p *postings) remove(id uuid.UUID) {
+	for _, term := range p.terms[id] {
+		delete(p.docs[term], id)
+		// Words no document has anymore are forgotten
+		if len(p.docs[term]) == 0 {
+			delete(p.docs, term)
+			if i, found := slices.BinarySearch(p.words, term); found {
+				p.words = slices.Delete(p.words, i, i+1)
+			}
+		}
+	}
+	delete(p.terms, id)
+}
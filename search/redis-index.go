@@ -0,0 +1,178 @@
+package search
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisIndex keeps the inverted index in Redis sorted sets: one per word with the
+// documents scored by weight, and one with every word to find them by prefix
+type redisIndex struct {
+	client *redis.Client
+}
+
+func NewRedisIndex(client *redis.Client) *redisIndex {
+	return &redisIndex{client: client}
+}
+
+// keys of the tenant, the ones of the other tenants start with "tenant:name:" like in the storages
+type redisKeys struct {
+	prefix string
+}
+
+func keysOf(tenant string) redisKeys {
+	if tenant == "" {
+		return redisKeys{prefix: "search:"}
+	}
+	return redisKeys{prefix: "tenant:" + tenant + ":search:"}
+}
+
+// words is the sorted set of every indexed word, all with score 0 so they are sorted by their bytes
+func (k redisKeys) words() string {
+	return k.prefix + "words"
+}
+
+func (k redisKeys) word(word string) string {
+	return k.prefix + "word:" + word
+}
+
+// built is set once the documents of the tenant were indexed by a Rebuild
+func (k redisKeys) built() string {
+	return k.prefix + "built"
+}
+
+// doc is the set of words of a document, to remove it
+func (k redisKeys) doc(id uuid.UUID) string {
+	return k.prefix + "doc:" + id.String()
+}
+
+func (r *redisIndex) Add(tenant string, doc Document) error {
+	ctx := context.Background()
+	keys := keysOf(tenant)
+	previous, err := r.client.SMembers(ctx, keys.doc(doc.Id)).Result()
+	if err != nil {
+		slog.Error(err.Error(), "id", doc.Id)
+		return ErrIndexing
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		r.queueRemove(ctx, pipe, keys, doc.Id, previous)
+		r.queueAdd(ctx, pipe, keys, doc)
+		return nil
+	})
+	if err != nil {
+		slog.Error(err.Error(), "id", doc.Id)
+		return ErrIndexing
+	}
+	return nil
+}
+
+func (r *redisIndex) Remove(tenant string, id uuid.UUID) error {
+	ctx := context.Background()
+	keys := keysOf(tenant)
+	previous, err := r.client.SMembers(ctx, keys.doc(id)).Result()
+	if err != nil {
+		slog.Error(err.Error(), "id", id)
+		return ErrIndexing
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		r.queueRemove(ctx, pipe, keys, id, previous)
+		return nil
+	})
+	if err != nil {
+		slog.Error(err.Error(), "id", id)
+		return ErrIndexing
+	}
+	return nil
+}
+
+func (r *redisIndex) Built(tenant string) (bool, error) {
+	count, err := r.client.Exists(context.Background(), keysOf(tenant).built()).Result()
+	if err != nil {
+		slog.Error(err.Error(), "tenant", tenant)
+		return false, ErrSearching
+	}
+	return count > 0, nil
+}
+
+// Rebuild adds the documents one by one, each replacing its previous words, so the
+// documents of the other processes are never wiped
+func (r *redisIndex) Rebuild(tenant string, docs []Document) error {
+	for _, doc := range docs {
+		if err := r.Add(tenant, doc); err != nil {
+			return err
+		}
+	}
+	if err := r.client.Set(context.Background(), keysOf(tenant).built(), "1", 0).Err(); err != nil {
+		slog.Error(err.Error(), "tenant", tenant)
+		return ErrIndexing
+	}
+	return nil
+}
+
+func (r *redisIndex) Search(tenant string, query string, limit int) ([]Hit, error) {
+	ctx := context.Background()
+	keys := keysOf(tenant)
+	return rank(query, limit, func(word string) (map[uuid.UUID]float64, error) {
+		// Every word starting with the searched one, 0xff is greater than any byte of UTF-8 text
+		terms, err := r.client.ZRangeByLex(ctx, keys.words(), &redis.ZRangeBy{
+			Min: "[" + word,
+			Max: "[" + word + "\xff",
+		}).Result()
+		if err != nil {
+			slog.Error(err.Error(), "word", word)
+			return nil, ErrSearching
+		}
+
+		results := make([]*redis.ZSliceCmd, len(terms))
+		_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, term := range terms {
+				results[i] = pipe.ZRangeWithScores(ctx, keys.word(term), 0, -1)
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error(err.Error(), "word", word)
+			return nil, ErrSearching
+		}
+
+		found := make(map[uuid.UUID]float64)
+		for i, result := range results {
+			for _, member := range result.Val() {
+				id, err := uuid.Parse(member.Member.(string))
+				if err != nil {
+					continue
+				}
+				found[id] = max(found[id], termScore(terms[i], word, member.Score))
+			}
+		}
+		return found, nil
+	})
+}
+
+func (r *redisIndex) queueAdd(ctx context.Context, pipe redis.Pipeliner, keys redisKeys, doc Document) {
+	weights := terms(doc)
+	if len(weights) == 0 {
+		return
+	}
+	docTerms := make([]any, 0, len(weights))
+	words := make([]redis.Z, 0, len(weights))
+	for term, weight := range weights {
+		pipe.ZAdd(ctx, keys.word(term), redis.Z{Score: weight, Member: doc.Id.String()})
+		words = append(words, redis.Z{Member: term})
+		docTerms = append(docTerms, term)
+	}
+	pipe.ZAdd(ctx, keys.words(), words...)
+	pipe.SAdd(ctx, keys.doc(doc.Id), docTerms...)
+}
+
+// queueRemove removes the document from the sets of its words. The words stay in the set
+// of every word, searching one no document has anymore only finds an empty set.
+func (r *redisIndex) queueRemove(ctx context.Context, pipe redis.Pipeliner, keys redisKeys, id uuid.UUID, terms []string) {
+	for _, term := range terms {
+		pipe.ZRem(ctx, keys.word(term), id.String())
+	}
+	pipe.Del(ctx, keys.doc(id))
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AnonymousActor = "anonymous"
+	// SystemActor does the changes of the background jobs
+	SystemActor = "system"
+)
+
+type actorKey struct{}
+
+// WithActor returns a context carrying who is doing the request
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns who is doing the request, AnonymousActor when it is unknown
+func ActorFrom(ctx context.Context) string {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	if !ok || actor == "" {
+		return AnonymousActor
+	}
+	return actor
+}
+
+type userKey struct{}
+
+// WithUser returns a context of a request made by the user with its access token
+func WithUser(ctx context.Context, id uuid.UUID) context.Context {
+	return context.WithValue(ctx, userKey{}, id)
+}
+
+// UserFrom returns the user of the access token of the request, false when it has none
+func UserFrom(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(userKey{}).(uuid.UUID)
+	return id, ok
+}
+
+type adminKey struct{}
+
+// WithAdmin returns a context of a request made by an admin
+func WithAdmin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminKey{}, true)
+}
+
+// IsAdmin tells if the request was made with an admin key
+func IsAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminKey{}).(bool)
+	return admin
+}
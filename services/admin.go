@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAdminOnly = errors.New("only an admin can change the activation of a user, users are activated by verifying their email")
+	ErrOwnerOnly = errors.New("only the user, with its access token, or an admin can change the email of a user")
+)
+
+// AdminKeys are the keys that make a request an admin one
+type AdminKeys []string
+
+// ParseAdminKeys reads a comma separated list of keys
+func ParseAdminKeys(value string) AdminKeys {
+	keys := make(AdminKeys, 0)
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Allows compares the key with every admin key in constant time
+func (k AdminKeys) Allows(key string) bool {
+	allowed := false
+	for _, adminKey := range k {
+		if subtle.ConstantTimeCompare([]byte(adminKey), []byte(key)) == 1 {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// requireOwnerOrAdmin fails when the request is neither an admin one nor one of the user
+// with its own access token
+func requireOwnerOrAdmin(ctx context.Context, id uuid.UUID) error {
+	if user, ok := UserFrom(ctx); (ok && user == id) || IsAdmin(ctx) {
+		return nil
+	}
+	return ErrOwnerOnly
+}
+
+// requireAdminToActivate fails when a request that isn't an admin one changes the activation
+func requireAdminToActivate(ctx context.Context, current bool, requested bool) error {
+	if current != requested && !IsAdmin(ctx) {
+		return ErrAdminOnly
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidAttribute = errors.New("invalid custom attribute")
+)
+
+// AttributeKey is the key of an attribute value in the IndexAttribute index
+func AttributeKey(name string, value string) string {
+	return name + "=" + value
+}
+
+type AttributeService = ResourceService[entities.AttributeDefinition, entities.AttributeDefinitionRequest]
+
+// NewAttributeService returns the CRUD service of the attribute definitions, the
+// name of a definition is unique and can't change
+func NewAttributeService(definitions *db.Tenants[entities.AttributeDefinition], clock Clock) *AttributeService {
+	return NewResourceService("attribute", definitions, Mapper[entities.AttributeDefinition, entities.AttributeDefinitionRequest]{
+		New: func(ctx context.Context, _ uuid.UUID, req entities.AttributeDefinitionRequest) (entities.AttributeDefinition, error) {
+			id := entities.AttributeDefinitionId(req.Name)
+			if _, err := definitions.For(TenantFrom(ctx)).Get(id); err == nil {
+				return entities.AttributeDefinition{}, fmt.Errorf("attribute %q: %w", req.Name, ErrResourceConflict)
+			}
+			now := clock.Now()
+			return entities.AttributeDefinition{
+				Id:          id,
+				Name:        req.Name,
+				Type:        req.Type,
+				Required:    req.Required,
+				Enum:        req.Enum,
+				Description: req.Description,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}, nil
+		},
+		Apply: func(ctx context.Context, current entities.AttributeDefinition, req entities.AttributeDefinitionRequest) (entities.AttributeDefinition, error) {
+			if req.Name != current.Name {
+				return current, fmt.Errorf("attribute %q can't be renamed: %w", current.Name, ErrResourceConflict)
+			}
+			current.Type = req.Type
+			current.Required = req.Required
+			current.Enum = req.Enum
+			current.Description = req.Description
+			current.UpdatedAt = clock.Now()
+			return current, nil
+		},
+	})
+}
+
+// validateAttributes checks the attributes against the definitions and returns
+// them converted to the type of their definition
+func validateAttributes(ctx context.Context, definitions *AttributeService, attributes entities.Attributes) (entities.Attributes, error) {
+	byName := make(map[string]entities.AttributeDefinition)
+	if definitions != nil {
+		all, err := db.Collect(definitions.Iterate(ctx, db.DefaultBatchSize))
+		if err != nil {
+			return nil, err
+		}
+		for _, definition := range all {
+			byName[definition.Name] = definition
+		}
+	}
+
+	validated := make(entities.Attributes, len(attributes))
+	for name, value := range attributes {
+		// A null value is the same as a missing attribute
+		if value == nil {
+			continue
+		}
+		definition, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w %q: it is not defined", ErrInvalidAttribute, name)
+		}
+		converted, err := convertAttribute(definition, value)
+		if err != nil {
+			return nil, err
+		}
+		validated[name] = converted
+	}
+	for name, definition := range byName {
+		if _, ok := validated[name]; definition.Required && !ok {
+			return nil, fmt.Errorf("%w %q: it is required", ErrInvalidAttribute, name)
+		}
+	}
+	return validated, nil
+}
+
+// convertAttribute returns the value with the type of the definition. Values
+// sent as text, like the ones of the XML requests, are parsed.
+func convertAttribute(definition entities.AttributeDefinition, value any) (any, error) {
+	invalid := fmt.Errorf("%w %q: it must be a %s", ErrInvalidAttribute, definition.Name, definition.Type)
+	switch definition.Type {
+	case entities.AttributeString:
+		text, ok := value.(string)
+		if !ok {
+			return nil, invalid
+		}
+		if len(definition.Enum) > 0 && !slices.Contains(definition.Enum, text) {
+			return nil, fmt.Errorf("%w %q: it must be one of %v", ErrInvalidAttribute, definition.Name, definition.Enum)
+		}
+		return text, nil
+	case entities.AttributeNumber:
+		switch number := value.(type) {
+		case float64:
+			return number, nil
+		case float32:
+			return float64(number), nil
+		case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint:
+			return strconv.ParseFloat(fmt.Sprint(number), 64)
+		case string:
+			parsed, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			return parsed, nil
+		}
+		return nil, invalid
+	case entities.AttributeBoolean:
+		switch boolean := value.(type) {
+		case bool:
+			return boolean, nil
+		case string:
+			parsed, err := strconv.ParseBool(boolean)
+			if err != nil {
+				return nil, invalid
+			}
+			return parsed, nil
+		}
+		return nil, invalid
+	}
+	return nil, invalid
+}
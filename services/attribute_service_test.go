@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"reflect"
+	"testing"
+)
+
+// newTestAttributes defines the attributes team, an enum, age and admin, the required one
+func newTestAttributes(t *testing.T) *AttributeService {
+	t.Helper()
+	attributes := NewAttributeService(memoryTenants[entities.AttributeDefinition](newTestTenants()), SystemClock)
+	for _, req := range []entities.AttributeDefinitionRequest{
+		{Name: "team", Type: entities.AttributeString, Enum: []string{"core", "web"}},
+		{Name: "age", Type: entities.AttributeNumber},
+		{Name: "admin", Type: entities.AttributeBoolean, Required: true},
+	} {
+		if _, err := attributes.Create(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return attributes
+}
+
+func TestAttributesAreValidatedAgainstTheirDefinitions(t *testing.T) {
+	attributes := newTestAttributes(t)
+
+	tests := []struct {
+		name       string
+		attributes entities.Attributes
+		want       entities.Attributes
+	}{
+		{"typed values", entities.Attributes{"team": "core", "age": float64(30), "admin": true}, entities.Attributes{"team": "core", "age": float64(30), "admin": true}},
+		{"text values", entities.Attributes{"age": "30.5", "admin": "false"}, entities.Attributes{"age": 30.5, "admin": false}},
+		{"integers", entities.Attributes{"age": 30, "admin": true}, entities.Attributes{"age": float64(30), "admin": true}},
+		{"null values", entities.Attributes{"team": nil, "admin": true}, entities.Attributes{"admin": true}},
+		{"undefined", entities.Attributes{"admin": true, "city": "Rome"}, nil},
+		{"outside the enum", entities.Attributes{"admin": true, "team": "ops"}, nil},
+		{"wrong type", entities.Attributes{"admin": true, "age": true}, nil},
+		{"unparsable text", entities.Attributes{"admin": "maybe"}, nil},
+		{"missing required", entities.Attributes{"team": "core"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := validateAttributes(context.Background(), attributes, tt.attributes)
+		if tt.want == nil {
+			if !errors.Is(err, ErrInvalidAttribute) {
+				t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, ErrInvalidAttribute)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	// Without definitions users can't have custom attributes
+	if _, err := validateAttributes(context.Background(), nil, entities.Attributes{"team": "core"}); !errors.Is(err, ErrInvalidAttribute) {
+		t.Errorf("without definitions: got %v, want %v", err, ErrInvalidAttribute)
+	}
+}
+
+func TestAttributeNamesAreUnique(t *testing.T) {
+	attributes := newTestAttributes(t)
+	ctx := context.Background()
+
+	if _, err := attributes.Create(ctx, entities.AttributeDefinitionRequest{Name: "team", Type: entities.AttributeString}); !errors.Is(err, ErrResourceConflict) {
+		t.Errorf("duplicate: got %v, want %v", err, ErrResourceConflict)
+	}
+	id := entities.AttributeDefinitionId("team")
+	if _, err := attributes.Update(ctx, id, entities.AttributeDefinitionRequest{Name: "squad", Type: entities.AttributeString}); !errors.Is(err, ErrResourceConflict) {
+		t.Errorf("rename: got %v, want %v", err, ErrResourceConflict)
+	}
+	updated, err := attributes.Update(ctx, id, entities.AttributeDefinitionRequest{Name: "team", Type: entities.AttributeString, Required: true})
+	if err != nil || !updated.Required {
+		t.Errorf("update: got %+v, %v", updated, err)
+	}
+}
+
+func TestUpdatesKeepTheAttributesAndTagsNotSent(t *testing.T) {
+	u := newTestUserService(WithAttributes(newTestAttributes(t)))
+	ctx := context.Background()
+	req := userRequest("Ann", "ann@example.com")
+	req.Attributes = entities.Attributes{"team": "core", "admin": "true"}
+	req.Tags = []string{" Staff", "admin", "staff "}
+	id, err := u.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := u.Get(ctx, id)
+	if !reflect.DeepEqual(user.Tags, []string{"admin", "staff"}) || user.Attributes["admin"] != true {
+		t.Errorf("created the tags %v and the attributes %v", user.Tags, user.Attributes)
+	}
+
+	// A client unaware of the attributes and tags
+	updated, err := u.Update(ctx, id, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(updated.Tags, user.Tags) || !reflect.DeepEqual(updated.Attributes, user.Attributes) {
+		t.Errorf("the update changed the tags %v and the attributes %v", updated.Tags, updated.Attributes)
+	}
+
+	// Attributes sent are checked for the required ones
+	req.Attributes = entities.Attributes{"team": "web"}
+	if _, err := u.Update(ctx, id, req); !errors.Is(err, ErrInvalidAttribute) {
+		t.Errorf("without a required attribute: got %v, want %v", err, ErrInvalidAttribute)
+	}
+}
+
+func TestListFiltersByTagAndAttribute(t *testing.T) {
+	users := memoryTenants[entities.User](newTestTenants(), UserIndexes...)
+	u := NewUserService(users, WithAttributes(newTestAttributes(t)))
+	ctx := context.Background()
+	create := func(name string, team string, tags ...string) string {
+		req := userRequest(name, name+"@example.com")
+		req.Attributes = entities.Attributes{"team": team, "admin": false}
+		req.Tags = tags
+		if _, err := u.Create(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	create("ann", "core", "staff", "admin")
+	create("bea", "core", "staff")
+	create("cid", "web", "staff")
+
+	tests := []struct {
+		query ListQuery
+		want  []string
+	}{
+		{ListQuery{Tags: []string{"admin"}}, []string{"ann"}},
+		{ListQuery{Tags: []string{"staff"}, Attributes: map[string]string{"team": "core"}}, []string{"ann", "bea"}},
+		{ListQuery{Attributes: map[string]string{"team": "web"}}, []string{"cid"}},
+		{ListQuery{Attributes: map[string]string{"team": "core", "admin": "true"}}, []string{}},
+		{ListQuery{Tags: []string{"guest"}}, []string{}},
+	}
+	for _, tt := range tests {
+		tt.query.Sort = SortByName
+		found, err := db.Collect(u.List(ctx, tt.query, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(found))
+		for _, user := range found {
+			names = append(names, user.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%+v: got %v, want %v", tt.query, names, tt.want)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"example/bootcamp_ex1/audit"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/mail"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrAccountLocked      = errors.New("the account is locked after too many failed logins, try again later")
+	ErrInactiveUser       = errors.New("the user is not active, it has to verify its email")
+	ErrEmailUnverified    = errors.New("the email of the user is not verified")
+	ErrInvalidAuthToken   = errors.New("invalid token")
+	ErrAuthTokenExpired   = errors.New("the token has expired")
+	ErrInvalidReset       = errors.New("invalid or expired password reset token")
+	ErrEmailInUse         = errors.New("another user with this email already has a password")
+	ErrPasswordRequired   = errors.New("the current password is required")
+)
+
+// Types of the tokens issued on login
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
+
+// credentialAttempts is how many times a credential change is tried while other requests change it
+const credentialAttempts = 5
+
+// AuthConfig sets how the AuthService signs its tokens, locks the accounts and mails the
+// password resets
+type AuthConfig struct {
+	// TokenSecret signs the HS256 tokens, it must not be the secret of the tenant tokens.
+	// The access tokens resolve the tenant of the requests with TenantAccess.Users.
+	TokenSecret []byte
+	AccessTTL   time.Duration
+	RefreshTTL  time.Duration
+	ResetTTL    time.Duration
+	// After MaxFailedLogins consecutive failures the account is locked for Lockout
+	MaxFailedLogins int
+	Lockout         time.Duration
+	Sender          mail.Sender
+	// URL the users open to reset their password, with the token in ?token=. Without
+	// it the email only has the token.
+	ResetURL string
+}
+
+// Tokens are issued on login and refresh, ExpiresIn is how long the access token is valid
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+}
+
+// authClaims are signed in the tokens, Version is the token version of the credential
+// on refresh tokens
+type authClaims struct {
+	Subject   uuid.UUID `json:"sub"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Type      string    `json:"typ"`
+	Version   int       `json:"ver,omitempty"`
+	IssuedAt  int64     `json:"iat"`
+	ExpiresAt int64     `json:"exp"`
+}
+
+// AuthService logs the users in with the passwords of their credentials
+type AuthService struct {
+	// Every tenant keeps the credentials of its users in its own storage
+	credentials *db.Tenants[entities.Credential]
+	users       *UserService
+	clock       Clock
+	config      AuthConfig
+	// dummyHash is checked when no user has the email, so unknown emails take as long as wrong passwords
+	dummyHash string
+}
+
+// NewAuthService logs in the users of the service. The credentials of the deleted users are
+// kept, they work again if the user is restored.
+func NewAuthService(credentials *db.Tenants[entities.Credential], users *UserService, clock Clock, config AuthConfig) (*AuthService, error) {
+	dummyHash, err := hashPassword(uuid.NewString())
+	if err != nil {
+		return nil, err
+	}
+	return &AuthService{
+		credentials: credentials,
+		users:       users,
+		clock:       clock,
+		config:      config,
+		dummyHash:   dummyHash,
+	}, nil
+}
+
+// Login checks the password of the active user with the verified email and issues its tokens.
+// Failed logins are counted and lock the account, a locked account fails even with the right
+// password.
+func (a *AuthService) Login(ctx context.Context, req entities.LoginRequest) (Tokens, error) {
+	//Log action
+	slog.Info("Logging in a user")
+	user, credential, err := a.findLogin(ctx, req.Email)
+	if errors.Is(err, ErrInvalidCredentials) {
+		checkPassword(req.Password, a.dummyHash)
+		return Tokens{}, err
+	}
+	if err != nil {
+		return Tokens{}, err
+	}
+	if a.locked(credential) {
+		return Tokens{}, ErrAccountLocked
+	}
+	ok, err := checkPassword(req.Password, credential.PasswordHash)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if !ok {
+		a.failLogin(ctx, credential.Id)
+		return Tokens{}, ErrInvalidCredentials
+	}
+	if err := checkLoginUser(user); err != nil {
+		return Tokens{}, err
+	}
+	if credential.FailedLogins > 0 || credential.LockedUntil != nil {
+		// Only the lockout is cleared, a change of the password meanwhile is kept
+		err := a.updateCredential(ctx, credential.Id, func(stored *entities.Credential) error {
+			if a.locked(*stored) {
+				return ErrAccountLocked
+			}
+			stored.FailedLogins = 0
+			stored.LockedUntil = nil
+			return nil
+		})
+		if err != nil {
+			return Tokens{}, err
+		}
+	}
+	slog.Info("User logged in", "id", user.Id)
+	return a.issue(ctx, user.Id, credential.TokenVersion)
+}
+
+// Refresh issues new tokens for a refresh token, until the password of the user changes
+func (a *AuthService) Refresh(ctx context.Context, token string) (Tokens, error) {
+	claims, err := a.parseToken(ctx, token, RefreshToken)
+	if err != nil {
+		return Tokens{}, err
+	}
+	user, err := a.users.Get(ctx, claims.Subject)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return Tokens{}, ErrInvalidAuthToken
+	}
+	if err != nil {
+		return Tokens{}, err
+	}
+	credential, _, err := a.credential(ctx, user.Id)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if credential.PasswordHash == "" || credential.TokenVersion != claims.Version {
+		return Tokens{}, ErrInvalidAuthToken
+	}
+	if a.locked(credential) {
+		return Tokens{}, ErrAccountLocked
+	}
+	if err := checkLoginUser(user); err != nil {
+		return Tokens{}, err
+	}
+	return a.issue(ctx, user.Id, credential.TokenVersion)
+}
+
+// Authenticate returns the active user with a verified email of an access token
+func (a *AuthService) Authenticate(ctx context.Context, token string) (entities.User, error) {
+	claims, err := a.parseToken(ctx, token, AccessToken)
+	if err != nil {
+		return entities.User{}, err
+	}
+	user, err := a.users.Get(ctx, claims.Subject)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return entities.User{}, ErrInvalidAuthToken
+	}
+	if err != nil {
+		return entities.User{}, err
+	}
+	if err := checkLoginUser(user); err != nil {
+		return entities.User{}, err
+	}
+	return user, nil
+}
+
+// AccessToken returns the tenant and the user of a valid access token, false for the other
+// bearers. The user can be inactive, Authenticate also checks it.
+func (a *AuthService) AccessToken(bearer string) (string, uuid.UUID, bool) {
+	claims, err := a.verifyToken(bearer, AccessToken)
+	if err != nil {
+		return "", uuid.Nil, false
+	}
+	return claims.Tenant, claims.Subject, true
+}
+
+// ChangePassword sets the password of the user. The user has to give its current password,
+// counted as a login, admins can set it without. The refresh tokens issued before stop working.
+func (a *AuthService) ChangePassword(ctx context.Context, id uuid.UUID, req entities.PasswordChangeRequest) error {
+	slog.Info("Changing user password", "id", id, "actor", ActorFrom(ctx))
+	user, err := a.users.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	credential, _, err := a.credential(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !IsAdmin(ctx) {
+		if req.CurrentPassword == "" {
+			return ErrPasswordRequired
+		}
+		// Without a password the user sets its first one with a password reset
+		if credential.PasswordHash == "" {
+			return ErrInvalidCredentials
+		}
+		if a.locked(credential) {
+			return ErrAccountLocked
+		}
+		ok, err := checkPassword(req.CurrentPassword, credential.PasswordHash)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			a.failLogin(ctx, credential.Id)
+			return ErrInvalidCredentials
+		}
+	}
+	return a.setPassword(ctx, user, req.NewPassword, audit.OperationPasswordChange, nil)
+}
+
+// RequestPasswordReset mails a token to set a new password to the user with the email, once
+// it is verified. It fails silently when no user has it, so it can't tell which emails have
+// an account.
+func (a *AuthService) RequestPasswordReset(ctx context.Context, req entities.PasswordResetRequest) error {
+	//Log action
+	slog.Info("Requesting a password reset")
+	user, ok, err := a.findReset(ctx, req.Email)
+	if err != nil || !ok {
+		return err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	// The id finds the credential, only the hash of the token is stored
+	token := user.Id.String() + "." + base64.RawURLEncoding.EncodeToString(secret)
+	expiresAt := a.clock.Now().Add(a.config.ResetTTL)
+	err = a.updateCredential(ctx, user.Id, func(credential *entities.Credential) error {
+		credential.ResetHash = resetHash(token, user.Email)
+		credential.ResetExpiresAt = &expiresAt
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nSet a new password with this token, it expires in %s:\n\n%s\n", user.Name, a.config.ResetTTL, token)
+	if a.config.ResetURL != "" {
+		body += fmt.Sprintf("\nOr open %s?token=%s\n", a.config.ResetURL, url.QueryEscape(token))
+	}
+	body += "\nIf you didn't ask for it, ignore this email.\n"
+	slog.Info("Sending password reset email", "id", user.Id)
+	// The reset is stored, a failing email must not tell the email has an account
+	if err := a.config.Sender.Send(ctx, mail.Message{To: user.Email, Subject: "Reset your password", Body: body}); err != nil {
+		slog.Error(err.Error(), "id", user.Id)
+	}
+	return nil
+}
+
+// ConfirmPasswordReset sets the password with the token mailed by RequestPasswordReset, it
+// also unlocks the account. A token is used once, and only while the user has the verified
+// email it was sent to.
+func (a *AuthService) ConfirmPasswordReset(ctx context.Context, req entities.PasswordResetConfirmRequest) error {
+	idPart, _, _ := strings.Cut(req.Token, ".")
+	id, err := uuid.Parse(idPart)
+	if err != nil {
+		return ErrInvalidReset
+	}
+	slog.Info("Resetting user password", "id", id)
+	user, err := a.users.Get(ctx, id)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return ErrInvalidReset
+	}
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt == nil {
+		return ErrInvalidReset
+	}
+	// Checked on the stored credential it replaces, so two requests can't both use the token
+	return a.setPassword(ctx, user, req.NewPassword, audit.OperationPasswordReset, func(credential entities.Credential) error {
+		if credential.ResetHash == "" || credential.ResetExpiresAt == nil ||
+			subtle.ConstantTimeCompare([]byte(credential.ResetHash), []byte(resetHash(req.Token, user.Email))) != 1 ||
+			!a.clock.Now().Before(*credential.ResetExpiresAt) {
+			return ErrInvalidReset
+		}
+		return nil
+	})
+}
+
+// setPassword hashes the new password and clears the lockout and the pending reset, check
+// can refuse the stored credential
+func (a *AuthService) setPassword(ctx context.Context, user entities.User, password string, operation string, check func(entities.Credential) error) error {
+	if err := emailFree(ctx, a.users, a.storage(ctx), user.Email, user.Id); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	now := a.clock.Now()
+	// The hashes are never logged, the entry has no changes
+	outbox, err := a.users.audited(ctx, nil, operation, user.Id, now, nil, nil)
+	if err != nil {
+		return err
+	}
+	return a.updateCredential(ctx, user.Id, func(credential *entities.Credential) error {
+		if check != nil {
+			if err := check(*credential); err != nil {
+				return err
+			}
+		}
+		credential.PasswordHash = hash
+		credential.PasswordChangedAt = &now
+		credential.TokenVersion++
+		credential.FailedLogins = 0
+		credential.LockedUntil = nil
+		credential.ResetHash = ""
+		credential.ResetExpiresAt = nil
+		return nil
+	}, outbox...)
+}
+
+// checkLoginUser fails for the users that can't log in, the inactive ones and the ones that
+// haven't verified their email
+func checkLoginUser(user entities.User) error {
+	if !user.Active {
+		return ErrInactiveUser
+	}
+	if user.EmailVerifiedAt == nil {
+		return ErrEmailUnverified
+	}
+	return nil
+}
+
+// findLogin returns the user with the email that has a password. Emails aren't unique, so
+// an email of several users with a password logs in none of them until they are merged.
+func (a *AuthService) findLogin(ctx context.Context, email string) (entities.User, entities.Credential, error) {
+	users, err := a.users.FindByEmail(ctx, email)
+	if err != nil {
+		return entities.User{}, entities.Credential{}, err
+	}
+	found := make([]entities.User, 0, 1)
+	var credential entities.Credential
+	for _, user := range users {
+		userCredential, _, err := a.credential(ctx, user.Id)
+		if err != nil {
+			return entities.User{}, entities.Credential{}, err
+		}
+		if userCredential.PasswordHash != "" {
+			found = append(found, user)
+			credential = userCredential
+		}
+	}
+	if len(found) > 1 {
+		slog.Warn("Several users with a password have the same email", "ids", userIds(found))
+	}
+	if len(found) != 1 {
+		return entities.User{}, entities.Credential{}, ErrInvalidCredentials
+	}
+	return found[0], credential, nil
+}
+
+// findReset returns the user with the email that has a password, or the only user with the
+// email, when the email is verified
+func (a *AuthService) findReset(ctx context.Context, email string) (entities.User, bool, error) {
+	user, _, err := a.findLogin(ctx, email)
+	if err != nil && !errors.Is(err, ErrInvalidCredentials) {
+		return entities.User{}, false, err
+	}
+	if err != nil {
+		users, err := a.users.FindByEmail(ctx, email)
+		if err != nil || len(users) != 1 {
+			return entities.User{}, false, err
+		}
+		user = users[0]
+	}
+	// The token must only reach the owner of the email
+	return user, user.EmailVerifiedAt != nil, nil
+}
+
+func (a *AuthService) locked(credential entities.Credential) bool {
+	return credential.LockedUntil != nil && a.clock.Now().Before(*credential.LockedUntil)
+}
+
+// failLogin counts a failed login on the stored credential and locks the account after
+// MaxFailedLogins, the concurrent failures are all counted
+func (a *AuthService) failLogin(ctx context.Context, id uuid.UUID) {
+	err := a.updateCredential(ctx, id, func(credential *entities.Credential) error {
+		// Locked meanwhile by the other failures
+		if a.locked(*credential) {
+			return nil
+		}
+		if credential.LockedUntil != nil {
+			credential.LockedUntil = nil
+			credential.FailedLogins = 0
+		}
+		credential.FailedLogins++
+		if credential.FailedLogins >= a.config.MaxFailedLogins {
+			lockedUntil := a.clock.Now().Add(a.config.Lockout)
+			credential.LockedUntil = &lockedUntil
+		}
+		return nil
+	})
+	// The login already failed, a failing count must not change its error
+	if err != nil {
+		slog.Error(err.Error(), "id", id)
+	}
+}
+
+// issue signs an access token and a refresh token for the user
+func (a *AuthService) issue(ctx context.Context, id uuid.UUID, version int) (Tokens, error) {
+	now := a.clock.Now()
+	claims := authClaims{
+		Subject:   id,
+		Tenant:    TenantFrom(ctx),
+		Type:      AccessToken,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(a.config.AccessTTL).Unix(),
+	}
+	access, err := a.signToken(claims)
+	if err != nil {
+		return Tokens{}, err
+	}
+	claims.Type = RefreshToken
+	claims.Version = version
+	claims.ExpiresAt = now.Add(a.config.RefreshTTL).Unix()
+	refresh, err := a.signToken(claims)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return Tokens{AccessToken: access, RefreshToken: refresh, ExpiresIn: a.config.AccessTTL}, nil
+}
+
+// signToken encodes the claims as an HS256 JWT
+func (a *AuthService) signToken(claims authClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + a.sign(unsigned), nil
+}
+
+// parseToken verifies a token of the type issued for the tenant of the request
+func (a *AuthService) parseToken(ctx context.Context, token string, tokenType string) (authClaims, error) {
+	claims, err := a.verifyToken(token, tokenType)
+	if err != nil {
+		return authClaims{}, err
+	}
+	if claims.Tenant != TenantFrom(ctx) {
+		return authClaims{}, ErrInvalidAuthToken
+	}
+	return claims, nil
+}
+
+// verifyToken verifies the signature, the type and the expiry of a token
+func (a *AuthService) verifyToken(token string, tokenType string) (authClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(a.sign(parts[0]+"."+parts[1]))) {
+		return authClaims{}, ErrInvalidAuthToken
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil || header.Algorithm != "HS256" {
+		return authClaims{}, ErrInvalidAuthToken
+	}
+	var claims authClaims
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return authClaims{}, ErrInvalidAuthToken
+	}
+	if claims.Type != tokenType {
+		return authClaims{}, ErrInvalidAuthToken
+	}
+	if a.clock.Now().Unix() >= claims.ExpiresAt {
+		return authClaims{}, ErrAuthTokenExpired
+	}
+	return claims, nil
+}
+
+func (a *AuthService) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, a.config.TokenSecret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// credential returns the credential of the user, an empty one when it has none yet
+func (a *AuthService) credential(ctx context.Context, id uuid.UUID) (entities.Credential, bool, error) {
+	credential, err := a.storage(ctx).Get(id)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return entities.Credential{Id: id}, false, nil
+	}
+	if err != nil {
+		return entities.Credential{}, false, err
+	}
+	return credential, true, nil
+}
+
+// updateCredential applies the change to the stored credential of the user, an empty one when
+// it has none yet. The change is only stored if the credential didn't change meanwhile, it is
+// applied again to the new one otherwise, so the concurrent requests don't undo each other.
+func (a *AuthService) updateCredential(ctx context.Context, id uuid.UUID, change func(*entities.Credential) error, outbox ...db.OutboxMessage) error {
+	for attempt := 0; attempt < credentialAttempts; attempt++ {
+		current, exists, err := a.credential(ctx, id)
+		if err != nil {
+			return err
+		}
+		changed := current
+		if err := change(&changed); err != nil {
+			return err
+		}
+		if !exists {
+			_, err = a.storage(ctx).Create(changed, outbox...)
+			return err
+		}
+		_, err = a.storage(ctx).Swap(id, current, changed, outbox...)
+		if !errors.Is(err, db.ErrStale) {
+			return err
+		}
+	}
+	return db.ErrStale
+}
+
+// storage returns the storage of the credentials of the tenant of the request
+func (a *AuthService) storage(ctx context.Context) db.Storage[entities.Credential] {
+	return a.credentials.For(TenantFrom(ctx))
+}
+
+// resetHash hashes the reset token with the email it was sent to, a new email invalidates it
+func resetHash(token string, email string) string {
+	sum := sha256.Sum256([]byte(email + "\n" + token))
+	return hex.EncodeToString(sum[:])
+}
+
+func decodeTokenPart(part string, v any) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
+
+func userIds(users []entities.User) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.Id)
+	}
+	return ids
+}
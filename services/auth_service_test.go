@@ -0,0 +1,453 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example/bootcamp_ex1/audit"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	testPassword    = "correct horse battery"
+	testMaxFailures = 3
+	testLockout     = 15 * time.Minute
+)
+
+// authFixture is an AuthService with the services and the storage it works with
+type authFixture struct {
+	auth        *AuthService
+	users       *UserService
+	credentials *db.Tenants[entities.Credential]
+	box         *mailbox
+	clock       *fakeClock
+}
+
+func newAuthFixture(t *testing.T) authFixture {
+	t.Helper()
+	clock := newFakeClock()
+	box := &mailbox{}
+	tenants := newTestTenants()
+	credentials := memoryTenants[entities.Credential](tenants)
+	users := NewUserService(memoryTenants(tenants, UserIndexes...), WithClock(clock), WithAuditLog(audit.NewMemorySink()),
+		WithCredentials(credentials), WithVerification(Verification{
+			Secret: []byte("test-verification-secret"),
+			TTL:    testVerifyTTL,
+			Sender: box,
+		}))
+	auth, err := NewAuthService(credentials, users, clock, AuthConfig{
+		TokenSecret:     []byte("test-auth-secret"),
+		AccessTTL:       time.Minute,
+		RefreshTTL:      time.Hour,
+		ResetTTL:        time.Hour,
+		MaxFailedLogins: testMaxFailures,
+		Lockout:         testLockout,
+		Sender:          box,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authFixture{auth: auth, users: users, credentials: credentials, box: box, clock: clock}
+}
+
+// verifiedUser creates a user with a verified email and the test password
+func (f authFixture) verifiedUser(t *testing.T, ctx context.Context, email string) uuid.UUID {
+	t.Helper()
+	id, err := f.users.Create(ctx, userRequest("Ann", email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.users.Verify(ctx, f.box.lastToken(t)); err != nil {
+		t.Fatal(err)
+	}
+	err = f.auth.ChangePassword(WithAdmin(ctx), id, entities.PasswordChangeRequest{NewPassword: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// resetToken returns the last password reset token mailed to the user
+func (f authFixture) resetToken(t *testing.T, id uuid.UUID) string {
+	t.Helper()
+	f.box.mu.Lock()
+	defer f.box.mu.Unlock()
+	for i := len(f.box.messages) - 1; i >= 0; i-- {
+		for _, word := range strings.Fields(f.box.messages[i].Body) {
+			if strings.HasPrefix(word, id.String()+".") {
+				return word
+			}
+		}
+	}
+	t.Fatal("no password reset email was sent")
+	return ""
+}
+
+func TestLoginIssuesTokensOfTheUser(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	id := f.verifiedUser(t, ctx, "ann@example.com")
+
+	tokens, err := f.auth.Login(ctx, entities.LoginRequest{Email: "ann@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := f.auth.Authenticate(ctx, tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != id {
+		t.Errorf("authenticated %s, want %s", user.Id, id)
+	}
+	if _, err := f.auth.Authenticate(ctx, tokens.RefreshToken); !errors.Is(err, ErrInvalidAuthToken) {
+		t.Errorf("refresh token as access token: got %v, want %v", err, ErrInvalidAuthToken)
+	}
+	// The tokens of a tenant don't log in another
+	if _, err := f.auth.Authenticate(WithTenant(ctx, "acme"), tokens.AccessToken); !errors.Is(err, ErrInvalidAuthToken) {
+		t.Errorf("other tenant: got %v, want %v", err, ErrInvalidAuthToken)
+	}
+	for _, req := range []entities.LoginRequest{
+		{Email: "ann@example.com", Password: "wrong password"},
+		{Email: "bob@example.com", Password: testPassword},
+	} {
+		if _, err := f.auth.Login(ctx, req); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("login %s: got %v, want %v", req.Email, err, ErrInvalidCredentials)
+		}
+	}
+}
+
+func TestLoginNeedsAVerifiedEmail(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	active := true
+	req := userRequest("Ann", "ann@example.com")
+	req.Active = &active
+	id, err := f.users.Create(WithAdmin(ctx), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.auth.ChangePassword(WithAdmin(ctx), id, entities.PasswordChangeRequest{NewPassword: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := entities.LoginRequest{Email: "ann@example.com", Password: testPassword}
+	if _, err := f.auth.Login(ctx, login); !errors.Is(err, ErrEmailUnverified) {
+		t.Errorf("login: got %v, want %v", err, ErrEmailUnverified)
+	}
+	if err := f.auth.RequestPasswordReset(ctx, entities.PasswordResetRequest{Email: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range f.box.messages {
+		if message.Subject == "Reset your password" {
+			t.Error("a password reset was sent to an unverified email")
+		}
+	}
+}
+
+func TestFailedLoginsLockTheAccount(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	f.verifiedUser(t, ctx, "ann@example.com")
+	wrong := entities.LoginRequest{Email: "ann@example.com", Password: "wrong password"}
+	right := entities.LoginRequest{Email: "ann@example.com", Password: testPassword}
+
+	// The concurrent failures are all counted
+	var wg sync.WaitGroup
+	for i := 0; i < testMaxFailures; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.auth.Login(ctx, wrong)
+		}()
+	}
+	wg.Wait()
+	if _, err := f.auth.Login(ctx, right); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("right password while locked: got %v, want %v", err, ErrAccountLocked)
+	}
+
+	f.clock.Advance(testLockout)
+	if _, err := f.auth.Login(ctx, right); err != nil {
+		t.Fatalf("login after the lockout: %v", err)
+	}
+	// The successful login starts the count again
+	for i := 0; i < testMaxFailures-1; i++ {
+		f.auth.Login(ctx, wrong)
+	}
+	if _, err := f.auth.Login(ctx, right); err != nil {
+		t.Errorf("login under the limit: %v", err)
+	}
+}
+
+func TestPasswordChangeRevokesTheRefreshTokens(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	id := f.verifiedUser(t, ctx, "ann@example.com")
+	tokens, err := f.auth.Login(ctx, entities.LoginRequest{Email: "ann@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := f.auth.Refresh(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.auth.ChangePassword(ctx, id, entities.PasswordChangeRequest{CurrentPassword: testPassword, NewPassword: "another long password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{tokens.RefreshToken, refreshed.RefreshToken} {
+		if _, err := f.auth.Refresh(ctx, token); !errors.Is(err, ErrInvalidAuthToken) {
+			t.Errorf("refresh after the change: got %v, want %v", err, ErrInvalidAuthToken)
+		}
+	}
+	f.clock.Advance(time.Hour)
+	tokens, err = f.auth.Login(ctx, entities.LoginRequest{Email: "ann@example.com", Password: "another long password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.clock.Advance(time.Hour)
+	if _, err := f.auth.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, ErrAuthTokenExpired) {
+		t.Errorf("expired refresh token: got %v, want %v", err, ErrAuthTokenExpired)
+	}
+}
+
+// staleCredentials refuses every swap, like credentials that other requests keep changing
+type staleCredentials struct {
+	db.Storage[entities.Credential]
+}
+
+func (s staleCredentials) Swap(id uuid.UUID, current entities.Credential, thing entities.Credential, outbox ...db.OutboxMessage) (entities.Credential, error) {
+	return entities.Credential{}, db.ErrStale
+}
+
+func TestPasswordChangeGivesUpWhenTheCredentialKeepsChanging(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	id := f.verifiedUser(t, ctx, "ann@example.com")
+	credentials := db.NewTenants(newTestTenants(), func(tenant string) db.Storage[entities.Credential] {
+		return staleCredentials{Storage: f.credentials.For(tenant)}
+	})
+	auth, err := NewAuthService(credentials, f.users, f.clock, AuthConfig{
+		TokenSecret: []byte("test-auth-secret"),
+		AccessTTL:   time.Minute,
+		RefreshTTL:  time.Hour,
+		ResetTTL:    time.Hour,
+		Sender:      f.box,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = auth.ChangePassword(ctx, id, entities.PasswordChangeRequest{CurrentPassword: testPassword, NewPassword: "another long password"})
+	if !errors.Is(err, db.ErrStale) {
+		t.Errorf("got %v, want %v", err, db.ErrStale)
+	}
+	if _, err := f.auth.Login(ctx, entities.LoginRequest{Email: "ann@example.com", Password: testPassword}); err != nil {
+		t.Errorf("the stale change was stored: %v", err)
+	}
+}
+
+func TestPasswordResetIsUsedOnce(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	id := f.verifiedUser(t, ctx, "ann@example.com")
+	if err := f.auth.RequestPasswordReset(ctx, entities.PasswordResetRequest{Email: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	token := f.resetToken(t, id)
+
+	// Only one of the concurrent confirmations sets its password
+	passwords := []string{"first new password", "second new password", "third new password"}
+	results := make([]error, len(passwords))
+	var wg sync.WaitGroup
+	for i, password := range passwords {
+		wg.Add(1)
+		go func(i int, password string) {
+			defer wg.Done()
+			results[i] = f.auth.ConfirmPasswordReset(ctx, entities.PasswordResetConfirmRequest{Token: token, NewPassword: password})
+		}(i, password)
+	}
+	wg.Wait()
+	set := ""
+	for i, err := range results {
+		switch {
+		case err == nil && set == "":
+			set = passwords[i]
+		case err == nil:
+			t.Errorf("the token set both %q and %q", set, passwords[i])
+		case !errors.Is(err, ErrInvalidReset):
+			t.Errorf("reused token: got %v, want %v", err, ErrInvalidReset)
+		}
+	}
+	if set == "" {
+		t.Fatal("no confirmation set the password")
+	}
+	if _, err := f.auth.Login(ctx, entities.LoginRequest{Email: "ann@example.com", Password: set}); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+}
+
+func TestPasswordResetOfAnOldEmailIsRefused(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	id := f.verifiedUser(t, ctx, "ann@example.com")
+	if err := f.auth.RequestPasswordReset(ctx, entities.PasswordResetRequest{Email: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	token := f.resetToken(t, id)
+	if _, err := f.users.Update(WithUser(ctx, id), id, userRequest("Ann", "ann.lee@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.users.Verify(ctx, f.box.lastToken(t)); err != nil {
+		t.Fatal(err)
+	}
+	err := f.auth.ConfirmPasswordReset(ctx, entities.PasswordResetConfirmRequest{Token: token, NewPassword: "another long password"})
+	if !errors.Is(err, ErrInvalidReset) {
+		t.Errorf("got %v, want %v", err, ErrInvalidReset)
+	}
+}
+
+func TestPasswordHashIsNeverSerialized(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	id := f.verifiedUser(t, ctx, "ann@example.com")
+	f.auth.Login(ctx, entities.LoginRequest{Email: "ann@example.com", Password: "wrong password"})
+	if err := f.auth.RequestPasswordReset(ctx, entities.PasswordResetRequest{Email: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	err := f.auth.ConfirmPasswordReset(ctx, entities.PasswordResetConfirmRequest{Token: f.resetToken(t, id), NewPassword: "another long password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := f.users.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := f.users.GetVersions(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userMessages, err := f.users.storage(ctx).PendingMessages(f.clock.Now(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialMessages, err := f.credentials.PendingMessages(f.clock.Now(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentialMessages) == 0 {
+		t.Fatal("the password changes were not audited")
+	}
+	for name, value := range map[string]any{
+		"user":                user,
+		"versions":            versions,
+		"user outbox":         userMessages,
+		"credential outbox":   credentialMessages,
+		"emails of the users": f.box.messages,
+	} {
+		payload, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"$argon2id$", testPassword, "another long password"} {
+			if strings.Contains(string(payload), secret) {
+				t.Errorf("the %s have %q", name, secret)
+			}
+		}
+	}
+}
+
+func TestEmailIsUniqueAmongUsersWithAPassword(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	id := f.verifiedUser(t, ctx, "ann@example.com")
+	other, err := f.users.Create(ctx, userRequest("Bob", "bob@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.users.Create(ctx, userRequest("Ann", "ann@example.com")); !errors.Is(err, ErrEmailInUse) {
+		t.Errorf("create: got %v, want %v", err, ErrEmailInUse)
+	}
+	if _, err := f.users.Update(WithAdmin(ctx), other, userRequest("Bob", "ann@example.com")); !errors.Is(err, ErrEmailInUse) {
+		t.Errorf("update: got %v, want %v", err, ErrEmailInUse)
+	}
+	// The user itself keeps its email
+	if _, err := f.users.Update(WithUser(ctx, id), id, userRequest("Annie", "ann@example.com")); err != nil {
+		t.Errorf("update of the user with the email: %v", err)
+	}
+}
+
+func TestOnlyTheUserOrAnAdminChangesTheEmail(t *testing.T) {
+	f := newAuthFixture(t)
+	ctx := context.Background()
+	id := f.verifiedUser(t, ctx, "ann@example.com")
+	other, err := f.users.Create(ctx, userRequest("Bob", "bob@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, caller := range map[string]context.Context{
+		"anonymous":  ctx,
+		"other user": WithUser(ctx, other),
+	} {
+		if _, err := f.users.Update(caller, id, userRequest("Ann", "mallory@example.com")); !errors.Is(err, ErrOwnerOnly) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrOwnerOnly)
+		}
+	}
+	// The other fields stay open
+	if _, err := f.users.Update(ctx, id, userRequest("Annie", "ann@example.com")); err != nil {
+		t.Errorf("update without the email: %v", err)
+	}
+	if _, err := f.users.Update(WithUser(ctx, id), id, userRequest("Ann", "ann.lee@example.com")); err != nil {
+		t.Errorf("update of the user: %v", err)
+	}
+}
+
+func TestAccessTokensProveTheTenantAndTheUser(t *testing.T) {
+	f := newAuthFixture(t)
+	acme := WithTenant(context.Background(), "acme")
+	id := f.verifiedUser(t, acme, "ann@example.com")
+	tokens, err := f.auth.Login(acme, entities.LoginRequest{Email: "ann@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := TenantAccess{TokenSecret: []byte(testTenantSecret), Tenants: newTestTenants(), Users: f.auth}
+
+	ctx, err := access.WithCaller(context.Background(), []string{"acme"}, tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := UserFrom(ctx); TenantFrom(ctx) != "acme" || !ok || user != id {
+		t.Errorf("got tenant %q and user %s, want acme and %s", TenantFrom(ctx), user, id)
+	}
+	if _, err := access.WithCaller(context.Background(), []string{"globex"}, tokens.AccessToken); !errors.Is(err, ErrTenantMismatch) {
+		t.Errorf("other tenant: got %v, want %v", err, ErrTenantMismatch)
+	}
+	// The refresh tokens and the tenant tokens signed with the auth secret prove nothing
+	for name, bearer := range map[string]string{
+		"refresh token":                tokens.RefreshToken,
+		"tenant token of the auth key": tenantToken("test-auth-secret", "acme"),
+	} {
+		if _, err := access.WithCaller(context.Background(), nil, bearer); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidToken)
+		}
+	}
+	// A tenant token proves no user
+	ctx, err = access.WithCaller(context.Background(), nil, tenantToken(testTenantSecret, "acme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := UserFrom(ctx); ok {
+		t.Error("a tenant token made the request as a user")
+	}
+}
@@ -0,0 +1,16 @@
+package services
+
+import "time"
+
+// Clock is the source of the timestamps set by the services, tests can replace it
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+var SystemClock Clock = systemClock{}
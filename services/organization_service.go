@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"log/slog"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrMembershipNotFound = errors.New("the user is not a member of this organization")
+)
+
+// Indexes of the memberships
+const (
+	IndexMemberUser         = "user"
+	IndexMemberOrganization = "organization"
+)
+
+// MembershipIndexes are kept by the storage of the memberships to find those of a user or an
+// organization without scanning every membership
+var MembershipIndexes = []db.Index[entities.Membership]{
+	{Name: IndexMemberUser, Keys: func(membership entities.Membership) []string {
+		return []string{membership.UserId.String()}
+	}},
+	{Name: IndexMemberOrganization, Keys: func(membership entities.Membership) []string {
+		return []string{membership.OrganizationId.String()}
+	}},
+}
+
+// NewOrganizationService returns the CRUD service of the organizations
+func NewOrganizationService(organizations *db.Tenants[entities.Organization], clock Clock) *ResourceService[entities.Organization, entities.OrganizationRequest] {
+	return NewResourceService("organization", organizations, Mapper[entities.Organization, entities.OrganizationRequest]{
+		New: func(ctx context.Context, id uuid.UUID, req entities.OrganizationRequest) (entities.Organization, error) {
+			now := clock.Now()
+			return entities.Organization{
+				Id:          id,
+				Name:        req.Name,
+				Description: req.Description,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}, nil
+		},
+		Apply: func(ctx context.Context, current entities.Organization, req entities.OrganizationRequest) (entities.Organization, error) {
+			current.Name = req.Name
+			current.Description = req.Description
+			current.UpdatedAt = clock.Now()
+			return current, nil
+		},
+	})
+}
+
+// Member is a user of an organization with its role
+type Member struct {
+	User       entities.User
+	Membership entities.Membership
+}
+
+func (m Member) GetId() uuid.UUID {
+	return m.Membership.Id
+}
+
+// MemberOf is an organization of a user with the role of the user
+type MemberOf struct {
+	Organization entities.Organization
+	Membership   entities.Membership
+}
+
+func (m MemberOf) GetId() uuid.UUID {
+	return m.Membership.Id
+}
+
+// MembershipService links the users to the organizations
+type MembershipService struct {
+	memberships   *db.Tenants[entities.Membership]
+	organizations *ResourceService[entities.Organization, entities.OrganizationRequest]
+	users         *UserService
+	clock         Clock
+}
+
+// NewMembershipService links the users and organizations of the services. The memberships of
+// the deleted organizations are removed with them, those of the purged users by HandleEvent.
+// The storages of the tenants must keep the MembershipIndexes.
+func NewMembershipService(memberships *db.Tenants[entities.Membership], organizations *ResourceService[entities.Organization, entities.OrganizationRequest], users *UserService, clock Clock) *MembershipService {
+	membershipService := &MembershipService{
+		memberships:   memberships,
+		organizations: organizations,
+		users:         users,
+		clock:         clock,
+	}
+	organizations.OnDelete(membershipService.removeOrganization)
+	return membershipService
+}
+
+// AddMember adds the user to the organization, or changes its role when it is already a member
+func (m *MembershipService) AddMember(ctx context.Context, organizationId uuid.UUID, userId uuid.UUID, role string) (entities.Membership, error) {
+	if _, err := m.organizations.Get(ctx, organizationId); err != nil {
+		return entities.Membership{}, err
+	}
+	if _, err := m.users.Get(ctx, userId); err != nil {
+		return entities.Membership{}, err
+	}
+
+	membership := entities.Membership{
+		Id:             entities.MembershipId(organizationId, userId),
+		OrganizationId: organizationId,
+		UserId:         userId,
+		Role:           role,
+		CreatedAt:      m.clock.Now(),
+	}
+	//Log action
+	slog.Info("Adding member", "organization", organizationId, "user", userId, "role", role)
+	if current, err := m.storage(ctx).Get(membership.Id); err == nil {
+		membership.CreatedAt = current.CreatedAt
+		return m.storage(ctx).Update(membership.Id, membership)
+	}
+	if _, err := m.storage(ctx).Create(membership); err != nil {
+		return entities.Membership{}, err
+	}
+	return membership, nil
+}
+
+func (m *MembershipService) RemoveMember(ctx context.Context, organizationId uuid.UUID, userId uuid.UUID) error {
+	//Log action
+	slog.Info("Removing member", "organization", organizationId, "user", userId)
+	if _, err := m.storage(ctx).Delete(entities.MembershipId(organizationId, userId)); err != nil {
+		return ErrMembershipNotFound
+	}
+	return nil
+}
+
+// Members lists the users of the organization
+func (m *MembershipService) Members(ctx context.Context, organizationId uuid.UUID) ([]Member, error) {
+	if _, err := m.organizations.Get(ctx, organizationId); err != nil {
+		return nil, err
+	}
+	memberships, err := m.find(ctx, IndexMemberOrganization, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Member, 0, len(memberships))
+	for _, membership := range memberships {
+		user, err := m.users.Get(ctx, membership.UserId)
+		if err != nil {
+			// A deleted user keeps its memberships until it is purged, for its restore
+			continue
+		}
+		members = append(members, Member{User: user, Membership: membership})
+	}
+	return members, nil
+}
+
+// Organizations lists the organizations of the user
+func (m *MembershipService) Organizations(ctx context.Context, userId uuid.UUID) ([]MemberOf, error) {
+	if _, err := m.users.Get(ctx, userId); err != nil {
+		return nil, err
+	}
+	memberships, err := m.find(ctx, IndexMemberUser, userId)
+	if err != nil {
+		return nil, err
+	}
+	organizations := make([]MemberOf, 0, len(memberships))
+	for _, membership := range memberships {
+		organization, err := m.organizations.Get(ctx, membership.OrganizationId)
+		if err != nil {
+			continue
+		}
+		organizations = append(organizations, MemberOf{Organization: organization, Membership: membership})
+	}
+	return organizations, nil
+}
+
+// HandleEvent removes the memberships of the purged users and moves those of the merged ones
+// to the survivor, it is meant to be subscribed to the events bus fed by the outbox relay so
+// no change is missed. It fails when any membership is left, the relay then retries the event.
+// The deleted users keep their memberships, they are back when the user is restored.
+func (m *MembershipService) HandleEvent(ctx context.Context, event events.Event) error {
+	ctx = WithTenant(ctx, event.Tenant)
+	switch event.Type {
+	case events.UserPurged:
+		return m.removeAll(ctx, IndexMemberUser, event.UserId)
+	case events.UserMerged:
+		if event.MergedId != nil {
+			return m.moveMemberships(ctx, *event.MergedId, event.UserId)
+		}
+	}
+	return nil
+}
+
+// moveMemberships gives the survivor of a merge the memberships of the merged user,
+// the role of the survivor is kept in the organizations both belong to
+func (m *MembershipService) moveMemberships(ctx context.Context, mergedId uuid.UUID, survivorId uuid.UUID) error {
+	memberships, err := m.find(ctx, IndexMemberUser, mergedId)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, membership := range memberships {
+		moved := membership
+		moved.Id = entities.MembershipId(membership.OrganizationId, survivorId)
+		moved.UserId = survivorId
+		if _, err := m.storage(ctx).Get(moved.Id); err != nil {
+			if _, err := m.storage(ctx).Create(moved); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if _, err := m.storage(ctx).Delete(membership.Id); err != nil && !errors.Is(err, db.ErrUserNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	slog.Info("Moved memberships of merged user", "merged", mergedId, "survivor", survivorId, "count", len(memberships))
+	return errors.Join(errs...)
+}
+
+func (m *MembershipService) removeOrganization(ctx context.Context, organizationId uuid.UUID) {
+	if err := m.removeAll(ctx, IndexMemberOrganization, organizationId); err != nil {
+		slog.Error(err.Error(), "organization", organizationId)
+	}
+}
+
+// removeAll removes the memberships of the user or organization with the id, by their index
+func (m *MembershipService) removeAll(ctx context.Context, index string, id uuid.UUID) error {
+	memberships, err := m.find(ctx, index, id)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, membership := range memberships {
+		if _, err := m.storage(ctx).Delete(membership.Id); err != nil && !errors.Is(err, db.ErrUserNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	slog.Info("Removed memberships", "count", len(memberships))
+	return errors.Join(errs...)
+}
+
+// find returns the memberships of the user or organization with the id, by their index
+func (m *MembershipService) find(ctx context.Context, index string, id uuid.UUID) ([]entities.Membership, error) {
+	return db.Collect(m.storage(ctx).FindBy(index, id.String(), db.DefaultBatchSize))
+}
+
+// storage returns the memberships of the tenant of the request
+func (m *MembershipService) storage(ctx context.Context) db.Storage[entities.Membership] {
+	return m.memberships.For(TenantFrom(ctx))
+}
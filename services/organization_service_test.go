@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMembershipsLastUntilTheUserIsPurged(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	tenants := newTestTenants()
+	users := memoryTenants(tenants, UserIndexes...)
+	userService := NewUserService(users, WithClock(clock))
+	organizations := NewOrganizationService(memoryTenants[entities.Organization](tenants), clock)
+	memberships := NewMembershipService(memoryTenants(tenants, MembershipIndexes...), organizations, userService, clock)
+	bus := events.NewBus()
+	bus.Subscribe(memberships.HandleEvent)
+	relay := events.NewRelay(users, bus, time.Minute)
+	dispatch := func() {
+		t.Helper()
+		if _, err := relay.DispatchPending(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	userId, err := userService.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	organization, err := organizations.Create(ctx, entities.OrganizationRequest{Name: "Acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memberships.AddMember(ctx, organization.Id, userId, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	membershipId := entities.MembershipId(organization.Id, userId)
+
+	// A deleted user is no longer listed but keeps its memberships for its restore
+	if _, err := userService.Delete(ctx, userId); err != nil {
+		t.Fatal(err)
+	}
+	dispatch()
+	members, err := memberships.Members(ctx, organization.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 0 {
+		t.Errorf("got %d members with the user deleted, want 0", len(members))
+	}
+	if _, err := userService.Restore(ctx, userId); err != nil {
+		t.Fatal(err)
+	}
+	dispatch()
+	memberOf, err := memberships.Organizations(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memberOf) != 1 || memberOf[0].Membership.Role != "admin" {
+		t.Errorf("got organizations %+v after the restore", memberOf)
+	}
+
+	// Purging the user removes its memberships
+	if _, err := userService.Delete(ctx, userId); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Hour)
+	purged, err := userService.Purge(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 1 {
+		t.Fatalf("purged %d users, want 1", len(purged))
+	}
+	dispatch()
+	if _, err := memberships.storage(ctx).Get(membershipId); err == nil {
+		t.Error("the membership of the purged user is still stored")
+	}
+}
+
+func TestMergedUserHandsItsMembershipsOverWithoutBeingDeleted(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	tenants := newTestTenants()
+	users := memoryTenants(tenants, UserIndexes...)
+	userService := NewUserService(users, WithClock(clock))
+	organizations := NewOrganizationService(memoryTenants[entities.Organization](tenants), clock)
+	memberships := NewMembershipService(memoryTenants(tenants, MembershipIndexes...), organizations, userService, clock)
+	bus := events.NewBus()
+	bus.Subscribe(memberships.HandleEvent)
+	dispatched := make([]events.EventType, 0)
+	bus.Subscribe(func(ctx context.Context, event events.Event) error {
+		dispatched = append(dispatched, event.Type)
+		return nil
+	})
+	relay := events.NewRelay(users, bus, time.Minute)
+
+	survivorId, err := userService.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mergedId, err := userService.Create(ctx, userRequest("Anne", "anne@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	organization, err := organizations.Create(ctx, entities.OrganizationRequest{Name: "Acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memberships.AddMember(ctx, organization.Id, mergedId, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userService.Merge(ctx, entities.MergeRequest{SurvivorId: survivorId, MergedId: mergedId}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.DispatchPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Contains(dispatched, events.UserDeleted) {
+		t.Errorf("the merge dispatched %v, want no %s", dispatched, events.UserDeleted)
+	}
+	if !slices.Contains(dispatched, events.UserMerged) {
+		t.Errorf("the merge dispatched %v, want %s", dispatched, events.UserMerged)
+	}
+	memberOf, err := memberships.Organizations(ctx, survivorId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memberOf) != 1 || memberOf[0].Membership.Role != "admin" {
+		t.Errorf("got organizations %+v of the survivor", memberOf)
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrResourceNotFound = errors.New("cannot find a record with this id")
+	ErrResourceConflict = errors.New("the request conflicts with a stored record")
+	ErrResourceInvalid  = errors.New("the request is not valid for this record")
+)
+
+// Mapper builds the entities of a resource from the requests of its api, the requests they
+// refuse are reported with an error wrapping ErrResourceInvalid
+type Mapper[T entities.StorageObject, R any] struct {
+	// New builds the entity created by the request with the given id
+	New func(ctx context.Context, id uuid.UUID, req R) (T, error)
+	// Apply returns the current entity changed by the request
+	Apply func(ctx context.Context, current T, req R) (T, error)
+}
+
+// ResourceService is the CRUD service of an entity T created and updated with requests R,
+// every tenant keeps its records in its own storage
+type ResourceService[T entities.StorageObject, R any] struct {
+	name     string
+	tenants  *db.Tenants[T]
+	mapper   Mapper[T, R]
+	onDelete []func(ctx context.Context, id uuid.UUID)
+}
+
+func NewResourceService[T entities.StorageObject, R any](name string, tenants *db.Tenants[T], mapper Mapper[T, R]) *ResourceService[T, R] {
+	return &ResourceService[T, R]{name: name, tenants: tenants, mapper: mapper}
+}
+
+// OnDelete registers a function called after every deletion, to clean up what depends on the record
+func (s *ResourceService[T, R]) OnDelete(fn func(ctx context.Context, id uuid.UUID)) {
+	s.onDelete = append(s.onDelete, fn)
+}
+
+// Name of the resource, used in the logs and the api documentation
+func (s *ResourceService[T, R]) Name() string {
+	return s.name
+}
+
+func (s *ResourceService[T, R]) Get(ctx context.Context, id uuid.UUID) (T, error) {
+	//Log action
+	slog.Info("Getting by id", "resource", s.name, "id", id)
+	thing, err := s.storage(ctx).Get(id)
+	return thing, s.notFound(err)
+}
+
+func (s *ResourceService[T, R]) Iterate(ctx context.Context, batchSize int) db.Iterator[T] {
+	//Log action
+	slog.Info("Listing", "resource", s.name, "batchSize", batchSize)
+	return s.storage(ctx).Iterate(batchSize)
+}
+
+func (s *ResourceService[T, R]) Create(ctx context.Context, req R) (T, error) {
+	var zeroValue T
+	thing, err := s.mapper.New(ctx, uuid.New(), req)
+	if err != nil {
+		return zeroValue, err
+	}
+	//Log action
+	slog.Info("Creating", "resource", s.name, "id", thing.GetId())
+	if _, err := s.storage(ctx).Create(thing); err != nil {
+		return zeroValue, err
+	}
+	return thing, nil
+}
+
+func (s *ResourceService[T, R]) Update(ctx context.Context, id uuid.UUID, req R) (T, error) {
+	current, err := s.Get(ctx, id)
+	if err != nil {
+		return current, err
+	}
+	thing, err := s.mapper.Apply(ctx, current, req)
+	if err != nil {
+		return current, err
+	}
+	//Log action
+	slog.Info("Updating", "resource", s.name, "id", id)
+	updated, err := s.storage(ctx).Update(id, thing)
+	return updated, s.notFound(err)
+}
+
+func (s *ResourceService[T, R]) Delete(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	//Log action
+	slog.Info("Deleting", "resource", s.name, "id", id)
+	id, err := s.storage(ctx).Delete(id)
+	if err != nil {
+		return uuid.Nil, s.notFound(err)
+	}
+	for _, fn := range s.onDelete {
+		fn(ctx, id)
+	}
+	return id, nil
+}
+
+// storage returns the storage of the tenant of the request
+func (s *ResourceService[T, R]) storage(ctx context.Context) db.Storage[T] {
+	return s.tenants.For(TenantFrom(ctx))
+}
+
+// notFound replaces the not found error of the storage, which names the users
+func (s *ResourceService[T, R]) notFound(err error) error {
+	if errors.Is(err, db.ErrUserNotFound) {
+		return fmt.Errorf("%s: %w", s.name, ErrResourceNotFound)
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidTenant    = errors.New("the tenant must be up to 63 lowercase letters, digits or dashes")
+	ErrTenantMismatch   = errors.New("the request names more than one tenant")
+	ErrTenantUnverified = errors.New("the tenant of the request must be proven by a tenant token or an admin key")
+	ErrInvalidToken     = errors.New("invalid bearer token")
+	ErrTokenExpired     = errors.New("the bearer token has expired")
+)
+
+// Tenant names end up in the storage keys, so they can't hold separators
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type tenantKey struct{}
+
+// WithTenant returns a context carrying the tenant the request acts on
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant the request acts on, db.DefaultTenant when it is unknown
+func TenantFrom(ctx context.Context) string {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok {
+		return db.DefaultTenant
+	}
+	return tenant
+}
+
+// ValidateTenant checks a tenant name coming from the outside
+func ValidateTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// Authenticator verifies the access tokens the users log in with, like the AuthService
+type Authenticator interface {
+	// AccessToken returns the tenant and the user of a valid access token, false for the other bearers
+	AccessToken(bearer string) (string, uuid.UUID, bool)
+}
+
+// TenantAccess decides the tenant a request acts on. A tenant named by the request, in a
+// header or a subdomain, is only trusted from the admin requests or with a tenant token of
+// the same tenant, the HS256 bearer tokens with a "tenant" claim.
+type TenantAccess struct {
+	// TokenSecret verifies the tenant tokens, tokens are ignored when empty
+	TokenSecret []byte
+	// Tenants are the tenants served, the requests for the others are refused
+	Tenants db.TenantSet
+	// Users verifies the access tokens, signed with their own secret. An access token proves
+	// the tenant it was issued for like a tenant token, and its user makes the request.
+	Users Authenticator
+}
+
+// tenantClaims are the claims of a tenant token
+type tenantClaims struct {
+	Tenant    string `json:"tenant"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// WithCaller returns the context of the request with its tenant, and with its user when the
+// bearer is an access token
+func (a TenantAccess) WithCaller(ctx context.Context, named []string, bearer string) (context.Context, error) {
+	tenant, err := a.Resolve(ctx, named, bearer)
+	if err != nil {
+		return nil, err
+	}
+	ctx = WithTenant(ctx, tenant)
+	if a.Users == nil {
+		return ctx, nil
+	}
+	// An admin can name another tenant than the one of its access token
+	if tokenTenant, user, ok := a.Users.AccessToken(bearer); ok && tokenTenant == tenant {
+		ctx = WithUser(ctx, user)
+	}
+	return ctx, nil
+}
+
+// Resolve returns the tenant of the request, db.DefaultTenant when it names none. The named
+// tenants and the tenant of the bearer token present must agree.
+func (a TenantAccess) Resolve(ctx context.Context, named []string, bearer string) (string, error) {
+	verified, err := a.tokenTenant(bearer)
+	if err != nil {
+		return "", err
+	}
+	found := make([]string, 0, len(named)+1)
+	for _, tenant := range named {
+		if tenant != "" {
+			found = append(found, tenant)
+		}
+	}
+	if verified != "" {
+		found = append(found, verified)
+	}
+
+	if len(found) == 0 {
+		return db.DefaultTenant, nil
+	}
+	for _, other := range found[1:] {
+		if other != found[0] {
+			return "", ErrTenantMismatch
+		}
+	}
+	tenant := found[0]
+	if err := ValidateTenant(tenant); err != nil {
+		return "", err
+	}
+	if tenant != verified && !IsAdmin(ctx) {
+		return "", ErrTenantUnverified
+	}
+	known, err := a.Tenants.Has(tenant)
+	if err != nil {
+		return "", err
+	}
+	if !known {
+		return "", db.ErrUnknownTenant
+	}
+	return tenant, nil
+}
+
+// tokenTenant verifies the tenant token, or the access token, and returns its tenant claim,
+// "" without a token. The bearer tokens that aren't JWTs, like the websocket api keys, prove
+// no tenant.
+func (a TenantAccess) tokenTenant(bearer string) (string, error) {
+	parts := strings.Split(bearer, ".")
+	if len(parts) != 3 {
+		return "", nil
+	}
+	if a.Users != nil {
+		if tenant, _, ok := a.Users.AccessToken(bearer); ok {
+			return tenant, nil
+		}
+	}
+	if len(a.TokenSecret) == 0 {
+		return "", nil
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil || header.Algorithm != "HS256" {
+		return "", ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, a.TokenSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", ErrInvalidToken
+	}
+
+	var claims tenantClaims
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return "", ErrInvalidToken
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return "", ErrTokenExpired
+	}
+	return claims.Tenant, nil
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"example/bootcamp_ex1/audit"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"testing"
+	"time"
+)
+
+const testTenantSecret = "test-tenant-secret"
+
+// newTestTenants is the set of the tenants of the tests, acme and globex with the default one
+func newTestTenants() db.TenantSet {
+	tenants := db.NewMemoryTenantSet()
+	tenants.Add("acme", "globex")
+	return tenants
+}
+
+// memoryTenants keeps the records of the tenants of the set in memory
+func memoryTenants[T entities.StorageObject](tenants db.TenantSet, indexes ...db.Index[T]) *db.Tenants[T] {
+	return db.NewTenants(tenants, func(tenant string) db.Storage[T] {
+		return db.NewMemoryStorage(indexes...)
+	})
+}
+
+// tenantToken signs a tenant token of the tenant
+func tenantToken(secret string, tenant string) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"tenant":"`+tenant+`"}`))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestTenantAccessTrustsOnlyTheProvenTenants(t *testing.T) {
+	access := TenantAccess{TokenSecret: []byte(testTenantSecret), Tenants: newTestTenants()}
+	user := context.Background()
+	admin := WithAdmin(context.Background())
+
+	for _, test := range []struct {
+		name   string
+		ctx    context.Context
+		named  []string
+		bearer string
+		want   string
+		err    error
+	}{
+		{name: "nothing named", ctx: user, want: db.DefaultTenant},
+		{name: "bare header", ctx: user, named: []string{"acme"}, err: ErrTenantUnverified},
+		{name: "header of an admin", ctx: admin, named: []string{"acme"}, want: "acme"},
+		{name: "header with its token", ctx: user, named: []string{"acme"}, bearer: tenantToken(testTenantSecret, "acme"), want: "acme"},
+		{name: "token alone", ctx: user, bearer: tenantToken(testTenantSecret, "globex"), want: "globex"},
+		{name: "header with another token", ctx: user, named: []string{"acme"}, bearer: tenantToken(testTenantSecret, "globex"), err: ErrTenantMismatch},
+		{name: "header with a default tenant token", ctx: user, named: []string{"acme"}, bearer: tenantToken(testTenantSecret, db.DefaultTenant), err: ErrTenantUnverified},
+		{name: "forged token", ctx: user, named: []string{"acme"}, bearer: tenantToken("other-secret", "acme"), err: ErrInvalidToken},
+		{name: "unknown tenant", ctx: admin, named: []string{"initech"}, err: db.ErrUnknownTenant},
+		{name: "invalid tenant", ctx: admin, named: []string{"Acme:users"}, err: ErrInvalidTenant},
+	} {
+		tenant, err := access.Resolve(test.ctx, test.named, test.bearer)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && tenant != test.want {
+			t.Errorf("%s: got tenant %q, want %q", test.name, tenant, test.want)
+		}
+	}
+}
+
+func TestTenantsOnlyServeTheTenantsOfTheSet(t *testing.T) {
+	u := newTestUserService()
+	ctx := WithTenant(context.Background(), "initech")
+	if _, err := u.Create(ctx, userRequest("Ann", "ann@example.com")); !errors.Is(err, db.ErrUnknownTenant) {
+		t.Errorf("create in an unknown tenant: got %v, want %v", err, db.ErrUnknownTenant)
+	}
+}
+
+func TestUsersAndTheirEventsStayInTheirTenant(t *testing.T) {
+	clock := newFakeClock()
+	tenants := newTestTenants()
+	users := memoryTenants(tenants, UserIndexes...)
+	userService := NewUserService(users, WithClock(clock))
+	webhooks := NewWebhookService(
+		memoryTenants[entities.WebhookSubscription](tenants),
+		memoryTenants(tenants, WebhookDeliveryIndexes...),
+		WithWebhookClock(clock), WithPrivateTargets(),
+	)
+	bus := events.NewBus()
+	bus.Subscribe(webhooks.HandleEvent)
+	relay := events.NewRelay(users, bus, time.Minute)
+
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+	subscriptions := make(map[string]entities.WebhookSubscription)
+	for _, ctx := range []context.Context{acme, globex, context.Background()} {
+		subscription, err := webhooks.Subscriptions().Create(ctx, entities.WebhookSubscriptionRequest{Url: "http://127.0.0.1:1/hook", Active: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subscriptions[TenantFrom(ctx)] = subscription
+	}
+	if _, err := webhooks.Subscriptions().Get(globex, subscriptions["acme"].Id); !errors.Is(err, ErrResourceNotFound) {
+		t.Errorf("globex got the subscription of acme: %v", err)
+	}
+
+	id, err := userService.Create(acme, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userService.Get(globex, id); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("globex got the user of acme: %v", err)
+	}
+	if _, err := relay.DispatchPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, ctx := range []context.Context{acme, globex, context.Background()} {
+		deliveries, err := webhooks.deliveryStorage(ctx).GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		if ctx == acme {
+			want = 1
+		}
+		if len(deliveries) != want {
+			t.Errorf("%q got %d deliveries of the acme event, want %d", TenantFrom(ctx), len(deliveries), want)
+		}
+	}
+}
+
+func TestPurgeReachesTheTenantsNotUsedSinceTheStart(t *testing.T) {
+	clock := newFakeClock()
+	tenants := newTestTenants()
+	// The storages outlive the restart of the process, like redis
+	storages := make(map[string]db.Storage[entities.User])
+	stored := func(tenant string) db.Storage[entities.User] {
+		if _, ok := storages[tenant]; !ok {
+			storages[tenant] = db.NewMemoryStorage(UserIndexes...)
+		}
+		return storages[tenant]
+	}
+
+	before := NewUserService(db.NewTenants(tenants, stored), WithClock(clock))
+	acme := WithTenant(context.Background(), "acme")
+	id, err := before.Create(acme, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := before.Delete(acme, id); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := db.NewTenants(tenants, stored)
+	pending, err := restarted.PendingMessages(clock.Now(), db.DefaultBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) == 0 {
+		t.Error("the relay of the restarted process got no message of acme")
+	}
+	clock.Advance(2 * time.Hour)
+	purged, err := NewUserService(restarted, WithClock(clock)).Purge(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 1 {
+		t.Errorf("purged %d users, want 1", len(purged))
+	}
+}
+
+func TestUsersAreIsolatedByTenant(t *testing.T) {
+	users := memoryTenants[entities.User](newTestTenants())
+	sink := audit.NewMemorySink()
+	u := NewUserService(users, WithAuditLog(sink))
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+
+	id, err := u.Create(acme, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := u.Get(globex, id); err != db.ErrUserNotFound {
+		t.Errorf("get from another tenant: got %v, want %v", err, db.ErrUserNotFound)
+	}
+	if _, err := u.Update(globex, id, userRequest("Eve", "eve@example.com")); err != db.ErrUserNotFound {
+		t.Errorf("update from another tenant: got %v, want %v", err, db.ErrUserNotFound)
+	}
+	if _, err := u.Delete(globex, id); err != db.ErrUserNotFound {
+		t.Errorf("delete from another tenant: got %v, want %v", err, db.ErrUserNotFound)
+	}
+	if list, _ := u.GetAll(globex); len(list) != 0 {
+		t.Errorf("another tenant lists %v", list)
+	}
+	if list, _ := u.GetAll(context.Background()); len(list) != 0 {
+		t.Errorf("the default tenant lists %v", list)
+	}
+	if user, err := u.Get(acme, id); err != nil || user.Name != "Ann" {
+		t.Errorf("the tenant got %+v, %v after the other tenant's attempts", user, err)
+	}
+
+	relayAudit(t, users, sink)
+	if entries, _ := u.AuditLog(globex, audit.Query{}); len(entries) != 0 {
+		t.Errorf("another tenant reads the audit entries %+v", entries)
+	}
+	if entries, _ := u.AuditLog(acme, audit.Query{}); len(entries) != 1 || entries[0].Tenant != "acme" {
+		t.Errorf("the tenant reads the audit entries %+v", entries)
+	}
+}
+
+func TestEventsCarryTheirTenant(t *testing.T) {
+	users := memoryTenants[entities.User](newTestTenants())
+	u := NewUserService(users)
+	changes := make([]events.Event, 0)
+	u.OnChange(func(ctx context.Context, event events.Event) error {
+		changes = append(changes, event)
+		return nil
+	})
+
+	u.Create(WithTenant(context.Background(), "acme"), userRequest("Ann", "ann@example.com"))
+	u.Create(WithTenant(context.Background(), "globex"), userRequest("Bea", "bea@example.com"))
+
+	if len(changes) != 2 || changes[0].Tenant != "acme" || changes[1].Tenant != "globex" {
+		t.Errorf("got the changes %+v", changes)
+	}
+	// The relay reads the outbox of every tenant
+	relayed := relayEvents(t, users)
+	tenants := make(map[string]bool)
+	for _, event := range relayed {
+		tenants[event.Tenant] = true
+	}
+	if len(relayed) != 2 || !tenants["acme"] || !tenants["globex"] {
+		t.Errorf("relayed %+v", relayed)
+	}
+}
+
+func TestTenantNamesAreValidated(t *testing.T) {
+	for _, tenant := range []string{"acme", "acme-2", "0"} {
+		if err := ValidateTenant(tenant); err != nil {
+			t.Errorf("%q: got %v", tenant, err)
+		}
+	}
+	for _, tenant := range []string{"", "Acme", "-acme", "acme:users", "acme.example", string(make([]byte, 64))} {
+		if err := ValidateTenant(tenant); err != ErrInvalidTenant {
+			t.Errorf("%q: got %v, want %v", tenant, err, ErrInvalidTenant)
+		}
+	}
+}
+
+func TestAttributeDefinitionsArePerTenant(t *testing.T) {
+	attributes := NewAttributeService(memoryTenants[entities.AttributeDefinition](newTestTenants()), newFakeClock())
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+	definition, err := attributes.Create(acme, entities.AttributeDefinitionRequest{Name: "plan", Type: entities.AttributeString})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := attributes.Get(globex, definition.Id); !errors.Is(err, ErrResourceNotFound) {
+		t.Errorf("globex got the definition of acme: %v", err)
+	}
+	// The same name is free in every tenant
+	if _, err := attributes.Create(globex, entities.AttributeDefinitionRequest{Name: "plan", Type: entities.AttributeNumber}); err != nil {
+		t.Errorf("globex can't define its own plan: %v", err)
+	}
+}
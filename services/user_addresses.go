@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"log/slog"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAddressNotFound      = errors.New("cannot find an address with this id")
+	ErrManyPrimaryAddresses = errors.New("only one address can be the primary one")
+	ErrPrimaryRequired      = errors.New("the primary address can't be unset, set another address as primary instead")
+	ErrLastAddress          = errors.New("cannot delete the only address of a user")
+)
+
+// Addresses lists the labeled addresses of the user, the primary one included
+func (u *UserService) Addresses(ctx context.Context, id uuid.UUID) ([]entities.UserAddress, error) {
+	//Log action
+	slog.Info("Listing user addresses", "id", id)
+	user, err := u.storage(ctx).Get(id)
+	if err != nil {
+		return nil, err
+	}
+	user.UpgradeAddresses()
+	return user.Addresses, nil
+}
+
+func (u *UserService) GetAddress(ctx context.Context, id uuid.UUID, addressId uuid.UUID) (entities.UserAddress, error) {
+	addresses, err := u.Addresses(ctx, id)
+	if err != nil {
+		return entities.UserAddress{}, err
+	}
+	i := indexOfAddress(addresses, addressId)
+	if i < 0 {
+		return entities.UserAddress{}, ErrAddressNotFound
+	}
+	return addresses[i], nil
+}
+
+// AddAddress adds an address to the user, the first one and the ones sent as primary become the primary address
+func (u *UserService) AddAddress(ctx context.Context, id uuid.UUID, req entities.UserAddressRequest) (entities.UserAddress, error) {
+	slog.Info("Adding user address", "id", id, "label", req.Label)
+	addressId := uuid.New()
+	var added entities.UserAddress
+	err := u.changeAddresses(ctx, id, func(addresses []entities.UserAddress) ([]entities.UserAddress, error) {
+		added = entities.UserAddress{
+			Id:      addressId,
+			Label:   req.Label,
+			Primary: req.Primary || len(addresses) == 0,
+			Address: req.Address,
+		}
+		if added.Primary {
+			unsetPrimary(addresses)
+		}
+		return append(addresses, added), nil
+	})
+	if err != nil {
+		return entities.UserAddress{}, err
+	}
+	return added, nil
+}
+
+// UpdateAddress replaces an address of the user, setting it as primary unsets the previous one
+func (u *UserService) UpdateAddress(ctx context.Context, id uuid.UUID, addressId uuid.UUID, req entities.UserAddressRequest) (entities.UserAddress, error) {
+	slog.Info("Updating user address", "id", id, "address", addressId)
+	var updated entities.UserAddress
+	err := u.changeAddresses(ctx, id, func(addresses []entities.UserAddress) ([]entities.UserAddress, error) {
+		i := indexOfAddress(addresses, addressId)
+		if i < 0 {
+			return nil, ErrAddressNotFound
+		}
+		if addresses[i].Primary && !req.Primary {
+			return nil, ErrPrimaryRequired
+		}
+		if req.Primary {
+			unsetPrimary(addresses)
+		}
+		addresses[i] = entities.UserAddress{
+			Id:      addressId,
+			Label:   req.Label,
+			Primary: req.Primary,
+			Address: req.Address,
+		}
+		updated = addresses[i]
+		return addresses, nil
+	})
+	if err != nil {
+		return entities.UserAddress{}, err
+	}
+	return updated, nil
+}
+
+// DeleteAddress removes an address of the user, the first remaining one replaces a deleted primary address
+func (u *UserService) DeleteAddress(ctx context.Context, id uuid.UUID, addressId uuid.UUID) (uuid.UUID, error) {
+	slog.Info("Deleting user address", "id", id, "address", addressId)
+	err := u.changeAddresses(ctx, id, func(addresses []entities.UserAddress) ([]entities.UserAddress, error) {
+		i := indexOfAddress(addresses, addressId)
+		if i < 0 {
+			return nil, ErrAddressNotFound
+		}
+		if len(addresses) == 1 {
+			return nil, ErrLastAddress
+		}
+		primary := addresses[i].Primary
+		addresses = slices.Delete(addresses, i, i+1)
+		if primary {
+			addresses[0].Primary = true
+		}
+		return addresses, nil
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return addressId, nil
+}
+
+// changeAddresses stores the user with the addresses returned by change, which
+// gets a copy of the current ones
+func (u *UserService) changeAddresses(ctx context.Context, id uuid.UUID, change func([]entities.UserAddress) ([]entities.UserAddress, error)) error {
+	_, err := retryStale(func() (entities.User, error) {
+		current, err := u.storage(ctx).Get(id)
+		if err != nil {
+			return entities.User{}, err
+		}
+		newUser := current
+		newUser.UpgradeAddresses()
+		addresses, err := change(slices.Clone(newUser.Addresses))
+		if err != nil {
+			return entities.User{}, err
+		}
+		newUser.SetAddresses(addresses)
+		return u.save(ctx, current, newUser)
+	})
+	return err
+}
+
+// newAddresses builds the addresses of a created user, sent as a list or as the single address
+func newAddresses(userReq entities.UserRequest) ([]entities.UserAddress, error) {
+	if len(userReq.Addresses) == 0 {
+		if userReq.Address == nil {
+			return []entities.UserAddress{}, nil
+		}
+		return []entities.UserAddress{{
+			Id:      uuid.New(),
+			Label:   entities.AddressHome,
+			Primary: true,
+			Address: *userReq.Address,
+		}}, nil
+	}
+
+	addresses := make([]entities.UserAddress, 0, len(userReq.Addresses))
+	primaries := 0
+	for _, req := range userReq.Addresses {
+		if req.Primary {
+			primaries++
+		}
+		addresses = append(addresses, entities.UserAddress{
+			Id:      uuid.New(),
+			Label:   req.Label,
+			Primary: req.Primary,
+			Address: req.Address,
+		})
+	}
+	if primaries > 1 {
+		return nil, ErrManyPrimaryAddresses
+	}
+	// Without one sent as primary the first address is
+	if primaries == 0 {
+		addresses[0].Primary = true
+	}
+	return addresses, nil
+}
+
+// updatedAddresses returns the addresses of an updated user. A list replaces
+// every address, the single address only the primary one and the rest are kept.
+func updatedAddresses(current entities.User, userReq entities.UserRequest) ([]entities.UserAddress, error) {
+	if len(userReq.Addresses) > 0 {
+		return newAddresses(userReq)
+	}
+	current.UpgradeAddresses()
+	addresses := slices.Clone(current.Addresses)
+	if userReq.Address == nil {
+		return addresses, nil
+	}
+	for i := range addresses {
+		if addresses[i].Primary {
+			addresses[i].Address = *userReq.Address
+			return addresses, nil
+		}
+	}
+	return newAddresses(userReq)
+}
+
+func indexOfAddress(addresses []entities.UserAddress, id uuid.UUID) int {
+	return slices.IndexFunc(addresses, func(address entities.UserAddress) bool {
+		return address.Id == id
+	})
+}
+
+func unsetPrimary(addresses []entities.UserAddress) {
+	for i := range addresses {
+		addresses[i].Primary = false
+	}
+}
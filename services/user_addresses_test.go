@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	rome  = entities.Address{City: "Rome", Country: "IT", AddressString: "Via 1"}
+	milan = entities.Address{City: "Milan", Country: "IT", AddressString: "Via 2"}
+	turin = entities.Address{City: "Turin", Country: "IT", AddressString: "Via 3"}
+)
+
+// primaryAddress returns the primary one of the addresses, failing unless exactly one is
+func primaryAddress(t *testing.T, user entities.User) entities.UserAddress {
+	t.Helper()
+	var primaries []entities.UserAddress
+	for _, address := range user.Addresses {
+		if address.Primary {
+			primaries = append(primaries, address)
+		}
+	}
+	if len(primaries) != 1 {
+		t.Fatalf("got the primary addresses %+v", primaries)
+	}
+	if user.Address != primaries[0].Address {
+		t.Errorf("the address %+v doesn't mirror the primary one %+v", user.Address, primaries[0].Address)
+	}
+	return primaries[0]
+}
+
+func TestASingleAddressIsThePrimaryHomeAddress(t *testing.T) {
+	u := newTestUserService()
+	ctx := context.Background()
+	id, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.AddAddress(ctx, id, entities.UserAddressRequest{Label: entities.AddressBilling, Address: milan}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The single address of an update only replaces the primary one
+	req := userRequest("Ann", "ann@example.com")
+	req.Address = &turin
+	updated, err := u.Update(ctx, id, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := primaryAddress(t, updated)
+	if primary.Label != entities.AddressHome || primary.Address != turin {
+		t.Errorf("got the primary address %+v", primary)
+	}
+	if len(updated.Addresses) != 2 {
+		t.Errorf("the update dropped an address: %+v", updated.Addresses)
+	}
+}
+
+func TestAddressesSentAsAListReplaceTheOthers(t *testing.T) {
+	u := newTestUserService()
+	ctx := context.Background()
+	req := userRequest("Ann", "ann@example.com")
+	req.Address = nil
+	req.Addresses = []entities.UserAddressRequest{
+		{Label: entities.AddressHome, Address: rome},
+		{Label: entities.AddressShipping, Address: milan},
+	}
+	id, err := u.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := u.Get(ctx, id)
+	// Without one sent as primary the first address is
+	if primary := primaryAddress(t, user); primary.Address != rome {
+		t.Errorf("got the primary address %+v", primary)
+	}
+
+	req.Addresses = []entities.UserAddressRequest{{Label: entities.AddressBilling, Primary: true, Address: turin}}
+	updated, err := u.Update(ctx, id, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.Addresses) != 1 || primaryAddress(t, updated).Address != turin {
+		t.Errorf("got the addresses %+v", updated.Addresses)
+	}
+
+	req.Addresses = []entities.UserAddressRequest{
+		{Label: entities.AddressHome, Primary: true, Address: rome},
+		{Label: entities.AddressBilling, Primary: true, Address: milan},
+	}
+	if _, err := u.Update(ctx, id, req); err != ErrManyPrimaryAddresses {
+		t.Errorf("two primary addresses: got %v, want %v", err, ErrManyPrimaryAddresses)
+	}
+}
+
+func TestAddressesKeepOnePrimaryAddress(t *testing.T) {
+	u := newTestUserService()
+	ctx := context.Background()
+	id, _ := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	user, _ := u.Get(ctx, id)
+	home := primaryAddress(t, user)
+
+	// Adding a primary address unsets the previous one
+	billing, err := u.AddAddress(ctx, id, entities.UserAddressRequest{Label: entities.AddressBilling, Primary: true, Address: milan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ = u.Get(ctx, id)
+	if primaryAddress(t, user).Id != billing.Id {
+		t.Errorf("the added address is not the primary one: %+v", user.Addresses)
+	}
+
+	if _, err := u.UpdateAddress(ctx, id, billing.Id, entities.UserAddressRequest{Label: entities.AddressBilling, Address: milan}); err != ErrPrimaryRequired {
+		t.Errorf("unsetting the primary address: got %v, want %v", err, ErrPrimaryRequired)
+	}
+	if _, err := u.UpdateAddress(ctx, id, uuid.New(), entities.UserAddressRequest{Label: entities.AddressHome, Address: rome}); err != ErrAddressNotFound {
+		t.Errorf("updating an unknown address: got %v, want %v", err, ErrAddressNotFound)
+	}
+
+	// The first remaining address replaces a deleted primary address
+	if _, err := u.DeleteAddress(ctx, id, billing.Id); err != nil {
+		t.Fatal(err)
+	}
+	user, _ = u.Get(ctx, id)
+	if primaryAddress(t, user).Id != home.Id {
+		t.Errorf("the remaining address is not the primary one: %+v", user.Addresses)
+	}
+	if _, err := u.DeleteAddress(ctx, id, home.Id); err != ErrLastAddress {
+		t.Errorf("deleting the last address: got %v, want %v", err, ErrLastAddress)
+	}
+}
+
+func TestRestoreUpgradesTheAddressesOfOldUsers(t *testing.T) {
+	users := memoryTenants[entities.User](newTestTenants())
+	u := NewUserService(users)
+	ctx := context.Background()
+	// A user deleted before the labeled addresses, so the migration skipped it
+	storage := users.For(db.DefaultTenant)
+	id, err := storage.Create(entities.User{Id: uuid.New(), Name: "Ann", LastName: "Lee", Email: "ann@example.com", Address: rome})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.SoftDelete(id, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := u.Restore(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := uuid.NewSHA1(id, []byte(entities.AddressHome))
+	if primary := primaryAddress(t, restored); primary.Id != want || primary.Address != rome {
+		t.Errorf("got the primary address %+v", primary)
+	}
+	if stored, _ := u.Get(ctx, id); len(stored.Addresses) != 1 {
+		t.Errorf("stored the addresses %+v", stored.Addresses)
+	}
+	// The upgrade is stored by the restore itself, its version has the addresses
+	versions, err := u.GetVersions(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || len(versions[2].Record.Addresses) != 1 {
+		t.Errorf("got the versions %+v, want the create, the delete and the upgraded restore", versions)
+	}
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/search"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reasons a pair of users is a possible duplicate
+const (
+	DuplicateEmail   = "email"
+	DuplicateName    = "name"
+	DuplicateAddress = "address"
+)
+
+// Weights of the similarities in the score of a pair, they add up to 1
+const (
+	emailSimilarity   = 0.45
+	nameSimilarity    = 0.4
+	addressSimilarity = 0.15
+)
+
+// Pairs scoring less are not reported
+const MinDuplicateScore = 0.5
+
+// Users sharing a blocking key are compared, keys shared by more users, like a common
+// first name, are skipped so a scan never compares every user with every other one
+const maxBlockSize = 200
+
+// DuplicatePair is two users that are probably the same person, First has the lowest id
+type DuplicatePair struct {
+	First   entities.User
+	Second  entities.User
+	Score   float64
+	Reasons []string
+}
+
+// GetId is the same for the same two users on every scan
+func (d DuplicatePair) GetId() uuid.UUID {
+	return uuid.NewSHA1(d.First.Id, d.Second.Id[:])
+}
+
+// DuplicateReport is the result of the last duplicate scan of a tenant
+type DuplicateReport struct {
+	ScannedAt time.Time
+	Pairs     []DuplicatePair
+}
+
+// duplicateReports keeps the last report of every tenant
+type duplicateReports struct {
+	mu      sync.Mutex
+	reports map[string]DuplicateReport
+}
+
+func newDuplicateReports() *duplicateReports {
+	return &duplicateReports{reports: make(map[string]DuplicateReport)}
+}
+
+func (d *duplicateReports) get(tenant string) (DuplicateReport, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	report, ok := d.reports[tenant]
+	return report, ok
+}
+
+func (d *duplicateReports) set(tenant string, report DuplicateReport) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reports[tenant] = report
+}
+
+func (d *duplicateReports) forget(tenant string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.reports, tenant)
+}
+
+// Duplicates returns the possible duplicates found by the last scan of the tenant, scanning
+// when there is none yet or refresh is set. The users are read again, so the pairs have their
+// current fields and the ones with a deleted user are left out.
+func (u *UserService) Duplicates(ctx context.Context, refresh bool) (DuplicateReport, error) {
+	//Log action
+	slog.Info("Listing duplicate users", "refresh", refresh)
+	tenant := TenantFrom(ctx)
+	report, ok := u.duplicates.get(tenant)
+	if !ok || refresh {
+		var err error
+		if report, err = u.scanDuplicates(tenant, u.storage(ctx)); err != nil {
+			return DuplicateReport{}, err
+		}
+	}
+
+	current := DuplicateReport{ScannedAt: report.ScannedAt, Pairs: make([]DuplicatePair, 0, len(report.Pairs))}
+	for _, pair := range report.Pairs {
+		first, err := u.storage(ctx).Get(pair.First.Id)
+		if err != nil {
+			continue
+		}
+		second, err := u.storage(ctx).Get(pair.Second.Id)
+		if err != nil {
+			continue
+		}
+		pair.First, pair.Second = first, second
+		current.Pairs = append(current.Pairs, pair)
+	}
+	return current, nil
+}
+
+// ScanDuplicates scans the users of every tenant for duplicates
+func (u *UserService) ScanDuplicates() error {
+	return u.tenants.Each(func(tenant string, storage db.Storage[entities.User]) error {
+		_, err := u.scanDuplicates(tenant, storage)
+		return err
+	})
+}
+
+// StartDuplicateScan scans for duplicates every interval until the context is done
+func (u *UserService) StartDuplicateScan(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := u.ScanDuplicates(); err != nil {
+					slog.Error(err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// scanDuplicates scores the users sharing a normalized email, a first name or the start
+// of a last name, and keeps the pairs scoring at least MinDuplicateScore, best first
+func (u *UserService) scanDuplicates(tenant string, storage db.Storage[entities.User]) (DuplicateReport, error) {
+	users, err := storage.GetAll()
+	if err != nil {
+		return DuplicateReport{}, err
+	}
+	blocks := make(map[string][]entities.User)
+	for _, user := range users {
+		for _, key := range blockingKeys(user) {
+			blocks[key] = append(blocks[key], user)
+		}
+	}
+
+	pairs := make([]DuplicatePair, 0)
+	compared := make(map[uuid.UUID]bool)
+	for _, block := range blocks {
+		if len(block) > maxBlockSize {
+			continue
+		}
+		for i := range block {
+			for j := i + 1; j < len(block); j++ {
+				pair := newDuplicatePair(block[i], block[j])
+				if compared[pair.GetId()] {
+					continue
+				}
+				compared[pair.GetId()] = true
+				if pair.Score >= MinDuplicateScore {
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		return pairs[i].GetId().String() < pairs[j].GetId().String()
+	})
+
+	report := DuplicateReport{ScannedAt: u.clock.Now(), Pairs: pairs}
+	u.duplicates.set(tenant, report)
+	slog.Info("Scanned duplicate users", "tenant", tenant, "users", len(users), "pairs", len(pairs))
+	return report, nil
+}
+
+func blockingKeys(user entities.User) []string {
+	keys := []string{"email:" + NormalizeEmail(user.Email)}
+	if name := foldedText(user.Name); name != "" {
+		keys = append(keys, "name:"+name)
+	}
+	if lastName := []rune(foldedText(user.LastName)); len(lastName) > 0 {
+		keys = append(keys, "lastname:"+string(lastName[:min(3, len(lastName))]))
+	}
+	return keys
+}
+
+// newDuplicatePair scores how likely two users are the same person
+func newDuplicatePair(a entities.User, b entities.User) DuplicatePair {
+	if b.Id.String() < a.Id.String() {
+		a, b = b, a
+	}
+	pair := DuplicatePair{First: a, Second: b, Reasons: make([]string, 0)}
+	if NormalizeEmail(a.Email) == NormalizeEmail(b.Email) {
+		pair.Score += emailSimilarity
+		pair.Reasons = append(pair.Reasons, DuplicateEmail)
+	}
+	names := similarity(foldedText(a.Name+" "+a.LastName), foldedText(b.Name+" "+b.LastName))
+	pair.Score += nameSimilarity * names
+	if names >= 0.8 {
+		pair.Reasons = append(pair.Reasons, DuplicateName)
+	}
+	addresses := addressMatch(a, b)
+	pair.Score += addressSimilarity * addresses
+	if addresses == 1 {
+		pair.Reasons = append(pair.Reasons, DuplicateAddress)
+	}
+	return pair
+}
+
+// NormalizeEmail lowercases the email and removes the +suffix of its local part,
+// so "Ann+news@Example.com" and "ann@example.com" are the same
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, domain, found := strings.Cut(email, "@")
+	if !found {
+		return email
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return local + "@" + domain
+}
+
+// addressMatch is 1 when the users share an address, 0.5 when they only share a city
+func addressMatch(a entities.User, b entities.User) float64 {
+	best := 0.0
+	for _, x := range a.Addresses {
+		for _, y := range b.Addresses {
+			if foldedText(x.City) != foldedText(y.City) || foldedText(x.Country) != foldedText(y.Country) {
+				continue
+			}
+			if foldedText(x.AddressString) == foldedText(y.AddressString) {
+				return 1
+			}
+			best = 0.5
+		}
+	}
+	return best
+}
+
+// foldedText is the text lowercased, without accents and with its words separated by one space
+func foldedText(text string) string {
+	return strings.Join(search.Tokenize(text), " ")
+}
+
+// similarity is 1 minus the edit distance of the texts relative to the longest one
+func similarity(a string, b string) float64 {
+	x, y := []rune(a), []rune(b)
+	if len(x) == 0 && len(y) == 0 {
+		return 1
+	}
+	return 1 - float64(editDistance(x, y))/float64(max(len(x), len(y)))
+}
+
+// editDistance is the Levenshtein distance of the texts
+func editDistance(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
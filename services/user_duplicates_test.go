@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNormalizeEmailDropsTheCaseAndSuffix(t *testing.T) {
+	tests := map[string]string{
+		"Ann+news@Example.com": "ann@example.com",
+		" ann@example.com ":    "ann@example.com",
+		"ann":                  "ann",
+	}
+	for email, want := range tests {
+		if got := NormalizeEmail(email); got != want {
+			t.Errorf("%q: got %q, want %q", email, got, want)
+		}
+	}
+}
+
+func TestDuplicatesScoresThePairs(t *testing.T) {
+	u := newTestUserService()
+	ctx := context.Background()
+	create := func(req entities.UserRequest) uuid.UUID {
+		id, err := u.Create(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	ann := create(userRequest("Ann", "ann@example.com"))
+	// Same normalized email, an accent and the same address
+	anna := create(userRequest("Ánn", "Ann+news@example.com"))
+	// Same last name and city only
+	other := userRequest("Zoe", "zoe@example.com")
+	other.Address = &entities.Address{City: "Rome", Country: "IT", AddressString: "Via 9"}
+	create(other)
+
+	report, err := u.Duplicates(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Pairs) != 1 {
+		t.Fatalf("got the pairs %+v", report.Pairs)
+	}
+	pair := report.Pairs[0]
+	ids := map[uuid.UUID]bool{pair.First.Id: true, pair.Second.Id: true}
+	if !ids[ann] || !ids[anna] || pair.Score != 1 {
+		t.Errorf("got the pair %+v", pair)
+	}
+	if want := []string{DuplicateEmail, DuplicateName, DuplicateAddress}; !reflect.DeepEqual(pair.Reasons, want) {
+		t.Errorf("got the reasons %v, want %v", pair.Reasons, want)
+	}
+
+	// The report has the current users, without the deleted ones
+	if _, err := u.Delete(ctx, anna); err != nil {
+		t.Fatal(err)
+	}
+	if report, _ := u.Duplicates(ctx, false); len(report.Pairs) != 0 {
+		t.Errorf("got a pair with a deleted user: %+v", report.Pairs)
+	}
+}
+
+func TestMergeCombinesTheUsers(t *testing.T) {
+	u := newTestUserService(WithAttributes(newTestAttributes(t)))
+	ctx := WithAdmin(context.Background())
+	survivorReq := userRequest("Ann", "ann@example.com")
+	survivorReq.Tags = []string{"staff"}
+	survivorReq.Attributes = entities.Attributes{"team": "core", "admin": false}
+	survivor, _ := u.Create(ctx, survivorReq)
+	mergedReq := userRequest("Anna", "anna@example.com")
+	active := true
+	mergedReq.Active = &active
+	mergedReq.Addresses = []entities.UserAddressRequest{
+		// The same address as the survivor's is kept once
+		{Label: entities.AddressHome, Address: rome},
+		{Label: entities.AddressBilling, Address: milan},
+	}
+	mergedReq.Tags = []string{"admin", "staff"}
+	mergedReq.Attributes = entities.Attributes{"team": "web", "age": 30, "admin": true}
+	merged, _ := u.Create(ctx, mergedReq)
+	changes := make([]events.EventType, 0)
+	u.OnChange(func(ctx context.Context, event events.Event) error {
+		changes = append(changes, event.Type)
+		return nil
+	})
+
+	user, err := u.Merge(ctx, entities.MergeRequest{
+		SurvivorId:     survivor,
+		MergedId:       merged,
+		TakeFromMerged: []string{entities.MergeActive, entities.MergeAttributes},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != survivor || user.Name != "Ann" || user.Email != "ann@example.com" || !user.Active {
+		t.Errorf("got the fields of %+v", user)
+	}
+	if len(user.Addresses) != 2 || primaryAddress(t, user).Address != rome {
+		t.Errorf("got the addresses %+v", user.Addresses)
+	}
+	if !reflect.DeepEqual(user.Tags, []string{"admin", "staff"}) {
+		t.Errorf("got the tags %v", user.Tags)
+	}
+	if want := (entities.Attributes{"team": "web", "age": float64(30), "admin": true}); !reflect.DeepEqual(user.Attributes, want) {
+		t.Errorf("got the attributes %v, want %v", user.Attributes, want)
+	}
+	if _, err := u.Get(ctx, merged); err != db.ErrUserNotFound {
+		t.Errorf("the merged user is not deleted: %v", err)
+	}
+	// The merged user is soft deleted, it can be restored until it is purged
+	if deleted, _ := u.GetDeleted(ctx); len(deleted) != 1 || deleted[0].Id != merged {
+		t.Errorf("got the deleted users %+v, want the merged one", deleted)
+	}
+	for _, change := range changes {
+		if change == events.UserDeleted {
+			t.Errorf("the merge published %v", changes)
+		}
+	}
+
+	if _, err := u.Merge(ctx, entities.MergeRequest{SurvivorId: survivor, MergedId: survivor}); err != ErrMergeSameUser {
+		t.Errorf("merging a user into itself: got %v, want %v", err, ErrMergeSameUser)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Indexes of the user storages
+const (
+	IndexTag       = "tag"
+	IndexAttribute = "attribute"
+	IndexActive    = "active"
+	IndexCountry   = "country"
+	// Renamed from "city" when its keys became JSON pairs, so the storages count them again
+	IndexCity  = "country_city"
+	IndexEmail = "email"
+)
+
+// UserIndexes are kept by the storages of the users to find them by tag, attribute value and
+// email, and to count them by activation and by the country and city of their primary address
+var UserIndexes = []db.Index[entities.User]{
+	{Name: IndexTag, Keys: func(user entities.User) []string {
+		return user.Tags
+	}},
+	{Name: IndexAttribute, Keys: func(user entities.User) []string {
+		keys := make([]string, 0, len(user.Attributes))
+		for name, value := range user.Attributes {
+			keys = append(keys, AttributeKey(name, entities.AttributeText(value)))
+		}
+		return keys
+	}},
+	{Name: IndexActive, Counted: true, Keys: func(user entities.User) []string {
+		return []string{strconv.FormatBool(user.Active)}
+	}},
+	{Name: IndexCountry, Counted: true, Keys: func(user entities.User) []string {
+		return []string{user.Address.Country}
+	}},
+	{Name: IndexCity, Counted: true, Keys: func(user entities.User) []string {
+		return []string{CityKey(user.Address.Country, user.Address.City)}
+	}},
+	{Name: IndexEmail, Keys: func(user entities.User) []string {
+		return []string{EmailKey(user.Email)}
+	}},
+}
+
+// EmailKey is the key of an email in the IndexEmail index, emails differing only in case are the same
+func EmailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CityKey is the key of a city in the IndexCity index, cities are counted per country.
+// The pair is JSON encoded so any character of the country or the city is kept apart.
+func CityKey(country string, city string) string {
+	key, _ := json.Marshal([2]string{country, city})
+	return string(key)
+}
+
+// cityFromKey splits a key of the IndexCity index back into its country and city
+func cityFromKey(key string) (string, string, error) {
+	var pair [2]string
+	if err := json.Unmarshal([]byte(key), &pair); err != nil {
+		return "", "", fmt.Errorf("city key %q: %w", key, err)
+	}
+	return pair[0], pair[1], nil
+}
+
+// FindByEmail returns the users having the email, ignoring its case
+func (u *UserService) FindByEmail(ctx context.Context, email string) ([]entities.User, error) {
+	return db.Collect(u.storage(ctx).FindBy(IndexEmail, EmailKey(email), db.DefaultBatchSize))
+}
+
+// checkEmailFree fails when a user other than the ones excepted has the email and a password
+func (u *UserService) checkEmailFree(ctx context.Context, email string, except ...uuid.UUID) error {
+	if u.credentials == nil {
+		return nil
+	}
+	return emailFree(ctx, u, u.credentials.For(TenantFrom(ctx)), email, except...)
+}
+
+// emailFree fails when a user other than the ones excepted has the email and a password
+func emailFree(ctx context.Context, users *UserService, credentials db.Storage[entities.Credential], email string, except ...uuid.UUID) error {
+	found, err := users.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	for _, other := range found {
+		if slices.Contains(except, other.Id) {
+			continue
+		}
+		credential, err := credentials.Get(other.Id)
+		if errors.Is(err, db.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if credential.PasswordHash != "" {
+			return ErrEmailInUse
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/audit"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"log/slog"
+	"slices"
+)
+
+var (
+	ErrMergeSameUser = errors.New("a user can't be merged into itself")
+)
+
+// Merge combines the merged user into the survivor field by field and deletes the merged user.
+// The survivor gets a UserMerged event and a merge audit entry. The two users are stored one
+// after the other, a failing deletion leaves the survivor merged and the merged user in place.
+func (u *UserService) Merge(ctx context.Context, req entities.MergeRequest) (entities.User, error) {
+	slog.Info("Merging users", "survivor", req.SurvivorId, "merged", req.MergedId, "actor", ActorFrom(ctx))
+	if req.SurvivorId == req.MergedId {
+		return entities.User{}, ErrMergeSameUser
+	}
+	var merged entities.User
+	updated, err := retryStale(func() (entities.User, error) {
+		var survivor entities.User
+		var err error
+		survivor, merged, err = u.readMerge(ctx, req)
+		if err != nil {
+			return entities.User{}, err
+		}
+		return u.mergeInto(ctx, survivor, merged, req.TakeFromMerged)
+	})
+	if err != nil {
+		return entities.User{}, err
+	}
+	if _, err := u.softDelete(ctx, merged, audit.OperationMerge); err != nil {
+		return entities.User{}, err
+	}
+	// The pairs of the last scan have the merged user
+	u.duplicates.forget(TenantFrom(ctx))
+	return updated, nil
+}
+
+// readMerge reads the survivor and the merged user of the request
+func (u *UserService) readMerge(ctx context.Context, req entities.MergeRequest) (entities.User, entities.User, error) {
+	survivor, err := u.storage(ctx).Get(req.SurvivorId)
+	if err != nil {
+		return entities.User{}, entities.User{}, err
+	}
+	merged, err := u.storage(ctx).Get(req.MergedId)
+	if err != nil {
+		return entities.User{}, entities.User{}, err
+	}
+	return survivor, merged, nil
+}
+
+// mergeInto stores the survivor combined with the merged user, with its UserMerged event
+func (u *UserService) mergeInto(ctx context.Context, survivor entities.User, merged entities.User, fromMerged []string) (entities.User, error) {
+	newUser := mergeUsers(survivor, merged, fromMerged)
+	if err := requireAdminToActivate(ctx, survivor.Active, newUser.Active); err != nil {
+		return entities.User{}, err
+	}
+	if newUser.Email != survivor.Email {
+		if err := requireOwnerOrAdmin(ctx, survivor.Id); err != nil {
+			return entities.User{}, err
+		}
+	}
+	// The merged user goes away, its email is free for the survivor
+	if err := u.checkEmailFree(ctx, newUser.Email, survivor.Id, merged.Id); err != nil {
+		return entities.User{}, err
+	}
+	newUser.UpdatedAt = u.clock.Now()
+	newUser.UpdatedBy = ActorFrom(ctx)
+	event := u.newEvents(ctx, newUser.UpdatedAt, newUser, events.UserMerged)[0]
+	event.MergedId = &merged.Id
+	return u.store(ctx, survivor, newUser, audit.OperationMerge, event)
+}
+
+// mergeUsers returns the survivor with the fields of the merged user it lacks, or listed in fromMerged
+func mergeUsers(survivor entities.User, merged entities.User, fromMerged []string) entities.User {
+	take := func(field string, survivorValue string, mergedValue string) string {
+		if survivorValue == "" || slices.Contains(fromMerged, field) {
+			return mergedValue
+		}
+		return survivorValue
+	}
+	newUser := survivor
+	newUser.Name = take(entities.MergeName, survivor.Name, merged.Name)
+	newUser.LastName = take(entities.MergeLastName, survivor.LastName, merged.LastName)
+	newUser.Email = take(entities.MergeEmail, survivor.Email, merged.Email)
+	if newUser.Email != survivor.Email {
+		newUser.EmailVerifiedAt = merged.EmailVerifiedAt
+	}
+	if slices.Contains(fromMerged, entities.MergeActive) {
+		newUser.Active = merged.Active
+	}
+	newUser.SetAddresses(mergeAddresses(survivor, merged, slices.Contains(fromMerged, entities.MergeAddress)))
+
+	newUser.Attributes = nil
+	if len(survivor.Attributes)+len(merged.Attributes) > 0 {
+		newUser.Attributes = make(entities.Attributes, len(survivor.Attributes)+len(merged.Attributes))
+	}
+	for name, value := range merged.Attributes {
+		newUser.Attributes[name] = value
+	}
+	for name, value := range survivor.Attributes {
+		if _, ok := merged.Attributes[name]; !ok || !slices.Contains(fromMerged, entities.MergeAttributes) {
+			newUser.Attributes[name] = value
+		}
+	}
+	newUser.Tags = entities.NormalizeTags(append(slices.Clone(survivor.Tags), merged.Tags...))
+	return newUser
+}
+
+// mergeAddresses keeps the addresses of both users once, the primary one is the one of
+// the survivor unless mergedPrimary is set
+func mergeAddresses(survivor entities.User, merged entities.User, mergedPrimary bool) []entities.UserAddress {
+	survivor.UpgradeAddresses()
+	merged.UpgradeAddresses()
+	addresses := slices.Clone(survivor.Addresses)
+	if mergedPrimary {
+		unsetPrimary(addresses)
+	}
+	for _, address := range merged.Addresses {
+		i := slices.IndexFunc(addresses, func(kept entities.UserAddress) bool {
+			return foldedText(kept.City) == foldedText(address.City) &&
+				foldedText(kept.Country) == foldedText(address.Country) &&
+				foldedText(kept.AddressString) == foldedText(address.AddressString)
+		})
+		if i >= 0 {
+			addresses[i].Primary = addresses[i].Primary || (mergedPrimary && address.Primary)
+			continue
+		}
+		address.Primary = mergedPrimary && address.Primary
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidSortField = errors.New("users cannot be sorted by this field")
+)
+
+const (
+	SortByCreatedAt = "created_at"
+	SortByUpdatedAt = "updated_at"
+	SortByName      = "name"
+	SortByLastName  = "lastname"
+	SortByEmail     = "email"
+)
+
+// SortFields lists the fields accepted by ListQuery.Sort
+var SortFields = []string{SortByCreatedAt, SortByUpdatedAt, SortByName, SortByLastName, SortByEmail}
+
+// ListQuery filters and sorts the listed users, zero values don't filter
+type ListQuery struct {
+	Sort          string
+	Descending    bool
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	CreatedBy     string
+	UpdatedBy     string
+	// The users have every tag and every attribute with the value, in its text form
+	Tags       []string
+	Attributes map[string]string
+}
+
+// Validate checks the sort field of the query
+func (q ListQuery) Validate() error {
+	if q.Sort == "" {
+		return nil
+	}
+	for _, field := range SortFields {
+		if q.Sort == field {
+			return nil
+		}
+	}
+	return ErrInvalidSortField
+}
+
+func (q ListQuery) Matches(user entities.User) bool {
+	if !q.CreatedAfter.IsZero() && !user.CreatedAt.After(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !user.CreatedAt.Before(q.CreatedBefore) {
+		return false
+	}
+	if !q.UpdatedAfter.IsZero() && !user.UpdatedAt.After(q.UpdatedAfter) {
+		return false
+	}
+	if !q.UpdatedBefore.IsZero() && !user.UpdatedAt.Before(q.UpdatedBefore) {
+		return false
+	}
+	if q.CreatedBy != "" && user.CreatedBy != q.CreatedBy {
+		return false
+	}
+	if q.UpdatedBy != "" && user.UpdatedBy != q.UpdatedBy {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.Contains(user.Tags, tag) {
+			return false
+		}
+	}
+	for name, value := range q.Attributes {
+		attribute, ok := user.Attributes[name]
+		if !ok || entities.AttributeText(attribute) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (q ListQuery) sortUsers(users []entities.User) {
+	less := func(a entities.User, b entities.User) bool {
+		switch q.Sort {
+		case SortByCreatedAt:
+			return a.CreatedAt.Before(b.CreatedAt)
+		case SortByUpdatedAt:
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		case SortByName:
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		case SortByLastName:
+			return strings.ToLower(a.LastName) < strings.ToLower(b.LastName)
+		case SortByEmail:
+			return strings.ToLower(a.Email) < strings.ToLower(b.Email)
+		}
+		return false
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		if q.Descending {
+			return less(users[j], users[i])
+		}
+		return less(users[i], users[j])
+	})
+}
+
+// SortKey is the position of a user in the order of a query, the id breaks the ties so
+// every user has its own position. Pages start after the key of the last user of the
+// previous one, which stays valid when users are added or removed.
+type SortKey struct {
+	Value string    `json:"v"`
+	Id    uuid.UUID `json:"id"`
+}
+
+// sortTime formats the times so their text sorts like the times
+const sortTime = "2006-01-02T15:04:05.000000000"
+
+// SortKey returns the position of the user in the order of the query
+func (q ListQuery) SortKey(user entities.User) SortKey {
+	key := SortKey{Id: user.Id}
+	switch q.Sort {
+	case SortByCreatedAt:
+		key.Value = user.CreatedAt.UTC().Format(sortTime)
+	case SortByUpdatedAt:
+		key.Value = user.UpdatedAt.UTC().Format(sortTime)
+	case SortByName:
+		key.Value = strings.ToLower(user.Name)
+	case SortByLastName:
+		key.Value = strings.ToLower(user.LastName)
+	case SortByEmail:
+		key.Value = strings.ToLower(user.Email)
+	}
+	return key
+}
+
+// compareKeys orders the keys like the query orders the users
+func (q ListQuery) compareKeys(a SortKey, b SortKey) int {
+	order := strings.Compare(a.Value, b.Value)
+	if order == 0 {
+		order = strings.Compare(a.Id.String(), b.Id.String())
+	}
+	if q.Descending {
+		return -order
+	}
+	return order
+}
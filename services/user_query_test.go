@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestUserService(opts ...UserServiceOption) *UserService {
+	return NewUserService(memoryTenants(newTestTenants(), UserIndexes...), opts...)
+}
+
+func userRequest(name string, email string) entities.UserRequest {
+	return entities.UserRequest{
+		Name:     name,
+		LastName: "Lee",
+		Email:    email,
+		Address:  &entities.Address{City: "Rome", Country: "IT", AddressString: "Via 1"},
+	}
+}
+
+func TestUpdateKeepsTheCreationMetadata(t *testing.T) {
+	clock := newFakeClock()
+	u := newTestUserService(WithClock(clock))
+	created := clock.Now()
+
+	id, err := u.Create(WithActor(context.Background(), "alice"), userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	updated, err := u.Update(WithActor(context.Background(), "bob"), id, userRequest("Anna", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !updated.CreatedAt.Equal(created) || updated.CreatedBy != "alice" {
+		t.Errorf("got the creation %s by %q, want %s by alice", updated.CreatedAt, updated.CreatedBy, created)
+	}
+	if !updated.UpdatedAt.Equal(clock.Now()) || updated.UpdatedBy != "bob" {
+		t.Errorf("got the update %s by %q, want %s by bob", updated.UpdatedAt, updated.UpdatedBy, clock.Now())
+	}
+	if stored, _ := u.Get(context.Background(), id); !reflect.DeepEqual(stored, updated) {
+		t.Errorf("stored %+v, want %+v", stored, updated)
+	}
+}
+
+// racingUsers tags the user before the first races swaps, like other requests storing it
+// between the read and the write of a change
+type racingUsers struct {
+	db.Storage[entities.User]
+	races int
+}
+
+func (r *racingUsers) Swap(id uuid.UUID, current entities.User, thing entities.User, outbox ...db.OutboxMessage) (entities.User, error) {
+	if r.races > 0 {
+		r.races--
+		tagged := current
+		tagged.Tags = append(slices.Clone(current.Tags), fmt.Sprintf("race%d", r.races))
+		if _, err := r.Storage.Update(id, tagged); err != nil {
+			return entities.User{}, err
+		}
+	}
+	return r.Storage.Swap(id, current, thing, outbox...)
+}
+
+func newRacingUserService(races int) (*UserService, *racingUsers) {
+	storage := &racingUsers{Storage: db.NewMemoryStorage(UserIndexes...)}
+	users := db.NewTenants(newTestTenants(), func(tenant string) db.Storage[entities.User] {
+		return storage
+	})
+	return NewUserService(users), storage
+}
+
+func TestUpdateKeepsTheChangesStoredMeanwhile(t *testing.T) {
+	u, storage := newRacingUserService(0)
+	id, err := u.Create(context.Background(), userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.races = 2
+
+	updated, err := u.Update(context.Background(), id, userRequest("Anna", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "Anna" || !reflect.DeepEqual(updated.Tags, []string{"race1", "race0"}) {
+		t.Errorf("got %q tagged %v, want the update with both tags stored meanwhile", updated.Name, updated.Tags)
+	}
+}
+
+func TestUpdateGivesUpWhenTheUserKeepsChanging(t *testing.T) {
+	u, storage := newRacingUserService(0)
+	id, err := u.Create(context.Background(), userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.races = storeAttempts
+
+	if _, err := u.Update(context.Background(), id, userRequest("Anna", "ann@example.com")); !errors.Is(err, db.ErrStale) {
+		t.Errorf("got %v, want %v", err, db.ErrStale)
+	}
+	if stored, _ := u.Get(context.Background(), id); stored.Name != "Ann" {
+		t.Errorf("the stale update was stored: %+v", stored)
+	}
+}
+
+func TestUsersWithoutActorAreAnonymous(t *testing.T) {
+	u := newTestUserService()
+	id, err := u.Create(context.Background(), userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := u.Get(context.Background(), id)
+	if user.CreatedBy != AnonymousActor || user.UpdatedBy != AnonymousActor {
+		t.Errorf("got the actors %q and %q, want %q", user.CreatedBy, user.UpdatedBy, AnonymousActor)
+	}
+}
+
+func TestListFiltersAndSortsTheUsers(t *testing.T) {
+	clock := newFakeClock()
+	u := newTestUserService(WithClock(clock))
+	start := clock.Now()
+	for _, user := range []struct {
+		name  string
+		actor string
+	}{{"carl", "alice"}, {"ann", "bob"}, {"Bea", "alice"}} {
+		if _, err := u.Create(WithActor(context.Background(), user.actor), userRequest(user.name, user.name+"@example.com")); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Hour)
+	}
+
+	names := func(query ListQuery) []string {
+		t.Helper()
+		users, err := db.Collect(u.List(context.Background(), query, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(users))
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+
+	for _, test := range []struct {
+		name  string
+		query ListQuery
+		want  []string
+	}{
+		{name: "by name", query: ListQuery{Sort: SortByName}, want: []string{"ann", "Bea", "carl"}},
+		{name: "by name descending", query: ListQuery{Sort: SortByName, Descending: true}, want: []string{"carl", "Bea", "ann"}},
+		{name: "by creation", query: ListQuery{Sort: SortByCreatedAt}, want: []string{"carl", "ann", "Bea"}},
+		{name: "created by", query: ListQuery{Sort: SortByCreatedAt, CreatedBy: "alice"}, want: []string{"carl", "Bea"}},
+		{name: "created after", query: ListQuery{Sort: SortByCreatedAt, CreatedAfter: start}, want: []string{"ann", "Bea"}},
+		{name: "created before", query: ListQuery{Sort: SortByCreatedAt, CreatedBefore: start.Add(2 * time.Hour)}, want: []string{"carl", "ann"}},
+		{name: "no match", query: ListQuery{UpdatedBy: "nobody"}, want: []string{}},
+	} {
+		got := names(test.query)
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestListQueryRefusesUnknownSortFields(t *testing.T) {
+	if err := (ListQuery{Sort: "password"}).Validate(); err != ErrInvalidSortField {
+		t.Errorf("got %v, want %v", err, ErrInvalidSortField)
+	}
+	for _, field := range append(SortFields, "") {
+		if err := (ListQuery{Sort: field}).Validate(); err != nil {
+			t.Errorf("sort %q: got %v", field, err)
+		}
+	}
+}
+
+func TestPageWalksEveryUserOnceInOrder(t *testing.T) {
+	clock := newFakeClock()
+	u := newTestUserService(WithClock(clock))
+	ctx := context.Background()
+	// Users sharing a name are told apart by their id
+	names := []string{"Eve", "Ann", "Bob", "Ann", "Dan", "Cid", "Bob"}
+	created := make([]uuid.UUID, 0, len(names))
+	for i, name := range names {
+		id, err := u.Create(ctx, userRequest(name, name+"@example.com"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, id)
+		if i%2 == 0 {
+			clock.Advance(time.Second)
+		}
+	}
+
+	for _, query := range []ListQuery{
+		{Sort: SortByCreatedAt},
+		{Sort: SortByName},
+		{Sort: SortByName, Descending: true},
+	} {
+		want, err := db.Collect(u.List(ctx, query, db.DefaultBatchSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[uuid.UUID]bool)
+		var after *SortKey
+		for {
+			page, more, err := u.Page(ctx, query, after, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, user := range page {
+				if seen[user.Id] {
+					t.Fatalf("%+v: %s listed twice", query, user.Id)
+				}
+				seen[user.Id] = true
+				if i > 0 && query.compareKeys(query.SortKey(page[i-1]), query.SortKey(user)) >= 0 {
+					t.Errorf("%+v: %s listed out of order", query, user.Id)
+				}
+			}
+			if !more {
+				break
+			}
+			key := query.SortKey(page[len(page)-1])
+			after = &key
+		}
+		if len(seen) != len(want) {
+			t.Errorf("%+v: listed %d users, want %d", query, len(seen), len(want))
+		}
+	}
+
+	// A cursor stays valid when its user is deleted
+	query := ListQuery{Sort: SortByCreatedAt}
+	first, _, err := u.Page(ctx, query, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := query.SortKey(first[1])
+	if _, err := u.Delete(ctx, first[1].Id); err != nil {
+		t.Fatal(err)
+	}
+	rest, _, err := u.Page(ctx, query, &key, len(created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != len(created)-2 {
+		t.Errorf("got %d users after the deleted one, want %d", len(rest), len(created)-2)
+	}
+}
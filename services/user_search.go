@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/search"
+	"log/slog"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrSearchDisabled = errors.New("search is not enabled")
+)
+
+// Weights of the searched fields, a match in the name ranks above one in the city
+const (
+	nameWeight  = 4
+	emailWeight = 2
+	cityWeight  = 1
+)
+
+// SearchResult is a user found by a search with the score of the match
+type SearchResult struct {
+	User  entities.User
+	Score float64
+}
+
+func (s SearchResult) GetId() uuid.UUID {
+	return s.User.Id
+}
+
+// WithSearch keeps the users of every tenant in the index, without it users can't be searched
+func WithSearch(index search.Index) UserServiceOption {
+	return func(u *UserService) {
+		u.search = index
+	}
+}
+
+// Search returns the users having every word of the query in their name, last name,
+// email or city, best matches first
+func (u *UserService) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	//Log action
+	slog.Info("Searching users", "query", query, "limit", limit)
+	if u.search == nil {
+		return nil, ErrSearchDisabled
+	}
+	hits, err := u.search.Search(TenantFrom(ctx), query, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		user, err := u.storage(ctx).Get(hit.Id)
+		// A user deleted by another process can still be in an in-memory index
+		if errors.Is(err, db.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, SearchResult{User: user, Score: hit.Score})
+	}
+	return results, nil
+}
+
+// RebuildSearch indexes every user stored by the tenant when its index was never built, or
+// always when force is set, and reports if it did
+func RebuildSearch(index search.Index, tenant string, storage db.Storage[entities.User], force bool) (bool, error) {
+	if !force {
+		built, err := index.Built(tenant)
+		if err != nil || built {
+			return false, err
+		}
+	}
+	docs := make([]search.Document, 0)
+	iter := storage.Iterate(db.DefaultBatchSize)
+	for iter.Next() {
+		for _, user := range iter.Batch() {
+			docs = append(docs, userDocument(user))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return false, err
+	}
+	return true, index.Rebuild(tenant, docs)
+}
+
+// indexSearch keeps the new state of the user in the search index
+func (u *UserService) indexSearch(ctx context.Context, user entities.User) {
+	if u.search == nil {
+		return
+	}
+	// The change is already stored, a failing index must not fail the request
+	if err := u.search.Add(TenantFrom(ctx), userDocument(user)); err != nil {
+		slog.Error(err.Error(), "id", user.Id)
+	}
+}
+
+// unindexSearch removes a deleted user from the search index
+func (u *UserService) unindexSearch(ctx context.Context, id uuid.UUID) {
+	if u.search == nil {
+		return
+	}
+	if err := u.search.Remove(TenantFrom(ctx), id); err != nil {
+		slog.Error(err.Error(), "id", id)
+	}
+}
+
+// userDocument is the searchable text of the user, the cities of every address included
+func userDocument(user entities.User) search.Document {
+	fields := []search.Field{
+		{Text: user.Name, Weight: nameWeight},
+		{Text: user.LastName, Weight: nameWeight},
+		{Text: user.Email, Weight: emailWeight},
+		{Text: user.Address.City, Weight: cityWeight},
+	}
+	for _, address := range user.Addresses {
+		fields = append(fields, search.Field{Text: address.City, Weight: cityWeight})
+	}
+	return search.Document{Id: user.Id, Fields: fields}
+}
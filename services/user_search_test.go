@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/search"
+	"testing"
+)
+
+// searchNames returns the names of the users found by the query, best first
+func searchNames(t *testing.T, u *UserService, ctx context.Context, query string) []string {
+	t.Helper()
+	results, err := u.Search(ctx, query, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(results))
+	for _, result := range results {
+		names = append(names, result.User.Name)
+	}
+	return names
+}
+
+func TestSearchFollowsTheUserChanges(t *testing.T) {
+	u := newTestUserService(WithSearch(search.NewMemoryIndex()))
+	// Changing the email needs the user or an admin
+	ctx := WithAdmin(context.Background())
+	id, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := searchNames(t, u, ctx, "ann rome"); len(names) != 1 {
+		t.Errorf("after the create: found %v", names)
+	}
+
+	req := userRequest("Anna", "anna@example.com")
+	req.Address = &milan
+	if _, err := u.Update(ctx, id, req); err != nil {
+		t.Fatal(err)
+	}
+	if names := searchNames(t, u, ctx, "rome"); len(names) != 0 {
+		t.Errorf("the old city is found: %v", names)
+	}
+	if names := searchNames(t, u, ctx, "anna milan"); len(names) != 1 {
+		t.Errorf("after the update: found %v", names)
+	}
+
+	if _, err := u.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if names := searchNames(t, u, ctx, "anna"); len(names) != 0 {
+		t.Errorf("the deleted user is found: %v", names)
+	}
+	if _, err := u.Restore(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if names := searchNames(t, u, ctx, "anna"); len(names) != 1 {
+		t.Errorf("the restored user is not found: %v", names)
+	}
+
+	// Another tenant has its own users
+	if names := searchNames(t, u, WithTenant(ctx, "acme"), "anna"); len(names) != 0 {
+		t.Errorf("another tenant found %v", names)
+	}
+}
+
+func TestRebuildSearchIndexesTheStoredUsers(t *testing.T) {
+	users := memoryTenants[entities.User](newTestTenants())
+	index := search.NewMemoryIndex()
+	// Users stored while the index was elsewhere
+	if _, err := NewUserService(users).Create(context.Background(), userRequest("Ann", "ann@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt, err := RebuildSearch(index, db.DefaultTenant, users.For(db.DefaultTenant), false); err != nil || !rebuilt {
+		t.Fatalf("got %v, %v, want the index built", rebuilt, err)
+	}
+	// Only a forced rebuild runs again
+	if rebuilt, err := RebuildSearch(index, db.DefaultTenant, users.For(db.DefaultTenant), false); err != nil || rebuilt {
+		t.Errorf("got %v, %v, want the built index kept", rebuilt, err)
+	}
+	if rebuilt, err := RebuildSearch(index, db.DefaultTenant, users.For(db.DefaultTenant), true); err != nil || !rebuilt {
+		t.Errorf("got %v, %v, want the index rebuilt when forced", rebuilt, err)
+	}
+	u := NewUserService(users, WithSearch(index))
+	if names := searchNames(t, u, context.Background(), "ann"); len(names) != 1 {
+		t.Errorf("found %v", names)
+	}
+}
+
+func TestSearchNeedsAnIndex(t *testing.T) {
+	if _, err := newTestUserService().Search(context.Background(), "ann", 10); err != ErrSearchDisabled {
+		t.Errorf("got %v, want %v", err, ErrSearchDisabled)
+	}
+}
@@ -1,76 +1,624 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/audit"
 	"example/bootcamp_ex1/db"
 	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"example/bootcamp_ex1/search"
 
 	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const UserEntityType = "user"
+
+// storeAttempts is how many times a user change is tried while other requests change the user
+const storeAttempts = 5
+
+var (
+	ErrAuditDisabled = errors.New("audit log is not enabled")
+	ErrRevertDeleted = errors.New("cannot revert to a deleted version")
+)
+
 type UserService struct {
-	storage db.Storage[entities.User]
+	// Every tenant keeps its users in its own storage
+	tenants  *db.Tenants[entities.User]
+	clock    Clock
+	auditLog audit.Sink
+	// changes notifies the listeners in this process as soon as a change is stored
+	changes *events.Bus
+	// attributes defines the custom attributes the users can have
+	attributes *AttributeService
+	// search finds the users by the words of their name, email and city
+	search search.Index
+	// duplicates keeps the last duplicate scan of every tenant
+	duplicates *duplicateReports
+	// verification mails the tokens the users verify their email with
+	verification *Verification
+	// resends keeps when a token was last mailed on request to every user
+	resends *verificationResends
+	// credentials have the passwords the users log in with, their emails can't be shared
+	credentials *db.Tenants[entities.Credential]
+}
+
+type UserServiceOption func(*UserService)
+
+// WithClock replaces the clock used for the audit timestamps
+func WithClock(clock Clock) UserServiceOption {
+	return func(u *UserService) {
+		u.clock = clock
+	}
+}
+
+// WithAuditLog records every change made to the users in the sink
+func WithAuditLog(sink audit.Sink) UserServiceOption {
+	return func(u *UserService) {
+		u.auditLog = sink
+	}
 }
 
-func NewUserService(storage db.Storage[entities.User]) *UserService {
+// WithAttributes validates the custom attributes of the users with the definitions of the service,
+// without it users can't have custom attributes
+func WithAttributes(attributes *AttributeService) UserServiceOption {
+	return func(u *UserService) {
+		u.attributes = attributes
+	}
+}
+
+// WithCredentials keeps the email of a user with a password, which logs it in, out of the
+// other users
+func WithCredentials(credentials *db.Tenants[entities.Credential]) UserServiceOption {
+	return func(u *UserService) {
+		u.credentials = credentials
+	}
+}
+
+func NewUserService(tenants *db.Tenants[entities.User], opts ...UserServiceOption) *UserService {
 	userService := new(UserService)
-	userService.storage = storage
+	userService.tenants = tenants
+	userService.clock = SystemClock
+	userService.changes = events.NewBus()
+	userService.duplicates = newDuplicateReports()
+	userService.resends = newVerificationResends()
+	for _, opt := range opts {
+		opt(userService)
+	}
 	return userService
 }
 
-func (u *UserService) Get(id uuid.UUID) (entities.User, error) {
+func (u *UserService) Get(ctx context.Context, id uuid.UUID) (entities.User, error) {
 	//Log action
-	slog.Info("Getting a user by id", id)
-	return u.storage.Get(id)
+	slog.Info("Getting a user by id", "id", id)
+	return u.storage(ctx).Get(id)
 }
 
-func (u *UserService) GetAll() ([]entities.User, error) {
+func (u *UserService) GetAll(ctx context.Context) ([]entities.User, error) {
 	//Log action
 	slog.Info("Logging all users")
 	//Return slice of users
-	return u.storage.GetAll()
+	return u.storage(ctx).GetAll()
+}
+
+func (u *UserService) Iterate(ctx context.Context, batchSize int) db.Iterator[entities.User] {
+	//Log action
+	slog.Info("Streaming all users", "batchSize", batchSize)
+	return u.storage(ctx).Iterate(batchSize)
 }
 
-func (u *UserService) Create(userReq entities.UserRequest) (uuid.UUID, error) {
+// List streams the users matching the query, sorted ones are loaded in memory first
+func (u *UserService) List(ctx context.Context, query ListQuery, batchSize int) db.Iterator[entities.User] {
+	//Log action
+	slog.Info("Listing users", "query", query)
+	iter := db.NewFilterIterator(u.candidates(ctx, query, batchSize), query.Matches)
+	if query.Sort == "" {
+		return iter
+	}
+
+	users, err := db.Collect(iter)
+	if err != nil {
+		return db.NewErrorIterator[entities.User](err)
+	}
+	query.sortUsers(users)
+	return db.NewSliceIterator(users, batchSize)
+}
+
+// Page returns the first users of the query after the key, nil for the first page, and if
+// more follow. Only a page of users is kept while the matching ones are read.
+func (u *UserService) Page(ctx context.Context, query ListQuery, after *SortKey, first int) ([]entities.User, bool, error) {
+	//Log action
+	slog.Info("Listing a page of users", "query", query, "first", first)
+	iter := db.NewFilterIterator(u.candidates(ctx, query, db.DefaultBatchSize), query.Matches)
+	// One more than the page tells if more follow
+	page := make([]entities.User, 0, first+1)
+	for iter.Next() {
+		for _, user := range iter.Batch() {
+			key := query.SortKey(user)
+			if after != nil && query.compareKeys(key, *after) <= 0 {
+				continue
+			}
+			i, _ := slices.BinarySearchFunc(page, key, func(user entities.User, key SortKey) int {
+				return query.compareKeys(query.SortKey(user), key)
+			})
+			if i > first {
+				continue
+			}
+			page = slices.Insert(page, i, user)
+			if len(page) > first+1 {
+				page = page[:first+1]
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(page) > first {
+		return page[:first], true, nil
+	}
+	return page, false, nil
+}
+
+// candidates iterates over the users that can match the query, found by an index when it filters by tag or attribute
+func (u *UserService) candidates(ctx context.Context, query ListQuery, batchSize int) db.Iterator[entities.User] {
+	if len(query.Tags) > 0 {
+		return u.storage(ctx).FindBy(IndexTag, query.Tags[0], batchSize)
+	}
+	for name, value := range query.Attributes {
+		return u.storage(ctx).FindBy(IndexAttribute, AttributeKey(name, value), batchSize)
+	}
+	return u.storage(ctx).Iterate(batchSize)
+}
+
+func (u *UserService) Create(ctx context.Context, userReq entities.UserRequest) (uuid.UUID, error) {
+	// New users start inactive until they verify their email
+	active := userReq.Active != nil && *userReq.Active
+	if err := requireAdminToActivate(ctx, false, active); err != nil {
+		return uuid.UUID{}, err
+	}
+	if err := u.checkEmailFree(ctx, userReq.Email); err != nil {
+		return uuid.UUID{}, err
+	}
 	id := uuid.New()
+	now := u.clock.Now()
+	actor := ActorFrom(ctx)
+	addresses, err := newAddresses(userReq)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	attributes, err := validateAttributes(ctx, u.attributes, userReq.Attributes)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
 	newUser := entities.User{
-		Id:       id,
-		Name:     userReq.Name,
-		LastName: userReq.LastName,
-		Email:    userReq.Email,
-		Address:  userReq.Address,
-		Active:   userReq.Active,
+		Id:         id,
+		Name:       userReq.Name,
+		LastName:   userReq.LastName,
+		Email:      userReq.Email,
+		Active:     active,
+		Attributes: attributes,
+		Tags:       entities.NormalizeTags(userReq.Tags),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		CreatedBy:  actor,
+		UpdatedBy:  actor,
 	}
+	newUser.SetAddresses(addresses)
 	//Log action
-	slog.Info("Creating user: ", newUser)
+	slog.Info("Creating user", "user", newUser)
 
-	id, err := u.storage.Create(newUser)
+	changes := u.newEvents(ctx, now, newUser, events.UserCreated)
+	outbox, err := u.outbox(ctx, changes, audit.OperationCreate, id, now, nil, newUser)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
+	id, err = u.storage(ctx).Create(newUser, outbox...)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	u.indexSearch(ctx, newUser)
+	u.notify(ctx, changes)
+	u.notifyVerification(ctx, newUser)
 
 	return id, nil
 }
 
-func (u *UserService) Update(id uuid.UUID, userReq entities.UserRequest) (entities.User, error) {
+func (u *UserService) Update(ctx context.Context, id uuid.UUID, userReq entities.UserRequest) (entities.User, error) {
+	return retryStale(func() (entities.User, error) {
+		return u.update(ctx, id, userReq)
+	})
+}
+
+func (u *UserService) update(ctx context.Context, id uuid.UUID, userReq entities.UserRequest) (entities.User, error) {
+	// The creation metadata is kept from the stored user
+	current, err := u.storage(ctx).Get(id)
+	if err != nil {
+		return entities.User{}, err
+	}
+
+	addresses, err := updatedAddresses(current, userReq)
+	if err != nil {
+		return entities.User{}, err
+	}
 	newUser := entities.User{
-		Id:       id,
-		Name:     userReq.Name,
-		LastName: userReq.LastName,
-		Email:    userReq.Email,
-		Address:  userReq.Address,
-		Active:   userReq.Active,
+		Id:              id,
+		Name:            userReq.Name,
+		LastName:        userReq.LastName,
+		Email:           userReq.Email,
+		Active:          current.Active,
+		Attributes:      current.Attributes,
+		Tags:            current.Tags,
+		EmailVerifiedAt: current.EmailVerifiedAt,
+		DeactivatedAt:   current.DeactivatedAt,
+		CreatedAt:       current.CreatedAt,
+		CreatedBy:       current.CreatedBy,
+	}
+	newUser.SetAddresses(addresses)
+	if userReq.Active != nil {
+		if err := requireAdminToActivate(ctx, current.Active, *userReq.Active); err != nil {
+			return entities.User{}, err
+		}
+		newUser.Active = *userReq.Active
+	}
+	// A new email has to be verified again, only the user or an admin can change it
+	emailChanged := newUser.Email != current.Email
+	if emailChanged {
+		if err := u.checkEmailChange(ctx, id, newUser.Email); err != nil {
+			return entities.User{}, err
+		}
+		newUser.EmailVerifiedAt = nil
+	}
+	// Clients unaware of the attributes and tags don't send them, they are kept
+	if userReq.Attributes != nil {
+		if newUser.Attributes, err = validateAttributes(ctx, u.attributes, userReq.Attributes); err != nil {
+			return entities.User{}, err
+		}
+	}
+	if userReq.Tags != nil {
+		newUser.Tags = entities.NormalizeTags(userReq.Tags)
 	}
+	updated, err := u.save(ctx, current, newUser)
+	if err == nil && emailChanged {
+		u.notifyVerification(ctx, updated)
+	}
+	return updated, err
+}
+
+// checkEmailChange fails when the request can't give the user the email
+func (u *UserService) checkEmailChange(ctx context.Context, id uuid.UUID, email string) error {
+	if err := requireOwnerOrAdmin(ctx, id); err != nil {
+		return err
+	}
+	return u.checkEmailFree(ctx, email, id)
+}
+
+// save stores a change of the user with its events, version and audit entry
+func (u *UserService) save(ctx context.Context, current entities.User, newUser entities.User) (entities.User, error) {
+	newUser.UpdatedAt = u.clock.Now()
+	newUser.UpdatedBy = ActorFrom(ctx)
+	return u.store(ctx, current, newUser, audit.OperationUpdate)
+}
+
+// retryStale runs the change again, reading the user anew, while another request stored the
+// user since the change read it, and fails with db.ErrStale after storeAttempts
+func retryStale(change func() (entities.User, error)) (entities.User, error) {
+	for attempt := 0; attempt < storeAttempts; attempt++ {
+		updated, err := change()
+		if !errors.Is(err, db.ErrStale) {
+			return updated, err
+		}
+	}
+	return entities.User{}, db.ErrStale
+}
 
+// store saves a user already stamped with UpdatedAt, the extra events are stored
+// after its lifecycle events and the audit entry has the operation. It fails with
+// db.ErrStale when the user was stored since current was read.
+func (u *UserService) store(ctx context.Context, current entities.User, newUser entities.User, operation string, extra ...events.Event) (entities.User, error) {
 	//Log action
-	slog.Info("Update user: ", newUser)
-	return u.storage.Update(id, newUser)
+	slog.Info("Update user", "user", newUser)
+	eventTypes := []events.EventType{events.UserUpdated}
+	if current.Active != newUser.Active {
+		eventTypes = append(eventTypes, activationEvent(newUser.Active))
+	}
+	// Only the admins change the activation, the verification can't undo a deactivation
+	if current.Active && !newUser.Active {
+		newUser.DeactivatedAt = &newUser.UpdatedAt
+	}
+	if newUser.Active {
+		newUser.DeactivatedAt = nil
+	}
+	changes := append(u.newEvents(ctx, newUser.UpdatedAt, newUser, eventTypes...), extra...)
+	outbox, err := u.outbox(ctx, changes, operation, newUser.Id, newUser.UpdatedAt, current, newUser)
+	if err != nil {
+		return entities.User{}, err
+	}
+	updated, err := u.storage(ctx).Swap(newUser.Id, current, newUser, outbox...)
+	if err != nil {
+		return entities.User{}, err
+	}
+	u.indexSearch(ctx, updated)
+	u.notify(ctx, changes)
+
+	return updated, nil
 }
 
-func (u *UserService) Delete(id uuid.UUID) (uuid.UUID, error) {
-	slog.Info("Deleting user: ", id)
-	return u.storage.Delete(id)
+// Delete soft deletes the user, it can be restored until it is purged
+func (u *UserService) Delete(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	slog.Info("Deleting user", "id", id, "actor", ActorFrom(ctx))
+	current, err := u.storage(ctx).Get(id)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return u.softDelete(ctx, current, audit.OperationDelete, events.UserDeleted)
+}
+
+// softDelete soft deletes the user with the events given, a merged user goes without any
+// since the UserMerged event of its survivor already tells it is gone
+func (u *UserService) softDelete(ctx context.Context, current entities.User, operation string, eventTypes ...events.EventType) (uuid.UUID, error) {
+	now := u.clock.Now()
+	changes := u.newEvents(ctx, now, current, eventTypes...)
+	outbox, err := u.outbox(ctx, changes, operation, current.Id, now, current, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	id, err := u.storage(ctx).SoftDelete(current.Id, now, outbox...)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	u.unindexSearch(ctx, id)
+	u.notify(ctx, changes)
+
+	return id, nil
+}
+
+func (u *UserService) Restore(ctx context.Context, id uuid.UUID) (entities.User, error) {
+	slog.Info("Restoring user", "id", id, "actor", ActorFrom(ctx))
+	// The restored user is the deleted one, as it was stored
+	deleted, err := u.findDeleted(ctx, id)
+	if err != nil {
+		return entities.User{}, err
+	}
+	// Users deleted before the labeled addresses missed the migration, they are upgraded
+	// in the same write as the restore
+	deleted.UpgradeAddresses()
+	now := u.clock.Now()
+	changes := u.newEvents(ctx, now, deleted, events.UserRestored)
+	outbox, err := u.outbox(ctx, changes, audit.OperationRestore, id, now, nil, deleted)
+	if err != nil {
+		return entities.User{}, err
+	}
+	restored, err := u.storage(ctx).Restore(id, now, (*entities.User).UpgradeAddresses, outbox...)
+	if err != nil {
+		return entities.User{}, err
+	}
+	u.indexSearch(ctx, restored)
+	u.notify(ctx, changes)
+
+	return restored, nil
+}
+
+func (u *UserService) GetVersions(ctx context.Context, id uuid.UUID) ([]db.Version[entities.User], error) {
+	//Log action
+	slog.Info("Listing user versions", "id", id)
+	return u.storage(ctx).GetVersions(id)
+}
+
+func (u *UserService) GetVersion(ctx context.Context, id uuid.UUID, number int) (db.Version[entities.User], error) {
+	//Log action
+	slog.Info("Getting user version", "id", id, "version", number)
+	return u.storage(ctx).GetVersion(id, number)
+}
+
+// GetAsOf returns the user as it was at the given time
+func (u *UserService) GetAsOf(ctx context.Context, id uuid.UUID, at time.Time) (entities.User, error) {
+	//Log action
+	slog.Info("Getting a user by id", "id", id, "asOf", at)
+	version, err := u.storage(ctx).GetAsOf(id, at)
+	if err != nil {
+		return entities.User{}, err
+	}
+	return version.Record, nil
+}
+
+// Revert updates the user with the fields of one of its previous versions
+func (u *UserService) Revert(ctx context.Context, id uuid.UUID, number int) (entities.User, error) {
+	slog.Info("Reverting user", "id", id, "version", number)
+	return retryStale(func() (entities.User, error) {
+		return u.revert(ctx, id, number)
+	})
+}
+
+func (u *UserService) revert(ctx context.Context, id uuid.UUID, number int) (entities.User, error) {
+	version, err := u.storage(ctx).GetVersion(id, number)
+	if err != nil {
+		return entities.User{}, err
+	}
+	if version.Deleted {
+		return entities.User{}, ErrRevertDeleted
+	}
+	current, err := u.storage(ctx).Get(id)
+	if err != nil {
+		return entities.User{}, err
+	}
+	old := version.Record
+	// Versions stored before the labeled addresses only have the single one
+	old.UpgradeAddresses()
+	newUser := current
+	newUser.Name = old.Name
+	newUser.LastName = old.LastName
+	if err := requireAdminToActivate(ctx, current.Active, old.Active); err != nil {
+		return entities.User{}, err
+	}
+	newUser.Email = old.Email
+	newUser.Active = old.Active
+	if old.Email != current.Email {
+		if err := u.checkEmailChange(ctx, id, old.Email); err != nil {
+			return entities.User{}, err
+		}
+		newUser.EmailVerifiedAt = old.EmailVerifiedAt
+	}
+	newUser.Attributes = old.Attributes
+	newUser.Tags = old.Tags
+	newUser.SetAddresses(old.Addresses)
+	return u.save(ctx, current, newUser)
+}
+
+// AuditLog returns the recorded changes of the users matching the query
+func (u *UserService) AuditLog(ctx context.Context, query audit.Query) ([]audit.Entry, error) {
+	if u.auditLog == nil {
+		return nil, ErrAuditDisabled
+	}
+	query.Tenant = TenantFrom(ctx)
+	query.EntityType = UserEntityType
+	return u.auditLog.Query(query)
+}
+
+// outbox wraps the events of a change and its audit entry in the messages stored with it,
+// before is nil on creations and after on deletions
+func (u *UserService) outbox(ctx context.Context, changes []events.Event, operation string, id uuid.UUID, timestamp time.Time, before any, after any) ([]db.OutboxMessage, error) {
+	messages, err := toOutbox(changes)
+	if err != nil {
+		return nil, err
+	}
+	return u.audited(ctx, messages, operation, id, timestamp, before, after)
+}
+
+// audited adds the audit entry of the change to its outbox messages, the relay appends
+// it to the audit log and retries until it is
+func (u *UserService) audited(ctx context.Context, messages []db.OutboxMessage, operation string, id uuid.UUID, timestamp time.Time, before any, after any) ([]db.OutboxMessage, error) {
+	if u.auditLog == nil {
+		return messages, nil
+	}
+	entry := audit.NewEntry(UserEntityType, id, ActorFrom(ctx), timestamp, operation, before, after)
+	entry.Tenant = TenantFrom(ctx)
+	message, err := audit.ToOutbox(entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(messages, message), nil
+}
+
+func (u *UserService) GetDeleted(ctx context.Context) ([]entities.User, error) {
+	//Log action
+	slog.Info("Listing deleted users")
+	deletedList, err := u.storage(ctx).GetDeleted()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]entities.User, 0, len(deletedList))
+	for _, deleted := range deletedList {
+		user := deleted.Record
+		// The range variable is shared by the iterations before go 1.22
+		deletedAt := deleted.DeletedAt
+		user.DeletedAt = &deletedAt
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// Purge hard deletes the users of every tenant deleted longer than the retention ago
+func (u *UserService) Purge(retention time.Duration) ([]uuid.UUID, error) {
+	purged := make([]uuid.UUID, 0)
+	err := u.tenants.Each(func(tenant string, storage db.Storage[entities.User]) error {
+		ctx := WithActor(WithTenant(context.Background(), tenant), SystemActor)
+		now := u.clock.Now()
+		ids, err := storage.Purge(now.Add(-retention), func(deleted db.Deleted[entities.User]) ([]db.OutboxMessage, error) {
+			changes := u.newEvents(ctx, now, deleted.Record, events.UserPurged)
+			return u.outbox(ctx, changes, audit.OperationPurge, deleted.Record.Id, now, deleted.Record, nil)
+		})
+		if len(ids) > 0 {
+			slog.Info("Purged deleted users", "tenant", tenant, "count", len(ids))
+		}
+		purged = append(purged, ids...)
+		return err
+	})
+	return purged, err
+}
+
+// StartPurge purges the deleted users every interval until the context is done
+func (u *UserService) StartPurge(ctx context.Context, interval time.Duration, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := u.Purge(retention); err != nil {
+					slog.Error(err.Error())
+				}
+			}
+		}
+	}()
 }
 
 //métodos create, get, get all, update y delete. Este struct debe ser privado y debe contar con un método constructor.
+
+// OnChange calls the listener with the events of every change stored from now on, the
+// returned function removes it. Unlike the outbox it is best effort and in process only.
+// Listeners run in the request goroutine and must not block.
+func (u *UserService) OnChange(listener events.Handler) func() {
+	return u.changes.Subscribe(listener)
+}
+
+// storage returns the storage of the tenant of the request
+func (u *UserService) storage(ctx context.Context) db.Storage[entities.User] {
+	return u.tenants.For(TenantFrom(ctx))
+}
+
+// newEvents builds the lifecycle events of a change
+func (u *UserService) newEvents(ctx context.Context, occurredAt time.Time, user entities.User, eventTypes ...events.EventType) []events.Event {
+	changes := make([]events.Event, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		event := events.NewEvent(eventType, user, ActorFrom(ctx), occurredAt)
+		event.Tenant = TenantFrom(ctx)
+		changes = append(changes, event)
+	}
+	return changes
+}
+
+// notify tells the change listeners about a change already stored, their failures are
+// only logged since the change can't be undone
+func (u *UserService) notify(ctx context.Context, changes []events.Event) {
+	for _, event := range changes {
+		if err := u.changes.Publish(ctx, event); err != nil {
+			slog.Error(err.Error(), "event", event.Id)
+		}
+	}
+}
+
+// toOutbox wraps the events in the messages stored atomically with the change,
+// they are delivered by an events.Relay
+func toOutbox(changes []events.Event) ([]db.OutboxMessage, error) {
+	messages := make([]db.OutboxMessage, 0, len(changes))
+	for _, event := range changes {
+		message, err := events.ToOutbox(event)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// findDeleted returns the soft deleted user with the id
+func (u *UserService) findDeleted(ctx context.Context, id uuid.UUID) (entities.User, error) {
+	deleted, err := u.storage(ctx).GetDeletedRecord(id)
+	if err != nil {
+		return entities.User{}, err
+	}
+	return deleted.Record, nil
+}
+
+func activationEvent(active bool) events.EventType {
+	if active {
+		return events.UserActivated
+	}
+	return events.UserDeactivated
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"example/bootcamp_ex1/audit"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEveryUserChangeIsAudited(t *testing.T) {
+	clock := newFakeClock()
+	users := memoryTenants[entities.User](newTestTenants())
+	sink := audit.NewMemorySink()
+	u := NewUserService(users, WithClock(clock), WithAuditLog(sink))
+	ctx := WithActor(context.Background(), "alice")
+
+	id, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := u.Update(ctx, id, userRequest("Anna", "ann@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := u.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := u.Restore(WithActor(context.Background(), "bob"), id); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := u.AuditLog(context.Background(), audit.Query{EntityId: id}); len(entries) != 0 {
+		t.Errorf("the entries %+v reached the audit log before the relay", entries)
+	}
+	relayAudit(t, users, sink)
+
+	entries, err := u.AuditLog(context.Background(), audit.Query{EntityId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	operations := []string{audit.OperationCreate, audit.OperationUpdate, audit.OperationDelete, audit.OperationRestore}
+	if len(entries) != len(operations) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(operations))
+	}
+	for i, entry := range entries {
+		if entry.Operation != operations[i] || entry.EntityType != UserEntityType {
+			t.Errorf("entry %d: got %s %s, want %s %s", i, entry.EntityType, entry.Operation, UserEntityType, operations[i])
+		}
+	}
+	if entries[3].Actor != "bob" {
+		t.Errorf("the restore was done by %q, want bob", entries[3].Actor)
+	}
+
+	// The update only records what it changed
+	changed := make(map[string]bool)
+	for _, change := range entries[1].Changes {
+		changed[change.Field] = true
+	}
+	if !changed["name"] || !changed["updated_at"] || changed["email"] || changed["created_at"] {
+		t.Errorf("got the changed fields %v", changed)
+	}
+
+	if entries, _ := u.AuditLog(context.Background(), audit.Query{Operation: audit.OperationDelete, From: clock.Now()}); len(entries) != 0 {
+		t.Errorf("got %d deletions after the last one", len(entries))
+	}
+}
+
+func TestAuditLogNeedsASink(t *testing.T) {
+	if _, err := newTestUserService().AuditLog(context.Background(), audit.Query{}); err != ErrAuditDisabled {
+		t.Errorf("got %v, want %v", err, ErrAuditDisabled)
+	}
+}
+
+func TestRevertUpdatesTheUserWithAnOldVersion(t *testing.T) {
+	clock := newFakeClock()
+	u := newTestUserService(WithClock(clock))
+	// Changing the email needs the user or an admin
+	ctx := WithAdmin(context.Background())
+	id, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := clock.Now()
+	clock.Advance(time.Hour)
+	if _, err := u.Update(ctx, id, userRequest("Anna", "anna@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := u.GetAsOf(ctx, id, created.Add(time.Minute))
+	if err != nil || old.Name != "Ann" {
+		t.Fatalf("got %+v, %v as of the creation", old, err)
+	}
+
+	clock.Advance(time.Hour)
+	reverted, err := u.Revert(ctx, id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Name != "Ann" || reverted.Email != "ann@example.com" || !reverted.CreatedAt.Equal(created) {
+		t.Errorf("got %+v after the revert", reverted)
+	}
+	// Reverting is a change of its own
+	versions, _ := u.GetVersions(ctx, id)
+	if len(versions) != 3 || versions[2].Record.Name != "Ann" {
+		t.Errorf("got the versions %+v", versions)
+	}
+
+	u.Delete(ctx, id)
+	u.Restore(ctx, id)
+	if _, err := u.Revert(ctx, id, 4); err != ErrRevertDeleted {
+		t.Errorf("reverting to the deletion: got %v, want %v", err, ErrRevertDeleted)
+	}
+}
+
+// relayEvents publishes the events in the outboxes of the tenants
+func relayEvents(t *testing.T, users *db.Tenants[entities.User]) []events.Event {
+	t.Helper()
+	bus := events.NewBus()
+	published := make([]events.Event, 0)
+	bus.Subscribe(func(ctx context.Context, event events.Event) error {
+		published = append(published, event)
+		return nil
+	})
+	if _, err := events.NewRelay(users, bus, time.Hour).DispatchPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return published
+}
+
+// relayAudit appends the audit entries stored in the outbox to the sink
+func relayAudit(t *testing.T, users *db.Tenants[entities.User], sink audit.Sink) {
+	t.Helper()
+	relay := events.NewRelay(users, events.NewBus(), time.Hour).Route(audit.OutboxTopic, audit.Deliver(sink))
+	if _, err := relay.DispatchPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgesAreAuditedByTheSystem(t *testing.T) {
+	clock := newFakeClock()
+	users := memoryTenants[entities.User](newTestTenants())
+	sink := audit.NewMemorySink()
+	u := NewUserService(users, WithClock(clock), WithAuditLog(sink))
+
+	id, err := u.Create(context.Background(), userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Delete(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Hour)
+	if purged, err := u.Purge(time.Hour); err != nil || len(purged) != 1 {
+		t.Fatalf("purged %v, %v", purged, err)
+	}
+	relayAudit(t, users, sink)
+
+	entries, err := u.AuditLog(context.Background(), audit.Query{EntityId: id, Operation: audit.OperationPurge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Actor != SystemActor {
+		t.Errorf("got the purge entries %+v", entries)
+	}
+}
+
+func TestUserChangesStoreTheirEvents(t *testing.T) {
+	clock := newFakeClock()
+	users := memoryTenants[entities.User](newTestTenants())
+	u := NewUserService(users, WithClock(clock))
+	ctx := WithActor(context.Background(), "alice")
+
+	id, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, inactive := true, false
+	activate := userRequest("Ann", "ann@example.com")
+	activate.Active = &active
+	deactivate := userRequest("Anna", "ann@example.com")
+	deactivate.Active = &inactive
+	// Only admins change the activation
+	admin := WithAdmin(ctx)
+	for _, change := range []func(){
+		func() { u.Update(admin, id, activate) },
+		func() { u.Update(admin, id, deactivate) },
+		func() { u.Delete(ctx, id) },
+		func() { u.Restore(ctx, id) },
+	} {
+		clock.Advance(time.Minute)
+		change()
+	}
+
+	published := relayEvents(t, users)
+	// The events of one change have no order among them
+	sort.SliceStable(published, func(i, j int) bool {
+		if !published[i].OccurredAt.Equal(published[j].OccurredAt) {
+			return published[i].OccurredAt.Before(published[j].OccurredAt)
+		}
+		return published[i].Type < published[j].Type
+	})
+	want := []events.EventType{
+		events.UserCreated,
+		events.UserActivated, events.UserUpdated,
+		events.UserDeactivated, events.UserUpdated,
+		events.UserDeleted,
+		events.UserRestored,
+	}
+	if len(published) != len(want) {
+		t.Fatalf("got %d events, want %d", len(published), len(want))
+	}
+	for i, event := range published {
+		if event.Type != want[i] || event.UserId != id || event.Actor != "alice" {
+			t.Errorf("event %d: got %s of %s by %q, want %s of %s by alice", i, event.Type, event.UserId, event.Actor, want[i], id)
+		}
+	}
+	// The deletion carries the last state of the user
+	if published[5].User.Name != "Anna" {
+		t.Errorf("got %+v in the deletion", published[5].User)
+	}
+}
+
+func TestFailedChangesStoreNoEvent(t *testing.T) {
+	users := memoryTenants[entities.User](newTestTenants())
+	u := NewUserService(users)
+	if _, err := u.Update(context.Background(), uuid.New(), userRequest("Ann", "ann@example.com")); err == nil {
+		t.Fatal("an unknown user was updated")
+	}
+	if _, err := u.Delete(context.Background(), uuid.New()); err == nil {
+		t.Fatal("an unknown user was deleted")
+	}
+	if _, err := u.Restore(context.Background(), uuid.New()); err == nil {
+		t.Fatal("an unknown user was restored")
+	}
+	if published := relayEvents(t, users); len(published) != 0 {
+		t.Errorf("got the events %+v", published)
+	}
+}
+
+func TestDeletedUsersKeepTheirOwnDeletionDate(t *testing.T) {
+	clock := newFakeClock()
+	u := newTestUserService(WithClock(clock))
+	ctx := context.Background()
+	deletedAt := make(map[uuid.UUID]time.Time)
+	for _, name := range []string{"Ann", "Bea", "Cid"} {
+		id, err := u.Create(ctx, userRequest(name, strings.ToLower(name)+"@example.com"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+		if _, err := u.Delete(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		deletedAt[id] = clock.Now()
+	}
+
+	deleted, err := u.GetDeleted(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != len(deletedAt) {
+		t.Fatalf("got %d deleted users, want %d", len(deleted), len(deletedAt))
+	}
+	for _, user := range deleted {
+		if user.DeletedAt == nil || !user.DeletedAt.Equal(deletedAt[user.Id]) {
+			t.Errorf("%s: got the deletion date %v, want %v", user.Name, user.DeletedAt, deletedAt[user.Id])
+		}
+	}
+}
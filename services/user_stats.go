@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+)
+
+// UserStats counts the users of a tenant, grouped by the country and city of their primary address
+type UserStats struct {
+	Total     int            `json:"total" xml:"total" yaml:"total"`
+	Active    int            `json:"active" xml:"active" yaml:"active"`
+	Inactive  int            `json:"inactive" xml:"inactive" yaml:"inactive"`
+	Countries []CountryCount `json:"countries" xml:"countries>country" yaml:"countries"`
+	Cities    []CityCount    `json:"cities" xml:"cities>city" yaml:"cities"`
+}
+
+type CountryCount struct {
+	Country string `json:"country" xml:"name" yaml:"country"`
+	Users   int    `json:"users" xml:"users" yaml:"users"`
+}
+
+type CityCount struct {
+	Country string `json:"country" xml:"country" yaml:"country"`
+	City    string `json:"city" xml:"name" yaml:"city"`
+	Users   int    `json:"users" xml:"users" yaml:"users"`
+}
+
+// Stats reads the counters the storage keeps on every write, the users aren't read.
+// Groups are sorted by users, most first.
+func (u *UserService) Stats(ctx context.Context) (UserStats, error) {
+	//Log action
+	slog.Info("Counting users")
+	storage := u.storage(ctx)
+	active, err := storage.Count(IndexActive)
+	if err != nil {
+		return UserStats{}, err
+	}
+	countries, err := storage.Count(IndexCountry)
+	if err != nil {
+		return UserStats{}, err
+	}
+	cities, err := storage.Count(IndexCity)
+	if err != nil {
+		return UserStats{}, err
+	}
+
+	stats := UserStats{
+		Active:    active[strconv.FormatBool(true)],
+		Inactive:  active[strconv.FormatBool(false)],
+		Countries: make([]CountryCount, 0, len(countries)),
+		Cities:    make([]CityCount, 0, len(cities)),
+	}
+	stats.Total = stats.Active + stats.Inactive
+	for country, users := range countries {
+		stats.Countries = append(stats.Countries, CountryCount{Country: country, Users: users})
+	}
+	sort.Slice(stats.Countries, func(i, j int) bool {
+		if stats.Countries[i].Users != stats.Countries[j].Users {
+			return stats.Countries[i].Users > stats.Countries[j].Users
+		}
+		return stats.Countries[i].Country < stats.Countries[j].Country
+	})
+	for key, users := range cities {
+		country, city, err := cityFromKey(key)
+		if err != nil {
+			return UserStats{}, err
+		}
+		stats.Cities = append(stats.Cities, CityCount{Country: country, City: city, Users: users})
+	}
+	sort.Slice(stats.Cities, func(i, j int) bool {
+		if stats.Cities[i].Users != stats.Cities[j].Users {
+			return stats.Cities[i].Users > stats.Cities[j].Users
+		}
+		if stats.Cities[i].Country != stats.Cities[j].Country {
+			return stats.Cities[i].Country < stats.Cities[j].Country
+		}
+		return stats.Cities[i].City < stats.Cities[j].City
+	})
+	return stats, nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"example/bootcamp_ex1/entities"
+	"reflect"
+	"testing"
+)
+
+func TestStatsCountTheUserChanges(t *testing.T) {
+	u := NewUserService(memoryTenants[entities.User](newTestTenants(), UserIndexes...))
+	ctx := WithAdmin(context.Background())
+	active := true
+	ann, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bea := userRequest("Bea", "bea@example.com")
+	bea.Active = &active
+	if _, err := u.Create(ctx, bea); err != nil {
+		t.Fatal(err)
+	}
+	cid := userRequest("Cid", "cid@example.com")
+	cid.Address = &entities.Address{City: "Paris", Country: "FR", AddressString: "Rue 1"}
+	cidId, err := u.Create(ctx, cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := u.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UserStats{
+		Total:     3,
+		Active:    1,
+		Inactive:  2,
+		Countries: []CountryCount{{Country: "IT", Users: 2}, {Country: "FR", Users: 1}},
+		Cities:    []CityCount{{Country: "IT", City: "Rome", Users: 2}, {Country: "FR", City: "Paris", Users: 1}},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("after the creates: got %+v, want %+v", stats, want)
+	}
+
+	// Ann moves to Milan and is activated, Cid is deleted
+	req := userRequest("Ann", "ann@example.com")
+	req.Active = &active
+	req.Address = &milan
+	if _, err := u.Update(ctx, ann, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Delete(ctx, cidId); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = u.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = UserStats{
+		Total:     2,
+		Active:    2,
+		Inactive:  0,
+		Countries: []CountryCount{{Country: "IT", Users: 2}},
+		Cities:    []CityCount{{Country: "IT", City: "Milan", Users: 1}, {Country: "IT", City: "Rome", Users: 1}},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("after the update and the delete: got %+v, want %+v", stats, want)
+	}
+
+	// Every tenant counts its own users
+	if stats, _ := u.Stats(WithTenant(ctx, "acme")); stats.Total != 0 || len(stats.Cities) != 0 {
+		t.Errorf("another tenant got %+v", stats)
+	}
+}
+
+func TestStatsKeepTheCitiesOfCountriesWithColons(t *testing.T) {
+	u := NewUserService(memoryTenants[entities.User](newTestTenants(), UserIndexes...))
+	ctx := WithAdmin(context.Background())
+	for _, address := range []entities.Address{
+		{City: "Rome", Country: "IT:North", AddressString: "Via 1"},
+		{City: "North:Rome", Country: "IT", AddressString: "Via 2"},
+	} {
+		req := userRequest("Ann", "ann@example.com")
+		req.Address = &address
+		if _, err := u.Create(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := u.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CityCount{{Country: "IT", City: "North:Rome", Users: 1}, {Country: "IT:North", City: "Rome", Users: 1}}
+	if !reflect.DeepEqual(stats.Cities, want) {
+		t.Errorf("got %+v, want %+v", stats.Cities, want)
+	}
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/mail"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidVerification  = errors.New("invalid verification token")
+	ErrVerificationExpired  = errors.New("the verification token has expired")
+	ErrAlreadyVerified      = errors.New("the email of the user is already verified")
+	ErrVerificationDisabled = errors.New("email verification is not enabled")
+	ErrUserDeactivated      = errors.New("the user was deactivated by an admin")
+	ErrVerificationTooSoon  = errors.New("a verification token was mailed to the user moments ago")
+)
+
+// defaultResendInterval is the least time between two tokens mailed to a user on request
+const defaultResendInterval = time.Minute
+
+// Verification signs the tokens the users verify their email with and mails them
+type Verification struct {
+	// Secret signs the tokens with HMAC-SHA256
+	Secret []byte
+	// TTL is how long a token can be used
+	TTL    time.Duration
+	Sender mail.Sender
+	// ResendInterval is the least time between two tokens mailed to a user on request, a
+	// minute when 0. Admins aren't limited.
+	ResendInterval time.Duration
+	// URL the users open to verify their email, with the token in ?token=. Without
+	// it the email only has the token.
+	URL string
+}
+
+// verificationClaims are signed in the tokens. A token only verifies the email it was sent to.
+type verificationClaims struct {
+	UserId    uuid.UUID `json:"sub"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Email     string    `json:"email"`
+	ExpiresAt int64     `json:"exp"`
+}
+
+// WithVerification mails a verification token to the new users and to the users changing
+// their email, without it the users can only be activated by an admin
+func WithVerification(verification Verification) UserServiceOption {
+	return func(u *UserService) {
+		u.verification = &verification
+	}
+}
+
+// Verify activates the user the token was sent to. A token can't be used once the email is
+// verified, nor by a user an admin deactivated, even after changing its email.
+func (u *UserService) Verify(ctx context.Context, token string) (entities.User, error) {
+	claims, err := u.parseVerificationToken(token)
+	if err != nil {
+		return entities.User{}, err
+	}
+	slog.Info("Verifying user email", "id", claims.UserId)
+	if claims.Tenant != TenantFrom(ctx) {
+		return entities.User{}, ErrInvalidVerification
+	}
+	return retryStale(func() (entities.User, error) {
+		return u.verify(ctx, claims)
+	})
+}
+
+func (u *UserService) verify(ctx context.Context, claims verificationClaims) (entities.User, error) {
+	current, err := u.storage(ctx).Get(claims.UserId)
+	if err != nil {
+		return entities.User{}, err
+	}
+	// The email changed after the token was sent
+	if current.Email != claims.Email {
+		return entities.User{}, ErrInvalidVerification
+	}
+	if current.EmailVerifiedAt != nil {
+		return entities.User{}, ErrAlreadyVerified
+	}
+	if current.DeactivatedAt != nil {
+		return entities.User{}, ErrUserDeactivated
+	}
+
+	newUser := current
+	verifiedAt := u.clock.Now()
+	newUser.EmailVerifiedAt = &verifiedAt
+	newUser.Active = true
+	return u.save(ctx, current, newUser)
+}
+
+// SendVerification mails a new verification token to a user that hasn't verified its email,
+// once per ResendInterval unless an admin asks
+func (u *UserService) SendVerification(ctx context.Context, id uuid.UUID) error {
+	user, err := u.storage(ctx).Get(id)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrAlreadyVerified
+	}
+	if u.verification == nil {
+		return ErrVerificationDisabled
+	}
+	interval := u.verification.ResendInterval
+	if interval == 0 {
+		interval = defaultResendInterval
+	}
+	if !IsAdmin(ctx) && !u.resends.take(TenantFrom(ctx), id, u.clock.Now(), interval) {
+		return ErrVerificationTooSoon
+	}
+	return u.sendVerification(ctx, user)
+}
+
+// verificationResends keeps when a token was last mailed on request to every user of this
+// process, so the users can't be flooded with emails
+type verificationResends struct {
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+func newVerificationResends() *verificationResends {
+	return &verificationResends{sent: make(map[string]time.Time)}
+}
+
+// take records a send to the user at now, false when the last one was less than interval ago
+func (v *verificationResends) take(tenant string, id uuid.UUID, now time.Time, interval time.Duration) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key := tenant + ":" + id.String()
+	if last, ok := v.sent[key]; ok && now.Sub(last) < interval {
+		return false
+	}
+	// The sends older than the interval can't limit anymore
+	for other, last := range v.sent {
+		if now.Sub(last) >= interval {
+			delete(v.sent, other)
+		}
+	}
+	v.sent[key] = now
+	return true
+}
+
+// sendVerification mails the user a token to verify its email
+func (u *UserService) sendVerification(ctx context.Context, user entities.User) error {
+	if u.verification == nil {
+		return nil
+	}
+	token, err := u.verificationToken(ctx, user)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hello %s,\n\nVerify your email with this token, it expires in %s:\n\n%s\n", user.Name, u.verification.TTL, token)
+	if u.verification.URL != "" {
+		body += fmt.Sprintf("\nOr open %s?token=%s\n", u.verification.URL, url.QueryEscape(token))
+	}
+	slog.Info("Sending verification email", "id", user.Id)
+	return u.verification.Sender.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body:    body,
+	})
+}
+
+// notifyVerification sends the verification email of a change already stored, a failing
+// email must not fail the request, the user can ask for another one
+func (u *UserService) notifyVerification(ctx context.Context, user entities.User) {
+	if err := u.sendVerification(ctx, user); err != nil {
+		slog.Error(err.Error(), "id", user.Id)
+	}
+}
+
+// verificationToken signs the claims of the user: base64url(claims).base64url(HMAC-SHA256)
+func (u *UserService) verificationToken(ctx context.Context, user entities.User) (string, error) {
+	payload, err := json.Marshal(verificationClaims{
+		UserId:    user.Id,
+		Tenant:    TenantFrom(ctx),
+		Email:     user.Email,
+		ExpiresAt: u.clock.Now().Add(u.verification.TTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + u.signVerification(encoded), nil
+}
+
+func (u *UserService) parseVerificationToken(token string) (verificationClaims, error) {
+	if u.verification == nil {
+		return verificationClaims{}, ErrVerificationDisabled
+	}
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(u.signVerification(encoded))) {
+		return verificationClaims{}, ErrInvalidVerification
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return verificationClaims{}, ErrInvalidVerification
+	}
+	var claims verificationClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return verificationClaims{}, ErrInvalidVerification
+	}
+	if u.clock.Now().Unix() >= claims.ExpiresAt {
+		return verificationClaims{}, ErrVerificationExpired
+	}
+	return claims, nil
+}
+
+func (u *UserService) signVerification(encoded string) string {
+	mac := hmac.New(sha256.New, u.verification.Secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
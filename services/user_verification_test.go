@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const testVerifyTTL = time.Hour
+
+// mailbox keeps the emails sent instead of sending them
+type mailbox struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *mailbox) Send(ctx context.Context, message mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// lastToken returns the token of the last verification email sent
+func (m *mailbox) lastToken(t *testing.T) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) == 0 {
+		t.Fatal("no verification email was sent")
+	}
+	words := strings.Fields(m.messages[len(m.messages)-1].Body)
+	return words[len(words)-1]
+}
+
+func newVerifyingUserService(clock Clock) (*UserService, *mailbox) {
+	box := &mailbox{}
+	return newTestUserService(WithClock(clock), WithVerification(Verification{
+		Secret: []byte("test-verification-secret"),
+		TTL:    testVerifyTTL,
+		Sender: box,
+	})), box
+}
+
+func TestVerificationActivatesTheUserOnce(t *testing.T) {
+	u, box := newVerifyingUserService(newFakeClock())
+	ctx := context.Background()
+	id, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := box.lastToken(t)
+
+	verified, err := u.Verify(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.Id != id || !verified.Active || verified.EmailVerifiedAt == nil {
+		t.Errorf("got %+v after the verification, want it active and verified", verified)
+	}
+	if _, err := u.Verify(ctx, token); !errors.Is(err, ErrAlreadyVerified) {
+		t.Errorf("second verification: got %v, want %v", err, ErrAlreadyVerified)
+	}
+}
+
+func TestVerificationTokenExpires(t *testing.T) {
+	clock := newFakeClock()
+	u, box := newVerifyingUserService(clock)
+	ctx := context.Background()
+	if _, err := u.Create(ctx, userRequest("Ann", "ann@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(testVerifyTTL)
+	if _, err := u.Verify(ctx, box.lastToken(t)); !errors.Is(err, ErrVerificationExpired) {
+		t.Errorf("got %v, want %v", err, ErrVerificationExpired)
+	}
+}
+
+func TestTamperedVerificationTokenIsRefused(t *testing.T) {
+	u, box := newVerifyingUserService(newFakeClock())
+	ctx := context.Background()
+	if _, err := u.Create(ctx, userRequest("Ann", "ann@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	token := box.lastToken(t)
+	claims, signature, _ := strings.Cut(token, ".")
+	other, otherBox := newVerifyingUserService(newFakeClock())
+	other.verification.Secret = []byte("other-secret")
+	if _, err := other.Create(ctx, userRequest("Ann", "ann@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		token string
+	}{
+		{name: "changed claims", token: strings.ToUpper(claims[:4]) + claims[4:] + "." + signature},
+		{name: "changed signature", token: claims + "." + strings.ToUpper(signature)},
+		{name: "no signature", token: claims},
+		{name: "other secret", token: otherBox.lastToken(t)},
+	} {
+		if _, err := u.Verify(ctx, test.token); !errors.Is(err, ErrInvalidVerification) {
+			t.Errorf("%s: got %v, want %v", test.name, err, ErrInvalidVerification)
+		}
+	}
+	// The token of a tenant doesn't verify the user in another
+	if _, err := u.Verify(WithTenant(ctx, "acme"), token); !errors.Is(err, ErrInvalidVerification) {
+		t.Errorf("other tenant: got %v, want %v", err, ErrInvalidVerification)
+	}
+}
+
+func TestEmailChangeInvalidatesTheVerificationToken(t *testing.T) {
+	u, box := newVerifyingUserService(newFakeClock())
+	ctx := context.Background()
+	id, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := box.lastToken(t)
+	if _, err := u.Update(WithUser(ctx, id), id, userRequest("Ann", "ann.lee@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Verify(ctx, sent); !errors.Is(err, ErrInvalidVerification) {
+		t.Errorf("token of the old email: got %v, want %v", err, ErrInvalidVerification)
+	}
+	verified, err := u.Verify(ctx, box.lastToken(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.Email != "ann.lee@example.com" {
+		t.Errorf("verified %q, want the new email", verified.Email)
+	}
+}
+
+func TestOnlyAdminsChangeTheActivation(t *testing.T) {
+	u, box := newVerifyingUserService(newFakeClock())
+	ctx := context.Background()
+	admin := WithAdmin(ctx)
+	active := func(active bool) entities.UserRequest {
+		req := userRequest("Ann", "ann@example.com")
+		req.Active = &active
+		return req
+	}
+
+	if _, err := u.Create(ctx, active(true)); !errors.Is(err, ErrAdminOnly) {
+		t.Errorf("create active: got %v, want %v", err, ErrAdminOnly)
+	}
+	id, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Update(ctx, id, active(true)); !errors.Is(err, ErrAdminOnly) {
+		t.Errorf("activate: got %v, want %v", err, ErrAdminOnly)
+	}
+	if _, err := u.Verify(ctx, box.lastToken(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Update(ctx, id, active(false)); !errors.Is(err, ErrAdminOnly) {
+		t.Errorf("deactivate: got %v, want %v", err, ErrAdminOnly)
+	}
+
+	// A user an admin deactivated can't activate itself again with a new email
+	if _, err := u.Update(admin, id, active(false)); err != nil {
+		t.Fatal(err)
+	}
+	changed := userRequest("Ann", "ann.lee@example.com")
+	if _, err := u.Update(WithUser(ctx, id), id, changed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Verify(ctx, box.lastToken(t)); !errors.Is(err, ErrUserDeactivated) {
+		t.Errorf("verify deactivated: got %v, want %v", err, ErrUserDeactivated)
+	}
+	activated, err := u.Update(admin, id, active(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !activated.Active || activated.DeactivatedAt != nil {
+		t.Errorf("got %+v after the admin activation", activated)
+	}
+}
+
+func TestVerificationResendIsLimited(t *testing.T) {
+	clock := newFakeClock()
+	u, box := newVerifyingUserService(clock)
+	ctx := context.Background()
+	id, err := u.Create(ctx, userRequest("Ann", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SendVerification(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SendVerification(ctx, id); !errors.Is(err, ErrVerificationTooSoon) {
+		t.Errorf("second resend: got %v, want %v", err, ErrVerificationTooSoon)
+	}
+	if err := u.SendVerification(WithAdmin(ctx), id); err != nil {
+		t.Errorf("resend of an admin: %v", err)
+	}
+	clock.Advance(defaultResendInterval)
+	if err := u.SendVerification(ctx, id); err != nil {
+		t.Errorf("resend after the interval: %v", err)
+	}
+	if err := u.SendVerification(ctx, uuid.New()); err == nil {
+		t.Error("resend to an unknown user succeeded")
+	}
+	if len(box.messages) != 4 {
+		t.Errorf("sent %d emails, want 4", len(box.messages))
+	}
+}
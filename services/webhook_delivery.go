@@ -0,0 +1,202 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers of the webhook requests. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the subscription secret, prefixed with "sha256=".
+const (
+	WebhookIdHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// SignWebhook computes the signature header of a webhook body sent at the unix timestamp
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// HandleEvent queues a delivery of the event for every subscription of its tenant receiving
+// it, it is meant to be subscribed to the events bus. A delivery is keyed by its event and
+// subscription, so an event the relay retries is queued once per subscription.
+func (w *WebhookService) HandleEvent(ctx context.Context, event events.Event) error {
+	ctx = WithTenant(ctx, event.Tenant)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	subscriptions, err := w.subscriptions.For(event.Tenant).GetAll()
+	if err != nil {
+		return err
+	}
+
+	now := w.clock.Now()
+	queued := 0
+	var errs []error
+	for _, subscription := range subscriptions {
+		if !subscription.Receives(string(event.Type)) {
+			continue
+		}
+		delivery := entities.WebhookDelivery{
+			Id:             DeliveryId(event.Id, subscription.Id),
+			SubscriptionId: subscription.Id,
+			EventId:        event.Id,
+			EventType:      string(event.Type),
+			Payload:        string(payload),
+			Status:         entities.DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		}
+		// Already queued when the event was handled before
+		if _, err := w.deliveryStorage(ctx).Get(delivery.Id); err == nil {
+			continue
+		} else if !errors.Is(err, db.ErrUserNotFound) {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := w.deliveryStorage(ctx).Create(delivery); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		queued++
+	}
+	if queued > 0 {
+		w.wakeUp()
+	}
+	return errors.Join(errs...)
+}
+
+// DeliveryId is the id of the delivery of an event to a subscription
+func DeliveryId(eventId uuid.UUID, subscriptionId uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(subscriptionId, eventId[:])
+}
+
+// StartDelivery sends the pending deliveries every interval, and as soon as new ones
+// are queued, until the context is done
+func (w *WebhookService) StartDelivery(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-w.wake:
+			}
+			if err := w.DeliverPending(ctx); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+	}()
+}
+
+// DeliverPending attempts every pending delivery that is due, of every tenant
+func (w *WebhookService) DeliverPending(ctx context.Context) error {
+	return w.deliveries.Each(func(tenant string, storage db.Storage[entities.WebhookDelivery]) error {
+		pending, err := db.Collect(storage.FindBy(IndexDeliveryStatus, entities.DeliveryPending, db.DefaultBatchSize))
+		if err != nil {
+			return err
+		}
+		now := w.clock.Now()
+		for _, delivery := range pending {
+			if !delivery.NextAttemptAt.After(now) {
+				w.attempt(WithTenant(ctx, tenant), delivery)
+			}
+		}
+		return nil
+	})
+}
+
+// attempt sends the delivery once and stores the result, scheduling the next attempt on failure
+func (w *WebhookService) attempt(ctx context.Context, delivery entities.WebhookDelivery) {
+	delivery.Attempts++
+	statusCode, err := w.send(ctx, delivery)
+	now := w.clock.Now()
+	delivery.LastStatusCode = statusCode
+	switch {
+	case err == nil:
+		delivery.Status = entities.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	case delivery.Attempts >= WebhookMaxAttempts:
+		slog.Error(err.Error(), "delivery", delivery.Id, "attempts", delivery.Attempts)
+		delivery.Status = entities.DeliveryDeadLetter
+		delivery.LastError = err.Error()
+	default:
+		slog.Error(err.Error(), "delivery", delivery.Id, "attempts", delivery.Attempts)
+		delivery.NextAttemptAt = now.Add(webhookBackoff(delivery.Attempts))
+		delivery.LastError = err.Error()
+	}
+	if _, err := w.deliveryStorage(ctx).Update(delivery.Id, delivery); err != nil {
+		slog.Error(err.Error(), "delivery", delivery.Id)
+	}
+}
+
+// send posts the signed payload, any status outside 2xx is a failure
+func (w *WebhookService) send(ctx context.Context, delivery entities.WebhookDelivery) (int, error) {
+	subscription, err := w.subscriptions.For(TenantFrom(ctx)).Get(delivery.SubscriptionId)
+	if err != nil {
+		return 0, w.resource.notFound(err)
+	}
+
+	body := []byte(delivery.Payload)
+	timestamp := w.clock.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIdHeader, delivery.Id.String())
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, timestamp, body))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+func (w *WebhookService) wakeUp() {
+	// A round already requested covers this one too
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// webhookBackoff is the wait after the given failed attempt, doubling up to webhookMaxBackoff
+func webhookBackoff(attempts int) time.Duration {
+	wait := webhookBaseBackoff
+	for i := 1; i < attempts && wait < webhookMaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, webhookMaxBackoff)
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Deliveries are attempted this many times before they are dead lettered
+	WebhookMaxAttempts = 8
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookTimeout     = 10 * time.Second
+	webhookSecretBytes = 32
+)
+
+// Indexes of the webhook deliveries
+const (
+	IndexDeliveryStatus       = "status"
+	IndexDeliverySubscription = "subscription"
+)
+
+// WebhookDeliveryIndexes are kept by the storage of the deliveries to find the pending and
+// dead lettered ones and the log of a subscription without scanning every delivery
+var WebhookDeliveryIndexes = []db.Index[entities.WebhookDelivery]{
+	{Name: IndexDeliveryStatus, Keys: func(delivery entities.WebhookDelivery) []string {
+		return []string{delivery.Status}
+	}},
+	{Name: IndexDeliverySubscription, Keys: func(delivery entities.WebhookDelivery) []string {
+		return []string{delivery.SubscriptionId.String()}
+	}},
+}
+
+var (
+	ErrDeliveryNotFound = errors.New("cannot find a webhook delivery with this id")
+	ErrNotDeadLetter    = errors.New("only dead lettered deliveries can be retried")
+)
+
+// WebhookService manages the webhook subscriptions and delivers the user events to them,
+// the subscriptions of a tenant only receive the events of its users
+type WebhookService struct {
+	subscriptions *db.Tenants[entities.WebhookSubscription]
+	resource      *ResourceService[entities.WebhookSubscription, entities.WebhookSubscriptionRequest]
+	deliveries    *db.Tenants[entities.WebhookDelivery]
+	client        *http.Client
+	clock         Clock
+	// privateTargets lets the webhooks call the hosts of private networks
+	privateTargets bool
+	// wake starts a delivery round before the next tick
+	wake chan struct{}
+}
+
+type WebhookServiceOption func(*WebhookService)
+
+// WithHTTPClient replaces the client used to call the webhooks
+func WithHTTPClient(client *http.Client) WebhookServiceOption {
+	return func(w *WebhookService) {
+		w.client = client
+	}
+}
+
+// WithWebhookClock replaces the clock used for the delivery schedule
+func WithWebhookClock(clock Clock) WebhookServiceOption {
+	return func(w *WebhookService) {
+		w.clock = clock
+	}
+}
+
+func NewWebhookService(subscriptions *db.Tenants[entities.WebhookSubscription], deliveries *db.Tenants[entities.WebhookDelivery], opts ...WebhookServiceOption) *WebhookService {
+	webhookService := new(WebhookService)
+	webhookService.subscriptions = subscriptions
+	webhookService.deliveries = deliveries
+	webhookService.client = newWebhookClient(webhookService)
+	webhookService.clock = SystemClock
+	webhookService.wake = make(chan struct{}, 1)
+	// The subscriptions are deleted with their delivery log kept
+	webhookService.resource = NewResourceService("webhook", subscriptions, Mapper[entities.WebhookSubscription, entities.WebhookSubscriptionRequest]{
+		New:   webhookService.newSubscription,
+		Apply: webhookService.applySubscription,
+	})
+	for _, opt := range opts {
+		opt(webhookService)
+	}
+	return webhookService
+}
+
+// Subscriptions is the CRUD service of the subscriptions, their urls must name public hosts
+// and a secret is generated when the request has none
+func (w *WebhookService) Subscriptions() *ResourceService[entities.WebhookSubscription, entities.WebhookSubscriptionRequest] {
+	return w.resource
+}
+
+func (w *WebhookService) newSubscription(ctx context.Context, id uuid.UUID, req entities.WebhookSubscriptionRequest) (entities.WebhookSubscription, error) {
+	if err := w.checkTarget(req.Url); err != nil {
+		return entities.WebhookSubscription{}, fmt.Errorf("%w: %w", ErrResourceInvalid, err)
+	}
+	secret, err := secretOrNew(req.Secret)
+	if err != nil {
+		return entities.WebhookSubscription{}, err
+	}
+	now := w.clock.Now()
+	return entities.WebhookSubscription{
+		Id:         id,
+		Url:        req.Url,
+		Secret:     secret,
+		EventTypes: req.EventTypes,
+		Active:     req.Active,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}, nil
+}
+
+// applySubscription replaces the subscription, the secret is kept when the request has none
+func (w *WebhookService) applySubscription(ctx context.Context, current entities.WebhookSubscription, req entities.WebhookSubscriptionRequest) (entities.WebhookSubscription, error) {
+	if err := w.checkTarget(req.Url); err != nil {
+		return entities.WebhookSubscription{}, fmt.Errorf("%w: %w", ErrResourceInvalid, err)
+	}
+	subscription := current
+	subscription.Url = req.Url
+	subscription.EventTypes = req.EventTypes
+	subscription.Active = req.Active
+	subscription.UpdatedAt = w.clock.Now()
+	if req.Secret != "" {
+		subscription.Secret = req.Secret
+	}
+	return subscription, nil
+}
+
+// Deliveries returns the delivery log of a subscription
+func (w *WebhookService) Deliveries(ctx context.Context, subscriptionId uuid.UUID) db.Iterator[entities.WebhookDelivery] {
+	//Log action
+	slog.Info("Listing webhook deliveries", "subscription", subscriptionId)
+	return w.deliveryStorage(ctx).FindBy(IndexDeliverySubscription, subscriptionId.String(), db.DefaultBatchSize)
+}
+
+// DeadLetters returns the deliveries that failed every attempt
+func (w *WebhookService) DeadLetters(ctx context.Context) db.Iterator[entities.WebhookDelivery] {
+	//Log action
+	slog.Info("Listing dead lettered webhook deliveries")
+	return w.deliveryStorage(ctx).FindBy(IndexDeliveryStatus, entities.DeliveryDeadLetter, db.DefaultBatchSize)
+}
+
+// Retry schedules a dead lettered delivery again with a fresh set of attempts
+func (w *WebhookService) Retry(ctx context.Context, id uuid.UUID) (entities.WebhookDelivery, error) {
+	//Log action
+	slog.Info("Retrying webhook delivery", "id", id)
+	delivery, err := w.deliveryStorage(ctx).Get(id)
+	if err != nil {
+		return entities.WebhookDelivery{}, ErrDeliveryNotFound
+	}
+	if delivery.Status != entities.DeliveryDeadLetter {
+		return entities.WebhookDelivery{}, ErrNotDeadLetter
+	}
+	delivery.Status = entities.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = w.clock.Now()
+	delivery, err = w.deliveryStorage(ctx).Update(id, delivery)
+	if err != nil {
+		return entities.WebhookDelivery{}, err
+	}
+	w.wakeUp()
+	return delivery, nil
+}
+
+// deliveryStorage returns the deliveries of the tenant of the request
+func (w *WebhookService) deliveryStorage(ctx context.Context) db.Storage[entities.WebhookDelivery] {
+	return w.deliveries.For(TenantFrom(ctx))
+}
+
+// secretOrNew returns the given secret or a random one
+func secretOrNew(secret string) (string, error) {
+	if secret != "" {
+		return secret, nil
+	}
+	random := make([]byte, webhookSecretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
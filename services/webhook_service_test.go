@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"example/bootcamp_ex1/db"
+	"example/bootcamp_ex1/entities"
+	"example/bootcamp_ex1/events"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestWebhookService(clock Clock, opts ...WebhookServiceOption) *WebhookService {
+	opts = append([]WebhookServiceOption{WithWebhookClock(clock)}, opts...)
+	tenants := newTestTenants()
+	return NewWebhookService(
+		memoryTenants[entities.WebhookSubscription](tenants),
+		memoryTenants(tenants, WebhookDeliveryIndexes...),
+		opts...,
+	)
+}
+
+func subscribe(t *testing.T, w *WebhookService, url string, eventTypes ...string) entities.WebhookSubscription {
+	t.Helper()
+	subscription, err := w.Subscriptions().Create(context.Background(), entities.WebhookSubscriptionRequest{
+		Url:        url,
+		Secret:     "0123456789abcdef",
+		EventTypes: eventTypes,
+		Active:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subscription
+}
+
+func testEvent(eventType events.EventType) events.Event {
+	return events.NewEvent(eventType, entities.User{Id: uuid.New(), Name: "Ann"}, SystemActor, time.Now())
+}
+
+func TestHandleEventQueuesADeliveryOncePerSubscription(t *testing.T) {
+	w := newTestWebhookService(newFakeClock(), WithPrivateTargets())
+	created := subscribe(t, w, "http://127.0.0.1:1/created", string(events.UserCreated))
+	subscribe(t, w, "http://127.0.0.1:1/deleted", string(events.UserDeleted))
+
+	event := testEvent(events.UserCreated)
+	// The relay sends the event again when a handler failed
+	for i := 0; i < 2; i++ {
+		if err := w.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, err := w.deliveryStorage(context.Background()).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	if deliveries[0].SubscriptionId != created.Id || deliveries[0].Id != DeliveryId(event.Id, created.Id) {
+		t.Errorf("got delivery %+v for subscription %s", deliveries[0], created.Id)
+	}
+}
+
+func TestDeliverPendingSendsTheSignedEvent(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	w := newTestWebhookService(newFakeClock(), WithPrivateTargets())
+	subscription := subscribe(t, w, server.URL)
+	event := testEvent(events.UserCreated)
+	if err := w.HandleEvent(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.DeliverPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got == nil {
+		t.Fatal("the webhook wasn't called")
+	}
+	timestamp, err := strconv.ParseInt(got.Header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature := got.Header.Get(WebhookSignatureHeader); signature != SignWebhook(subscription.Secret, timestamp, body) {
+		t.Errorf("got signature %q", signature)
+	}
+	delivery, err := w.deliveryStorage(context.Background()).Get(DeliveryId(event.Id, subscription.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != entities.DeliveryDelivered || delivery.Attempts != 1 {
+		t.Errorf("got status %s after %d attempts", delivery.Status, delivery.Attempts)
+	}
+}
+
+func TestFailedDeliveryIsDeadLetteredAfterEveryAttempt(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	w := newTestWebhookService(clock, WithPrivateTargets())
+	subscribe(t, w, server.URL)
+	if err := w.HandleEvent(context.Background(), testEvent(events.UserCreated)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < WebhookMaxAttempts+2; i++ {
+		if err := w.DeliverPending(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(webhookMaxBackoff)
+	}
+	if calls != WebhookMaxAttempts {
+		t.Errorf("got %d calls, want %d", calls, WebhookMaxAttempts)
+	}
+
+	dead, err := db.Collect(w.DeadLetters(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].LastStatusCode != http.StatusInternalServerError {
+		t.Fatalf("got dead letters %+v", dead)
+	}
+	retried, err := w.Retry(context.Background(), dead[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != entities.DeliveryPending || retried.Attempts != 0 {
+		t.Errorf("got status %s after %d attempts", retried.Status, retried.Attempts)
+	}
+}
+
+func TestSubscriptionsRefusePrivateTargets(t *testing.T) {
+	w := newTestWebhookService(newFakeClock())
+	for _, url := range []string{
+		"http://localhost:8000/hook",
+		"http://api.localhost/hook",
+		"http://127.0.0.1/hook",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.64.0.1/hook",
+		"http://[::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+		"ftp://example.com/hook",
+	} {
+		_, err := w.Subscriptions().Create(context.Background(), entities.WebhookSubscriptionRequest{Url: url, Active: true})
+		if !errors.Is(err, ErrWebhookTarget) {
+			t.Errorf("%s: got %v, want %v", url, err, ErrWebhookTarget)
+		}
+	}
+	if _, err := w.Subscriptions().Create(context.Background(), entities.WebhookSubscriptionRequest{Url: "https://hooks.example.com/users", Active: true}); err != nil {
+		t.Errorf("public host refused: %v", err)
+	}
+}
+
+func TestDeliveryRefusesToConnectToPrivateAddresses(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	// A name resolving to a private address passes the check of the url, the dialer refuses it
+	w := newTestWebhookService(newFakeClock())
+	subscription := entities.WebhookSubscription{Id: uuid.New(), Url: server.URL, Active: true}
+	if _, err := w.subscriptions.For(db.DefaultTenant).Create(subscription); err != nil {
+		t.Fatal(err)
+	}
+	event := testEvent(events.UserCreated)
+	if err := w.HandleEvent(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.DeliverPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if called {
+		t.Error("the webhook of a private address was called")
+	}
+	delivery, err := w.deliveryStorage(context.Background()).Get(DeliveryId(event.Id, subscription.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != entities.DeliveryPending || delivery.LastError == "" {
+		t.Errorf("got status %s and error %q", delivery.Status, delivery.LastError)
+	}
+}
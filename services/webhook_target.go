@@ -0,0 +1,77 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+var (
+	ErrWebhookTarget = errors.New("webhooks can only call http(s) urls of public hosts")
+)
+
+// Shared address space of the carrier-grade NATs, not covered by netip.Addr.IsPrivate
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// WithPrivateTargets lets the webhooks call loopback and private network hosts, for
+// development and tests only since any admin could then reach the internal services
+func WithPrivateTargets() WebhookServiceOption {
+	return func(w *WebhookService) {
+		w.privateTargets = true
+	}
+}
+
+// checkTarget refuses the urls naming a host of a private network. The names resolving
+// to one are refused when connecting, by the dialer of newWebhookClient.
+func (w *WebhookService) checkTarget(rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return ErrWebhookTarget
+	}
+	if w.privateTargets {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(target.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrWebhookTarget
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublic(addr) {
+		return ErrWebhookTarget
+	}
+	return nil
+}
+
+// newWebhookClient returns the default client of the webhooks, its dialer checks the
+// address every connection is made to, after the name resolution and on every redirect
+func newWebhookClient(w *WebhookService) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			if w.privateTargets {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !isPublic(addrPort.Addr()) {
+				return ErrWebhookTarget
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			// No proxy, it would be the one checked instead of the target
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+		},
+	}
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
@@ -0,0 +1,4 @@
+// Package userpb holds the code generated from proto/users.proto
+package userpb
+
+//go:generate protoc -I ../proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative users.proto